@@ -0,0 +1,103 @@
+package chattmpl
+
+// node is one piece of a parsed template: literal text, a {{ }} output, an
+// {% if %} block, or a {% for %} block. Render walks a []node in order.
+type node interface {
+	isNode()
+}
+
+type textNode struct {
+	text string
+}
+
+type outputNode struct {
+	expr expr
+}
+
+type ifBranch struct {
+	cond expr // nil for the trailing else branch
+	body []node
+}
+
+type ifNode struct {
+	branches []ifBranch // in order: if, elif*, and an optional else (cond == nil)
+}
+
+type forNode struct {
+	varName  string
+	iterable expr
+	body     []node
+}
+
+func (textNode) isNode()   {}
+func (outputNode) isNode() {}
+func (ifNode) isNode()     {}
+func (forNode) isNode()    {}
+
+// expr is one node of a {{ }} or {% if/for %} expression: a literal, a
+// variable/attribute/index lookup, a unary or binary operation, a string
+// method call (.strip()/.startswith()), or the raise_exception builtin.
+type expr interface {
+	isExpr()
+}
+
+type stringLit struct{ value string }
+type numberLit struct{ value float64 }
+type boolLit struct{ value bool }
+type noneLit struct{}
+
+// varRef resolves a top-level name against the render environment
+// (messages, tools, add_generation_prompt, bos_token, eos_token, loop
+// variables bound by an enclosing for, or any name a for loop bound).
+type varRef struct{ name string }
+
+// attrAccess resolves target.name, used for both real struct-like field
+// access (message.role) and dict-style lookups, since HF chat templates use
+// both interchangeably on the same message dicts.
+type attrAccess struct {
+	target expr
+	name   string
+}
+
+// indexAccess resolves target[index], e.g. messages[0] or message['role'].
+type indexAccess struct {
+	target expr
+	index  expr
+}
+
+// methodCall resolves target.name(args...), scoped to exactly the string
+// methods real chat templates lean on: strip() and startswith(prefix).
+type methodCall struct {
+	target expr
+	name   string
+	args   []expr
+}
+
+// callExpr resolves name(args...) for a bare builtin call, scoped to
+// exactly raise_exception(message).
+type callExpr struct {
+	name string
+	args []expr
+}
+
+type unaryExpr struct {
+	op      string // "not" or "-"
+	operand expr
+}
+
+type binaryExpr struct {
+	op          string // "+", "==", "!=", "<", "<=", ">", ">=", "and", "or", "in"
+	left, right expr
+}
+
+func (stringLit) isExpr()  {}
+func (numberLit) isExpr()  {}
+func (boolLit) isExpr()    {}
+func (noneLit) isExpr()    {}
+func (varRef) isExpr()     {}
+func (attrAccess) isExpr() {}
+func (indexAccess) isExpr() {}
+func (methodCall) isExpr() {}
+func (callExpr) isExpr()   {}
+func (unaryExpr) isExpr()  {}
+func (binaryExpr) isExpr() {}
@@ -0,0 +1,108 @@
+// Package chattmpl renders the chat templates HuggingFace tokenizer_config.json
+// files ship (a Jinja2 dialect) well enough to reproduce the prompt formats
+// MLX text models expect, without pulling in a general-purpose Jinja engine.
+// It covers exactly what real chat templates use in practice: {{ }} variable
+// interpolation, {% if/elif/else/endif %} and {% for/endfor %} control flow,
+// the .strip()/.startswith() string methods, the raise_exception(message)
+// builtin, and string concatenation/comparison/boolean operators. Anything
+// outside that (macros, filters, whitespace-insensitive dict literals, …)
+// is a parse error rather than best-effort output, so callers can fall back
+// to a hand-written formatter instead of silently mis-rendering.
+package chattmpl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Template is a parsed chat template, ready to Render against a per-request
+// environment.
+type Template struct {
+	nodes []node
+}
+
+// Parse parses a chat_template string from a model's tokenizer_config.json.
+func Parse(src string) (*Template, error) {
+	return parse(src)
+}
+
+// Render executes t against env, which should provide at least "messages"
+// ([]interface{} of map[string]interface{} with "role"/"content"), "tools",
+// "add_generation_prompt", "bos_token", and "eos_token" — the same
+// variables HuggingFace's own chat-template rendering exposes. Render
+// returns an error if the template calls raise_exception or uses a
+// construct this package doesn't support.
+func (t *Template) Render(env map[string]interface{}) (string, error) {
+	var out strings.Builder
+	if err := execNodes(t.nodes, cloneEnv(env), &out); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func execNodes(nodes []node, env map[string]interface{}, out *strings.Builder) error {
+	for _, n := range nodes {
+		if err := execNode(n, env, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func execNode(n node, env map[string]interface{}, out *strings.Builder) error {
+	switch v := n.(type) {
+	case textNode:
+		out.WriteString(v.text)
+		return nil
+
+	case outputNode:
+		val, err := evalExpr(v.expr, env)
+		if err != nil {
+			return err
+		}
+		out.WriteString(toString(val))
+		return nil
+
+	case ifNode:
+		for _, branch := range v.branches {
+			if branch.cond == nil {
+				return execNodes(branch.body, env, out)
+			}
+			val, err := evalExpr(branch.cond, env)
+			if err != nil {
+				return err
+			}
+			if truthy(val) {
+				return execNodes(branch.body, env, out)
+			}
+		}
+		return nil
+
+	case forNode:
+		iterable, err := evalExpr(v.iterable, env)
+		if err != nil {
+			return err
+		}
+		items, ok := iterable.([]interface{})
+		if !ok {
+			return fmt.Errorf("chattmpl: {%% for %%} target is not a list (got %T)", iterable)
+		}
+		for _, item := range items {
+			loopEnv := cloneEnv(env)
+			loopEnv[v.varName] = item
+			if err := execNodes(v.body, loopEnv, out); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+func cloneEnv(env map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(env)+1)
+	for k, v := range env {
+		out[k] = v
+	}
+	return out
+}
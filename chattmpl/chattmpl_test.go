@@ -0,0 +1,104 @@
+package chattmpl
+
+import "testing"
+
+func TestRenderChatML(t *testing.T) {
+	const src = `{% for message in messages %}{{'<|im_start|>' + message['role'] + '\n' + message['content'] + '<|im_end|>\n'}}{% endfor %}{% if add_generation_prompt %}{{'<|im_start|>assistant\n'}}{% endif %}`
+
+	tmpl, err := Parse(src)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	env := map[string]interface{}{
+		"messages": []interface{}{
+			map[string]interface{}{"role": "user", "content": "hi"},
+		},
+		"add_generation_prompt": true,
+	}
+
+	got, err := tmpl.Render(env)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	want := "<|im_start|>user\nhi<|im_end|>\n<|im_start|>assistant\n"
+	if got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderIfElifElse(t *testing.T) {
+	const src = `{% if role == 'system' %}S{% elif role == 'user' %}U{% else %}A{% endif %}`
+
+	tmpl, err := Parse(src)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	cases := []struct {
+		role string
+		want string
+	}{
+		{"system", "S"},
+		{"user", "U"},
+		{"assistant", "A"},
+	}
+	for _, c := range cases {
+		got, err := tmpl.Render(map[string]interface{}{"role": c.role})
+		if err != nil {
+			t.Fatalf("Render() error = %v", err)
+		}
+		if got != c.want {
+			t.Errorf("Render() with role=%q = %q, want %q", c.role, got, c.want)
+		}
+	}
+}
+
+func TestRenderStringMethods(t *testing.T) {
+	const src = `{% if content.startswith('/') %}cmd:{{ content.strip() }}{% else %}{{ content.strip() }}{% endif %}`
+
+	tmpl, err := Parse(src)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	got, err := tmpl.Render(map[string]interface{}{"content": "  /help  "})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if want := "/help"; got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderRaiseException(t *testing.T) {
+	const src = `{% if not messages %}{{ raise_exception('messages must not be empty') }}{% endif %}`
+
+	tmpl, err := Parse(src)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	_, err = tmpl.Render(map[string]interface{}{"messages": []interface{}{}})
+	if err == nil {
+		t.Fatal("Render() error = nil, want an error from raise_exception")
+	}
+}
+
+func TestRenderTrimMarkers(t *testing.T) {
+	const src = "  {%- if true -%}  \n  hello  {%- endif -%}  "
+
+	tmpl, err := Parse(src)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	got, err := tmpl.Render(nil)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if want := "hello"; got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+}
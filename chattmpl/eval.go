@@ -0,0 +1,282 @@
+package chattmpl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// lookup resolves name against env, returning nil (not an error) when it's
+// absent: Jinja treats an undefined variable as falsy/empty rather than
+// raising, and optional chat-template variables like tools are frequently
+// left unset.
+func lookup(env map[string]interface{}, name string) interface{} {
+	return env[name]
+}
+
+func evalExpr(e expr, env map[string]interface{}) (interface{}, error) {
+	switch n := e.(type) {
+	case stringLit:
+		return n.value, nil
+	case numberLit:
+		return n.value, nil
+	case boolLit:
+		return n.value, nil
+	case noneLit:
+		return nil, nil
+	case varRef:
+		return lookup(env, n.name), nil
+	case attrAccess:
+		target, err := evalExpr(n.target, env)
+		if err != nil {
+			return nil, err
+		}
+		return attrOf(target, n.name), nil
+	case indexAccess:
+		target, err := evalExpr(n.target, env)
+		if err != nil {
+			return nil, err
+		}
+		idx, err := evalExpr(n.index, env)
+		if err != nil {
+			return nil, err
+		}
+		return indexOf(target, idx)
+	case methodCall:
+		target, err := evalExpr(n.target, env)
+		if err != nil {
+			return nil, err
+		}
+		args := make([]interface{}, len(n.args))
+		for i, a := range n.args {
+			v, err := evalExpr(a, env)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = v
+		}
+		return callMethod(target, n.name, args)
+	case callExpr:
+		args := make([]interface{}, len(n.args))
+		for i, a := range n.args {
+			v, err := evalExpr(a, env)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = v
+		}
+		return callBuiltin(n.name, args)
+	case unaryExpr:
+		operand, err := evalExpr(n.operand, env)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "not":
+			return !truthy(operand), nil
+		case "-":
+			return -toNumber(operand), nil
+		}
+		return nil, fmt.Errorf("chattmpl: unknown unary operator %q", n.op)
+	case binaryExpr:
+		return evalBinary(n, env)
+	}
+	return nil, fmt.Errorf("chattmpl: unhandled expression %T", e)
+}
+
+func evalBinary(n binaryExpr, env map[string]interface{}) (interface{}, error) {
+	// "and"/"or" short-circuit, so the right side is only evaluated (and
+	// any raise_exception it carries only triggered) when necessary.
+	if n.op == "and" {
+		left, err := evalExpr(n.left, env)
+		if err != nil {
+			return nil, err
+		}
+		if !truthy(left) {
+			return left, nil
+		}
+		return evalExpr(n.right, env)
+	}
+	if n.op == "or" {
+		left, err := evalExpr(n.left, env)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(left) {
+			return left, nil
+		}
+		return evalExpr(n.right, env)
+	}
+
+	left, err := evalExpr(n.left, env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := evalExpr(n.right, env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "+":
+		if ls, ok := left.(string); ok {
+			return ls + toString(right), nil
+		}
+		return toNumber(left) + toNumber(right), nil
+	case "==":
+		return equalValues(left, right), nil
+	case "!=":
+		return !equalValues(left, right), nil
+	case "<":
+		return toNumber(left) < toNumber(right), nil
+	case "<=":
+		return toNumber(left) <= toNumber(right), nil
+	case ">":
+		return toNumber(left) > toNumber(right), nil
+	case ">=":
+		return toNumber(left) >= toNumber(right), nil
+	case "in":
+		return membership(left, right), nil
+	}
+	return nil, fmt.Errorf("chattmpl: unknown binary operator %q", n.op)
+}
+
+func attrOf(target interface{}, name string) interface{} {
+	m, ok := target.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return m[name]
+}
+
+func indexOf(target, idx interface{}) (interface{}, error) {
+	switch t := target.(type) {
+	case map[string]interface{}:
+		return t[toString(idx)], nil
+	case []interface{}:
+		i := int(toNumber(idx))
+		if i < 0 || i >= len(t) {
+			return nil, fmt.Errorf("chattmpl: index %d out of range (length %d)", i, len(t))
+		}
+		return t[i], nil
+	}
+	return nil, fmt.Errorf("chattmpl: cannot index into %T", target)
+}
+
+// callMethod implements exactly the string methods chat templates lean on:
+// .strip() and .startswith(prefix).
+func callMethod(target interface{}, name string, args []interface{}) (interface{}, error) {
+	s, ok := target.(string)
+	if !ok {
+		return nil, fmt.Errorf("chattmpl: .%s() is only supported on strings, got %T", name, target)
+	}
+	switch name {
+	case "strip":
+		return strings.TrimSpace(s), nil
+	case "startswith":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("chattmpl: .startswith() takes exactly one argument")
+		}
+		return strings.HasPrefix(s, toString(args[0])), nil
+	}
+	return nil, fmt.Errorf("chattmpl: unsupported string method %q", name)
+}
+
+// callBuiltin implements the one bare-function builtin chat templates rely
+// on: raise_exception, which chat templates call to reject malformed input
+// (e.g. a system message in the wrong position) rather than render garbage.
+func callBuiltin(name string, args []interface{}) (interface{}, error) {
+	if name != "raise_exception" {
+		return nil, fmt.Errorf("chattmpl: unsupported function %q", name)
+	}
+	msg := ""
+	if len(args) > 0 {
+		msg = toString(args[0])
+	}
+	return nil, fmt.Errorf("chattmpl: template raised an exception: %s", msg)
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != ""
+	case float64:
+		return t != 0
+	case []interface{}:
+		return len(t) > 0
+	case map[string]interface{}:
+		return len(t) > 0
+	}
+	return true
+}
+
+func toNumber(v interface{}) float64 {
+	switch t := v.(type) {
+	case float64:
+		return t
+	case int:
+		return float64(t)
+	case bool:
+		if t {
+			return 1
+		}
+		return 0
+	case string:
+		f, _ := strconv.ParseFloat(t, 64)
+		return f
+	}
+	return 0
+}
+
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case bool:
+		return strconv.FormatBool(t)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", t)
+	}
+}
+
+func equalValues(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	as, aIsStr := a.(string)
+	bs, bIsStr := b.(string)
+	if aIsStr && bIsStr {
+		return as == bs
+	}
+	if ab, ok := a.(bool); ok {
+		bb, ok := b.(bool)
+		return ok && ab == bb
+	}
+	return toNumber(a) == toNumber(b)
+}
+
+func membership(item, collection interface{}) bool {
+	switch c := collection.(type) {
+	case string:
+		return strings.Contains(c, toString(item))
+	case []interface{}:
+		for _, v := range c {
+			if equalValues(item, v) {
+				return true
+			}
+		}
+		return false
+	case map[string]interface{}:
+		_, ok := c[toString(item)]
+		return ok
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package chattmpl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tokKind enumerates the token kinds the expression lexer produces. Template
+// text and {{ }}/{% %} delimiters are handled separately, in parser.go's
+// splitTags; this lexer only runs on the contents between those delimiters.
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokPunct // one of the fixed operator/punctuation strings below
+)
+
+type token struct {
+	kind tokKind
+	text string
+}
+
+// exprLexer tokenizes the contents of a single {{ ... }} or {% ... %} tag.
+type exprLexer struct {
+	src string
+	pos int
+}
+
+func newExprLexer(src string) *exprLexer {
+	return &exprLexer{src: src}
+}
+
+// multiCharPuncts must be checked longest-first so "==" isn't lexed as two
+// "=" tokens.
+var multiCharPuncts = []string{"==", "!=", "<=", ">="}
+
+func (l *exprLexer) next() (token, error) {
+	l.skipSpace()
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF}, nil
+	}
+
+	c := l.src[l.pos]
+
+	if c == '\'' || c == '"' {
+		return l.lexString(c)
+	}
+	if isDigit(c) {
+		return l.lexNumber()
+	}
+	if isIdentStart(c) {
+		return l.lexIdent()
+	}
+
+	for _, p := range multiCharPuncts {
+		if strings.HasPrefix(l.src[l.pos:], p) {
+			l.pos += len(p)
+			return token{kind: tokPunct, text: p}, nil
+		}
+	}
+
+	switch c {
+	case '(', ')', '[', ']', '.', ',', '+', '-', '<', '>', '=':
+		l.pos++
+		return token{kind: tokPunct, text: string(c)}, nil
+	}
+
+	return token{}, fmt.Errorf("chattmpl: unexpected character %q", c)
+}
+
+func (l *exprLexer) skipSpace() {
+	for l.pos < len(l.src) && (l.src[l.pos] == ' ' || l.src[l.pos] == '\t' || l.src[l.pos] == '\n' || l.src[l.pos] == '\r') {
+		l.pos++
+	}
+}
+
+func (l *exprLexer) lexString(quote byte) (token, error) {
+	l.pos++ // opening quote
+	var sb strings.Builder
+	for l.pos < len(l.src) && l.src[l.pos] != quote {
+		c := l.src[l.pos]
+		if c == '\\' && l.pos+1 < len(l.src) {
+			l.pos++
+			switch e := l.src[l.pos]; e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			default:
+				sb.WriteByte(e)
+			}
+		} else {
+			sb.WriteByte(c)
+		}
+		l.pos++
+	}
+	if l.pos >= len(l.src) {
+		return token{}, fmt.Errorf("chattmpl: unterminated string literal")
+	}
+	l.pos++ // closing quote
+	return token{kind: tokString, text: sb.String()}, nil
+}
+
+func (l *exprLexer) lexNumber() (token, error) {
+	start := l.pos
+	for l.pos < len(l.src) && (isDigit(l.src[l.pos]) || l.src[l.pos] == '.') {
+		l.pos++
+	}
+	return token{kind: tokNumber, text: l.src[start:l.pos]}, nil
+}
+
+func (l *exprLexer) lexIdent() (token, error) {
+	start := l.pos
+	for l.pos < len(l.src) && isIdentPart(l.src[l.pos]) {
+		l.pos++
+	}
+	return token{kind: tokIdent, text: l.src[start:l.pos]}, nil
+}
+
+func isDigit(c byte) bool      { return c >= '0' && c <= '9' }
+func isIdentStart(c byte) bool { return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+func isIdentPart(c byte) bool  { return isIdentStart(c) || isDigit(c) }
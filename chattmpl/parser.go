@@ -0,0 +1,555 @@
+package chattmpl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parser turns raw template source into a []node tree. It only understands
+// the tags this package actually supports ({{ }}, {% if/elif/else/endif %},
+// {% for/endfor %}) and errors on anything else, rather than silently
+// ignoring a construct a real Jinja chat template might use.
+type parser struct {
+	src string
+	pos int
+
+	// trimNextText is set by a tag ending in "-%}"/"-}}" and consumed by
+	// whichever text run comes next, even if that text is collected by an
+	// outer parseUntil frame (e.g. "{% endif -%}" trims the text that
+	// follows the if block, in the parent's accumulation).
+	trimNextText bool
+}
+
+// parse parses src into a Template.
+func parse(src string) (*Template, error) {
+	p := &parser{src: src}
+	nodes, kw, _, err := p.parseUntil()
+	if err != nil {
+		return nil, err
+	}
+	if kw != "" {
+		return nil, fmt.Errorf("chattmpl: unexpected {%% %s %%} with no matching opening tag", kw)
+	}
+	return &Template{nodes: nodes}, nil
+}
+
+// parseUntil collects nodes until it hits EOF or a {% %} tag whose keyword
+// is one of stops, in which case it returns that keyword and the tag's
+// trimmed remaining content (e.g. the condition text of an "elif") without
+// consuming any more input.
+func (p *parser) parseUntil(stops ...string) (nodes []node, matchedKeyword, matchedRest string, err error) {
+	for {
+		ddIdx, dpIdx := strings.Index(p.src[p.pos:], "{{"), strings.Index(p.src[p.pos:], "{%")
+		nextTag, nextIdx := "", -1
+		switch {
+		case ddIdx == -1 && dpIdx == -1:
+			nextIdx = -1
+		case ddIdx == -1:
+			nextTag, nextIdx = "{%", dpIdx
+		case dpIdx == -1:
+			nextTag, nextIdx = "{{", ddIdx
+		case ddIdx < dpIdx:
+			nextTag, nextIdx = "{{", ddIdx
+		default:
+			nextTag, nextIdx = "{%", dpIdx
+		}
+
+		if nextIdx == -1 {
+			p.appendText(&nodes, p.src[p.pos:])
+			p.pos = len(p.src)
+			if len(stops) > 0 {
+				return nil, "", "", fmt.Errorf("chattmpl: unexpected end of template, expected one of %v", stops)
+			}
+			return nodes, "", "", nil
+		}
+
+		p.appendText(&nodes, p.src[p.pos:p.pos+nextIdx])
+		p.pos += nextIdx
+
+		if nextTag == "{{" {
+			content, trimLeft, err := p.consumeTag("{{", "}}")
+			if err != nil {
+				return nil, "", "", err
+			}
+			if trimLeft {
+				trimTrailingTextNode(&nodes)
+			}
+			e, err := parseExprString(content)
+			if err != nil {
+				return nil, "", "", err
+			}
+			nodes = append(nodes, outputNode{expr: e})
+			continue
+		}
+
+		content, trimLeft, err := p.consumeTag("{%", "%}")
+		if err != nil {
+			return nil, "", "", err
+		}
+		if trimLeft {
+			trimTrailingTextNode(&nodes)
+		}
+		keyword, rest := splitKeyword(content)
+
+		if contains(stops, keyword) {
+			return nodes, keyword, rest, nil
+		}
+
+		switch keyword {
+		case "if":
+			if err := p.parseIf(&nodes, rest); err != nil {
+				return nil, "", "", err
+			}
+		case "for":
+			if err := p.parseFor(&nodes, rest); err != nil {
+				return nil, "", "", err
+			}
+		default:
+			return nil, "", "", fmt.Errorf("chattmpl: unsupported tag %q", keyword)
+		}
+	}
+}
+
+func (p *parser) parseIf(nodes *[]node, condText string) error {
+	cond, err := parseExprString(condText)
+	if err != nil {
+		return err
+	}
+	body, kw, rest, err := p.parseUntil("elif", "else", "endif")
+	if err != nil {
+		return err
+	}
+	branches := []ifBranch{{cond: cond, body: body}}
+
+	for kw == "elif" {
+		nextCond, err := parseExprString(rest)
+		if err != nil {
+			return err
+		}
+		var nextBody []node
+		nextBody, kw, rest, err = p.parseUntil("elif", "else", "endif")
+		if err != nil {
+			return err
+		}
+		branches = append(branches, ifBranch{cond: nextCond, body: nextBody})
+	}
+
+	if kw == "else" {
+		elseBody, elseKw, _, err := p.parseUntil("endif")
+		if err != nil {
+			return err
+		}
+		branches = append(branches, ifBranch{cond: nil, body: elseBody})
+		kw = elseKw
+	}
+
+	if kw != "endif" {
+		return fmt.Errorf("chattmpl: if block missing {%% endif %%}")
+	}
+
+	*nodes = append(*nodes, ifNode{branches: branches})
+	return nil
+}
+
+func (p *parser) parseFor(nodes *[]node, rest string) error {
+	fields := strings.SplitN(rest, " in ", 2)
+	if len(fields) != 2 {
+		return fmt.Errorf("chattmpl: malformed for tag %q, expected \"x in expr\"", rest)
+	}
+	varName := strings.TrimSpace(fields[0])
+	iterable, err := parseExprString(fields[1])
+	if err != nil {
+		return err
+	}
+
+	body, kw, _, err := p.parseUntil("endfor")
+	if err != nil {
+		return err
+	}
+	if kw != "endfor" {
+		return fmt.Errorf("chattmpl: for block missing {%% endfor %%}")
+	}
+
+	*nodes = append(*nodes, forNode{varName: varName, iterable: iterable, body: body})
+	return nil
+}
+
+// consumeTag reads the content of a {{ }}/{% %} tag starting at p.pos
+// (which must be at the opening delimiter), handling "-" whitespace-trim
+// markers on either side, and leaves p.pos just past the closing delimiter.
+// trimLeft reports whether the tag opened with "{{-"/"{%-", which the
+// caller uses to retroactively trim the text node it just appended; a
+// trailing "-}}"/"-%}" is recorded on p.trimNextText instead, since the
+// text it affects may not be collected until after this tag returns.
+func (p *parser) consumeTag(open, close string) (content string, trimLeft bool, err error) {
+	start := p.pos + len(open)
+	if start < len(p.src) && p.src[start] == '-' {
+		trimLeft = true
+		start++
+	}
+
+	closeIdx := strings.Index(p.src[start:], close)
+	if closeIdx == -1 {
+		return "", false, fmt.Errorf("chattmpl: unterminated %q tag", open)
+	}
+	end := start + closeIdx
+
+	trimRight := false
+	if end > start && p.src[end-1] == '-' {
+		trimRight = true
+		end--
+	}
+	p.trimNextText = trimRight
+
+	p.pos = start + closeIdx + len(close)
+	return strings.TrimSpace(p.src[start:end]), trimLeft, nil
+}
+
+// trimTrailingTextNode right-trims whitespace off nodes' last entry if it's
+// a textNode, for a tag that opened with a "{{-"/"{%-" marker.
+func trimTrailingTextNode(nodes *[]node) {
+	n := len(*nodes)
+	if n == 0 {
+		return
+	}
+	if t, ok := (*nodes)[n-1].(textNode); ok {
+		(*nodes)[n-1] = textNode{text: strings.TrimRight(t.text, " \t\r\n")}
+	}
+}
+
+// appendText appends text as a textNode, applying a pending trim request
+// from a preceding "-%}"/"-}}" marker.
+func (p *parser) appendText(nodes *[]node, text string) {
+	if p.trimNextText {
+		text = strings.TrimLeft(text, " \t\r\n")
+		p.trimNextText = false
+	}
+	if text == "" {
+		return
+	}
+	*nodes = append(*nodes, textNode{text: text})
+}
+
+func splitKeyword(content string) (keyword, rest string) {
+	content = strings.TrimSpace(content)
+	idx := strings.IndexAny(content, " \t\n")
+	if idx == -1 {
+		return content, ""
+	}
+	return content[:idx], strings.TrimSpace(content[idx+1:])
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func parseExprString(src string) (expr, error) {
+	src = strings.TrimSpace(src)
+	ep, err := newExprParser(src)
+	if err != nil {
+		return nil, err
+	}
+	e, err := ep.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if ep.cur.kind != tokEOF {
+		return nil, fmt.Errorf("chattmpl: unexpected trailing input %q in expression %q", ep.cur.text, src)
+	}
+	return e, nil
+}
+
+// exprParser is a recursive-descent, precedence-climbing parser over the
+// token stream newExprLexer produces, covering: or, and, not, the
+// comparison operators (== != < <= > >= and membership "in"), "+" for both
+// numeric addition and string concatenation, attribute/index access,
+// .strip()/.startswith() method calls, and the raise_exception(...)
+// builtin.
+type exprParser struct {
+	lex *exprLexer
+	cur token
+}
+
+func newExprParser(src string) (*exprParser, error) {
+	ep := &exprParser{lex: newExprLexer(src)}
+	if err := ep.advance(); err != nil {
+		return nil, err
+	}
+	return ep, nil
+}
+
+func (ep *exprParser) advance() error {
+	t, err := ep.lex.next()
+	if err != nil {
+		return err
+	}
+	ep.cur = t
+	return nil
+}
+
+func (ep *exprParser) parseExpr() (expr, error) {
+	return ep.parseOr()
+}
+
+func (ep *exprParser) parseOr() (expr, error) {
+	left, err := ep.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for ep.cur.kind == tokIdent && ep.cur.text == "or" {
+		if err := ep.advance(); err != nil {
+			return nil, err
+		}
+		right, err := ep.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (ep *exprParser) parseAnd() (expr, error) {
+	left, err := ep.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for ep.cur.kind == tokIdent && ep.cur.text == "and" {
+		if err := ep.advance(); err != nil {
+			return nil, err
+		}
+		right, err := ep.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (ep *exprParser) parseNot() (expr, error) {
+	if ep.cur.kind == tokIdent && ep.cur.text == "not" {
+		if err := ep.advance(); err != nil {
+			return nil, err
+		}
+		operand, err := ep.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr{op: "not", operand: operand}, nil
+	}
+	return ep.parseComparison()
+}
+
+var comparisonOps = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+func (ep *exprParser) parseComparison() (expr, error) {
+	left, err := ep.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if ep.cur.kind == tokPunct && comparisonOps[ep.cur.text] {
+			op := ep.cur.text
+			if err := ep.advance(); err != nil {
+				return nil, err
+			}
+			right, err := ep.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			left = binaryExpr{op: op, left: left, right: right}
+			continue
+		}
+		if ep.cur.kind == tokIdent && ep.cur.text == "in" {
+			if err := ep.advance(); err != nil {
+				return nil, err
+			}
+			right, err := ep.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			left = binaryExpr{op: "in", left: left, right: right}
+			continue
+		}
+		break
+	}
+	return left, nil
+}
+
+func (ep *exprParser) parseAdditive() (expr, error) {
+	left, err := ep.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for ep.cur.kind == tokPunct && (ep.cur.text == "+" || ep.cur.text == "-") {
+		op := ep.cur.text
+		if err := ep.advance(); err != nil {
+			return nil, err
+		}
+		right, err := ep.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (ep *exprParser) parseUnary() (expr, error) {
+	if ep.cur.kind == tokPunct && ep.cur.text == "-" {
+		if err := ep.advance(); err != nil {
+			return nil, err
+		}
+		operand, err := ep.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr{op: "-", operand: operand}, nil
+	}
+	return ep.parsePostfix()
+}
+
+func (ep *exprParser) parsePostfix() (expr, error) {
+	primary, err := ep.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case ep.cur.kind == tokPunct && ep.cur.text == ".":
+			if err := ep.advance(); err != nil {
+				return nil, err
+			}
+			if ep.cur.kind != tokIdent {
+				return nil, fmt.Errorf("chattmpl: expected identifier after \".\"")
+			}
+			name := ep.cur.text
+			if err := ep.advance(); err != nil {
+				return nil, err
+			}
+			if ep.cur.kind == tokPunct && ep.cur.text == "(" {
+				args, err := ep.parseArgs()
+				if err != nil {
+					return nil, err
+				}
+				primary = methodCall{target: primary, name: name, args: args}
+			} else {
+				primary = attrAccess{target: primary, name: name}
+			}
+
+		case ep.cur.kind == tokPunct && ep.cur.text == "[":
+			if err := ep.advance(); err != nil {
+				return nil, err
+			}
+			idx, err := ep.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := ep.expectPunct("]"); err != nil {
+				return nil, err
+			}
+			primary = indexAccess{target: primary, index: idx}
+
+		case ep.cur.kind == tokPunct && ep.cur.text == "(":
+			ref, ok := primary.(varRef)
+			if !ok {
+				return nil, fmt.Errorf("chattmpl: cannot call a non-function expression")
+			}
+			args, err := ep.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			primary = callExpr{name: ref.name, args: args}
+
+		default:
+			return primary, nil
+		}
+	}
+}
+
+func (ep *exprParser) parseArgs() ([]expr, error) {
+	if err := ep.advance(); err != nil { // consume "("
+		return nil, err
+	}
+	var args []expr
+	if ep.cur.kind == tokPunct && ep.cur.text == ")" {
+		return args, ep.advance()
+	}
+	for {
+		a, err := ep.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+		if ep.cur.kind == tokPunct && ep.cur.text == "," {
+			if err := ep.advance(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		break
+	}
+	if err := ep.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+func (ep *exprParser) parsePrimary() (expr, error) {
+	switch ep.cur.kind {
+	case tokString:
+		lit := stringLit{value: ep.cur.text}
+		return lit, ep.advance()
+	case tokNumber:
+		f, err := strconv.ParseFloat(ep.cur.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("chattmpl: invalid number %q", ep.cur.text)
+		}
+		return numberLit{value: f}, ep.advance()
+	case tokIdent:
+		switch ep.cur.text {
+		case "true", "True":
+			return boolLit{value: true}, ep.advance()
+		case "false", "False":
+			return boolLit{value: false}, ep.advance()
+		case "none", "None", "null":
+			return noneLit{}, ep.advance()
+		default:
+			return varRef{name: ep.cur.text}, ep.advance()
+		}
+	case tokPunct:
+		if ep.cur.text == "(" {
+			if err := ep.advance(); err != nil {
+				return nil, err
+			}
+			e, err := ep.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := ep.expectPunct(")"); err != nil {
+				return nil, err
+			}
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("chattmpl: unexpected token %q", ep.cur.text)
+}
+
+// v2expr returns e unchanged. Nothing calls it: parsePrimary's
+// "return x, ep.advance()" returns need no helper.
+func v2expr(e expr) expr { return e }
+
+func (ep *exprParser) expectPunct(p string) error {
+	if ep.cur.kind != tokPunct || ep.cur.text != p {
+		return fmt.Errorf("chattmpl: expected %q, got %q", p, ep.cur.text)
+	}
+	return ep.advance()
+}
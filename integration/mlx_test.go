@@ -3,15 +3,19 @@ package integration
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/integration/mlxtest"
 	"github.com/ollama/ollama/llm"
 )
 
@@ -62,31 +66,10 @@ func TestMLXBackendLoading(t *testing.T) {
 
 // TestMLXCompletion tests text generation with MLX models
 func TestMLXCompletion(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping MLX completion test in short mode")
-	}
-
-	// This test requires the ollama server to be running
-	// and a model to be available
-
-	// Check if server is running
-	resp, err := http.Get("http://localhost:11434/api/version")
-	if err != nil {
-		t.Skip("ollama server not running, skipping test")
-	}
-	resp.Body.Close()
-
-	// Use a small test model
 	testModel := "mlx-community/SmolLM2-135M-Instruct-4bit"
-
-	// Check if model exists
-	manager := llm.NewMLXModelManager()
-	if !manager.ModelExists(testModel) {
-		t.Skipf("test model %s not available", testModel)
-	}
+	srv := mlxtest.Start(t, mlxtest.WithModel(testModel))
 
 	// Test completion endpoint
-	client := &http.Client{}
 	reqBody := map[string]interface{}{
 		"model":  testModel,
 		"prompt": "Hello",
@@ -97,24 +80,9 @@ func TestMLXCompletion(t *testing.T) {
 		},
 	}
 
-	reqBytes, _ := json.Marshal(reqBody)
-	req, _ := http.NewRequest("POST", "http://localhost:11434/api/generate", strings.NewReader(string(reqBytes)))
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err = client.Do(req)
-	if err != nil {
-		t.Fatalf("Failed to call generate endpoint: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, string(body))
-	}
-
 	var result api.GenerateResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		t.Fatalf("Failed to decode response: %v", err)
+	if err := srv.PostJSON(context.Background(), "/api/generate", reqBody, &result); err != nil {
+		t.Fatalf("Failed to call generate endpoint: %v", err)
 	}
 
 	if result.Model != testModel {
@@ -124,32 +92,81 @@ func TestMLXCompletion(t *testing.T) {
 	if result.Response == "" {
 		t.Error("Expected non-empty response")
 	}
-}
 
-// TestMLXStreaming tests streaming responses from MLX models
-func TestMLXStreaming(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping MLX streaming test in short mode")
+	if result.EvalCount <= 0 {
+		t.Errorf("Expected EvalCount > 0, got %d", result.EvalCount)
 	}
 
-	// This test requires the ollama server to be running
-	resp, err := http.Get("http://localhost:11434/api/version")
-	if err != nil {
-		t.Skip("ollama server not running, skipping test")
+	// The test harness has no tokenizer of its own to compute the exact
+	// prompt length against, so this only checks that the MLX runner
+	// reported tokenizing the prompt at all, not the precise count.
+	if result.PromptEvalCount <= 0 {
+		t.Errorf("Expected PromptEvalCount > 0, got %d", result.PromptEvalCount)
 	}
-	resp.Body.Close()
 
-	// Use a small test model
-	testModel := "mlx-community/SmolLM2-135M-Instruct-4bit"
+	t.Run("tool calling", func(t *testing.T) {
+		weatherTool := map[string]interface{}{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        "get_weather",
+				"description": "Get the current weather for a city.",
+				"parameters": map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"city": map[string]interface{}{"type": "string"}},
+					"required":   []string{"city"},
+				},
+			},
+		}
 
-	// Check if model exists
-	manager := llm.NewMLXModelManager()
-	if !manager.ModelExists(testModel) {
-		t.Skipf("test model %s not available", testModel)
-	}
+		cases := []struct {
+			name       string
+			prompt     string
+			toolChoice string
+		}{
+			{"auto lets the model decide whether to call the tool", "What's the weather like in Paris?", "auto"},
+			{"named tool choice steers the model toward get_weather", "What's the weather like in Paris?", "get_weather"},
+		}
+
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				reqBody := map[string]interface{}{
+					"model":       testModel,
+					"messages":    []map[string]string{{"role": "user", "content": tc.prompt}},
+					"stream":      false,
+					"tools":       []interface{}{weatherTool},
+					"tool_choice": tc.toolChoice,
+				}
+
+				var chatResp api.ChatResponse
+				if err := srv.PostJSON(context.Background(), "/api/chat", reqBody, &chatResp); err != nil {
+					t.Fatalf("Failed to call chat endpoint: %v", err)
+				}
+
+				// The model may answer in plain text instead of calling the
+				// tool even with tool_choice steering it - these are small
+				// instruct models without guaranteed tool-calling behavior -
+				// so this only checks that a tool call, when present, comes
+				// back well-formed rather than requiring one every run.
+				for _, call := range chatResp.Message.ToolCalls {
+					if call.Function.Name != "get_weather" {
+						t.Errorf("unexpected tool call %q", call.Function.Name)
+						continue
+					}
+					if _, ok := call.Function.Arguments["city"]; !ok {
+						t.Errorf("get_weather call missing required \"city\" argument: %+v", call.Function.Arguments)
+					}
+				}
+			})
+		}
+	})
+}
+
+// TestMLXStreaming tests streaming responses from MLX models
+func TestMLXStreaming(t *testing.T) {
+	testModel := "mlx-community/SmolLM2-135M-Instruct-4bit"
+	srv := mlxtest.Start(t, mlxtest.WithModel(testModel))
 
 	// Test streaming completion endpoint
-	client := &http.Client{}
 	reqBody := map[string]interface{}{
 		"model":  testModel,
 		"prompt": "Why is the sky blue?",
@@ -160,11 +177,7 @@ func TestMLXStreaming(t *testing.T) {
 		},
 	}
 
-	reqBytes, _ := json.Marshal(reqBody)
-	req, _ := http.NewRequest("POST", "http://localhost:11434/api/generate", strings.NewReader(string(reqBytes)))
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err = client.Do(req)
+	resp, err := srv.PostStream(context.Background(), "/api/generate", reqBody)
 	if err != nil {
 		t.Fatalf("Failed to call generate endpoint: %v", err)
 	}
@@ -340,29 +353,12 @@ func TestMLXvsGGUFCompatibility(t *testing.T) {
 
 // BenchmarkMLXPerformance benchmarks MLX model performance
 func BenchmarkMLXPerformance(b *testing.B) {
-	if testing.Short() {
-		b.Skip("skipping MLX benchmark in short mode")
-	}
-
-	// This benchmark requires the ollama server to be running
-	resp, err := http.Get("http://localhost:11434/api/version")
-	if err != nil {
-		b.Skip("ollama server not running, skipping benchmark")
-	}
-	resp.Body.Close()
-
-	// Use a small test model
 	testModel := "mlx-community/SmolLM2-135M-Instruct-4bit"
-
-	// Check if model exists
-	manager := llm.NewMLXModelManager()
-	if !manager.ModelExists(testModel) {
-		b.Skipf("test model %s not available", testModel)
-	}
+	srv := mlxtest.Start(b, mlxtest.WithModel(testModel))
 
 	// Warm up
 	for i := 0; i < 5; i++ {
-		if _, err := generateText(testModel, "Warm up"); err != nil {
+		if _, err := generateText(srv, testModel, "Warm up"); err != nil {
 			b.Skipf("generate warmup failed: %v", err)
 		}
 	}
@@ -370,15 +366,14 @@ func BenchmarkMLXPerformance(b *testing.B) {
 	// Benchmark
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		if _, err := generateText(testModel, "Why is the sky blue?"); err != nil {
+		if _, err := generateText(srv, testModel, "Why is the sky blue?"); err != nil {
 			b.Fatalf("generate failed: %v", err)
 		}
 	}
 }
 
 // generateText generates text using the MLX model
-func generateText(model, prompt string) (string, error) {
-	client := &http.Client{}
+func generateText(srv *mlxtest.Server, model, prompt string) (string, error) {
 	reqBody := map[string]interface{}{
 		"model":  model,
 		"prompt": prompt,
@@ -389,25 +384,67 @@ func generateText(model, prompt string) (string, error) {
 		},
 	}
 
-	reqBytes, _ := json.Marshal(reqBody)
-	req, _ := http.NewRequest("POST", "http://localhost:11434/api/generate", strings.NewReader(string(reqBytes)))
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := client.Do(req)
-	if err != nil {
+	var result api.GenerateResponse
+	if err := srv.PostJSON(context.Background(), "/api/generate", reqBody, &result); err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("status %d: %s", resp.StatusCode, string(body))
+	return result.Response, nil
+}
+
+// TestMLXGalleryPreload exercises the gallery/preload path end to end
+// against fake HTTP servers (a gallery manifest and a fake weights host, via
+// OLLAMA_MLX_GALLERY_URLS and OLLAMA_MLX_BASE_URL), so it doesn't need
+// internet access the way TestMLXModelPull does.
+func TestMLXGalleryPreload(t *testing.T) {
+	const testModel = "mlx-community/gallery-preload-test"
+	weights := []byte("fake mlx weights for a preload test")
+	sum := sha256.Sum256(weights)
+	weightsDigest := hex.EncodeToString(sum[:])
+
+	weightsSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "weights.npz"):
+			w.Write(weights)
+		default:
+			w.Write([]byte("{}"))
+		}
+	}))
+	defer weightsSrv.Close()
+
+	manifest := fmt.Sprintf(`{"models":[{"name":%q,"license":"MIT","shards":{"weights.npz":"sha256:%s"}}]}`, testModel, weightsDigest)
+	gallerySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(manifest))
+	}))
+	defer gallerySrv.Close()
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_MLX_BASE_URL", weightsSrv.URL)
+	t.Setenv("OLLAMA_MLX_GALLERY_URLS", gallerySrv.URL)
+	t.Setenv("OLLAMA_PRELOAD_MODELS", testModel)
+
+	manager := llm.NewMLXModelManager()
+
+	catalog, err := manager.FetchGallery(context.Background())
+	if err != nil {
+		t.Fatalf("FetchGallery() error = %v", err)
+	}
+	if len(catalog) != 1 || catalog[0].Name != testModel {
+		t.Fatalf("FetchGallery() = %+v, want one entry named %s", catalog, testModel)
 	}
 
-	var result api.GenerateResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
+	var progressed bool
+	err = manager.PreloadModelsFromEnv(context.Background(), func(model, status string, completed, total int64) {
+		progressed = true
+	})
+	if err != nil {
+		t.Fatalf("PreloadModelsFromEnv() error = %v", err)
+	}
+	if !progressed {
+		t.Error("expected PreloadModelsFromEnv to report progress")
 	}
 
-	return result.Response, nil
+	if !manager.ModelExists(testModel) {
+		t.Fatal("expected preloaded model to exist")
+	}
 }
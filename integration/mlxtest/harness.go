@@ -0,0 +1,290 @@
+// Package mlxtest is a small harness for MLX integration tests: it builds
+// and launches the real ollama server binary against an isolated, temporary
+// model root, waits for it to come up, and hands the test a base URL plus
+// an HTTP client and teardown (via t.Cleanup) - replacing the "skip unless
+// something happens to already be listening on localhost:11434" pattern the
+// MLX integration tests used before this package existed.
+package mlxtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/llm"
+)
+
+// Option configures a Server before Start launches it.
+type Option func(*config)
+
+type config struct {
+	models []string
+}
+
+// WithModel arranges for ref to be present in the harness's model root
+// before the test body runs: a fixture under MLX_TEST_FIXTURE_DIR (named
+// after ref the same way llm.MLXModelManager.GetModelPath flattens it, e.g.
+// "mlx-community/SmolLM2-135M-Instruct-4bit" -> "mlx-community_SmolLM2-135M-Instruct-4bit")
+// is copied in if the env var is set and the fixture exists, otherwise ref
+// is downloaded the normal way, which requires internet access.
+func WithModel(ref string) Option {
+	return func(c *config) { c.models = append(c.models, ref) }
+}
+
+// Server is a running ollama server instance under test, isolated to its
+// own model root and port.
+type Server struct {
+	BaseURL   string
+	ModelsDir string
+	HTTP      *http.Client
+
+	cmd *exec.Cmd
+}
+
+// Start builds the ollama binary (once per test binary run), launches it
+// against a fresh model root with every WithModel option applied, waits for
+// /api/version to answer, and registers a teardown that kills the process
+// when the test completes.
+func Start(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping MLX harness test in short mode")
+	}
+
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	bin := buildServerBinary(t)
+
+	modelsDir := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", modelsDir)
+	manager := llm.NewMLXModelManager()
+	for _, ref := range cfg.models {
+		if err := seedModel(t, manager, ref); err != nil {
+			t.Fatalf("mlxtest: %v", err)
+		}
+	}
+
+	port, err := freePort()
+	if err != nil {
+		t.Fatalf("mlxtest: failed to reserve a port: %v", err)
+	}
+	baseURL := fmt.Sprintf("http://127.0.0.1:%d", port)
+
+	logPath := filepath.Join(t.TempDir(), "ollama-serve.log")
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		t.Fatalf("mlxtest: failed to create server log file: %v", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(bin, "serve")
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("OLLAMA_HOST=127.0.0.1:%d", port),
+		fmt.Sprintf("OLLAMA_MODELS=%s", modelsDir),
+	)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("mlxtest: failed to start ollama serve: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if cmd.Process != nil {
+			cmd.Process.Kill()
+		}
+		cmd.Wait()
+	})
+
+	if err := waitForHealthy(baseURL, 30*time.Second); err != nil {
+		out, _ := os.ReadFile(logPath)
+		t.Fatalf("mlxtest: server did not become healthy: %v\nserver output:\n%s", err, out)
+	}
+
+	return &Server{
+		BaseURL:   baseURL,
+		ModelsDir: modelsDir,
+		HTTP:      &http.Client{},
+		cmd:       cmd,
+	}
+}
+
+// PostJSON marshals body as JSON, POSTs it to path, and decodes a 200
+// response into out (if non-nil). Use PostStream instead for a streaming
+// request, since this reads and closes the whole response body itself.
+func (s *Server) PostJSON(ctx context.Context, path string, body, out any) error {
+	resp, err := s.PostStream(ctx, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("mlxtest: %s: status %d: %s", path, resp.StatusCode, respBody)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// PostStream marshals body as JSON and POSTs it to path, returning the raw
+// response so a caller can read a streaming NDJSON body itself; the caller
+// owns closing resp.Body.
+func (s *Server) PostStream(ctx context.Context, path string, body any) (*http.Response, error) {
+	reqBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("mlxtest: failed to marshal request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.BaseURL+path, bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return s.HTTP.Do(req)
+}
+
+var (
+	buildOnce sync.Once
+	buildErr  error
+	binPath   string
+)
+
+// buildServerBinary builds the ollama CLI binary once per test binary run
+// (mirrors TestCompiledMLXRunnerLaunches's go build step for cmd/runner)
+// and caches its path for every Start call in the same process.
+func buildServerBinary(t testing.TB) string {
+	t.Helper()
+	buildOnce.Do(func() {
+		projectRoot, err := filepath.Abs("..")
+		if err != nil {
+			buildErr = fmt.Errorf("failed to resolve project root: %w", err)
+			return
+		}
+
+		dir, err := os.MkdirTemp("", "mlxtest-bin-*")
+		if err != nil {
+			buildErr = fmt.Errorf("failed to create build directory: %w", err)
+			return
+		}
+
+		bin := filepath.Join(dir, "ollama")
+		if runtime.GOOS == "windows" {
+			bin += ".exe"
+		}
+
+		build := exec.Command("go", "build", "-o", bin, ".")
+		build.Dir = projectRoot
+		build.Env = os.Environ()
+		if output, err := build.CombinedOutput(); err != nil {
+			buildErr = fmt.Errorf("failed to build ollama binary: %w\n%s", err, output)
+			return
+		}
+		binPath = bin
+	})
+	if buildErr != nil {
+		t.Fatalf("mlxtest: %v", buildErr)
+	}
+	return binPath
+}
+
+// seedModel makes sure ref is present under manager's model root, either by
+// copying a pre-seeded fixture from MLX_TEST_FIXTURE_DIR or, absent that,
+// downloading it the normal way.
+func seedModel(t testing.TB, manager *llm.MLXModelManager, ref string) error {
+	t.Helper()
+	if manager.ModelExists(ref) {
+		return nil
+	}
+
+	if fixtureDir := os.Getenv("MLX_TEST_FIXTURE_DIR"); fixtureDir != "" {
+		localName := strings.ReplaceAll(ref, "/", "_")
+		src := filepath.Join(fixtureDir, localName)
+		if _, err := os.Stat(src); err != nil {
+			return fmt.Errorf("fixture for %s not found under %s: %w", ref, fixtureDir, err)
+		}
+		return copyDir(src, manager.GetModelPath(ref))
+	}
+
+	return manager.DownloadMLXModel(context.Background(), ref, func(status string, completed, total int64) {
+		t.Logf("mlxtest: downloading %s: %s (%d/%d bytes)", ref, status, completed, total)
+	})
+}
+
+// copyDir recursively copies src onto dst, for seeding a model fixture into
+// a test's model root.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, info.Mode())
+	})
+}
+
+// freePort reserves an unused TCP port on 127.0.0.1 for the server process
+// to bind; there's an inherent, accepted race between closing the listener
+// here and the child process binding the same port.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// waitForHealthy polls baseURL's /api/version until it answers 200 or
+// timeout elapses.
+func waitForHealthy(baseURL string, timeout time.Duration) error {
+	client := &http.Client{Timeout: 2 * time.Second}
+	deadline := time.Now().Add(timeout)
+
+	var lastErr error
+	for time.Now().Before(deadline) {
+		resp, err := client.Get(baseURL + "/api/version")
+		if err != nil {
+			lastErr = err
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			lastErr = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("timed out after %s: %w", timeout, lastErr)
+}
@@ -0,0 +1,71 @@
+package mlxtest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWaitForHealthySucceedsOnceVersionAnswers(t *testing.T) {
+	var ready bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ready || r.URL.Path != "/api/version" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ready = true
+	}()
+
+	if err := waitForHealthy(srv.URL, 2*time.Second); err != nil {
+		t.Fatalf("waitForHealthy() error = %v", err)
+	}
+}
+
+func TestWaitForHealthyTimesOutAgainstADeadServer(t *testing.T) {
+	if err := waitForHealthy("http://127.0.0.1:1", 200*time.Millisecond); err == nil {
+		t.Fatal("expected waitForHealthy to time out against an unreachable server")
+	}
+}
+
+func TestCopyDirPreservesStructureAndContents(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "config.json"), []byte(`{"a":1}`), 0o644); err != nil {
+		t.Fatalf("failed to write fixture file: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(src, "shards"), 0o755); err != nil {
+		t.Fatalf("failed to create fixture subdirectory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "shards", "weights.npz"), []byte("fake weights"), 0o644); err != nil {
+		t.Fatalf("failed to write fixture shard: %v", err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "model")
+	if err := copyDir(src, dst); err != nil {
+		t.Fatalf("copyDir() error = %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dst, "shards", "weights.npz"))
+	if err != nil {
+		t.Fatalf("failed to read copied shard: %v", err)
+	}
+	if string(got) != "fake weights" {
+		t.Errorf("copied shard content = %q, want %q", got, "fake weights")
+	}
+
+	got, err = os.ReadFile(filepath.Join(dst, "config.json"))
+	if err != nil {
+		t.Fatalf("failed to read copied config: %v", err)
+	}
+	if string(got) != `{"a":1}` {
+		t.Errorf("copied config content = %q, want %q", got, `{"a":1}`)
+	}
+}
@@ -0,0 +1,30 @@
+package llm
+
+// RunnerState is one snapshot of a loaded MLX runner's health, pushed over
+// its control-plane connection (see runner/mlxrunner's handleControlPlane
+// and server's superviseControlPlane) so the server can react to memory
+// pressure, a crash, or load changes without polling for them the way
+// RunnerSupervisor.watch's health check already has to.
+type RunnerState struct {
+	LoadedModels     []string `json:"loaded_models"`
+	KVCacheBytes     int64    `json:"kv_cache_bytes"`
+	WiredMemoryBytes int64    `json:"wired_memory_bytes"`
+	InFlightRequests int      `json:"in_flight_requests"`
+
+	// Metal is this runner's MLX Metal device memory stats, as reported by
+	// mlx.core.metal.get_active_memory/get_cache_memory/get_peak_memory.
+	// Like KVCacheBytes above, it's always its zero value today: the actual
+	// MLX inference (and the only process that can call those APIs) lives
+	// in the out-of-process Python backend, which this Go-side code has no
+	// instrumentation hook into yet. The field exists so a future hook only
+	// has to populate it, not add it.
+	Metal MetalDeviceStats `json:"metal"`
+}
+
+// MetalDeviceStats mirrors the three counters mlx.core.metal's memory
+// introspection functions report, in bytes.
+type MetalDeviceStats struct {
+	ActiveMemoryBytes int64 `json:"active_memory_bytes"`
+	CacheMemoryBytes  int64 `json:"cache_memory_bytes"`
+	PeakMemoryBytes   int64 `json:"peak_memory_bytes"`
+}
@@ -1,6 +1,9 @@
 package llm
 
 import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -27,6 +30,230 @@ func (f ModelFormat) String() string {
 	}
 }
 
+// ModelInfo is a richer classification of a model directory than ModelFormat
+// alone. It is derived from actually inspecting the safetensors tensor
+// header and config.json instead of just checking for the presence of
+// well-known file names, so it can tell an MLX-quantized checkpoint (as
+// produced by mlx_lm convert/quantize) apart from a vanilla HuggingFace
+// transformers safetensors checkpoint that merely happens to sit next to a
+// config.json.
+type ModelInfo struct {
+	Format ModelFormat
+
+	// QuantizationBits and GroupSize come from config.json's "quantization"
+	// block, which mlx_lm writes as {"group_size": N, "bits": N}. Both are
+	// zero when the checkpoint is unquantized or the block is absent.
+	QuantizationBits int
+	GroupSize        int
+
+	// ParameterCount is a rough element count estimate, summed from the
+	// "shape" of every tensor in the safetensors header. It is 0 if no
+	// safetensors file could be read.
+	ParameterCount int64
+}
+
+// safetensorsTensorEntry is one value in a safetensors JSON header, keyed by
+// tensor name (see readSafetensorsHeader).
+type safetensorsTensorEntry struct {
+	Dtype string  `json:"dtype"`
+	Shape []int64 `json:"shape"`
+}
+
+// readSafetensorsHeader reads the JSON tensor header embedded at the start
+// of a .safetensors file: an 8-byte little-endian length prefix followed by
+// that many bytes of JSON, mapping each tensor name to its dtype/shape (plus
+// an optional "__metadata__" entry holding free-form string metadata).
+func readSafetensorsHeader(path string) (map[string]json.RawMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lenBytes [8]byte
+	if _, err := io.ReadFull(f, lenBytes[:]); err != nil {
+		return nil, err
+	}
+
+	headerLen := binary.LittleEndian.Uint64(lenBytes[:])
+	// A legitimate safetensors header is at most a few MB of JSON; anything
+	// wildly larger means this isn't really a safetensors file.
+	if headerLen == 0 || headerLen > 64*1024*1024 {
+		return nil, &safetensorsHeaderError{path: path}
+	}
+
+	headerBytes := make([]byte, headerLen)
+	if _, err := io.ReadFull(f, headerBytes); err != nil {
+		return nil, err
+	}
+
+	var header map[string]json.RawMessage
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, err
+	}
+
+	return header, nil
+}
+
+type safetensorsHeaderError struct {
+	path string
+}
+
+func (e *safetensorsHeaderError) Error() string {
+	return e.path + ": implausible safetensors header length"
+}
+
+// inspectSafetensorsTensors scans a safetensors header for MLX's
+// quantization naming convention (mlx_lm.quantize emits "*.scales" and
+// "*.biases" tensors alongside each quantized weight) and sums up a rough
+// parameter count from every tensor's shape.
+func inspectSafetensorsTensors(header map[string]json.RawMessage) (isMLXQuantizedLayout bool, paramCount int64) {
+	for name, raw := range header {
+		if name == "__metadata__" {
+			continue
+		}
+
+		if strings.HasSuffix(name, ".scales") || strings.HasSuffix(name, ".biases") {
+			isMLXQuantizedLayout = true
+		}
+
+		var entry safetensorsTensorEntry
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			continue
+		}
+
+		count := int64(1)
+		for _, dim := range entry.Shape {
+			count *= dim
+		}
+		paramCount += count
+	}
+
+	return isMLXQuantizedLayout, paramCount
+}
+
+// mlxQuantizationConfig mirrors the "quantization" block mlx_lm writes into
+// a quantized model's config.json, e.g. {"group_size": 64, "bits": 4}.
+type mlxQuantizationConfig struct {
+	GroupSize int `json:"group_size"`
+	Bits      int `json:"bits"`
+}
+
+// readMLXQuantizationConfig reports whether modelPath/config.json declares
+// an mlx_lm-style quantization block.
+func readMLXQuantizationConfig(modelPath string) (mlxQuantizationConfig, bool) {
+	data, err := os.ReadFile(filepath.Join(modelPath, "config.json"))
+	if err != nil {
+		return mlxQuantizationConfig{}, false
+	}
+
+	var cfg struct {
+		Quantization *mlxQuantizationConfig `json:"quantization"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil || cfg.Quantization == nil {
+		return mlxQuantizationConfig{}, false
+	}
+
+	return *cfg.Quantization, true
+}
+
+// findSafetensorsFile returns the path to modelPath's primary safetensors
+// file (model.safetensors, or the first shard of a sharded checkpoint), or
+// "" if none is present.
+func findSafetensorsFile(modelPath string) string {
+	direct := filepath.Join(modelPath, "model.safetensors")
+	if fileExists(direct) {
+		return direct
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(modelPath, "*.safetensors"))
+	if len(matches) > 0 {
+		return matches[0]
+	}
+
+	return ""
+}
+
+// detectMLXModelDir inspects a local model directory's safetensors header
+// and config.json and reports whether the directory looks like an MLX model
+// and, if so, the richer ModelInfo describing it. A quantized MLX checkpoint
+// is recognised by config.json's quantization block or by .scales/.biases
+// tensors. Any other directory holding safetensors and a config.json is also
+// reported as MLX, so an unquantized HuggingFace transformers checkpoint is
+// not told apart from an unquantized MLX one. A directory with neither
+// safetensors nor weights.npz (such as a GGUF-in-a-directory layout) is not
+// reported as MLX.
+func detectMLXModelDir(modelPath string) (ModelInfo, bool) {
+	safetensorsPath := findSafetensorsFile(modelPath)
+	if safetensorsPath == "" {
+		// Legacy MLX dumps used weights.npz instead of safetensors; we can't
+		// inspect those further, but config.json + weights.npz together is
+		// still a reliable MLX signal.
+		configPath := filepath.Join(modelPath, "config.json")
+		weightsPath := filepath.Join(modelPath, "weights.npz")
+		if fileExists(configPath) && fileExists(weightsPath) {
+			return ModelInfo{Format: ModelFormatMLX}, true
+		}
+		return ModelInfo{}, false
+	}
+
+	header, err := readSafetensorsHeader(safetensorsPath)
+	if err != nil {
+		return ModelInfo{}, false
+	}
+
+	isMLXQuantizedLayout, paramCount := inspectSafetensorsTensors(header)
+	info := ModelInfo{ParameterCount: paramCount}
+
+	if quant, ok := readMLXQuantizationConfig(modelPath); ok {
+		info.Format = ModelFormatMLX
+		info.QuantizationBits = quant.Bits
+		info.GroupSize = quant.GroupSize
+		return info, true
+	}
+
+	if isMLXQuantizedLayout {
+		info.Format = ModelFormatMLX
+		return info, true
+	}
+
+	// Neither signal fired, which is also what an unquantized mlx_lm
+	// convert output looks like: mlx_lm only adds the "quantization" block
+	// and .scales/.biases tensors when -q was passed, and otherwise copies
+	// the original HF config.json and safetensors layout verbatim, so
+	// nothing in the file contents actually distinguishes it from a vanilla
+	// HF transformers checkpoint. Fall back to the same config.json +
+	// safetensors heuristic this function's predecessor used rather than
+	// reporting false here, since doing so regressed plain, non-4bit
+	// mlx-community checkpoints to ModelFormatGGUF.
+	if fileExists(filepath.Join(modelPath, "config.json")) {
+		info.Format = ModelFormatMLX
+		return info, true
+	}
+
+	return ModelInfo{}, false
+}
+
+// DetectModelInfo is like DetectModelFormat but additionally parses the
+// safetensors tensor header and config.json's quantization block, so it can
+// report quantization bits/group size and a parameter count estimate
+// alongside the format.
+func DetectModelInfo(modelPath string) ModelInfo {
+	ext := strings.ToLower(filepath.Ext(modelPath))
+	if ext == ".gguf" {
+		return ModelInfo{Format: ModelFormatGGUF}
+	}
+
+	info, err := os.Stat(modelPath)
+	if err == nil && info.IsDir() {
+		if mlxInfo, ok := detectMLXModelDir(modelPath); ok {
+			return mlxInfo
+		}
+	}
+
+	return ModelInfo{Format: DetectModelFormat(modelPath)}
+}
+
 // DetectModelFormat determines the format of a model based on its path and metadata
 func DetectModelFormat(modelPath string) ModelFormat {
 	// Check file extension first
@@ -40,18 +267,11 @@ func DetectModelFormat(modelPath string) ModelFormat {
 	// Check if it's a directory (typical for MLX models)
 	info, err := os.Stat(modelPath)
 	if err == nil && info.IsDir() {
-		// MLX models are typically directories containing:
-		// - config.json
-		// - model.safetensors or weights.npz
-		// - tokenizer.json or tokenizer.model
-
-		// Check for MLX model indicators
-		configPath := filepath.Join(modelPath, "config.json")
-		safetensorsPath := filepath.Join(modelPath, "model.safetensors")
-		weightsPath := filepath.Join(modelPath, "weights.npz")
-
-		if fileExists(configPath) && (fileExists(safetensorsPath) || fileExists(weightsPath)) {
-			return ModelFormatMLX
+		// Parse the safetensors header and config.json (see
+		// detectMLXModelDir for which layouts count as MLX; an unquantized HF
+		// transformers checkpoint next to a config.json counts as MLX too).
+		if mlxInfo, ok := detectMLXModelDir(modelPath); ok {
+			return mlxInfo.Format
 		}
 	}
 
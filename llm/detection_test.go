@@ -0,0 +1,118 @@
+package llm
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFakeSafetensors writes a minimal valid safetensors file containing
+// only a header (no tensor data), matching the subset readSafetensorsHeader
+// actually parses: an 8-byte little-endian length prefix followed by that
+// many bytes of header JSON.
+func writeFakeSafetensors(t *testing.T, path string, header map[string]any) {
+	t.Helper()
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		t.Fatalf("failed to marshal fake safetensors header: %v", err)
+	}
+
+	var lenBytes [8]byte
+	binary.LittleEndian.PutUint64(lenBytes[:], uint64(len(data)))
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create fake safetensors file: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(lenBytes[:]); err != nil {
+		t.Fatalf("failed to write safetensors length prefix: %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("failed to write safetensors header: %v", err)
+	}
+}
+
+func TestDetectMLXModelDirQuantizedCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	writeFakeSafetensors(t, filepath.Join(dir, "model.safetensors"), map[string]any{
+		"model.layers.0.weight":        map[string]any{"dtype": "U8", "shape": []int64{4, 4}},
+		"model.layers.0.weight.scales": map[string]any{"dtype": "F16", "shape": []int64{4}},
+		"model.layers.0.weight.biases": map[string]any{"dtype": "F16", "shape": []int64{4}},
+	})
+	configJSON := `{"model_type":"llama","quantization":{"group_size":64,"bits":4}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(configJSON), 0o644); err != nil {
+		t.Fatalf("failed to write config.json: %v", err)
+	}
+
+	info, ok := detectMLXModelDir(dir)
+	if !ok {
+		t.Fatal("detectMLXModelDir() ok = false, want true")
+	}
+	if info.Format != ModelFormatMLX {
+		t.Errorf("detectMLXModelDir() Format = %v, want %v", info.Format, ModelFormatMLX)
+	}
+	if info.QuantizationBits != 4 || info.GroupSize != 64 {
+		t.Errorf("detectMLXModelDir() bits/group = %d/%d, want 4/64", info.QuantizationBits, info.GroupSize)
+	}
+}
+
+// TestDetectMLXModelDirUnquantizedCheckpoint covers a plain, unquantized
+// mlx_lm-converted checkpoint - a normal fp16/bf16 safetensors export with
+// no "quantization" block and no .scales/.biases tensors, as produced by
+// `mlx_lm.convert` without -q (common for non-4bit mlx-community models).
+// It must still be recognized as MLX rather than falling through to
+// ModelFormatGGUF.
+func TestDetectMLXModelDirUnquantizedCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	writeFakeSafetensors(t, filepath.Join(dir, "model.safetensors"), map[string]any{
+		"model.layers.0.weight": map[string]any{"dtype": "F16", "shape": []int64{4, 4}},
+	})
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"model_type":"llama"}`), 0o644); err != nil {
+		t.Fatalf("failed to write config.json: %v", err)
+	}
+
+	info, ok := detectMLXModelDir(dir)
+	if !ok {
+		t.Fatal("detectMLXModelDir() ok = false, want true")
+	}
+	if info.Format != ModelFormatMLX {
+		t.Errorf("detectMLXModelDir() Format = %v, want %v", info.Format, ModelFormatMLX)
+	}
+	if info.QuantizationBits != 0 || info.GroupSize != 0 {
+		t.Errorf("detectMLXModelDir() bits/group = %d/%d, want 0/0 for an unquantized checkpoint", info.QuantizationBits, info.GroupSize)
+	}
+}
+
+func TestDetectMLXModelDirRejectsSafetensorsWithoutConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeFakeSafetensors(t, filepath.Join(dir, "model.safetensors"), map[string]any{
+		"model.layers.0.weight": map[string]any{"dtype": "F16", "shape": []int64{4, 4}},
+	})
+
+	if _, ok := detectMLXModelDir(dir); ok {
+		t.Error("detectMLXModelDir() ok = true, want false without a config.json present")
+	}
+}
+
+func TestDetectMLXModelDirLegacyWeightsNPZ(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{}`), 0o644); err != nil {
+		t.Fatalf("failed to write config.json: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "weights.npz"), []byte{}, 0o644); err != nil {
+		t.Fatalf("failed to write weights.npz: %v", err)
+	}
+
+	info, ok := detectMLXModelDir(dir)
+	if !ok {
+		t.Fatal("detectMLXModelDir() ok = false, want true")
+	}
+	if info.Format != ModelFormatMLX {
+		t.Errorf("detectMLXModelDir() Format = %v, want %v", info.Format, ModelFormatMLX)
+	}
+}
@@ -0,0 +1,375 @@
+package llm
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Digest is a content identifier of the form "<algorithm>:<hex>" (the same
+// convention OCI/Flux digests use, e.g. "sha256:abcd..."), used on
+// MLXModelInfo to distinguish a real per-file content hash from the cheap
+// name+size LayoutFingerprint. Internally the blob store still plumbs plain
+// strings (ManifestDescriptor, mlxBlobPath, ...) the way the rest of this
+// package already does; Digest exists for the public-facing shape shown to
+// callers of GetModelInfo/VerifyModel and returned via /api/show.
+type Digest string
+
+// NewDigest builds a Digest from an algorithm and its hex-encoded hash.
+func NewDigest(algorithm, hex string) Digest {
+	return Digest(algorithm + ":" + hex)
+}
+
+// Algorithm returns the part of the digest before the colon, e.g. "sha256".
+func (d Digest) Algorithm() string {
+	if i := strings.Index(string(d), ":"); i >= 0 {
+		return string(d)[:i]
+	}
+	return ""
+}
+
+// Hex returns the part of the digest after the colon.
+func (d Digest) Hex() string {
+	if i := strings.Index(string(d), ":"); i >= 0 {
+		return string(d)[i+1:]
+	}
+	return string(d)
+}
+
+// ManifestDescriptor records one file belonging to an MLX model: which blob
+// in the shared store holds its content, its media type, and its size.
+type ManifestDescriptor struct {
+	Filename  string `json:"filename"`
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// Manifest is the content-addressed record of one pulled MLX model: which
+// blobs (see mlxBlobsDir) its files resolve to, mirroring the
+// config+layers shape of an OCI image manifest without trying to be one.
+type Manifest struct {
+	MediaType string               `json:"mediaType"`
+	Config    ManifestDescriptor   `json:"config"`
+	Layers    []ManifestDescriptor `json:"layers"`
+}
+
+const (
+	mlxManifestMediaType  = "application/vnd.ollmlx.mlx.model.manifest.v1+json"
+	mlxConfigMediaType    = "application/vnd.ollmlx.mlx.model.config.v1+json"
+	mlxWeightsMediaType   = "application/vnd.ollmlx.mlx.model.weights.v1"
+	mlxTokenizerMediaType = "application/vnd.ollmlx.mlx.model.tokenizer.v1+json"
+)
+
+// newMLXManifest splits descriptors into a config entry (config.json, if
+// present) and the remaining layers, the same config/layers split an OCI
+// manifest makes between metadata and bulk content.
+func newMLXManifest(descriptors []ManifestDescriptor) *Manifest {
+	mf := &Manifest{MediaType: mlxManifestMediaType}
+	for _, d := range descriptors {
+		if d.Filename == "config.json" {
+			mf.Config = d
+			continue
+		}
+		mf.Layers = append(mf.Layers, d)
+	}
+	return mf
+}
+
+// blobDigests returns every blob digest mf references, config included.
+func (mf *Manifest) blobDigests() []string {
+	var digests []string
+	if mf.Config.Digest != "" {
+		digests = append(digests, mf.Config.Digest)
+	}
+	for _, l := range mf.Layers {
+		digests = append(digests, l.Digest)
+	}
+	return digests
+}
+
+// mlxLayerMediaType guesses a ManifestDescriptor's media type from its
+// filename; it only needs to be informative, nothing downstream parses it.
+func mlxLayerMediaType(filename string) string {
+	switch {
+	case filename == "config.json":
+		return mlxConfigMediaType
+	case strings.HasPrefix(filename, "tokenizer"):
+		return mlxTokenizerMediaType
+	default:
+		return mlxWeightsMediaType
+	}
+}
+
+// mlxBlobsDir is the shared content-addressed store every MLX model's files
+// are downloaded into, keyed by sha256 digest. It lives alongside the
+// gallery cache and the Modelfile-config manifest store already rooted at
+// <models>/mlx (see mlx_gallery.go, mlx_manifest.go).
+func mlxBlobsDir() string {
+	return filepath.Join(NewMLXModelManager().GetModelsDir(), "mlx", "blobs")
+}
+
+// mlxBlobPath returns where a blob with the given "sha256:<hex>" digest
+// lives in the store.
+func mlxBlobPath(digest string) string {
+	return filepath.Join(mlxBlobsDir(), strings.ReplaceAll(digest, ":", "-"))
+}
+
+// mlxModelManifestsDir is the root of per-model weight manifests. It is
+// deliberately nested under a "models" subpath so it can't collide with
+// mlx_manifest.go's own manifests directory, which stores Modelfile configs
+// keyed by a different scheme under the same <models>/mlx/manifests parent.
+func mlxModelManifestsDir() string {
+	return filepath.Join(NewMLXModelManager().GetModelsDir(), "mlx", "manifests", "models")
+}
+
+// sanitizeMLXPathComponent makes s safe to use as a single filesystem path
+// segment, the same way llm.MLXModelManager.GetModelPath already sanitizes a
+// downloaded model's directory name: any remaining "/" is replaced with
+// "_", and a result that would otherwise resolve to "." or ".." (letting a
+// path escape its intended parent) is replaced outright.
+func sanitizeMLXPathComponent(s string) string {
+	s = strings.ReplaceAll(s, "/", "_")
+	s = strings.ReplaceAll(s, `\`, "_")
+	if s == "" || s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
+
+// splitMLXModelRef splits a "org/name" HuggingFace-style model reference
+// into its org and name parts, defaulting org to "_" for a bare name. Both
+// parts are sanitized so a model name carrying "../" or extra "/" segments
+// can't make mlxModelManifestPath resolve outside mlxModelManifestsDir.
+func splitMLXModelRef(modelName string) (org, name string) {
+	var rawOrg, rawName string
+	if i := strings.Index(modelName, "/"); i >= 0 {
+		rawOrg, rawName = modelName[:i], modelName[i+1:]
+	} else {
+		rawOrg, rawName = "_", modelName
+	}
+	return sanitizeMLXPathComponent(rawOrg), sanitizeMLXPathComponent(rawName)
+}
+
+// mlxModelManifestPath returns the manifest file for a given model
+// reference, e.g. <models>/mlx/manifests/models/mlx-community/SmolLM2-135M-Instruct-4bit/latest.json
+func mlxModelManifestPath(modelName string) string {
+	org, name := splitMLXModelRef(modelName)
+	return filepath.Join(mlxModelManifestsDir(), org, name, "latest.json")
+}
+
+// readMLXModelManifest loads the manifest previously written for modelName,
+// if any. Callers use a returned error to mean "no manifest yet" (e.g. a
+// legacy flat-directory model, or a fresh pull) rather than treating it as
+// fatal.
+func readMLXModelManifest(modelName string) (*Manifest, error) {
+	data, err := os.ReadFile(mlxModelManifestPath(modelName))
+	if err != nil {
+		return nil, err
+	}
+	var mf Manifest
+	if err := json.Unmarshal(data, &mf); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest for %s: %w", modelName, err)
+	}
+	return &mf, nil
+}
+
+// writeMLXModelManifest persists mf as modelName's manifest, creating its
+// parent directories as needed.
+func writeMLXModelManifest(modelName string, mf *Manifest) error {
+	path := mlxModelManifestPath(modelName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(mf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// mlxAllModelManifests walks mlxModelManifestsDir and returns every manifest
+// found, used by mlxBlobRefCount to see what else references a blob before
+// deleting it.
+func mlxAllModelManifests() ([]*Manifest, error) {
+	root := mlxModelManifestsDir()
+	var manifests []*Manifest
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || filepath.Base(path) != "latest.json" {
+			return nil
+		}
+		data, readErr := os.ReadFile(path)
+		if readErr != nil {
+			return nil
+		}
+		var mf Manifest
+		if err := json.Unmarshal(data, &mf); err != nil {
+			return nil
+		}
+		manifests = append(manifests, &mf)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return manifests, nil
+}
+
+// mlxBlobRefCount reports how many known model manifests reference digest,
+// so DeleteModel can tell whether a blob is safe to remove from the shared
+// store once the calling model's own manifest has already been excluded.
+func mlxBlobRefCount(digest string) (int, error) {
+	manifests, err := mlxAllModelManifests()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, mf := range manifests {
+		for _, d := range mf.blobDigests() {
+			if d == digest {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+// linkBlob makes linkPath (a path inside a model's directory) resolve to
+// digest's blob via an absolute symlink, so every existing path-based reader
+// of a model's files (ModelExists, the MLX runner loader, the chat template
+// loader) keeps working unmodified against what looks like an ordinary file.
+func linkBlob(linkPath, digest string) error {
+	if err := os.MkdirAll(filepath.Dir(linkPath), 0755); err != nil {
+		return err
+	}
+	os.Remove(linkPath)
+	target, err := filepath.Abs(mlxBlobPath(digest))
+	if err != nil {
+		return err
+	}
+	return os.Symlink(target, linkPath)
+}
+
+// manifestSize sums mf's recorded layer and config sizes, the manifest's
+// own record of a model's true size - unlike walking the model's directory
+// on disk, which would see only the tiny size of each symlink.
+func manifestSize(mf *Manifest) int64 {
+	var total int64
+	if mf.Config.Digest != "" {
+		total += mf.Config.Size
+	}
+	for _, l := range mf.Layers {
+		total += l.Size
+	}
+	return total
+}
+
+// VerifyResult reports whether one of a model's files still matches the
+// content digest its manifest recorded at download time.
+type VerifyResult struct {
+	Filename string
+	Expected Digest
+	Actual   Digest
+	OK       bool
+}
+
+// VerifyModel re-hashes each of modelName's files on disk and compares them
+// against the content digests recorded in its manifest, catching corruption
+// (a truncated copy, a bit-flipped shard) that happened after the original
+// download-time digest check in downloadBlob. It returns an error only if
+// modelName has no manifest to verify against (e.g. a legacy flat-directory
+// model, or one that was never pulled through the blob store).
+func (m *MLXModelManager) VerifyModel(modelName string) ([]VerifyResult, error) {
+	mf, err := readMLXModelManifest(modelName)
+	if err != nil {
+		return nil, fmt.Errorf("no manifest for %s, cannot verify: %w", modelName, err)
+	}
+
+	modelPath := m.GetModelPath(modelName)
+	descriptors := append([]ManifestDescriptor{}, mf.Layers...)
+	if mf.Config.Digest != "" {
+		descriptors = append(descriptors, mf.Config)
+	}
+
+	results := make([]VerifyResult, 0, len(descriptors))
+	for _, d := range descriptors {
+		actual, err := hashFileContents(filepath.Join(modelPath, d.Filename))
+		if err != nil {
+			results = append(results, VerifyResult{Filename: d.Filename, Expected: Digest(d.Digest)})
+			continue
+		}
+		results = append(results, VerifyResult{
+			Filename: d.Filename,
+			Expected: Digest(d.Digest),
+			Actual:   Digest(actual),
+			OK:       actual == d.Digest,
+		})
+	}
+
+	return results, nil
+}
+
+// hashFileContents streams path's content through sha256 without loading it
+// all into memory, so verifying a multi-GB shard doesn't require holding it
+// in RAM.
+func hashFileContents(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+// manifestLayoutFingerprint hashes mf's filenames and recorded sizes the
+// same way the legacy computeDigest hashes a directory walk, but off the
+// manifest's real sizes rather than a symlink's on-disk size.
+func manifestLayoutFingerprint(mf *Manifest) Digest {
+	h := sha256.New()
+	for _, d := range append(append([]ManifestDescriptor{}, mf.Layers...), mf.Config) {
+		if d.Filename == "" {
+			continue
+		}
+		fmt.Fprintf(h, "%s:%d\n", d.Filename, d.Size)
+	}
+	return Digest(fmt.Sprintf("sha256:%x", h.Sum(nil)))
+}
+
+// manifestContentDigests returns a filename -> content Digest map for every
+// file mf describes, for MLXModelInfo.ContentDigest.
+func manifestContentDigests(mf *Manifest) map[string]Digest {
+	digests := make(map[string]Digest, len(mf.Layers)+1)
+	for _, d := range mf.Layers {
+		digests[d.Filename] = Digest(d.Digest)
+	}
+	if mf.Config.Filename != "" {
+		digests[mf.Config.Filename] = Digest(mf.Config.Digest)
+	}
+	return digests
+}
+
+// manifestDigest derives a single digest for a model from its manifest's
+// content, for display purposes (e.g. `ollama list`), by hashing the
+// manifest's own JSON encoding rather than the model's blobs directly.
+func manifestDigest(mf *Manifest) string {
+	data, err := json.Marshal(mf)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
@@ -0,0 +1,225 @@
+package llm
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLinkBlobDedupsIdenticalContentAcrossModels(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	blobsDir := mlxBlobsDir()
+	if err := os.MkdirAll(blobsDir, 0o755); err != nil {
+		t.Fatalf("failed to create blobs dir: %v", err)
+	}
+
+	digest := "sha256:deadbeef"
+	if err := os.WriteFile(mlxBlobPath(digest), []byte("shared weights"), 0o644); err != nil {
+		t.Fatalf("failed to seed blob: %v", err)
+	}
+
+	modelsDir := filepath.Join(t.TempDir(), "unused") // linkBlob only needs the link path's parent
+	linkA := filepath.Join(modelsDir, "model-a", "weights.npz")
+	linkB := filepath.Join(modelsDir, "model-b", "weights.npz")
+
+	if err := linkBlob(linkA, digest); err != nil {
+		t.Fatalf("linkBlob(a) error = %v", err)
+	}
+	if err := linkBlob(linkB, digest); err != nil {
+		t.Fatalf("linkBlob(b) error = %v", err)
+	}
+
+	gotA, err := os.ReadFile(linkA)
+	if err != nil {
+		t.Fatalf("failed to read through link a: %v", err)
+	}
+	gotB, err := os.ReadFile(linkB)
+	if err != nil {
+		t.Fatalf("failed to read through link b: %v", err)
+	}
+	if string(gotA) != "shared weights" || string(gotB) != "shared weights" {
+		t.Fatalf("linked content = %q, %q, want both %q", gotA, gotB, "shared weights")
+	}
+}
+
+func TestManifestRoundTripsAndComputesSize(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	mf := newMLXManifest([]ManifestDescriptor{
+		{Filename: "config.json", Digest: "sha256:aaa", Size: 10},
+		{Filename: "weights.npz", Digest: "sha256:bbb", Size: 1000},
+	})
+
+	if mf.Config.Digest != "sha256:aaa" {
+		t.Fatalf("Config.Digest = %q, want %q", mf.Config.Digest, "sha256:aaa")
+	}
+	if len(mf.Layers) != 1 || mf.Layers[0].Digest != "sha256:bbb" {
+		t.Fatalf("Layers = %+v, want a single sha256:bbb layer", mf.Layers)
+	}
+	if got, want := manifestSize(mf), int64(1010); got != want {
+		t.Errorf("manifestSize() = %d, want %d", got, want)
+	}
+
+	if err := writeMLXModelManifest("mlx-community/Test-Model", mf); err != nil {
+		t.Fatalf("writeMLXModelManifest() error = %v", err)
+	}
+
+	got, err := readMLXModelManifest("mlx-community/Test-Model")
+	if err != nil {
+		t.Fatalf("readMLXModelManifest() error = %v", err)
+	}
+	if got.Config.Digest != mf.Config.Digest || len(got.Layers) != len(mf.Layers) {
+		t.Fatalf("round-tripped manifest = %+v, want %+v", got, mf)
+	}
+}
+
+func TestDeleteModelRemovesBlobsOnlyWhenUnreferenced(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	manager := NewMLXModelManager()
+
+	shared := ManifestDescriptor{Filename: "tokenizer.json", Digest: "sha256:shared", Size: 5}
+	if err := os.MkdirAll(mlxBlobsDir(), 0o755); err != nil {
+		t.Fatalf("failed to create blobs dir: %v", err)
+	}
+	if err := os.WriteFile(mlxBlobPath(shared.Digest), []byte("tok"), 0o644); err != nil {
+		t.Fatalf("failed to seed shared blob: %v", err)
+	}
+	onlyA := ManifestDescriptor{Filename: "weights.npz", Digest: "sha256:only-a", Size: 7}
+	if err := os.WriteFile(mlxBlobPath(onlyA.Digest), []byte("weights"), 0o644); err != nil {
+		t.Fatalf("failed to seed model-a's blob: %v", err)
+	}
+
+	mfA := newMLXManifest([]ManifestDescriptor{shared, onlyA})
+	if err := writeMLXModelManifest("org/model-a", mfA); err != nil {
+		t.Fatalf("writeMLXModelManifest(a) error = %v", err)
+	}
+	if err := linkBlob(manager.GetModelPath("org/model-a")+"/tokenizer.json", shared.Digest); err != nil {
+		t.Fatalf("linkBlob(a, shared) error = %v", err)
+	}
+	if err := linkBlob(manager.GetModelPath("org/model-a")+"/weights.npz", onlyA.Digest); err != nil {
+		t.Fatalf("linkBlob(a, only-a) error = %v", err)
+	}
+
+	mfB := newMLXManifest([]ManifestDescriptor{shared})
+	if err := writeMLXModelManifest("org/model-b", mfB); err != nil {
+		t.Fatalf("writeMLXModelManifest(b) error = %v", err)
+	}
+	if err := linkBlob(manager.GetModelPath("org/model-b")+"/tokenizer.json", shared.Digest); err != nil {
+		t.Fatalf("linkBlob(b, shared) error = %v", err)
+	}
+
+	if err := manager.DeleteModel("org/model-a"); err != nil {
+		t.Fatalf("DeleteModel(a) error = %v", err)
+	}
+
+	if _, err := os.Stat(mlxBlobPath(onlyA.Digest)); !os.IsNotExist(err) {
+		t.Errorf("model-a's exclusive blob should have been removed, stat err = %v", err)
+	}
+	if _, err := os.Stat(mlxBlobPath(shared.Digest)); err != nil {
+		t.Errorf("shared blob should still exist (model-b still references it): %v", err)
+	}
+	if _, err := readMLXModelManifest("org/model-a"); err == nil {
+		t.Error("model-a's manifest should have been removed")
+	}
+}
+
+func TestSplitMLXModelRefSanitizesTraversal(t *testing.T) {
+	tests := []struct {
+		modelName string
+		wantOrg   string
+		wantName  string
+	}{
+		{modelName: "mlx-community/SmolLM2-135M-Instruct-4bit", wantOrg: "mlx-community", wantName: "SmolLM2-135M-Instruct-4bit"},
+		{modelName: "bare-model", wantOrg: "_", wantName: "bare-model"},
+		{modelName: "../../../../etc/passwd", wantOrg: "_", wantName: ".._.._.._.._etc_passwd"},
+		{modelName: "..", wantOrg: "_", wantName: "_"},
+	}
+
+	for _, tt := range tests {
+		org, name := splitMLXModelRef(tt.modelName)
+		if org != tt.wantOrg || name != tt.wantName {
+			t.Errorf("splitMLXModelRef(%q) = (%q, %q), want (%q, %q)", tt.modelName, org, name, tt.wantOrg, tt.wantName)
+		}
+		if strings.Contains(org, "/") || strings.Contains(name, "/") {
+			t.Errorf("splitMLXModelRef(%q) left a path separator in (%q, %q)", tt.modelName, org, name)
+		}
+	}
+}
+
+func TestMlxModelManifestPathStaysUnderManifestsDir(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	path := mlxModelManifestPath("../../../../../../tmp/evil")
+	root := mlxModelManifestsDir()
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		t.Fatalf("filepath.Rel() error = %v", err)
+	}
+	if strings.HasPrefix(rel, "..") {
+		t.Errorf("mlxModelManifestPath() = %q, escapes manifests dir %q (rel = %q)", path, root, rel)
+	}
+}
+
+func TestDigestAlgorithmAndHex(t *testing.T) {
+	d := NewDigest("sha256", "abcd1234")
+	if d.Algorithm() != "sha256" {
+		t.Errorf("Algorithm() = %q, want %q", d.Algorithm(), "sha256")
+	}
+	if d.Hex() != "abcd1234" {
+		t.Errorf("Hex() = %q, want %q", d.Hex(), "abcd1234")
+	}
+}
+
+func TestVerifyModelDetectsCorruption(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	manager := NewMLXModelManager()
+
+	original := "original weight bytes"
+	sum := sha256.Sum256([]byte(original))
+	digest := fmt.Sprintf("sha256:%x", sum)
+
+	if err := os.MkdirAll(mlxBlobsDir(), 0o755); err != nil {
+		t.Fatalf("failed to create blobs dir: %v", err)
+	}
+	if err := os.WriteFile(mlxBlobPath(digest), []byte(original), 0o644); err != nil {
+		t.Fatalf("failed to seed blob: %v", err)
+	}
+
+	mf := newMLXManifest([]ManifestDescriptor{
+		{Filename: "weights.npz", Digest: digest, Size: int64(len(original))},
+	})
+	modelName := "org/verify-me"
+	if err := writeMLXModelManifest(modelName, mf); err != nil {
+		t.Fatalf("writeMLXModelManifest() error = %v", err)
+	}
+	linkPath := filepath.Join(manager.GetModelPath(modelName), "weights.npz")
+	if err := linkBlob(linkPath, digest); err != nil {
+		t.Fatalf("linkBlob() error = %v", err)
+	}
+
+	results, err := manager.VerifyModel(modelName)
+	if err != nil {
+		t.Fatalf("VerifyModel() error = %v", err)
+	}
+	if len(results) != 1 || !results[0].OK {
+		t.Fatalf("VerifyModel() on an untouched model = %+v, want one OK result", results)
+	}
+
+	// Corrupt the underlying blob content directly (bypassing the symlink)
+	// to simulate on-disk bitrot after the original download-time check.
+	if err := os.WriteFile(mlxBlobPath(digest), []byte("corrupted!!"), 0o644); err != nil {
+		t.Fatalf("failed to corrupt blob: %v", err)
+	}
+
+	results, err = manager.VerifyModel(modelName)
+	if err != nil {
+		t.Fatalf("VerifyModel() error = %v", err)
+	}
+	if len(results) != 1 || results[0].OK {
+		t.Fatalf("VerifyModel() after corruption = %+v, want a failing result", results)
+	}
+}
@@ -0,0 +1,257 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// mlxDownloadChunkSize is the fixed size of one chunk in a chunked,
+// resumable download (see downloadChunkedBlob).
+const mlxDownloadChunkSize = 16 * 1024 * 1024 // 16 MiB
+
+// mlxDownloadConcurrency returns how many chunks may download in parallel
+// for a single file, configured via OLLAMA_MLX_DOWNLOAD_CONCURRENCY
+// (default 4). It is read straight from the environment here since
+// downloadChunkedBlob has no constructor of its own to thread an option
+// through; mlxservice's pool width, by contrast, is set with its
+// WithMaxConcurrency option.
+func mlxDownloadConcurrency() int {
+	if v := strings.TrimSpace(os.Getenv("OLLAMA_MLX_DOWNLOAD_CONCURRENCY")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 4
+}
+
+// partialDownloadMeta tracks which chunks of a file have already landed on
+// disk, persisted as a sidecar JSON file next to the sparse partial file
+// itself so a later call to downloadChunkedBlob for the same URL resumes
+// only the missing chunks instead of restarting from zero.
+type partialDownloadMeta struct {
+	URL       string `json:"url"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunkSize"`
+	Completed []bool `json:"completed"`
+}
+
+// mlxPartialPath returns where a url's in-progress chunked download lives,
+// keyed by a hash of the URL itself since the blob's eventual content
+// digest isn't known until the download finishes.
+func mlxPartialPath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(mlxBlobsDir(), fmt.Sprintf("partial-%x.part", sum))
+}
+
+func mlxPartialMetaPath(url string) string {
+	return mlxPartialPath(url) + ".meta"
+}
+
+func loadPartialDownloadMeta(url string) (*partialDownloadMeta, error) {
+	data, err := os.ReadFile(mlxPartialMetaPath(url))
+	if err != nil {
+		return nil, err
+	}
+	var meta partialDownloadMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func savePartialDownloadMeta(url string, meta *partialDownloadMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(mlxPartialMetaPath(url), data, 0644)
+}
+
+// probeRangeSupport issues a GET with Range: bytes=0-0 to learn a url's
+// total size and whether the server honors byte ranges at all - reported
+// by a 206 Partial Content response with a Content-Range header, the same
+// signal curl -C/wget -c rely on before attempting a resumable transfer.
+func probeRangeSupport(ctx context.Context, client *http.Client, url, token string) (size int64, supportsRanges bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusPartialContent {
+		// The server honored the range, so the body is just the one
+		// requested byte - safe to drain so the connection can be reused.
+		io.Copy(io.Discard, resp.Body)
+		if cr := resp.Header.Get("Content-Range"); cr != "" {
+			if i := strings.LastIndex(cr, "/"); i >= 0 {
+				if n, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+					return n, true, nil
+				}
+			}
+		}
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		// The server ignored the Range header and is about to send the
+		// entire file - do not read any of it here, or downloadBlob's
+		// subsequent downloadBlobSingleStream call would download the same
+		// multi-GB shard a second time from scratch. Closing resp.Body now
+		// (via the deferred Close above) without draining it means the
+		// underlying connection can't be reused, which is a fine trade for
+		// not doubling bandwidth on every non-range-serving mirror.
+		if resp.ContentLength > 0 {
+			return resp.ContentLength, false, nil
+		}
+	}
+
+	return 0, false, fmt.Errorf("could not determine content length (status %d)", resp.StatusCode)
+}
+
+// downloadChunkedBlob downloads url in mlxDownloadChunkSize chunks across a
+// worker pool bounded by mlxDownloadConcurrency, writing each chunk
+// directly to its offset in a sparse partial file via WriteAt so chunks can
+// land out of order. A sidecar meta file is updated after each completed
+// chunk, so if the process is interrupted, the next call for the same url
+// resumes only the chunks still marked incomplete. progress is called with
+// each chunk's byte count as it lands, from whichever worker goroutine
+// completes it - callers relying on it for a running total (like
+// ProgressReader's callers elsewhere in this package) must aggregate it
+// under their own lock if they touch shared state.
+func downloadChunkedBlob(ctx context.Context, client *http.Client, url, token string, size int64, progress func(int64)) (path string, err error) {
+	partialPath := mlxPartialPath(url)
+	if err := os.MkdirAll(filepath.Dir(partialPath), 0755); err != nil {
+		return "", err
+	}
+
+	meta, metaErr := loadPartialDownloadMeta(url)
+	if metaErr != nil || meta.Size != size || meta.ChunkSize != mlxDownloadChunkSize {
+		numChunks := int((size + mlxDownloadChunkSize - 1) / mlxDownloadChunkSize)
+		meta = &partialDownloadMeta{URL: url, Size: size, ChunkSize: mlxDownloadChunkSize, Completed: make([]bool, numChunks)}
+	}
+
+	f, err := os.OpenFile(partialPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		return "", err
+	}
+
+	var (
+		metaMu   sync.Mutex
+		errMu    sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	sem := make(chan struct{}, mlxDownloadConcurrency())
+
+	for i, done := range meta.Completed {
+		if done {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		i := i
+		start := int64(i) * meta.ChunkSize
+		end := start + meta.ChunkSize - 1
+		if end > size-1 {
+			end = size - 1
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			errMu.Lock()
+			failed := firstErr != nil
+			errMu.Unlock()
+			if failed {
+				return
+			}
+
+			if err := downloadChunkRange(ctx, client, url, token, f, start, end, progress); err != nil {
+				errMu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMu.Unlock()
+				return
+			}
+
+			metaMu.Lock()
+			meta.Completed[i] = true
+			savePartialDownloadMeta(url, meta)
+			metaMu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return "", firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	return partialPath, nil
+}
+
+// downloadChunkRange fetches the inclusive byte range [start, end] of url
+// and writes it to f at offset start.
+func downloadChunkRange(ctx context.Context, client *http.Client, url, token string, f *os.File, start, end int64, progress func(int64)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("chunk request for bytes=%d-%d returned status %d", start, end, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(data, start); err != nil {
+		return err
+	}
+	if progress != nil {
+		progress(int64(len(data)))
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// rangeServingHandler serves content with Range support, mimicking the
+// Accept-Ranges behavior HuggingFace's CDN provides for LFS-tracked files.
+func rangeServingHandler(content []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rng := r.Header.Get("Range")
+		if rng == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content)
+			return
+		}
+
+		start, end, err := parseRangeHeader(rng)
+		if err != nil {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if end >= len(content) {
+			end = len(content) - 1
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(content[start : end+1])
+	}
+}
+
+func parseRangeHeader(header string) (start, end int, err error) {
+	header = strings.TrimPrefix(header, "bytes=")
+	parts := strings.SplitN(header, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("malformed range header %q", header)
+	}
+	start, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+func TestProbeRangeSupportDetectsPartialContent(t *testing.T) {
+	content := make([]byte, 1024)
+	srv := httptest.NewServer(rangeServingHandler(content))
+	defer srv.Close()
+
+	size, supported, err := probeRangeSupport(context.Background(), srv.Client(), srv.URL, "")
+	if err != nil {
+		t.Fatalf("probeRangeSupport() error = %v", err)
+	}
+	if !supported {
+		t.Error("probeRangeSupport() supportsRanges = false, want true")
+	}
+	if size != int64(len(content)) {
+		t.Errorf("probeRangeSupport() size = %d, want %d", size, len(content))
+	}
+}
+
+// countingReadCloser wraps a response body to record how many bytes the
+// caller actually reads from it before closing it.
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingRoundTripper wraps every response's body in a countingReadCloser
+// and hands the most recent one to the test via last, so the test can
+// assert how much of the body probeRangeSupport actually read - regardless
+// of how much the server wrote.
+type countingRoundTripper struct {
+	base http.RoundTripper
+	last *countingReadCloser
+}
+
+func (rt *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := rt.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	rt.last = &countingReadCloser{ReadCloser: resp.Body}
+	resp.Body = rt.last
+	return resp, nil
+}
+
+// TestProbeRangeSupportDoesNotDrainNonRangeResponse verifies that a server
+// ignoring the Range header (returning a plain 200 with the whole body)
+// never has that body actually read by probeRangeSupport - only its
+// Content-Length is consulted. If probeRangeSupport drained the body, this
+// test would observe bytes read equal to len(content), which would mean
+// probeRangeSupport just downloaded the entire multi-GB shard a caller is
+// about to download again via downloadBlobSingleStream.
+func TestProbeRangeSupportDoesNotDrainNonRangeResponse(t *testing.T) {
+	content := make([]byte, 1024)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	rt := &countingRoundTripper{base: http.DefaultTransport}
+	client := &http.Client{Transport: rt}
+
+	size, supported, err := probeRangeSupport(context.Background(), client, srv.URL, "")
+	if err != nil {
+		t.Fatalf("probeRangeSupport() error = %v", err)
+	}
+	if supported {
+		t.Error("probeRangeSupport() supportsRanges = true, want false")
+	}
+	if size != int64(len(content)) {
+		t.Errorf("probeRangeSupport() size = %d, want %d", size, len(content))
+	}
+	if rt.last == nil {
+		t.Fatal("request was never made - test is broken")
+	}
+	if rt.last.n != 0 {
+		t.Errorf("probeRangeSupport read %d bytes of the response body, want 0", rt.last.n)
+	}
+}
+
+func TestDownloadChunkedBlobReassemblesContentAndResumes(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_MLX_DOWNLOAD_CONCURRENCY", "2")
+
+	content := make([]byte, mlxDownloadChunkSize*2+1024)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	srv := httptest.NewServer(rangeServingHandler(content))
+	defer srv.Close()
+
+	size, supported, err := probeRangeSupport(context.Background(), srv.Client(), srv.URL, "")
+	if err != nil || !supported {
+		t.Fatalf("probeRangeSupport() = %d, %v, %v", size, supported, err)
+	}
+
+	// progress runs on the download's worker goroutines, so the totals
+	// below are aggregated under progressMu.
+	var progressMu sync.Mutex
+	var totalProgress int64
+	path, err := downloadChunkedBlob(context.Background(), srv.Client(), srv.URL, "", size, func(n int64) {
+		progressMu.Lock()
+		totalProgress += n
+		progressMu.Unlock()
+	})
+	if err != nil {
+		t.Fatalf("downloadChunkedBlob() error = %v", err)
+	}
+	defer os.Remove(path)
+	defer os.Remove(mlxPartialMetaPath(srv.URL))
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read reassembled file: %v", err)
+	}
+	if len(got) != len(content) {
+		t.Fatalf("reassembled file size = %d, want %d", len(got), len(content))
+	}
+	for i := range content {
+		if got[i] != content[i] {
+			t.Fatalf("reassembled content differs at byte %d: got %d, want %d", i, got[i], content[i])
+		}
+	}
+	if totalProgress != int64(len(content)) {
+		t.Errorf("aggregated progress = %d, want %d", totalProgress, len(content))
+	}
+
+	// A second call against the same URL with everything already marked
+	// complete in the sidecar meta should resume instantly without
+	// re-fetching any chunk (no further progress reported).
+	var secondProgress int64
+	path2, err := downloadChunkedBlob(context.Background(), srv.Client(), srv.URL, "", size, func(n int64) {
+		progressMu.Lock()
+		secondProgress += n
+		progressMu.Unlock()
+	})
+	if err != nil {
+		t.Fatalf("downloadChunkedBlob() (resume) error = %v", err)
+	}
+	if path2 != path {
+		t.Errorf("resumed download path = %q, want %q", path2, path)
+	}
+	if secondProgress != 0 {
+		t.Errorf("resumed download re-fetched %d bytes, want 0 (everything already complete)", secondProgress)
+	}
+}
@@ -0,0 +1,180 @@
+package llm
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// fastWalkConcurrency bounds how many directories fastWalkDir scans in
+// parallel, configured via OLLAMA_MLX_WALK_CONCURRENCY - the same
+// env-var-gates-a-worker-pool convention mlxDownloadConcurrency already
+// follows for chunked downloads.
+func fastWalkConcurrency() int {
+	if v := strings.TrimSpace(os.Getenv("OLLAMA_MLX_WALK_CONCURRENCY")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 8
+}
+
+// fastWalkFile is one regular file fastWalkDir found, named relative to the
+// walk's root.
+type fastWalkFile struct {
+	RelPath string
+	Size    int64
+}
+
+// fastWalkDir concurrently scans root's directory tree with a bounded
+// worker pool reading directories via os.ReadDir - inspired by MinIO's
+// data-usage crawler - rather than filepath.Walk's single goroutine.
+// Directories are recognised from the os.ReadDir entry without an extra
+// stat; each regular file still costs one Info (lstat) call for its size.
+// It returns every regular file found (file order is not
+// guaranteed; sort by RelPath first if a caller needs a stable result) and
+// their combined size.
+func fastWalkDir(root string, parallelism int) ([]fastWalkFile, int64, error) {
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+
+	type job struct{ dir, rel string }
+
+	var (
+		mu        sync.Mutex
+		files     []fastWalkFile
+		totalSize int64
+		firstErr  error
+	)
+
+	// Dirs in a single MLX model directory number in the dozens at most
+	// (a handful of shard/tokenizer files, rarely nested subdirectories),
+	// so a generously-sized buffer avoids workers blocking on the send side
+	// of the queue while still bounding memory.
+	queue := make(chan job, 4096)
+	var pending int64
+	var wg sync.WaitGroup
+
+	enqueue := func(j job) {
+		atomic.AddInt64(&pending, 1)
+		queue <- j
+	}
+
+	worker := func() {
+		defer wg.Done()
+		for j := range queue {
+			entries, err := os.ReadDir(j.dir)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			} else {
+				for _, e := range entries {
+					childRel := filepath.Join(j.rel, e.Name())
+					childPath := filepath.Join(j.dir, e.Name())
+
+					if e.IsDir() {
+						enqueue(job{dir: childPath, rel: childRel})
+						continue
+					}
+
+					info, err := e.Info()
+					if err != nil {
+						// Vanished between ReadDir and Info (e.g. a
+						// concurrent delete); skip rather than fail the
+						// whole walk over one file.
+						continue
+					}
+
+					mu.Lock()
+					files = append(files, fastWalkFile{RelPath: childRel, Size: info.Size()})
+					totalSize += info.Size()
+					mu.Unlock()
+				}
+			}
+
+			if atomic.AddInt64(&pending, -1) == 0 {
+				close(queue)
+			}
+		}
+	}
+
+	wg.Add(parallelism)
+	for i := 0; i < parallelism; i++ {
+		go worker()
+	}
+
+	enqueue(job{dir: root, rel: ""})
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, 0, firstErr
+	}
+	return files, totalSize, nil
+}
+
+// dirSizeCacheEntry is one cached fastWalkDir result, valid as long as
+// path's own mtime hasn't changed and the entry isn't past its TTL.
+type dirSizeCacheEntry struct {
+	size       int64
+	fileCount  int
+	dirModTime time.Time
+	cachedAt   time.Time
+}
+
+// dirSizeCacheTTL bounds how long a cached directory size is trusted even
+// if the directory's mtime looks unchanged, as a hedge against mtime
+// granularity/clock quirks on the host filesystem.
+const dirSizeCacheTTL = 30 * time.Second
+
+var (
+	dirSizeCacheMu sync.Mutex
+	dirSizeCache   = map[string]dirSizeCacheEntry{}
+)
+
+// dirSizeCacheLookup returns a cached size for path if its directory mtime
+// still matches what was recorded and the entry hasn't expired. Note this
+// only catches changes to path's own immediate entries (files added or
+// removed directly inside it) - a change confined to a file nested a
+// directory deeper wouldn't touch path's own mtime. MLX model directories
+// are effectively flat (shards + tokenizer files at one level), so this
+// holds for the case this cache targets: repeat GET /api/tags calls over
+// an unchanged model store.
+func dirSizeCacheLookup(path string) (dirSizeCacheEntry, bool) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return dirSizeCacheEntry{}, false
+	}
+
+	dirSizeCacheMu.Lock()
+	entry, ok := dirSizeCache[path]
+	dirSizeCacheMu.Unlock()
+
+	if !ok || !entry.dirModTime.Equal(stat.ModTime()) || time.Since(entry.cachedAt) > dirSizeCacheTTL {
+		return dirSizeCacheEntry{}, false
+	}
+	return entry, true
+}
+
+func dirSizeCacheStore(path string, size int64, fileCount int) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	dirSizeCacheMu.Lock()
+	dirSizeCache[path] = dirSizeCacheEntry{
+		size:       size,
+		fileCount:  fileCount,
+		dirModTime: stat.ModTime(),
+		cachedAt:   time.Now(),
+	}
+	dirSizeCacheMu.Unlock()
+}
@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFastWalkDirFindsAllFilesAndSumsSize(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "config.json"), []byte(`{}`), 0o644); err != nil {
+		t.Fatalf("failed to write config.json: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "shards"), 0o755); err != nil {
+		t.Fatalf("failed to create shards dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "shards", "model-00001.safetensors"), make([]byte, 100), 0o644); err != nil {
+		t.Fatalf("failed to write shard: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "shards", "model-00002.safetensors"), make([]byte, 200), 0o644); err != nil {
+		t.Fatalf("failed to write shard: %v", err)
+	}
+
+	files, total, err := fastWalkDir(root, 4)
+	if err != nil {
+		t.Fatalf("fastWalkDir() error = %v", err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("fastWalkDir() found %d files, want 3: %+v", len(files), files)
+	}
+	if total != 302 { // len(`{}`) + 100 + 200
+		t.Errorf("fastWalkDir() total size = %d, want 302", total)
+	}
+}
+
+func TestCalculateDirSizeUsesCacheUntilMtimeChanges(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.bin"), make([]byte, 50), 0o644); err != nil {
+		t.Fatalf("failed to write a.bin: %v", err)
+	}
+
+	manager := &MLXModelManager{modelsDir: t.TempDir()}
+
+	size, err := manager.calculateDirSize(root)
+	if err != nil {
+		t.Fatalf("calculateDirSize() error = %v", err)
+	}
+	if size != 50 {
+		t.Fatalf("calculateDirSize() = %d, want 50", size)
+	}
+
+	// Change content without touching the directory's own mtime (overwrite
+	// the existing file) - the cache should still report the stale size.
+	if err := os.WriteFile(filepath.Join(root, "a.bin"), make([]byte, 999), 0o644); err != nil {
+		t.Fatalf("failed to rewrite a.bin: %v", err)
+	}
+	cachedSize, err := manager.calculateDirSize(root)
+	if err != nil {
+		t.Fatalf("calculateDirSize() (cached) error = %v", err)
+	}
+	if cachedSize != 50 {
+		t.Fatalf("calculateDirSize() (expected stale cache hit) = %d, want 50", cachedSize)
+	}
+
+	// Adding a new file changes root's own mtime, which should invalidate
+	// the cache and force a fresh walk.
+	time.Sleep(10 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(root, "b.bin"), make([]byte, 1), 0o644); err != nil {
+		t.Fatalf("failed to write b.bin: %v", err)
+	}
+	freshSize, err := manager.calculateDirSize(root)
+	if err != nil {
+		t.Fatalf("calculateDirSize() (after mtime change) error = %v", err)
+	}
+	if freshSize != 1000 { // 999 + 1
+		t.Fatalf("calculateDirSize() (after mtime change) = %d, want 1000", freshSize)
+	}
+}
@@ -0,0 +1,303 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// GalleryModel is one curated model entry in a gallery manifest: enough
+// metadata for a picker UI to list a model before it's downloaded, plus the
+// per-shard sha256 digests PreloadModel verifies once the weights land on
+// disk.
+type GalleryModel struct {
+	Name          string            `json:"name"`
+	DisplayName   string            `json:"display_name,omitempty"`
+	Quantization  string            `json:"quantization,omitempty"`
+	ContextLength int               `json:"context_length,omitempty"`
+	License       string            `json:"license,omitempty"`
+	Shards        map[string]string `json:"shards,omitempty"` // filename -> "sha256:<hex>"
+}
+
+type galleryManifest struct {
+	Models []GalleryModel `json:"models"`
+}
+
+// galleryCacheEntry is what gets written under galleryCacheDir for each
+// gallery URL: the raw manifest body plus the ETag it was served with, so a
+// restart can send a conditional request instead of re-pulling the whole
+// manifest.
+type galleryCacheEntry struct {
+	ETag string          `json:"etag,omitempty"`
+	Body json.RawMessage `json:"body"`
+}
+
+// galleryURLsFromEnv returns the configured gallery manifest URLs.
+// OLLAMA_MLX_GALLERY_URLS is a comma-separated list, the same shape
+// OLLAMA_TOOLBOX_ALLOW takes (see server/toolbox.go's getToolbox).
+func galleryURLsFromEnv() []string {
+	raw := os.Getenv("OLLAMA_MLX_GALLERY_URLS")
+	if raw == "" {
+		return nil
+	}
+
+	var urls []string
+	for _, u := range strings.Split(raw, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// galleryCacheDir holds cached gallery manifests, alongside the other
+// directories ListModels already knows aren't models (see internalDirs).
+func (m *MLXModelManager) galleryCacheDir() string {
+	return filepath.Join(m.modelsDir, "mlx", "gallery")
+}
+
+func (m *MLXModelManager) galleryCachePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(m.galleryCacheDir(), hex.EncodeToString(sum[:])+".json")
+}
+
+// FetchGallery merges every URL named by OLLAMA_MLX_GALLERY_URLS into a
+// single catalog, sorted by name, with a later URL's entry overriding an
+// earlier one of the same name. A URL whose request fails in transport, or
+// that answers 304 Not Modified, is served from its on-disk cache; a URL
+// that answers any other non-200 status or an invalid manifest drops out of
+// the catalog. An unset OLLAMA_MLX_GALLERY_URLS returns an empty catalog.
+func (m *MLXModelManager) FetchGallery(ctx context.Context) ([]GalleryModel, error) {
+	urls := galleryURLsFromEnv()
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	byName := make(map[string]GalleryModel)
+	var order []string
+	for _, url := range urls {
+		manifest, err := m.fetchGalleryManifest(ctx, url)
+		if err != nil {
+			slog.Warn("failed to fetch MLX gallery manifest", "url", url, "error", err)
+			continue
+		}
+		for _, model := range manifest.Models {
+			if _, ok := byName[model.Name]; !ok {
+				order = append(order, model.Name)
+			}
+			byName[model.Name] = model
+		}
+	}
+
+	sort.Strings(order)
+	catalog := make([]GalleryModel, 0, len(order))
+	for _, name := range order {
+		catalog = append(catalog, byName[name])
+	}
+	return catalog, nil
+}
+
+// fetchGalleryManifest fetches a single gallery URL, sending an
+// If-None-Match from the cached copy's ETag when one exists and reusing
+// that cached body on a 304 or a request failure.
+func (m *MLXModelManager) fetchGalleryManifest(ctx context.Context, url string) (*galleryManifest, error) {
+	var cached galleryCacheEntry
+	if data, err := os.ReadFile(m.galleryCachePath(url)); err == nil {
+		json.Unmarshal(data, &cached)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if manifest, ok := parseGalleryCacheBody(cached); ok {
+			return manifest, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		if manifest, ok := parseGalleryCacheBody(cached); ok {
+			return manifest, nil
+		}
+		return nil, fmt.Errorf("gallery %s: 304 Not Modified with no cached manifest", url)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gallery %s returned status %d", url, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest galleryManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("gallery %s: invalid manifest: %w", url, err)
+	}
+
+	m.writeGalleryCache(url, resp.Header.Get("ETag"), body)
+	return &manifest, nil
+}
+
+func parseGalleryCacheBody(cached galleryCacheEntry) (*galleryManifest, bool) {
+	if len(cached.Body) == 0 {
+		return nil, false
+	}
+	var manifest galleryManifest
+	if err := json.Unmarshal(cached.Body, &manifest); err != nil {
+		return nil, false
+	}
+	return &manifest, true
+}
+
+func (m *MLXModelManager) writeGalleryCache(url, etag string, body []byte) {
+	data, err := json.Marshal(galleryCacheEntry{ETag: etag, Body: body})
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(m.galleryCacheDir(), 0755); err != nil {
+		return
+	}
+	os.WriteFile(m.galleryCachePath(url), data, 0644)
+}
+
+// verifyShards checks every file shards names against its expected sha256
+// digest, returning the first missing file or mismatch it finds.
+func verifyShards(modelPath string, shards map[string]string) error {
+	for filename, want := range shards {
+		want = strings.TrimPrefix(want, "sha256:")
+
+		f, err := os.Open(filepath.Join(modelPath, filename))
+		if err != nil {
+			return fmt.Errorf("verify %s: %w", filename, err)
+		}
+
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("verify %s: %w", filename, err)
+		}
+
+		if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, want) {
+			return fmt.Errorf("shard %s: digest mismatch: got sha256:%s want sha256:%s", filename, got, want)
+		}
+	}
+	return nil
+}
+
+// PreloadModel downloads modelID if it isn't already cached. When modelID
+// names an entry in gallery with shard digests, the downloaded files are
+// verified against them; a mismatch deletes the partially-downloaded model
+// rather than leaving a corrupt one behind.
+func (m *MLXModelManager) PreloadModel(ctx context.Context, modelID string, gallery map[string]GalleryModel, progressFn func(string, int64, int64)) error {
+	if m.ModelExists(modelID) {
+		if progressFn != nil {
+			progressFn(fmt.Sprintf("%s already exists", modelID), 0, 0)
+		}
+		return nil
+	}
+
+	if err := m.DownloadMLXModel(ctx, modelID, progressFn); err != nil {
+		return err
+	}
+
+	if entry, ok := gallery[modelID]; ok && len(entry.Shards) > 0 {
+		modelPath := m.GetModelPath(modelID)
+		if err := verifyShards(modelPath, entry.Shards); err != nil {
+			os.RemoveAll(modelPath)
+			return fmt.Errorf("preload %s: %w", modelID, err)
+		}
+	}
+
+	return nil
+}
+
+// maxConcurrentPreloads bounds how many models PreloadModelsFromEnv downloads
+// at once, so a long OLLAMA_PRELOAD_MODELS list doesn't saturate the host's
+// bandwidth or disk I/O all at the same moment.
+const maxConcurrentPreloads = 3
+
+// PreloadModelsFromEnv downloads and warms every model ref named by
+// OLLAMA_PRELOAD_MODELS (comma-separated, accepting the same slash-separated
+// shape normalizeLegacyMLXModelName normalizes legacy names into) at bounded
+// parallelism, reporting progress per model through progressFn. Shards are
+// verified against the gallery catalog for any ref that's also a gallery
+// entry. Call this once at startup; an unset OLLAMA_PRELOAD_MODELS is a
+// no-op.
+func (m *MLXModelManager) PreloadModelsFromEnv(ctx context.Context, progressFn func(model, status string, completed, total int64)) error {
+	raw := os.Getenv("OLLAMA_PRELOAD_MODELS")
+	if raw == "" {
+		return nil
+	}
+
+	var refs []string
+	for _, ref := range strings.Split(raw, ",") {
+		if ref = strings.TrimSpace(ref); ref != "" {
+			refs = append(refs, ref)
+		}
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+
+	catalog, err := m.FetchGallery(ctx)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]GalleryModel, len(catalog))
+	for _, g := range catalog {
+		byName[g.Name] = g
+	}
+
+	sem := make(chan struct{}, maxConcurrentPreloads)
+	var wg sync.WaitGroup
+	errs := make([]error, len(refs))
+
+	for i, ref := range refs {
+		i, ref := i, ref
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = m.PreloadModel(ctx, ref, byName, func(status string, completed, total int64) {
+				if progressFn != nil {
+					progressFn(ref, status, completed, total)
+				}
+			})
+		}()
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			slog.Warn("failed to preload MLX model", "model", refs[i], "error", err)
+			failed = append(failed, refs[i])
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to preload %d model(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
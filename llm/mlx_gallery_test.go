@@ -0,0 +1,92 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetchGalleryCachesManifestByETag(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`{"models":[{"name":"mlx-community/Tiny-1bit","license":"MIT"}]}`))
+	}))
+	defer srv.Close()
+
+	t.Setenv("OLLAMA_MLX_GALLERY_URLS", srv.URL)
+	manager := &MLXModelManager{modelsDir: t.TempDir()}
+
+	first, err := manager.FetchGallery(context.Background())
+	if err != nil {
+		t.Fatalf("FetchGallery() error = %v", err)
+	}
+	if len(first) != 1 || first[0].Name != "mlx-community/Tiny-1bit" {
+		t.Fatalf("FetchGallery() = %+v", first)
+	}
+
+	second, err := manager.FetchGallery(context.Background())
+	if err != nil {
+		t.Fatalf("FetchGallery() (cached) error = %v", err)
+	}
+	if len(second) != 1 || second[0].Name != first[0].Name {
+		t.Fatalf("FetchGallery() (cached) = %+v, want %+v", second, first)
+	}
+
+	if requests != 2 {
+		t.Fatalf("requests = %d, want 2 (one per FetchGallery call)", requests)
+	}
+}
+
+func TestFetchGalleryMergesAndSortsAcrossURLs(t *testing.T) {
+	srvA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"models":[{"name":"mlx-community/Zeta"}]}`))
+	}))
+	defer srvA.Close()
+
+	srvB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"models":[{"name":"mlx-community/Alpha"}]}`))
+	}))
+	defer srvB.Close()
+
+	t.Setenv("OLLAMA_MLX_GALLERY_URLS", srvA.URL+","+srvB.URL)
+	manager := &MLXModelManager{modelsDir: t.TempDir()}
+
+	catalog, err := manager.FetchGallery(context.Background())
+	if err != nil {
+		t.Fatalf("FetchGallery() error = %v", err)
+	}
+	if len(catalog) != 2 || catalog[0].Name != "mlx-community/Alpha" || catalog[1].Name != "mlx-community/Zeta" {
+		t.Fatalf("FetchGallery() = %+v, want sorted [Alpha, Zeta]", catalog)
+	}
+}
+
+func TestVerifyShardsDetectsMismatch(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "weights.npz"), []byte("hello"), 0o644); err != nil {
+		t.Fatalf("failed to write test shard: %v", err)
+	}
+
+	// sha256("hello") = 2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824
+	if err := verifyShards(dir, map[string]string{
+		"weights.npz": "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+	}); err != nil {
+		t.Fatalf("verifyShards() with a matching digest returned %v", err)
+	}
+
+	if err := verifyShards(dir, map[string]string{"weights.npz": "sha256:deadbeef"}); err == nil {
+		t.Fatal("verifyShards() with a mismatched digest returned nil, want an error")
+	}
+
+	if err := verifyShards(dir, map[string]string{"missing.npz": "sha256:deadbeef"}); err == nil {
+		t.Fatal("verifyShards() with a missing file returned nil, want an error")
+	}
+}
@@ -0,0 +1,212 @@
+package llm
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Modelfile configurations created via CreateMLXModelFromModelfile are kept
+// in a content-addressed manifest store under <models>/mlx/manifests,
+// mirroring Ollama's GGUF manifest layout: each saved config is a blob keyed
+// by the sha256 digest of its canonical JSON encoding, and a separate
+// tags/<name> pointer file maps a human-readable model name to the digest it
+// currently resolves to. This means re-saving an identical config is a
+// no-op. Retagging a name overwrites its tag file: the manifest it pointed
+// to before stays in the store, but nothing records which name it belonged
+// to.
+
+// mlxManifestsDir returns <models>/mlx/manifests.
+func mlxManifestsDir() string {
+	return filepath.Join(NewMLXModelManager().GetModelsDir(), "mlx", "manifests")
+}
+
+// mlxTagsDir returns <models>/mlx/manifests/tags.
+func mlxTagsDir() string {
+	return filepath.Join(mlxManifestsDir(), "tags")
+}
+
+// mlxManifestPath returns the on-disk path for a manifest digest, e.g.
+// "sha256:abcd..." -> <manifests>/sha256/abcd...
+func mlxManifestPath(digest string) string {
+	algo, hex, ok := strings.Cut(digest, ":")
+	if !ok {
+		algo, hex = "sha256", digest
+	}
+	return filepath.Join(mlxManifestsDir(), algo, hex)
+}
+
+// saveMLXManifest writes mf's canonical JSON encoding to the manifest store,
+// keyed by its sha256 digest, and returns that digest.
+func saveMLXManifest(mf *MLXModelfile) (string, error) {
+	data, err := json.Marshal(mf)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal Modelfile config: %w", err)
+	}
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+
+	manifestPath := mlxManifestPath(digest)
+	if _, err := os.Stat(manifestPath); err == nil {
+		// Identical config already stored under this digest.
+		return digest, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(manifestPath), 0755); err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(manifestPath, data, 0644); err != nil {
+		return "", err
+	}
+
+	return digest, nil
+}
+
+// loadMLXManifest reads and decodes the Modelfile config stored at digest.
+func loadMLXManifest(digest string) (*MLXModelfile, error) {
+	data, err := os.ReadFile(mlxManifestPath(digest))
+	if err != nil {
+		return nil, err
+	}
+
+	var mf MLXModelfile
+	if err := json.Unmarshal(data, &mf); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", digest, err)
+	}
+
+	return &mf, nil
+}
+
+// sanitizeMLXTagName converts a model name into a single safe filesystem
+// component the same way llm.MLXModelManager.GetModelPath already does for
+// downloaded model directories (slashes -> underscores), so a name like
+// "mlx-community/MyModel" becomes a flat tag file rather than a nested
+// directory. This also closes off path traversal: with no "/" left in the
+// result, a name like "../../etc/passwd" can't mean anything but a literal
+// (harmless) filename.
+func sanitizeMLXTagName(name string) string {
+	return strings.ReplaceAll(name, "/", "_")
+}
+
+// tagMLXManifest points name at digest, creating or overwriting its tag file.
+func tagMLXManifest(name, digest string) error {
+	tagPath := filepath.Join(mlxTagsDir(), sanitizeMLXTagName(name))
+	if err := os.MkdirAll(filepath.Dir(tagPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(tagPath, []byte(digest), 0644)
+}
+
+// resolveMLXTag reads the manifest digest name currently points at.
+func resolveMLXTag(name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(mlxTagsDir(), sanitizeMLXTagName(name)))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// ShowMLXModel resolves name's tag to its manifest digest and returns the
+// Modelfile configuration stored there.
+func ShowMLXModel(name string) (*MLXModelfile, string, error) {
+	digest, err := resolveMLXTag(name)
+	if err != nil {
+		return nil, "", fmt.Errorf("model %q not found: %w", name, err)
+	}
+
+	mf, err := loadMLXManifest(digest)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return mf, digest, nil
+}
+
+// RemoveMLXModel removes name's tag. The underlying manifest blob is left in
+// place, since it may be shared by other tags with an identical
+// configuration, for a future garbage-collection pass to reclaim.
+func RemoveMLXModel(name string) error {
+	tagPath := filepath.Join(mlxTagsDir(), sanitizeMLXTagName(name))
+	if _, err := os.Stat(tagPath); err != nil {
+		return err
+	}
+	return os.Remove(tagPath)
+}
+
+// ListMLXModels returns the name, digest, and last-tagged time for every
+// model created via CreateMLXModelFromModelfile. This is distinct from
+// MLXModelManager.ListModels, which lists raw downloaded model directories
+// rather than tagged Modelfile configurations.
+//
+// Tags are flat files since sanitizeMLXTagName, but a tag written before
+// that sanitization landed can still be sitting on disk as a nested
+// "org/name" directory (the literal "/" in the name made filepath.Join
+// create one); rather than dropping those from the listing, one level of
+// subdirectory is walked to pick them up too.
+func ListMLXModels() ([]MLXModelInfo, error) {
+	root := mlxTagsDir()
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var models []MLXModelInfo
+	for _, entry := range entries {
+		if entry.IsDir() {
+			nested, err := os.ReadDir(filepath.Join(root, entry.Name()))
+			if err != nil {
+				continue
+			}
+			for _, child := range nested {
+				if child.IsDir() {
+					continue
+				}
+				name := entry.Name() + "/" + child.Name()
+				if model, ok := readMLXTagEntry(filepath.Join(root, entry.Name(), child.Name()), name, child); ok {
+					models = append(models, model)
+				}
+			}
+			continue
+		}
+
+		if model, ok := readMLXTagEntry(filepath.Join(root, entry.Name()), entry.Name(), entry); ok {
+			models = append(models, model)
+		}
+	}
+
+	return models, nil
+}
+
+// readMLXTagEntry reads the digest a tag file at tagPath points at and
+// confirms its manifest still exists, returning the MLXModelInfo ListMLXModels
+// reports for it under displayName.
+func readMLXTagEntry(tagPath, displayName string, entry os.DirEntry) (MLXModelInfo, bool) {
+	data, err := os.ReadFile(tagPath)
+	if err != nil {
+		return MLXModelInfo{}, false
+	}
+	digest := strings.TrimSpace(string(data))
+	if _, err := loadMLXManifest(digest); err != nil {
+		return MLXModelInfo{}, false
+	}
+
+	var modifiedAt time.Time
+	if info, err := entry.Info(); err == nil {
+		modifiedAt = info.ModTime()
+	}
+
+	return MLXModelInfo{
+		Name:       displayName,
+		Digest:     digest,
+		ModifiedAt: modifiedAt,
+		Format:     "MLX",
+	}, true
+}
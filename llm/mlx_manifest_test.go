@@ -0,0 +1,100 @@
+package llm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTagMLXManifestSanitizesTraversal(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	mf := &MLXModelfile{}
+	digest, err := saveMLXManifest(mf)
+	if err != nil {
+		t.Fatalf("saveMLXManifest() error = %v", err)
+	}
+
+	if err := tagMLXManifest("../../../../tmp/evil", digest); err != nil {
+		t.Fatalf("tagMLXManifest() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(mlxTagsDir(), "tmp", "evil")); err == nil {
+		t.Fatalf("tagMLXManifest() with a traversal name escaped mlxTagsDir()")
+	}
+
+	got, err := resolveMLXTag("../../../../tmp/evil")
+	if err != nil {
+		t.Fatalf("resolveMLXTag() error = %v", err)
+	}
+	if got != digest {
+		t.Errorf("resolveMLXTag() = %q, want %q", got, digest)
+	}
+}
+
+func TestTagMLXManifestRoundTripsNamespacedName(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	mf := &MLXModelfile{}
+	digest, err := saveMLXManifest(mf)
+	if err != nil {
+		t.Fatalf("saveMLXManifest() error = %v", err)
+	}
+
+	name := "mlx-community/MyModel"
+	if err := tagMLXManifest(name, digest); err != nil {
+		t.Fatalf("tagMLXManifest() error = %v", err)
+	}
+
+	got, err := resolveMLXTag(name)
+	if err != nil {
+		t.Fatalf("resolveMLXTag() error = %v", err)
+	}
+	if got != digest {
+		t.Errorf("resolveMLXTag() = %q, want %q", got, digest)
+	}
+
+	if _, err := os.Stat(filepath.Join(mlxTagsDir(), "mlx-community_MyModel")); err != nil {
+		t.Errorf("expected a single flat tag file, stat error = %v", err)
+	}
+}
+
+func TestListMLXModelsIncludesLegacyNestedTags(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	mf := &MLXModelfile{}
+	digest, err := saveMLXManifest(mf)
+	if err != nil {
+		t.Fatalf("saveMLXManifest() error = %v", err)
+	}
+
+	// Simulate a tag written before sanitizeMLXTagName existed, when a
+	// namespaced name's "/" made tagMLXManifest create a nested directory.
+	legacyDir := filepath.Join(mlxTagsDir(), "mlx-community")
+	if err := os.MkdirAll(legacyDir, 0o755); err != nil {
+		t.Fatalf("failed to create legacy tag dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(legacyDir, "OldModel"), []byte(digest), 0o644); err != nil {
+		t.Fatalf("failed to write legacy tag file: %v", err)
+	}
+
+	if err := tagMLXManifest("flat-model", digest); err != nil {
+		t.Fatalf("tagMLXManifest() error = %v", err)
+	}
+
+	models, err := ListMLXModels()
+	if err != nil {
+		t.Fatalf("ListMLXModels() error = %v", err)
+	}
+
+	names := map[string]bool{}
+	for _, m := range models {
+		names[m.Name] = true
+	}
+	if !names["mlx-community/OldModel"] {
+		t.Errorf("ListMLXModels() = %+v, want it to include the legacy namespaced tag", models)
+	}
+	if !names["flat-model"] {
+		t.Errorf("ListMLXModels() = %+v, want it to include the flat tag", models)
+	}
+}
@@ -1,10 +1,10 @@
 package llm
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/ollama/ollama/api"
@@ -27,8 +27,8 @@ type MLXModelfile struct {
 	// Parameters
 	Parameters map[string]interface{}
 
-	// Adapter paths (LoRA)
-	Adapters []string
+	// Adapter paths (LoRA), optionally stacked with a per-adapter scale
+	Adapters []MLXAdapter
 
 	// License
 	License string
@@ -37,117 +37,114 @@ type MLXModelfile struct {
 	Messages []api.Message
 }
 
-// ParseMLXModelfile parses a Modelfile and extracts MLX-specific configuration
+// MLXAdapter represents a LoRA adapter layered on top of the base model via the
+// ADAPTER directive (e.g. "ADAPTER ./adapter.safetensors scale=0.8"). Multiple
+// adapters may be stacked; each is applied with its own Scale, which defaults
+// to 1.0 when the directive omits it.
+type MLXAdapter struct {
+	Path  string  `json:"path"`
+	Scale float64 `json:"scale"`
+}
+
+// ParseMLXModelfile parses a Modelfile and extracts MLX-specific configuration.
+// Parsing is handled by a lexer/recursive-descent parser (see
+// mlx_modelfile_parser.go) so that triple-quoted blocks, escaped quotes, and
+// MESSAGE directives are handled correctly instead of by line-prefix matching.
 func ParseMLXModelfile(path string) (*MLXModelfile, error) {
 	content, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	mf := &MLXModelfile{
-		Parameters: make(map[string]interface{}),
+	mf, err := newModelfileParser(string(content)).parse()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
 	}
 
-	lines := strings.Split(string(content), "\n")
-	var currentCommand string
-	var currentValue strings.Builder
+	return mf, nil
+}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
+// parseAdapterDirective parses the value portion of an ADAPTER directive, which is
+// a path optionally followed by "scale=<float>", e.g. "./lora scale=0.8".
+func parseAdapterDirective(value string) (MLXAdapter, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return MLXAdapter{}, fmt.Errorf("expected an adapter path")
+	}
 
-		// Skip comments and empty lines
-		if line == "" || strings.HasPrefix(line, "#") {
+	adapter := MLXAdapter{Path: fields[0], Scale: 1.0}
+	for _, field := range fields[1:] {
+		key, val, ok := strings.Cut(field, "=")
+		if !ok || key != "scale" {
 			continue
 		}
-
-		// Check for command keywords
-		if strings.HasPrefix(line, "FROM ") {
-			mf.From = strings.TrimSpace(strings.TrimPrefix(line, "FROM "))
-			continue
+		scale, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return MLXAdapter{}, fmt.Errorf("invalid scale %q: %w", val, err)
 		}
+		adapter.Scale = scale
+	}
 
-		if strings.HasPrefix(line, "SYSTEM ") {
-			currentCommand = "SYSTEM"
-			currentValue.Reset()
-			value := strings.TrimPrefix(line, "SYSTEM ")
-			// Handle both inline and multiline
-			if strings.HasPrefix(value, "\"\"\"") {
-				currentValue.WriteString(strings.TrimPrefix(value, "\"\"\""))
-			} else {
-				mf.System = strings.Trim(value, "\"")
-				currentCommand = ""
-			}
-			continue
-		}
+	return adapter, nil
+}
 
-		if strings.HasPrefix(line, "TEMPLATE ") {
-			currentCommand = "TEMPLATE"
-			currentValue.Reset()
-			value := strings.TrimPrefix(line, "TEMPLATE ")
-			if strings.HasPrefix(value, "\"\"\"") {
-				currentValue.WriteString(strings.TrimPrefix(value, "\"\"\""))
-			} else {
-				mf.Template = strings.Trim(value, "\"")
-				currentCommand = ""
-			}
-			continue
-		}
+// validateAdapterPath ensures an ADAPTER path exists and points at a file MLX
+// can load as a LoRA adapter (mlx_lm only accepts safetensors or npz weights).
+func validateAdapterPath(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("expected a file, got a directory")
+	}
 
-		if strings.HasPrefix(line, "PARAMETER ") {
-			parts := strings.SplitN(strings.TrimPrefix(line, "PARAMETER "), " ", 2)
-			if len(parts) == 2 {
-				mf.Parameters[parts[0]] = parseParameterValue(parts[1])
-			}
-			continue
-		}
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".safetensors" && ext != ".npz" {
+		return fmt.Errorf("adapter file must be .safetensors or .npz, got %q", ext)
+	}
 
-		if strings.HasPrefix(line, "ADAPTER ") {
-			adapter := strings.TrimSpace(strings.TrimPrefix(line, "ADAPTER "))
-			mf.Adapters = append(mf.Adapters, adapter)
-			continue
-		}
+	return nil
+}
 
-		if strings.HasPrefix(line, "LICENSE ") {
-			currentCommand = "LICENSE"
-			currentValue.Reset()
-			value := strings.TrimPrefix(line, "LICENSE ")
-			if strings.HasPrefix(value, "\"\"\"") {
-				currentValue.WriteString(strings.TrimPrefix(value, "\"\"\""))
-			} else {
-				mf.License = strings.Trim(value, "\"")
-				currentCommand = ""
-			}
-			continue
+// ResolveMLXAdapters validates each ADAPTER directive on mf and resolves its path
+// to an absolute path, ready to hand to the MLX backend for mlx_lm.load(...,
+// adapter_path=...). Adapters are returned in the order they were declared so
+// stacked LoRAs apply in a predictable sequence.
+func ResolveMLXAdapters(mf *MLXModelfile) ([]MLXAdapter, error) {
+	resolved := make([]MLXAdapter, 0, len(mf.Adapters))
+	for _, adapter := range mf.Adapters {
+		if err := validateAdapterPath(adapter.Path); err != nil {
+			return nil, fmt.Errorf("invalid ADAPTER %q: %w", adapter.Path, err)
 		}
 
-		// Handle multiline content
-		if currentCommand != "" {
-			if strings.HasSuffix(line, "\"\"\"") {
-				currentValue.WriteString("\n")
-				currentValue.WriteString(strings.TrimSuffix(line, "\"\"\""))
-
-				switch currentCommand {
-				case "SYSTEM":
-					mf.System = currentValue.String()
-				case "TEMPLATE":
-					mf.Template = currentValue.String()
-				case "LICENSE":
-					mf.License = currentValue.String()
-				}
-				currentCommand = ""
-			} else {
-				currentValue.WriteString("\n")
-				currentValue.WriteString(line)
-			}
+		abs, err := filepath.Abs(adapter.Path)
+		if err != nil {
+			return nil, fmt.Errorf("resolve ADAPTER %q: %w", adapter.Path, err)
 		}
+
+		resolved = append(resolved, MLXAdapter{Path: abs, Scale: adapter.Scale})
 	}
 
-	// Validate required fields
-	if mf.From == "" {
-		return nil, fmt.Errorf("Modelfile must specify FROM directive")
+	return resolved, nil
+}
+
+// ApplyMLXAdapters adds a resolved adapter list to an MLX options map under the
+// "adapters" key so the backend can stack one or more LoRAs at generation time,
+// each with its own scale, mirroring how GGUF layers ADAPTER weights.
+func ApplyMLXAdapters(mlxOpts map[string]interface{}, adapters []MLXAdapter) {
+	if len(adapters) == 0 {
+		return
 	}
 
-	return mf, nil
+	list := make([]map[string]interface{}, 0, len(adapters))
+	for _, adapter := range adapters {
+		list = append(list, map[string]interface{}{
+			"path":  adapter.Path,
+			"scale": adapter.Scale,
+		})
+	}
+	mlxOpts["adapters"] = list
 }
 
 // parseParameterValue converts string parameter values to appropriate types
@@ -180,6 +177,16 @@ func parseParameterValue(value string) interface{} {
 	return strings.Trim(value, "\"")
 }
 
+// escapeMLXTripleQuoted escapes every quote of any "\"\"\"" already present
+// in s so it round-trips through a Modelfile's triple-quoted blocks:
+// modelfileScanner's scanTripleString (mlx_modelfile_parser.go) reads a
+// backslash followed by a quote as one literal quote, so an embedded """
+// written as three escaped quotes is never taken for the block's closing
+// delimiter, which would truncate or corrupt the Modelfile on save.
+func escapeMLXTripleQuoted(s string) string {
+	return strings.ReplaceAll(s, `"""`, `\"\"\"`)
+}
+
 // SaveMLXModelfile saves an MLX model configuration to a Modelfile
 func SaveMLXModelfile(path string, mf *MLXModelfile) error {
 	var content strings.Builder
@@ -187,32 +194,49 @@ func SaveMLXModelfile(path string, mf *MLXModelfile) error {
 	content.WriteString(fmt.Sprintf("FROM %s\n\n", mf.From))
 
 	if mf.System != "" {
-		content.WriteString("SYSTEM \"\"\"\n")
-		content.WriteString(mf.System)
-		content.WriteString("\n\"\"\"\n\n")
+		content.WriteString("SYSTEM \"\"\"")
+		content.WriteString(escapeMLXTripleQuoted(mf.System))
+		content.WriteString("\"\"\"\n\n")
 	}
 
 	if mf.Template != "" {
-		content.WriteString("TEMPLATE \"\"\"\n")
-		content.WriteString(mf.Template)
-		content.WriteString("\n\"\"\"\n\n")
+		content.WriteString("TEMPLATE \"\"\"")
+		content.WriteString(escapeMLXTripleQuoted(mf.Template))
+		content.WriteString("\"\"\"\n\n")
 	}
 
 	for key, value := range mf.Parameters {
+		if list, ok := value.([]interface{}); ok {
+			for _, item := range list {
+				content.WriteString(fmt.Sprintf("PARAMETER %s %v\n", key, item))
+			}
+			continue
+		}
 		content.WriteString(fmt.Sprintf("PARAMETER %s %v\n", key, value))
 	}
 
+	if len(mf.Messages) > 0 {
+		content.WriteString("\n")
+		for _, m := range mf.Messages {
+			content.WriteString(fmt.Sprintf("MESSAGE %s \"\"\"%s\"\"\"\n", m.Role, escapeMLXTripleQuoted(m.Content)))
+		}
+	}
+
 	if len(mf.Adapters) > 0 {
 		content.WriteString("\n")
 		for _, adapter := range mf.Adapters {
-			content.WriteString(fmt.Sprintf("ADAPTER %s\n", adapter))
+			if adapter.Scale != 1.0 {
+				content.WriteString(fmt.Sprintf("ADAPTER %s scale=%g\n", adapter.Path, adapter.Scale))
+			} else {
+				content.WriteString(fmt.Sprintf("ADAPTER %s\n", adapter.Path))
+			}
 		}
 	}
 
 	if mf.License != "" {
-		content.WriteString("\nLICENSE \"\"\"\n")
-		content.WriteString(mf.License)
-		content.WriteString("\n\"\"\"\n")
+		content.WriteString("\nLICENSE \"\"\"")
+		content.WriteString(escapeMLXTripleQuoted(mf.License))
+		content.WriteString("\"\"\"\n")
 	}
 
 	return os.WriteFile(path, []byte(content.String()), 0644)
@@ -239,17 +263,19 @@ func CreateMLXModelFromModelfile(name string, modelfilePath string) error {
 		}
 	}
 
-	// Store the modelfile configuration
-	configPath := filepath.Join(manager.GetModelsDir(), ".modelfiles", name+".json")
-	os.MkdirAll(filepath.Dir(configPath), 0755)
+	if _, err := ResolveMLXAdapters(mf); err != nil {
+		return err
+	}
 
-	configData, err := json.MarshalIndent(mf, "", "  ")
+	// Store the modelfile configuration in the content-addressed manifest
+	// store and point name's tag at it.
+	digest, err := saveMLXManifest(mf)
 	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
+		return fmt.Errorf("failed to save config: %w", err)
 	}
 
-	if err := os.WriteFile(configPath, configData, 0644); err != nil {
-		return fmt.Errorf("failed to save config: %w", err)
+	if err := tagMLXManifest(name, digest); err != nil {
+		return fmt.Errorf("failed to tag config: %w", err)
 	}
 
 	return nil
@@ -257,10 +283,7 @@ func CreateMLXModelFromModelfile(name string, modelfilePath string) error {
 
 // GetMLXModelConfig retrieves the Modelfile configuration for an MLX model
 func GetMLXModelConfig(name string) (*MLXModelfile, error) {
-	manager := NewMLXModelManager()
-	configPath := filepath.Join(manager.GetModelsDir(), ".modelfiles", name+".json")
-
-	data, err := os.ReadFile(configPath)
+	mf, _, err := ShowMLXModel(name)
 	if err != nil {
 		// No custom config, return defaults
 		return &MLXModelfile{
@@ -269,16 +292,17 @@ func GetMLXModelConfig(name string) (*MLXModelfile, error) {
 		}, nil
 	}
 
-	var mf MLXModelfile
-	if err := json.Unmarshal(data, &mf); err != nil {
-		return nil, fmt.Errorf("failed to parse config: %w", err)
-	}
-
-	return &mf, nil
+	return mf, nil
 }
 
-// ConvertOptionsToMLXFormat converts Ollama API options to MLX-compatible format
-func ConvertOptionsToMLXFormat(opts api.Options) map[string]interface{} {
+// ConvertOptionsToMLXFormat converts Ollama API options to MLX-compatible format.
+// extra carries MLX-only sampler knobs that have no equivalent api.Options field
+// (xtc_probability, xtc_threshold); these are sourced from a Modelfile's
+// PARAMETER directives rather than the core options schema, e.g.:
+//
+//	PARAMETER xtc_probability 0.5
+//	PARAMETER xtc_threshold 0.1
+func ConvertOptionsToMLXFormat(opts api.Options, extra map[string]interface{}) map[string]interface{} {
 	mlxOpts := make(map[string]interface{})
 
 	// Map Ollama parameters to MLX equivalents
@@ -291,15 +315,38 @@ func ConvertOptionsToMLXFormat(opts api.Options) map[string]interface{} {
 	if opts.TopP > 0 {
 		mlxOpts["top_p"] = opts.TopP
 	}
+	if opts.MinP > 0 {
+		// mlx-lm truncates tokens below min_p * max_prob.
+		mlxOpts["min_p"] = opts.MinP
+	}
+	if opts.TypicalP > 0 {
+		// Locally typical sampling: keep tokens whose negative log-prob is
+		// closest to the distribution's entropy until cumulative mass
+		// reaches typical_p.
+		mlxOpts["typical_p"] = opts.TypicalP
+	}
 	if opts.NumPredict > 0 {
 		mlxOpts["max_tokens"] = opts.NumPredict
 	}
 	if opts.RepeatPenalty > 0 {
 		mlxOpts["repetition_penalty"] = opts.RepeatPenalty
 	}
+	if opts.RepeatLastN > 0 {
+		// How many prior tokens the repetition penalty applies to.
+		mlxOpts["repetition_context_size"] = opts.RepeatLastN
+	}
 	if opts.Seed > 0 {
 		mlxOpts["seed"] = opts.Seed
 	}
 
+	// XTC (exclude-top-choices): with probability xtc_probability, drop
+	// tokens above xtc_threshold except the least-probable one among them,
+	// to encourage diversity. Only forwarded when the Modelfile sets it.
+	for _, key := range []string{"xtc_probability", "xtc_threshold"} {
+		if v, ok := extra[key]; ok {
+			mlxOpts[key] = v
+		}
+	}
+
 	return mlxOpts
 }
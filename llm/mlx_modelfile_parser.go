@@ -0,0 +1,415 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// tokenKind identifies the lexical class of a modelfileToken.
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokTripleString
+	tokNewline
+)
+
+// modelfileToken is one lexical unit produced by modelfileScanner, tagged with
+// its source line/column so parse errors can point back at the Modelfile.
+type modelfileToken struct {
+	kind   tokenKind
+	value  string
+	line   int
+	column int
+}
+
+// modelfileScanner turns raw Modelfile text into a stream of tokens. It is a
+// small hand-written lexer rather than text/scanner because Modelfiles mix
+// bare identifiers, single-line quoted strings, and triple-quoted blocks that
+// may open and close on the same line or span many lines and contain escaped
+// quotes.
+type modelfileScanner struct {
+	src    []rune
+	pos    int
+	line   int
+	column int
+}
+
+func newModelfileScanner(content string) *modelfileScanner {
+	return &modelfileScanner{src: []rune(content), line: 1, column: 1}
+}
+
+func (s *modelfileScanner) peekAt(offset int) (rune, bool) {
+	if s.pos+offset >= len(s.src) {
+		return 0, false
+	}
+	return s.src[s.pos+offset], true
+}
+
+func (s *modelfileScanner) peek() (rune, bool) {
+	return s.peekAt(0)
+}
+
+func (s *modelfileScanner) advance() (rune, bool) {
+	r, ok := s.peek()
+	if !ok {
+		return 0, false
+	}
+	s.pos++
+	if r == '\n' {
+		s.line++
+		s.column = 1
+	} else {
+		s.column++
+	}
+	return r, true
+}
+
+// next returns the next token, skipping spaces/tabs and "# ..." comments.
+func (s *modelfileScanner) next() (modelfileToken, error) {
+	for {
+		r, ok := s.peek()
+		if !ok {
+			return modelfileToken{kind: tokEOF, line: s.line, column: s.column}, nil
+		}
+
+		switch r {
+		case ' ', '\t', '\r':
+			s.advance()
+			continue
+		case '#':
+			for {
+				r, ok := s.peek()
+				if !ok || r == '\n' {
+					break
+				}
+				s.advance()
+			}
+			continue
+		case '\n':
+			line, column := s.line, s.column
+			s.advance()
+			return modelfileToken{kind: tokNewline, line: line, column: column}, nil
+		case '"':
+			if n1, ok1 := s.peekAt(1); ok1 && n1 == '"' {
+				if n2, ok2 := s.peekAt(2); ok2 && n2 == '"' {
+					return s.scanTripleString()
+				}
+			}
+			return s.scanString()
+		default:
+			return s.scanIdent()
+		}
+	}
+}
+
+func (s *modelfileScanner) scanString() (modelfileToken, error) {
+	line, column := s.line, s.column
+	s.advance() // opening quote
+
+	var b strings.Builder
+	for {
+		r, ok := s.advance()
+		if !ok {
+			return modelfileToken{}, fmt.Errorf("line %d:%d: unterminated string", line, column)
+		}
+		if r == '\\' {
+			esc, ok := s.advance()
+			if !ok {
+				return modelfileToken{}, fmt.Errorf("line %d:%d: unterminated escape sequence", line, column)
+			}
+			switch esc {
+			case 'n':
+				b.WriteRune('\n')
+			case 't':
+				b.WriteRune('\t')
+			case '"', '\\':
+				b.WriteRune(esc)
+			default:
+				b.WriteRune('\\')
+				b.WriteRune(esc)
+			}
+			continue
+		}
+		if r == '"' {
+			break
+		}
+		b.WriteRune(r)
+	}
+
+	return modelfileToken{kind: tokString, value: b.String(), line: line, column: column}, nil
+}
+
+// scanTripleString reads a """..."""-delimited block. Unlike scanString it
+// does not stop at newlines, and a backslash followed by a quote yields one
+// literal quote, so an escaped quote never counts toward the closing
+// delimiter.
+func (s *modelfileScanner) scanTripleString() (modelfileToken, error) {
+	line, column := s.line, s.column
+	s.advance()
+	s.advance()
+	s.advance() // opening """
+
+	var b strings.Builder
+	for {
+		r, ok := s.peek()
+		if !ok {
+			return modelfileToken{}, fmt.Errorf("line %d:%d: unterminated triple-quoted string", line, column)
+		}
+
+		if r == '\\' {
+			if n1, ok1 := s.peekAt(1); ok1 && n1 == '"' {
+				s.advance()
+				r, _ := s.advance()
+				b.WriteRune(r)
+				continue
+			}
+		}
+
+		if r == '"' {
+			if n1, ok1 := s.peekAt(1); ok1 && n1 == '"' {
+				if n2, ok2 := s.peekAt(2); ok2 && n2 == '"' {
+					s.advance()
+					s.advance()
+					s.advance()
+					return modelfileToken{kind: tokTripleString, value: b.String(), line: line, column: column}, nil
+				}
+			}
+		}
+
+		r, _ = s.advance()
+		b.WriteRune(r)
+	}
+}
+
+func (s *modelfileScanner) scanIdent() (modelfileToken, error) {
+	line, column := s.line, s.column
+	var b strings.Builder
+	for {
+		r, ok := s.peek()
+		if !ok || r == '\n' || r == ' ' || r == '\t' || r == '\r' || r == '"' {
+			break
+		}
+		s.advance()
+		b.WriteRune(r)
+	}
+	return modelfileToken{kind: tokIdent, value: b.String(), line: line, column: column}, nil
+}
+
+// modelfileParser is a small recursive-descent parser over the token stream
+// produced by modelfileScanner. One line is one statement: a leading IDENT
+// names the directive, and the rest of the line is interpreted according to
+// that directive's grammar.
+type modelfileParser struct {
+	scanner   *modelfileScanner
+	lookahead *modelfileToken
+}
+
+func newModelfileParser(content string) *modelfileParser {
+	return &modelfileParser{scanner: newModelfileScanner(content)}
+}
+
+func (p *modelfileParser) peek() (modelfileToken, error) {
+	if p.lookahead != nil {
+		return *p.lookahead, nil
+	}
+	tok, err := p.scanner.next()
+	if err != nil {
+		return tok, err
+	}
+	p.lookahead = &tok
+	return tok, nil
+}
+
+func (p *modelfileParser) take() (modelfileToken, error) {
+	tok, err := p.peek()
+	if err != nil {
+		return tok, err
+	}
+	p.lookahead = nil
+	return tok, nil
+}
+
+// restOfLine consumes every remaining token on the current line and joins
+// their literal text with spaces; used for directives like FROM and PARAMETER
+// values that accept bare, unquoted text.
+func (p *modelfileParser) restOfLine() (string, error) {
+	var parts []string
+	for {
+		tok, err := p.peek()
+		if err != nil {
+			return "", err
+		}
+		if tok.kind == tokNewline || tok.kind == tokEOF {
+			break
+		}
+		p.take()
+		parts = append(parts, tok.value)
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// stringValue parses the value for SYSTEM/TEMPLATE/LICENSE/MESSAGE: either a
+// quoted or triple-quoted string, or (for backwards compatibility) bare text
+// running to the end of the line.
+func (p *modelfileParser) stringValue() (string, error) {
+	tok, err := p.peek()
+	if err != nil {
+		return "", err
+	}
+	if tok.kind == tokString || tok.kind == tokTripleString {
+		p.take()
+		return tok.value, nil
+	}
+	return p.restOfLine()
+}
+
+// expectEndOfLine reports a syntax error if the current line has trailing
+// tokens a directive's grammar did not consume.
+func (p *modelfileParser) expectEndOfLine() error {
+	tok, err := p.peek()
+	if err != nil {
+		return err
+	}
+	if tok.kind != tokNewline && tok.kind != tokEOF {
+		return fmt.Errorf("line %d:%d: unexpected trailing token %q", tok.line, tok.column, tok.value)
+	}
+	return nil
+}
+
+func (p *modelfileParser) parse() (*MLXModelfile, error) {
+	mf := &MLXModelfile{Parameters: make(map[string]interface{})}
+
+	for {
+		tok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if tok.kind == tokEOF {
+			break
+		}
+		if tok.kind == tokNewline {
+			p.take()
+			continue
+		}
+
+		keyword, err := p.take()
+		if err != nil {
+			return nil, err
+		}
+		if keyword.kind != tokIdent {
+			return nil, fmt.Errorf("line %d:%d: expected a directive, got %q", keyword.line, keyword.column, keyword.value)
+		}
+
+		if err := p.parseDirective(mf, keyword); err != nil {
+			return nil, err
+		}
+
+		if err := p.expectEndOfLine(); err != nil {
+			return nil, err
+		}
+	}
+
+	if mf.From == "" {
+		return nil, fmt.Errorf("Modelfile must specify FROM directive")
+	}
+
+	return mf, nil
+}
+
+func (p *modelfileParser) parseDirective(mf *MLXModelfile, keyword modelfileToken) error {
+	switch strings.ToUpper(keyword.value) {
+	case "FROM":
+		value, err := p.restOfLine()
+		if err != nil {
+			return err
+		}
+		mf.From = strings.TrimSpace(value)
+
+	case "SYSTEM":
+		value, err := p.stringValue()
+		if err != nil {
+			return err
+		}
+		mf.System = value
+
+	case "TEMPLATE":
+		value, err := p.stringValue()
+		if err != nil {
+			return err
+		}
+		mf.Template = value
+
+	case "LICENSE":
+		value, err := p.stringValue()
+		if err != nil {
+			return err
+		}
+		mf.License = value
+
+	case "PARAMETER":
+		name, err := p.take()
+		if err != nil {
+			return err
+		}
+		if name.kind != tokIdent {
+			return fmt.Errorf("line %d:%d: PARAMETER requires a name", name.line, name.column)
+		}
+		value, err := p.restOfLine()
+		if err != nil {
+			return err
+		}
+		addMLXParameter(mf.Parameters, name.value, parseParameterValue(value))
+
+	case "ADAPTER":
+		value, err := p.restOfLine()
+		if err != nil {
+			return err
+		}
+		adapter, err := parseAdapterDirective(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("line %d:%d: invalid ADAPTER directive: %w", keyword.line, keyword.column, err)
+		}
+		mf.Adapters = append(mf.Adapters, adapter)
+
+	case "MESSAGE":
+		role, err := p.take()
+		if err != nil {
+			return err
+		}
+		if role.kind != tokIdent {
+			return fmt.Errorf("line %d:%d: MESSAGE requires a role", role.line, role.column)
+		}
+		content, err := p.stringValue()
+		if err != nil {
+			return err
+		}
+		mf.Messages = append(mf.Messages, api.Message{Role: role.value, Content: content})
+
+	default:
+		return fmt.Errorf("line %d:%d: unknown directive %q", keyword.line, keyword.column, keyword.value)
+	}
+
+	return nil
+}
+
+// addMLXParameter records a PARAMETER value, collecting repeated keys (e.g.
+// multiple "PARAMETER stop" lines) into a slice instead of overwriting.
+func addMLXParameter(params map[string]interface{}, name string, value interface{}) {
+	existing, ok := params[name]
+	if !ok {
+		params[name] = value
+		return
+	}
+
+	if list, ok := existing.([]interface{}); ok {
+		params[name] = append(list, value)
+		return
+	}
+
+	params[name] = []interface{}{existing, value}
+}
@@ -0,0 +1,173 @@
+package llm
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestParseMLXModelfileRoundTrip(t *testing.T) {
+	mf := &MLXModelfile{
+		From:     "mlx-community/Llama-3.2-1B-Instruct-4bit",
+		System:   "You are a helpful assistant.\nBe concise.",
+		Template: "{{ .Prompt }}",
+		Parameters: map[string]interface{}{
+			"num_ctx": 4096,
+			"stop":    []interface{}{"<|eot_id|>", "<|end|>"},
+		},
+		Adapters: []MLXAdapter{
+			{Path: "./adapters/one.safetensors", Scale: 1},
+			{Path: "./adapters/two.npz", Scale: 0.8},
+		},
+		License: "MIT",
+		Messages: []api.Message{
+			{Role: "user", Content: "hi"},
+			{Role: "assistant", Content: "hello there"},
+		},
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Modelfile")
+	if err := SaveMLXModelfile(path, mf); err != nil {
+		t.Fatalf("SaveMLXModelfile() error = %v", err)
+	}
+
+	got, err := ParseMLXModelfile(path)
+	if err != nil {
+		t.Fatalf("ParseMLXModelfile() error = %v", err)
+	}
+
+	if got.From != mf.From {
+		t.Errorf("From = %q, want %q", got.From, mf.From)
+	}
+	if got.System != mf.System {
+		t.Errorf("System = %q, want %q", got.System, mf.System)
+	}
+	if got.Template != mf.Template {
+		t.Errorf("Template = %q, want %q", got.Template, mf.Template)
+	}
+	if got.License != mf.License {
+		t.Errorf("License = %q, want %q", got.License, mf.License)
+	}
+	if !reflect.DeepEqual(got.Adapters, mf.Adapters) {
+		t.Errorf("Adapters = %+v, want %+v", got.Adapters, mf.Adapters)
+	}
+	if !reflect.DeepEqual(got.Messages, mf.Messages) {
+		t.Errorf("Messages = %+v, want %+v", got.Messages, mf.Messages)
+	}
+
+	stop, ok := got.Parameters["stop"].([]interface{})
+	if !ok || !reflect.DeepEqual(stop, mf.Parameters["stop"]) {
+		t.Errorf("Parameters[stop] = %#v, want %#v", got.Parameters["stop"], mf.Parameters["stop"])
+	}
+}
+
+func TestParseMLXModelfileSameLineTripleQuote(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Modelfile")
+	content := "FROM mlx-community/test\n" +
+		`SYSTEM """Be terse."""` + "\n" +
+		`MESSAGE user """hello \"world\""""` + "\n"
+	writeFile(t, path, content)
+
+	mf, err := ParseMLXModelfile(path)
+	if err != nil {
+		t.Fatalf("ParseMLXModelfile() error = %v", err)
+	}
+
+	if mf.System != "Be terse." {
+		t.Errorf("System = %q, want %q", mf.System, "Be terse.")
+	}
+	if len(mf.Messages) != 1 || mf.Messages[0].Content != `hello "world"` {
+		t.Errorf("Messages = %+v, want one message with escaped quotes preserved", mf.Messages)
+	}
+}
+
+func TestSaveMLXModelfileEscapesEmbeddedTripleQuotes(t *testing.T) {
+	mf := &MLXModelfile{
+		From:     "mlx-community/test",
+		System:   `She said """hi""" to me.`,
+		Template: `{{ if true }}"""{{ end }}`,
+		License:  `Contains a """ sequence.`,
+		Messages: []api.Message{
+			{Role: "user", Content: `quote this: """`},
+		},
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Modelfile")
+	if err := SaveMLXModelfile(path, mf); err != nil {
+		t.Fatalf("SaveMLXModelfile() error = %v", err)
+	}
+
+	got, err := ParseMLXModelfile(path)
+	if err != nil {
+		t.Fatalf("ParseMLXModelfile() error = %v", err)
+	}
+
+	if got.System != mf.System {
+		t.Errorf("System = %q, want %q", got.System, mf.System)
+	}
+	if got.Template != mf.Template {
+		t.Errorf("Template = %q, want %q", got.Template, mf.Template)
+	}
+	if got.License != mf.License {
+		t.Errorf("License = %q, want %q", got.License, mf.License)
+	}
+	if !reflect.DeepEqual(got.Messages, mf.Messages) {
+		t.Errorf("Messages = %+v, want %+v", got.Messages, mf.Messages)
+	}
+}
+
+func TestParseMLXModelfileRepeatedParameter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Modelfile")
+	content := "FROM mlx-community/test\n" +
+		`PARAMETER stop "<|eot_id|>"` + "\n" +
+		`PARAMETER stop "<|end|>"` + "\n"
+	writeFile(t, path, content)
+
+	mf, err := ParseMLXModelfile(path)
+	if err != nil {
+		t.Fatalf("ParseMLXModelfile() error = %v", err)
+	}
+
+	stop, ok := mf.Parameters["stop"].([]interface{})
+	if !ok || len(stop) != 2 {
+		t.Fatalf("Parameters[stop] = %#v, want a 2-element slice", mf.Parameters["stop"])
+	}
+	if stop[0] != "<|eot_id|>" || stop[1] != "<|end|>" {
+		t.Errorf("Parameters[stop] = %#v, want [<|eot_id|> <|end|>]", stop)
+	}
+}
+
+func TestParseMLXModelfileRejectsUnterminatedTripleQuote(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Modelfile")
+	content := "FROM mlx-community/test\n" + `SYSTEM """unterminated` + "\n"
+	writeFile(t, path, content)
+
+	if _, err := ParseMLXModelfile(path); err == nil {
+		t.Fatal("expected an error for an unterminated triple-quoted string")
+	}
+}
+
+func TestParseMLXModelfileRejectsMissingFrom(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Modelfile")
+	writeFile(t, path, `SYSTEM "no FROM directive"`+"\n")
+
+	if _, err := ParseMLXModelfile(path); err == nil {
+		t.Fatal("expected an error when FROM is missing")
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write test Modelfile: %v", err)
+	}
+}
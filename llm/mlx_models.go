@@ -9,7 +9,9 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ollama/ollama/envconfig"
@@ -17,16 +19,27 @@ import (
 
 // MLXModelInfo represents metadata about an MLX model
 type MLXModelInfo struct {
-	Name           string    `json:"name"`
-	Size           int64     `json:"size"`
-	Digest         string    `json:"digest"`
-	ModifiedAt     time.Time `json:"modified_at"`
-	Format         string    `json:"format"`
-	Family         string    `json:"family"`
-	ParameterSize  string    `json:"parameter_size"`
-	QuantizLevel   string    `json:"quantization_level"`
-	LocalPath      string    `json:"-"`
-	HuggingFaceURL string    `json:"huggingface_url,omitempty"`
+	Name          string    `json:"name"`
+	Size          int64     `json:"size"`
+	Digest        string    `json:"digest"`
+	ModifiedAt    time.Time `json:"modified_at"`
+	Format        string    `json:"format"`
+	Family        string    `json:"family"`
+	ParameterSize string    `json:"parameter_size"`
+	QuantizLevel  string    `json:"quantization_level"`
+	LocalPath     string    `json:"-"`
+
+	// LayoutFingerprint is the cheap name+size hash computeDigest has always
+	// produced - fast to recompute, but only a fingerprint, not proof a
+	// file's bytes are intact.
+	LayoutFingerprint Digest `json:"layout_fingerprint,omitempty"`
+	// ContentDigest is the real per-file sha256 recorded in the model's
+	// manifest at download time (see mlx_blobstore.go), keyed by filename.
+	// Only populated for models pulled through the blob store; empty for
+	// legacy flat-directory models.
+	ContentDigest map[string]Digest `json:"content_digest,omitempty"`
+
+	HuggingFaceURL string `json:"huggingface_url,omitempty"`
 }
 
 // MLXModelManager handles MLX model storage and retrieval
@@ -42,7 +55,8 @@ type hfModelInfo struct {
 		RFilename string `json:"rfilename"`
 		Size      int64  `json:"size"`
 		LFS       struct {
-			Size int64 `json:"size"`
+			Size int64  `json:"size"`
+			Oid  string `json:"oid"` // sha256 content hash, when the file is stored via Git LFS
 		} `json:"lfs"`
 	} `json:"siblings"`
 }
@@ -161,6 +175,19 @@ func (m *MLXModelManager) GetModelInfo(modelName string) (MLXModelInfo, error) {
 		}
 	}
 
+	// A model pulled onto the blob store has a manifest recording its real
+	// size/digest directly; calculateDirSize/computeDigest would instead see
+	// only the tiny size of each file's symlink, so prefer the manifest
+	// whenever one exists and fall back to the directory walk for legacy
+	// flat-directory models that predate it.
+	if mf, err := readMLXModelManifest(modelName); err == nil {
+		info.Size = manifestSize(mf)
+		info.Digest = manifestDigest(mf)
+		info.LayoutFingerprint = manifestLayoutFingerprint(mf)
+		info.ContentDigest = manifestContentDigests(mf)
+		return info, nil
+	}
+
 	// Calculate total size
 	size, err := m.calculateDirSize(modelPath)
 	if err == nil {
@@ -170,9 +197,11 @@ func (m *MLXModelManager) GetModelInfo(modelName string) (MLXModelInfo, error) {
 	// Generate a stable digest from file layout (fallback to name if it fails)
 	if digest, err := computeDigest(modelPath); err == nil {
 		info.Digest = digest
+		info.LayoutFingerprint = Digest(digest)
 	} else {
 		sum := sha256.Sum256([]byte(modelName))
 		info.Digest = fmt.Sprintf("sha256:%x", sum)
+		info.LayoutFingerprint = Digest(info.Digest)
 	}
 
 	return info, nil
@@ -209,27 +238,55 @@ func (m *MLXModelManager) ModelExists(modelName string) bool {
 	return err1 == nil || err2 == nil
 }
 
-// DeleteModel removes a model from local storage
+// DeleteModel removes a model from local storage. For a model pulled onto
+// the blob store, its manifest is removed first and each blob it referenced
+// is only deleted from the shared store once no other model's manifest
+// still references it (mlxBlobRefCount); legacy flat-directory models with
+// no manifest fall back to plainly removing their directory.
 func (m *MLXModelManager) DeleteModel(modelName string) error {
 	modelPath := m.GetModelPath(modelName)
-	return os.RemoveAll(modelPath)
-}
 
-// calculateDirSize calculates the total size of a directory
-func (m *MLXModelManager) calculateDirSize(path string) (int64, error) {
-	var size int64
+	mf, err := readMLXModelManifest(modelName)
+	if err != nil {
+		return os.RemoveAll(modelPath)
+	}
 
-	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+	if err := os.RemoveAll(modelPath); err != nil {
+		return err
+	}
+	if err := os.Remove(mlxModelManifestPath(modelName)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove manifest: %w", err)
+	}
+
+	for _, digest := range mf.blobDigests() {
+		refs, err := mlxBlobRefCount(digest)
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to check blob references: %w", err)
 		}
-		if !info.IsDir() {
-			size += info.Size()
+		if refs == 0 {
+			os.Remove(mlxBlobPath(digest))
 		}
-		return nil
-	})
+	}
+
+	return nil
+}
 
-	return size, err
+// calculateDirSize calculates the total size of a directory, via the
+// bounded parallel walker in mlx_fastwalk.go rather than filepath.Walk's
+// single goroutine, and reuses a cached result when path's mtime hasn't
+// changed since the last call (see dirSizeCacheLookup).
+func (m *MLXModelManager) calculateDirSize(path string) (int64, error) {
+	if cached, ok := dirSizeCacheLookup(path); ok {
+		return cached.size, nil
+	}
+
+	files, total, err := fastWalkDir(path, fastWalkConcurrency())
+	if err != nil {
+		return 0, err
+	}
+
+	dirSizeCacheStore(path, total, len(files))
+	return total, nil
 }
 
 // HuggingFaceModelInfo represents model information from HuggingFace API
@@ -241,8 +298,16 @@ type HuggingFaceModelInfo struct {
 	LastUpdated string   `json:"lastModified"`
 }
 
-// SearchMLXModels searches HuggingFace for MLX models
-func SearchMLXModels(query string, limit int) ([]HuggingFaceModelInfo, error) {
+// SearchMLXModels searches for MLX models. registry selects the source: ""
+// or "huggingface" (the default) searches HuggingFace's model hub as
+// before; any other value is treated as an OCI registry host, and query is
+// taken as a repository whose tags are listed via the OCI distribution
+// spec (see searchOCIModels) since that spec has no text-search endpoint.
+func SearchMLXModels(query string, limit int, registry string) ([]HuggingFaceModelInfo, error) {
+	if registry != "" && registry != "huggingface" {
+		return searchOCIModels(registry, query, limit)
+	}
+
 	// Search HuggingFace for models with MLX tag
 	url := fmt.Sprintf("https://huggingface.co/api/models?search=%s&filter=mlx&limit=%d", query, limit)
 
@@ -299,24 +364,23 @@ func shouldDownloadFile(name string) bool {
 
 // computeDigest derives a stable digest from filenames and sizes to avoid
 // hashing multi‑GB payloads. It is intentionally lightweight so it can run on
-// large local caches without blocking.
+// large local caches without blocking. Like calculateDirSize, it walks via
+// fastWalkDir; since that walk's file order isn't deterministic, the files
+// are sorted by relative path before hashing so the digest doesn't depend
+// on which worker visited which directory first.
 func computeDigest(root string) (string, error) {
-	h := sha256.New()
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-		rel, _ := filepath.Rel(root, path)
-		// include name + size so digest changes when any weight differs
-		fmt.Fprintf(h, "%s:%d\n", rel, info.Size())
-		return nil
-	})
+	files, _, err := fastWalkDir(root, fastWalkConcurrency())
 	if err != nil {
 		return "", err
 	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].RelPath < files[j].RelPath })
+
+	h := sha256.New()
+	for _, f := range files {
+		// include name + size so digest changes when any weight differs
+		fmt.Fprintf(h, "%s:%d\n", f.RelPath, f.Size)
+	}
 	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
 }
 
@@ -334,45 +398,55 @@ func getHFToken() string {
 	return strings.TrimSpace(string(data))
 }
 
-func (m *MLXModelManager) fetchHFFileList(ctx context.Context, modelID string) ([]string, map[string]int64, error) {
+// resolveHFToken returns the HuggingFace token to authenticate requests
+// with, preferring the one stored via `ollmlx login` and falling back to
+// whichever of the common HF env vars is set.
+func resolveHFToken() string {
+	if token := getHFToken(); token != "" {
+		return token
+	}
+	for _, key := range []string{"HUGGINGFACEHUB_API_TOKEN", "HUGGING_FACE_HUB_TOKEN", "HF_TOKEN"} {
+		if tok := strings.TrimSpace(os.Getenv(key)); tok != "" {
+			return tok
+		}
+	}
+	return ""
+}
+
+// fetchHFFileList returns the downloadable files for modelID, their sizes,
+// and (where HuggingFace reports one) each file's Git LFS oid - a real
+// sha256 of its content, used by downloadBlob to catch corruption rather
+// than just comparing filenames and sizes.
+func (m *MLXModelManager) fetchHFFileList(ctx context.Context, modelID string) ([]string, map[string]int64, map[string]string, error) {
 	url := fmt.Sprintf("https://huggingface.co/api/models/%s", modelID)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
-	// Allow HF tokens from common env vars if provided.
-	token := getHFToken()
-	if token == "" {
-		for _, key := range []string{"HUGGINGFACEHUB_API_TOKEN", "HUGGING_FACE_HUB_TOKEN", "HF_TOKEN"} {
-			if tok := strings.TrimSpace(os.Getenv(key)); tok != "" {
-				token = tok
-				break
-			}
-		}
-	}
-	if token != "" {
+	if token := resolveHFToken(); token != "" {
 		req.Header.Set("Authorization", "Bearer "+token)
 	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
-		return nil, nil, fmt.Errorf("huggingface api returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		return nil, nil, nil, fmt.Errorf("huggingface api returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
 	}
 
 	var meta hfModelInfo
 	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	var files []string
 	sizes := make(map[string]int64)
+	oids := make(map[string]string)
 	for _, sib := range meta.Siblings {
 		name := sib.RFilename
 		if name == "" {
@@ -387,17 +461,33 @@ func (m *MLXModelManager) fetchHFFileList(ctx context.Context, modelID string) (
 		}
 		files = append(files, name)
 		sizes[name] = size
+		if sib.LFS.Oid != "" {
+			oids[name] = sib.LFS.Oid
+		}
 	}
 
 	if len(files) == 0 {
-		return nil, nil, fmt.Errorf("no downloadable MLX files found for %s", modelID)
+		return nil, nil, nil, fmt.Errorf("no downloadable MLX files found for %s", modelID)
 	}
 
-	return files, sizes, nil
+	return files, sizes, oids, nil
 }
 
-// DownloadMLXModel downloads an MLX model from HuggingFace
+// DownloadMLXModel downloads an MLX model from HuggingFace. Each file lands
+// in the shared content-addressed blob store (see mlx_blobstore.go) rather
+// than directly under the model's directory; modelPath ends up with one
+// symlink per downloaded file pointing into that store, so every other
+// method that reads a model's files (ModelExists, GetModelInfo, the MLX
+// runner loader) keeps working against what looks like an ordinary
+// directory of real files.
 func (m *MLXModelManager) DownloadMLXModel(ctx context.Context, modelID string, progressFn func(string, int64, int64)) error {
+	// "<registry>/<repo>:<tag>" and "oci://..." refs are pulled via the OCI
+	// distribution spec instead of the HuggingFace file-list API below; see
+	// DownloadOCIModel in mlx_oci.go.
+	if _, ok := parseOCIRef(modelID); ok {
+		return m.DownloadOCIModel(ctx, modelID, progressFn)
+	}
+
 	modelPath := m.GetModelPath(modelID)
 
 	// Create model directory
@@ -412,103 +502,175 @@ func (m *MLXModelManager) DownloadMLXModel(ctx context.Context, modelID string,
 		}
 	}()
 
-	files, sizes, err := m.fetchHFFileList(ctx, modelID)
+	files, sizes, oids, err := m.fetchHFFileList(ctx, modelID)
 	if err != nil {
 		// fallback to the legacy file list so we still support minimal layouts
 		files = []string{"config.json", "tokenizer.json", "tokenizer_config.json", "model.safetensors", "weights.npz"}
 		sizes = map[string]int64{}
+		oids = map[string]string{}
 	}
 
 	baseURL := fmt.Sprintf("%s/resolve/main", getMLXBaseURL(modelID))
-	
+
 	// Calculate total size
 	var totalSize int64
 	for _, f := range files {
 		totalSize += sizes[f]
 	}
-	
+
+	// A previous manifest for this exact model (a re-pull) names blobs that
+	// may still be sitting in the store untouched, which lets a re-pull skip
+	// the network entirely for files that haven't changed.
+	knownLayers := map[string]ManifestDescriptor{}
+	if mf, err := readMLXModelManifest(modelID); err == nil {
+		for _, l := range append(mf.Layers, mf.Config) {
+			knownLayers[l.Filename] = l
+		}
+	}
+
 	var totalDownloaded int64
+	var progressMu sync.Mutex
 	client := &http.Client{Timeout: 30 * time.Minute}
 
+	// Chunked downloads report progress from several worker goroutines at
+	// once (see downloadChunkedBlob), so this aggregation has to be safe to
+	// call concurrently for the CLI's running total to stay accurate.
 	updateProgress := func(status string, inc int64) {
 		if progressFn == nil {
 			return
 		}
+		progressMu.Lock()
 		totalDownloaded += inc
-		progressFn(status, totalDownloaded, totalSize)
+		downloaded := totalDownloaded
+		progressMu.Unlock()
+		progressFn(status, downloaded, totalSize)
 	}
 
+	var descriptors []ManifestDescriptor
 	for _, filename := range files {
 		if err := ctx.Err(); err != nil {
 			return err
 		}
-		fileURL := fmt.Sprintf("%s/%s", baseURL, filename)
-		destPath := filepath.Join(modelPath, filename)
-		fileSize := sizes[filename]
+		linkPath := filepath.Join(modelPath, filename)
 
 		// Initial report for this file
 		if progressFn != nil {
 			progressFn(fmt.Sprintf("pulling %s", filename), totalDownloaded, totalSize)
 		}
 
-		err := m.downloadFile(ctx, client, fileURL, destPath, fileSize, func(n int64) {
+		if known, ok := knownLayers[filename]; ok {
+			if _, err := os.Stat(mlxBlobPath(known.Digest)); err == nil {
+				if err := linkBlob(linkPath, known.Digest); err == nil {
+					updateProgress(fmt.Sprintf("pulling %s", filename), known.Size)
+					descriptors = append(descriptors, known)
+					continue
+				}
+			}
+		}
+
+		fileURL := fmt.Sprintf("%s/%s", baseURL, filename)
+		var expectedDigest string
+		if oid := oids[filename]; oid != "" {
+			expectedDigest = "sha256:" + oid
+		}
+		digest, size, err := m.downloadBlob(ctx, client, fileURL, expectedDigest, func(n int64) {
 			updateProgress(fmt.Sprintf("pulling %s", filename), n)
 		})
-
 		if err != nil {
 			if err := ctx.Err(); err != nil {
 				return err
 			}
 			return fmt.Errorf("failed to download %s: %w", filename, err)
 		}
+
+		if err := linkBlob(linkPath, digest); err != nil {
+			return fmt.Errorf("failed to link %s into model directory: %w", filename, err)
+		}
+
+		descriptors = append(descriptors, ManifestDescriptor{
+			Filename:  filename,
+			MediaType: mlxLayerMediaType(filename),
+			Digest:    digest,
+			Size:      size,
+		})
 	}
 
 	if progressFn != nil {
 		progressFn("success", totalSize, totalSize)
 	}
 
+	manifest := newMLXManifest(descriptors)
+	if err := writeMLXModelManifest(modelID, manifest); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
 	cleanup = false
 
-	// Compute a lightweight digest for listing/show calls.
-	if digest, err := computeDigest(modelPath); err == nil {
-		// Just final status update, no size change
-		if progressFn != nil {
-			progressFn(fmt.Sprintf("digest %s", digest), totalSize, totalSize)
-		}
+	if progressFn != nil {
+		progressFn(fmt.Sprintf("digest %s", manifestDigest(manifest)), totalSize, totalSize)
 	}
 
 	return nil
 }
 
-// downloadFile downloads a file from a URL to a local path
-func (m *MLXModelManager) downloadFile(ctx context.Context, client *http.Client, url, destPath string, expectSize int64, progress func(int64)) error {
-	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-		return err
-	}
+// downloadBlob fetches url into the content-addressed blob store
+// (mlx_blobstore.go). It first probes whether the server honors HTTP range
+// requests (probeRangeSupport); when it does and the file is large enough
+// to be worth splitting, it downloads via downloadChunkedBlob - concurrent,
+// resumable chunks - and otherwise falls back to the single-stream copy in
+// downloadBlobSingleStream. Either path verifies the result against
+// expectedDigest (HuggingFace's reported Git LFS oid, when known) before
+// the blob is considered valid. It returns the blob's digest
+// ("sha256:<hex>") and size.
+func (m *MLXModelManager) downloadBlob(ctx context.Context, client *http.Client, url string, expectedDigest string, progress func(int64)) (digest string, size int64, err error) {
+	blobsDir := mlxBlobsDir()
+	if err := os.MkdirAll(blobsDir, 0755); err != nil {
+		return "", 0, err
+	}
+
+	token := resolveHFToken()
+
+	if contentLength, supportsRanges, err := probeRangeSupport(ctx, client, url, token); err == nil && supportsRanges && contentLength > mlxDownloadChunkSize {
+		partialPath, err := downloadChunkedBlob(ctx, client, url, token, contentLength, progress)
+		if err != nil {
+			return "", 0, err
+		}
+
+		digest, err := hashFileContents(partialPath)
+		if err != nil {
+			return "", 0, err
+		}
+		if expectedDigest != "" && digest != expectedDigest {
+			os.Remove(partialPath)
+			os.Remove(mlxPartialMetaPath(url))
+			return "", 0, fmt.Errorf("digest mismatch: huggingface reported %s but downloaded content hashes to %s", expectedDigest, digest)
+		}
 
-	// Skip download if the target already exists with the expected size.
-	// Note: We still need to count its size towards the total progress
-	if stat, err := os.Stat(destPath); err == nil && expectSize > 0 && stat.Size() == expectSize {
-		if progress != nil {
-			progress(expectSize)
+		finalPath := mlxBlobPath(digest)
+		if _, err := os.Stat(finalPath); err == nil {
+			// Content-addressed dedup: this exact content is already stored.
+			os.Remove(partialPath)
+		} else if err := os.Rename(partialPath, finalPath); err != nil {
+			return "", 0, err
 		}
-		return nil
+		os.Remove(mlxPartialMetaPath(url))
+
+		return digest, contentLength, nil
 	}
 
+	return m.downloadBlobSingleStream(ctx, client, url, token, expectedDigest, progress)
+}
+
+// downloadBlobSingleStream is downloadBlob's fallback path for servers that
+// don't support HTTP range requests: a single sequential copy, streamed
+// through sha256 as it goes, into a temp file that's atomically renamed
+// into the blob store once the digest is verified.
+func (m *MLXModelManager) downloadBlobSingleStream(ctx context.Context, client *http.Client, url, token, expectedDigest string, progress func(int64)) (digest string, size int64, err error) {
+	blobsDir := mlxBlobsDir()
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return err
-	}
-
-	// Add HuggingFace token for authentication
-	token := getHFToken()
-	if token == "" {
-		for _, key := range []string{"HUGGINGFACEHUB_API_TOKEN", "HUGGING_FACE_HUB_TOKEN", "HF_TOKEN"} {
-			if tok := strings.TrimSpace(os.Getenv(key)); tok != "" {
-				token = tok
-				break
-			}
-		}
+		return "", 0, err
 	}
 	if token != "" {
 		req.Header.Set("Authorization", "Bearer "+token)
@@ -516,47 +678,62 @@ func (m *MLXModelManager) downloadFile(ctx context.Context, client *http.Client,
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return "", 0, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
 		io.Copy(io.Discard, resp.Body)
-		return fmt.Errorf("authentication required - please run 'ollmlx login' with your HuggingFace token")
+		return "", 0, fmt.Errorf("authentication required - please run 'ollmlx login' with your HuggingFace token")
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		io.Copy(io.Discard, resp.Body)
-		return fmt.Errorf("server returned status %d", resp.StatusCode)
+		return "", 0, fmt.Errorf("server returned status %d", resp.StatusCode)
 	}
 
-	tmpPath := destPath + ".part"
-	out, err := os.Create(tmpPath)
+	tmp, err := os.CreateTemp(blobsDir, "sha256-*.partial")
 	if err != nil {
-		return err
+		return "", 0, err
 	}
-	defer out.Close()
+	tmpPath := tmp.Name()
 
-	// Create a proxy reader that reports progress
-	reader := &ProgressReader{
-		Reader: resp.Body,
-		Callback: func(n int64) {
-			if progress != nil {
-				progress(n)
-			}
-		},
+	h := sha256.New()
+	reader := &ProgressReader{Reader: io.TeeReader(resp.Body, h), Callback: progress}
+	n, copyErr := io.Copy(tmp, reader)
+	closeErr := tmp.Close()
+
+	if copyErr != nil {
+		os.Remove(tmpPath)
+		return "", 0, copyErr
+	}
+	if closeErr != nil {
+		os.Remove(tmpPath)
+		return "", 0, closeErr
 	}
 
-	if _, err = io.Copy(out, reader); err != nil {
+	digest = fmt.Sprintf("sha256:%x", h.Sum(nil))
+	if expectedDigest != "" && digest != expectedDigest {
 		os.Remove(tmpPath)
-		return err
+		return "", 0, fmt.Errorf("digest mismatch: huggingface reported %s but downloaded content hashes to %s", expectedDigest, digest)
 	}
 
-	if err := os.Rename(tmpPath, destPath); err != nil {
-		return err
+	finalPath := mlxBlobPath(digest)
+
+	if _, err := os.Stat(finalPath); err == nil {
+		// Another model (or an earlier run) already stored this exact
+		// content - the whole point of content addressing - so drop the
+		// redundant copy instead of overwriting an identical blob.
+		os.Remove(tmpPath)
+		return digest, n, nil
 	}
 
-	return nil
+	if err := os.Rename(tmpPath, finalPath); err != nil {
+		os.Remove(tmpPath)
+		return "", 0, err
+	}
+
+	return digest, n, nil
 }
 
 // ProgressReader wraps an io.Reader to report progress
@@ -0,0 +1,524 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ociRef is a parsed reference to a model published to an OCI distribution
+// spec registry (GHCR, ORAS, ModelPack, ...), as opposed to the "org/name"
+// HuggingFace references the rest of this file handles. It is built by
+// parseOCIRef from either an "oci://" URI or a bare
+// "<registry>/<repo>:<tag>" string.
+type ociRef struct {
+	Registry   string
+	Repository string
+	Tag        string // e.g. "latest"; empty if Digest is set
+	Digest     string // e.g. "sha256:...", set instead of Tag for a @sha256 pin
+}
+
+// reference returns the value to substitute into a /v2/<name>/manifests/<ref>
+// request: the digest when the ref is digest-pinned, the tag otherwise.
+func (r *ociRef) reference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// parseOCIRef reports whether modelID names an OCI registry model rather
+// than a HuggingFace one, returning its parsed form when it does. An
+// "oci://" scheme is always treated as OCI; a bare reference is only
+// treated as OCI when its first path segment looks like a registry host
+// (contains a "." or a ":" port), the same heuristic `docker pull` uses to
+// tell "ghcr.io/org/model:tag" apart from a Docker Hub "org/model" - here
+// standing in for telling an OCI ref apart from a HuggingFace "org/model"
+// ref, which never carries a tag.
+func parseOCIRef(modelID string) (*ociRef, bool) {
+	s := strings.TrimPrefix(modelID, "oci://")
+	isExplicitScheme := s != modelID
+
+	firstSlash := strings.Index(s, "/")
+	if firstSlash <= 0 {
+		return nil, false
+	}
+	registry := s[:firstSlash]
+	if !isExplicitScheme && !strings.ContainsAny(registry, ".:") {
+		return nil, false
+	}
+
+	rest := s[firstSlash+1:]
+	ref := &ociRef{Registry: registry}
+
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		ref.Repository = rest[:i]
+		ref.Digest = rest[i+1:]
+	} else if i := strings.LastIndex(rest, ":"); i >= 0 {
+		ref.Repository = rest[:i]
+		ref.Tag = rest[i+1:]
+	} else {
+		ref.Repository = rest
+		ref.Tag = "latest"
+	}
+
+	if ref.Repository == "" {
+		return nil, false
+	}
+	return ref, true
+}
+
+// ociDescriptor mirrors an OCI content descriptor: a blob's media type,
+// digest and size, plus whatever annotations the publisher attached (most
+// usefully org.opencontainers.image.title, the file's original filename).
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociManifest mirrors the subset of the OCI image manifest spec needed to
+// find an MLX model's files: its config descriptor and layer list.
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// ociMLXLayerMediaTypes is the set of media types this package recognizes
+// as MLX model content when pulling from an OCI registry - everything else
+// in a manifest (e.g. an unrelated sidecar layer) is skipped.
+var ociMLXLayerMediaTypes = map[string]bool{
+	"application/vnd.mlx.weights.safetensors": true,
+	"application/vnd.mlx.tokenizer.json+json": true,
+	"application/vnd.mlx.config+json":         true,
+}
+
+// ociLayerFilename derives the filename a layer should be linked into a
+// model's directory as, preferring the publisher's own
+// org.opencontainers.image.title annotation and falling back to a
+// media-type-derived name for registries that don't set one. The
+// annotation is registry-supplied and untrusted, so it's run through
+// sanitizeMLXPathComponent the same way splitMLXModelRef sanitizes a model
+// reference - without that, a registry setting title to e.g.
+// "../../../../home/user/.ssh/authorized_keys" could make linkBlob remove
+// and symlink an arbitrary file outside the model directory.
+func ociLayerFilename(d ociDescriptor) string {
+	if title := d.Annotations["org.opencontainers.image.title"]; title != "" {
+		return sanitizeMLXPathComponent(title)
+	}
+	switch d.MediaType {
+	case "application/vnd.mlx.config+json":
+		return "config.json"
+	case "application/vnd.mlx.tokenizer.json+json":
+		return "tokenizer.json"
+	default:
+		hex := strings.TrimPrefix(d.Digest, "sha256:")
+		if len(hex) > 12 {
+			hex = hex[:12]
+		}
+		return fmt.Sprintf("model-%s.safetensors", hex)
+	}
+}
+
+// parseWWWAuthenticate parses a `Bearer realm="...",service="...",scope="..."`
+// challenge header into its token endpoint realm and the full parameter set,
+// per the OCI distribution spec's authorization flow.
+func parseWWWAuthenticate(header string) (realm string, params map[string]string, err error) {
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", nil, fmt.Errorf("unsupported WWW-Authenticate scheme: %s", header)
+	}
+
+	params = map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(header, "Bearer "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[kv[0]] = strings.Trim(kv[1], `"`)
+	}
+
+	realm = params["realm"]
+	if realm == "" {
+		return "", nil, fmt.Errorf("WWW-Authenticate challenge missing realm: %s", header)
+	}
+	return realm, params, nil
+}
+
+// ociAuthenticate exchanges a 401 response's WWW-Authenticate challenge for
+// a bearer token by hitting its realm with the service/scope it specified,
+// the standard anonymous (or, for private images, Basic-authenticated)
+// token flow GHCR/Docker Hub/most OCI registries require.
+func ociAuthenticate(ctx context.Context, client *http.Client, challenge string) (string, error) {
+	realm, params, err := parseWWWAuthenticate(challenge)
+	if err != nil {
+		return "", err
+	}
+
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("invalid token realm %q: %w", realm, err)
+	}
+	q := tokenURL.Query()
+	for k, v := range params {
+		if k == "realm" {
+			continue
+		}
+		q.Set(k, v)
+	}
+	tokenURL.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var tokenResp struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", err
+	}
+	if tokenResp.Token != "" {
+		return tokenResp.Token, nil
+	}
+	return tokenResp.AccessToken, nil
+}
+
+// ociDo performs req, transparently handling the registry's token-auth
+// challenge: on a 401 it parses the WWW-Authenticate header, exchanges it
+// for a bearer token via ociAuthenticate, and retries req once with that
+// token attached. token, when already known from an earlier request in the
+// same pull (manifest and blob fetches share the same pull scope on most
+// registries), is sent up front so the common case needs no round trip at
+// all. It returns whichever token the final, successful attempt used, so
+// callers can reuse it for subsequent requests.
+func ociDo(ctx context.Context, client *http.Client, req *http.Request, token string) (*http.Response, string, error) {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, token, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, token, nil
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if challenge == "" {
+		return nil, token, fmt.Errorf("registry returned 401 without a WWW-Authenticate challenge")
+	}
+
+	newToken, err := ociAuthenticate(ctx, client, challenge)
+	if err != nil {
+		return nil, token, fmt.Errorf("oci token auth failed: %w", err)
+	}
+
+	retry := req.Clone(ctx)
+	retry.Header.Set("Authorization", "Bearer "+newToken)
+	resp, err = client.Do(retry)
+	if err != nil {
+		return nil, newToken, err
+	}
+	return resp, newToken, nil
+}
+
+// fetchOCIManifest fetches ref's manifest per the OCI distribution spec
+// (GET /v2/<name>/manifests/<ref> with an OCI image manifest Accept
+// header), authenticating via ociDo on demand. It returns the manifest
+// along with whatever bearer token the request ended up using, so the
+// blob fetches that follow can start with it already in hand.
+func fetchOCIManifest(ctx context.Context, client *http.Client, ref *ociRef) (*ociManifest, string, error) {
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", ref.Registry, ref.Repository, ref.reference())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, manifestURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "application/vnd.oci.image.manifest.v1+json")
+
+	resp, token, err := ociDo(ctx, client, req, "")
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, "", fmt.Errorf("registry returned %d fetching manifest for %s: %s", resp.StatusCode, ref.Repository, strings.TrimSpace(string(body)))
+	}
+
+	var mf ociManifest
+	if err := json.NewDecoder(resp.Body).Decode(&mf); err != nil {
+		return nil, "", fmt.Errorf("failed to parse OCI manifest: %w", err)
+	}
+	return &mf, token, nil
+}
+
+// downloadOCIBlob fetches one layer (GET /v2/<name>/blobs/<digest>) into
+// the shared content-addressed blob store from mlx_blobstore.go, verifying
+// the streamed content hashes to desc.Digest before it's considered valid -
+// the same digest-while-streaming shape downloadBlobSingleStream already
+// uses for HuggingFace pulls, just checked against the registry's own
+// descriptor instead of a Git LFS oid.
+func (m *MLXModelManager) downloadOCIBlob(ctx context.Context, client *http.Client, ref *ociRef, desc ociDescriptor, token string, progress func(int64)) (digest string, err error) {
+	blobsDir := mlxBlobsDir()
+	if err := os.MkdirAll(blobsDir, 0755); err != nil {
+		return "", err
+	}
+
+	blobURL := fmt.Sprintf("https://%s/v2/%s/blobs/%s", ref.Registry, ref.Repository, desc.Digest)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, blobURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, _, err := ociDo(ctx, client, req, token)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("registry returned %d fetching blob %s: %s", resp.StatusCode, desc.Digest, strings.TrimSpace(string(body)))
+	}
+
+	tmp, err := os.CreateTemp(blobsDir, "sha256-*.partial")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := tmp.Name()
+
+	h := sha256.New()
+	reader := &ProgressReader{Reader: io.TeeReader(resp.Body, h), Callback: progress}
+	_, copyErr := io.Copy(tmp, reader)
+	closeErr := tmp.Close()
+
+	if copyErr != nil {
+		os.Remove(tmpPath)
+		return "", copyErr
+	}
+	if closeErr != nil {
+		os.Remove(tmpPath)
+		return "", closeErr
+	}
+
+	digest = fmt.Sprintf("sha256:%x", h.Sum(nil))
+	if desc.Digest != "" && digest != desc.Digest {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("digest mismatch for blob: registry descriptor said %s but downloaded content hashes to %s", desc.Digest, digest)
+	}
+
+	finalPath := mlxBlobPath(digest)
+	if _, err := os.Stat(finalPath); err == nil {
+		os.Remove(tmpPath)
+		return digest, nil
+	}
+	if err := os.Rename(tmpPath, finalPath); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return digest, nil
+}
+
+// DownloadOCIModel pulls modelID - already confirmed by the caller to parse
+// as an OCI reference via parseOCIRef - from its container registry,
+// following the OCI distribution spec: fetch the manifest, keep only the
+// layers whose mediaType is a recognized MLX one (ociMLXLayerMediaTypes),
+// then fetch and verify each. Every layer lands in the same shared blob
+// store a HuggingFace pull uses (see mlx_blobstore.go), linked into the
+// model's directory the same way, so a model pulled from an OCI registry is
+// indistinguishable on disk from one pulled from HuggingFace.
+func (m *MLXModelManager) DownloadOCIModel(ctx context.Context, modelID string, progressFn func(string, int64, int64)) error {
+	ref, ok := parseOCIRef(modelID)
+	if !ok {
+		return fmt.Errorf("%q is not a valid OCI model reference", modelID)
+	}
+
+	modelPath := m.GetModelPath(modelID)
+	if err := os.MkdirAll(modelPath, 0755); err != nil {
+		return fmt.Errorf("failed to create model directory: %w", err)
+	}
+
+	cleanup := true
+	defer func() {
+		if cleanup {
+			os.RemoveAll(modelPath)
+		}
+	}()
+
+	client := &http.Client{Timeout: 30 * time.Minute}
+	mf, token, err := fetchOCIManifest(ctx, client, ref)
+	if err != nil {
+		return fmt.Errorf("failed to fetch OCI manifest: %w", err)
+	}
+
+	var layers []ociDescriptor
+	if ociMLXLayerMediaTypes[mf.Config.MediaType] {
+		layers = append(layers, mf.Config)
+	}
+	for _, l := range mf.Layers {
+		if ociMLXLayerMediaTypes[l.MediaType] {
+			layers = append(layers, l)
+		}
+	}
+	if len(layers) == 0 {
+		return fmt.Errorf("no MLX layers found in OCI manifest for %s (checked %d layers)", modelID, len(mf.Layers))
+	}
+
+	var totalSize int64
+	for _, l := range layers {
+		totalSize += l.Size
+	}
+
+	var totalDownloaded int64
+	var progressMu sync.Mutex
+	updateProgress := func(status string, inc int64) {
+		if progressFn == nil {
+			return
+		}
+		progressMu.Lock()
+		totalDownloaded += inc
+		downloaded := totalDownloaded
+		progressMu.Unlock()
+		progressFn(status, downloaded, totalSize)
+	}
+
+	var descriptors []ManifestDescriptor
+	for _, l := range layers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		filename := ociLayerFilename(l)
+		linkPath := filepath.Join(modelPath, filename)
+
+		if progressFn != nil {
+			progressFn(fmt.Sprintf("pulling %s", filename), totalDownloaded, totalSize)
+		}
+
+		if _, err := os.Stat(mlxBlobPath(l.Digest)); err == nil {
+			if err := linkBlob(linkPath, l.Digest); err == nil {
+				updateProgress(fmt.Sprintf("pulling %s", filename), l.Size)
+				descriptors = append(descriptors, ManifestDescriptor{Filename: filename, MediaType: l.MediaType, Digest: l.Digest, Size: l.Size})
+				continue
+			}
+		}
+
+		digest, err := m.downloadOCIBlob(ctx, client, ref, l, token, func(n int64) {
+			updateProgress(fmt.Sprintf("pulling %s", filename), n)
+		})
+		if err != nil {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return fmt.Errorf("failed to download layer %s: %w", l.Digest, err)
+		}
+
+		if err := linkBlob(linkPath, digest); err != nil {
+			return fmt.Errorf("failed to link %s into model directory: %w", filename, err)
+		}
+
+		descriptors = append(descriptors, ManifestDescriptor{
+			Filename:  filename,
+			MediaType: l.MediaType,
+			Digest:    digest,
+			Size:      l.Size,
+		})
+	}
+
+	manifest := newMLXManifest(descriptors)
+	if err := writeMLXModelManifest(modelID, manifest); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	cleanup = false
+	if progressFn != nil {
+		progressFn(fmt.Sprintf("digest %s", manifestDigest(manifest)), totalSize, totalSize)
+	}
+	return nil
+}
+
+// listOCITags fetches an OCI repository's available tags (GET
+// /v2/<name>/tags/list), authenticating the same way fetchOCIManifest does.
+// SearchMLXModels uses this as its OCI-registry search path, since the
+// distribution spec has no text-search endpoint comparable to HuggingFace's
+// model hub API.
+func listOCITags(ctx context.Context, client *http.Client, ref *ociRef) ([]string, error) {
+	tagsURL := fmt.Sprintf("https://%s/v2/%s/tags/list", ref.Registry, ref.Repository)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tagsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, _, err := ociDo(ctx, client, req, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("registry returned %d listing tags for %s: %s", resp.StatusCode, ref.Repository, strings.TrimSpace(string(body)))
+	}
+
+	var list struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to parse tag list: %w", err)
+	}
+	return list.Tags, nil
+}
+
+// searchOCIModels lists registry's tags for repo and reports one result per
+// tag, each ModelID formatted as an "oci://" reference DownloadMLXModel can
+// pull directly - the OCI-registry counterpart of SearchMLXModels' default
+// HuggingFace hub search.
+func searchOCIModels(registry, repo string, limit int) ([]HuggingFaceModelInfo, error) {
+	ref := &ociRef{Registry: registry, Repository: repo}
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	tags, err := listOCITags(context.Background(), client, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search OCI registry %s: %w", registry, err)
+	}
+	if limit > 0 && len(tags) > limit {
+		tags = tags[:limit]
+	}
+
+	results := make([]HuggingFaceModelInfo, 0, len(tags))
+	for _, tag := range tags {
+		results = append(results, HuggingFaceModelInfo{
+			ModelID: fmt.Sprintf("oci://%s/%s:%s", registry, repo, tag),
+		})
+	}
+	return results, nil
+}
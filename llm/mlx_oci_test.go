@@ -0,0 +1,217 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseOCIRefDistinguishesFromHuggingFace(t *testing.T) {
+	tests := []struct {
+		modelID    string
+		wantOK     bool
+		wantRef    *ociRef
+		wantDigest string
+	}{
+		{modelID: "mlx-community/Llama-3.2-3B-Instruct-4bit", wantOK: false},
+		{modelID: "ghcr.io/acme/llama-mlx:4bit", wantOK: true, wantRef: &ociRef{Registry: "ghcr.io", Repository: "acme/llama-mlx", Tag: "4bit"}},
+		{modelID: "oci://registry.example.com:5000/models/llama:latest", wantOK: true, wantRef: &ociRef{Registry: "registry.example.com:5000", Repository: "models/llama", Tag: "latest"}},
+		{modelID: "oci://ghcr.io/acme/llama-mlx", wantOK: true, wantRef: &ociRef{Registry: "ghcr.io", Repository: "acme/llama-mlx", Tag: "latest"}},
+	}
+
+	for _, tt := range tests {
+		ref, ok := parseOCIRef(tt.modelID)
+		if ok != tt.wantOK {
+			t.Errorf("parseOCIRef(%q) ok = %v, want %v", tt.modelID, ok, tt.wantOK)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if ref.Registry != tt.wantRef.Registry || ref.Repository != tt.wantRef.Repository || ref.Tag != tt.wantRef.Tag {
+			t.Errorf("parseOCIRef(%q) = %+v, want %+v", tt.modelID, ref, tt.wantRef)
+		}
+	}
+}
+
+func TestOCILayerFilenameSanitizesTitleAnnotation(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string
+		want  string
+	}{
+		{name: "path traversal", title: "../../../../home/user/.ssh/authorized_keys", want: ".._.._.._.._home_user_.ssh_authorized_keys"},
+		{name: "nested slash", title: "weights/model.safetensors", want: "weights_model.safetensors"},
+		{name: "plain filename unaffected", title: "model.safetensors", want: "model.safetensors"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ociLayerFilename(ociDescriptor{
+				MediaType:   "application/vnd.mlx.weights.safetensors",
+				Annotations: map[string]string{"org.opencontainers.image.title": tt.title},
+			})
+			if got != tt.want {
+				t.Errorf("ociLayerFilename() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOCIRefHandlesDigestPin(t *testing.T) {
+	ref, ok := parseOCIRef("ghcr.io/acme/llama-mlx@sha256:deadbeef")
+	if !ok {
+		t.Fatalf("parseOCIRef() ok = false, want true")
+	}
+	if ref.Digest != "sha256:deadbeef" || ref.Tag != "" {
+		t.Errorf("parseOCIRef() = %+v, want digest pin", ref)
+	}
+	if ref.reference() != "sha256:deadbeef" {
+		t.Errorf("reference() = %q, want %q", ref.reference(), "sha256:deadbeef")
+	}
+}
+
+// ociTestRegistry is a minimal fake OCI registry that requires a bearer
+// token obtained from its own fake token endpoint, mirroring the
+// 401 -> WWW-Authenticate -> token endpoint -> retry flow a real registry
+// like GHCR implements.
+func ociTestRegistry(t *testing.T, manifest ociManifest, blobs map[string][]byte) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"token": "test-token"})
+	})
+
+	requireAuth := func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("Authorization") == "Bearer test-token" {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repo:pull"`, srv.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+
+	mux.HandleFunc("/v2/acme/llama-mlx/manifests/latest", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAuth(w, r) {
+			return
+		}
+		json.NewEncoder(w).Encode(manifest)
+	})
+
+	mux.HandleFunc("/v2/acme/llama-mlx/tags/list", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAuth(w, r) {
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"name": "acme/llama-mlx", "tags": []string{"latest", "4bit"}})
+	})
+
+	mux.HandleFunc("/v2/acme/llama-mlx/blobs/", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAuth(w, r) {
+			return
+		}
+		digest := r.URL.Path[len("/v2/acme/llama-mlx/blobs/"):]
+		data, ok := blobs[digest]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	})
+
+	srv = httptest.NewServer(mux)
+	return srv
+}
+
+func TestDownloadOCIModelFetchesManifestAndLayers(t *testing.T) {
+	configBytes := []byte(`{"architectures":["LlamaForCausalLM"]}`)
+	weightsBytes := []byte("fake-weights-content")
+	configDigest := hashBytesForTest(configBytes)
+	weightsDigest := hashBytesForTest(weightsBytes)
+
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.manifest.v1+json",
+		Config: ociDescriptor{
+			MediaType:   "application/vnd.mlx.config+json",
+			Digest:      configDigest,
+			Size:        int64(len(configBytes)),
+			Annotations: map[string]string{"org.opencontainers.image.title": "config.json"},
+		},
+		Layers: []ociDescriptor{
+			{
+				MediaType:   "application/vnd.mlx.weights.safetensors",
+				Digest:      weightsDigest,
+				Size:        int64(len(weightsBytes)),
+				Annotations: map[string]string{"org.opencontainers.image.title": "model.safetensors"},
+			},
+			{MediaType: "application/vnd.some.unrelated.sidecar", Digest: "sha256:ignoredlayer", Size: 1},
+		},
+	}
+
+	srv := ociTestRegistry(t, manifest, map[string][]byte{
+		configDigest:  configBytes,
+		weightsDigest: weightsBytes,
+	})
+	defer srv.Close()
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	manager := NewMLXModelManager()
+
+	registry := srv.URL[len("http://"):]
+	modelID := fmt.Sprintf("oci://%s/acme/llama-mlx:latest", registry)
+
+	var progressed bool
+	if err := manager.DownloadOCIModel(context.Background(), modelID, func(status string, done, total int64) {
+		progressed = true
+	}); err != nil {
+		t.Fatalf("DownloadOCIModel() error = %v", err)
+	}
+	if !progressed {
+		t.Error("DownloadOCIModel() never reported progress")
+	}
+
+	if !manager.ModelExists(modelID) {
+		t.Fatalf("ModelExists(%q) = false after successful pull", modelID)
+	}
+
+	mf, err := readMLXModelManifest(modelID)
+	if err != nil {
+		t.Fatalf("readMLXModelManifest() error = %v", err)
+	}
+	if len(mf.Layers) != 1 {
+		t.Fatalf("manifest has %d layers, want 1 (unrelated sidecar layer should be skipped)", len(mf.Layers))
+	}
+	if mf.Layers[0].Filename != "model.safetensors" {
+		t.Errorf("manifest layer filename = %q, want %q", mf.Layers[0].Filename, "model.safetensors")
+	}
+}
+
+func TestSearchMLXModelsDispatchesToOCIRegistry(t *testing.T) {
+	srv := ociTestRegistry(t, ociManifest{}, nil)
+	defer srv.Close()
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	registry := srv.URL[len("http://"):]
+
+	results, err := SearchMLXModels("acme/llama-mlx", 10, registry)
+	if err != nil {
+		t.Fatalf("SearchMLXModels() error = %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("SearchMLXModels() returned %d results, want 2", len(results))
+	}
+	want := fmt.Sprintf("oci://%s/acme/llama-mlx:latest", registry)
+	if results[0].ModelID != want {
+		t.Errorf("SearchMLXModels() first result = %q, want %q", results[0].ModelID, want)
+	}
+}
+
+func hashBytesForTest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
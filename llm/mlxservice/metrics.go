@@ -0,0 +1,37 @@
+package mlxservice
+
+import "sync"
+
+// gaugeVec is a settable/addable value keyed by label (always a model name
+// here), mirroring server/supervisor_metrics.go's counterVec shape but
+// allowing negative deltas since a queue depth goes down as well as up.
+// The zero value is ready to use.
+type gaugeVec struct {
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+func (g *gaugeVec) add(label string, delta int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.values == nil {
+		g.values = make(map[string]int64)
+	}
+	g.values[label] += delta
+}
+
+func (g *gaugeVec) get(label string) int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.values[label]
+}
+
+func (g *gaugeVec) snapshot() map[string]int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make(map[string]int64, len(g.values))
+	for k, v := range g.values {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,210 @@
+// Package mlxservice multiplexes MLX generate/chat requests onto a
+// bounded-concurrency worker pool keyed per model, giving every job a
+// cancel token the runner itself understands (see Backend) instead of
+// relying solely on HTTP context cancellation - a client disconnect only
+// stops the Go side from reading further, it doesn't reach into the
+// already-dispatched Python generation loop on the other end of the
+// connection.
+package mlxservice
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+)
+
+// Chunk is one piece of streamed output from a Generate/Chat job, the
+// common currency every Backend implementation produces regardless of
+// which runner actually served the request. ToolCalls and Metrics carry
+// through whatever the backend's final chunk reported, so a caller driving
+// a full response (not just visible text) off the channel doesn't lose
+// them to the queue.
+type Chunk struct {
+	Content    string
+	ToolCalls  []api.ToolCall
+	Metrics    api.Metrics
+	Done       bool
+	DoneReason string
+	Err        error
+}
+
+// Backend executes jobs against a loaded model. Run streams req's output
+// onto out until the job finishes or ctx is canceled; Service closes out
+// once Run returns, so Run itself must not close it. Cancel asks the
+// backend to stop jobID's generation outright (e.g. sendMLXCancel's POST
+// /cancel/{job_id}, mapped to mlx.core.stop() on that job's active
+// generation in the Python runner) - the mechanism a disconnect needs,
+// since the job may be sharing its runner with others that must keep
+// running.
+type Backend interface {
+	Run(ctx context.Context, jobID, model string, req any, out chan<- Chunk)
+	Cancel(jobID, model string)
+}
+
+// defaultMaxConcurrency is how many jobs a single model's pool runs at
+// once when the caller doesn't set WithMaxConcurrency. An MLX runner
+// serves one generation at a time today (see server/routes_mlx.go's
+// mlxRunnerEntry, whose deadline and lastMessages fields both assume a
+// single in-flight generation), so 1 matches that until a runner can
+// actually run several jobs concurrently.
+const defaultMaxConcurrency = 1
+
+// Option configures a Service at construction time.
+type Option func(*Service)
+
+// WithMaxConcurrency overrides defaultMaxConcurrency for every model's
+// pool this Service creates.
+func WithMaxConcurrency(n int) Option {
+	return func(s *Service) { s.maxConcurrency = n }
+}
+
+// Service multiplexes Generate/Chat jobs onto a bounded-concurrency worker
+// pool keyed per model: a burst of requests against the same model queues
+// behind its pool's limit instead of overwhelming the runner serving it,
+// while requests against distinct models proceed fully in parallel.
+type Service struct {
+	backend        Backend
+	maxConcurrency int
+
+	mu    sync.Mutex
+	pools map[string]*modelPool
+
+	queueDepth gaugeVec
+}
+
+// New builds a Service backed by backend, the thing that actually runs
+// jobs and cancels them by job ID (see Backend) - in production, an
+// adapter over the existing MLX runner pool; in tests, a fake.
+func New(backend Backend, opts ...Option) *Service {
+	s := &Service{
+		backend:        backend,
+		maxConcurrency: defaultMaxConcurrency,
+		pools:          make(map[string]*modelPool),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// modelPool bounds how many jobs against one model run at once; sem's
+// buffer size is the pool's max concurrency, and holding a slot in it is
+// literally "running" for queue-depth accounting purposes.
+type modelPool struct {
+	sem chan struct{}
+}
+
+func (s *Service) pool(model string) *modelPool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pools[model]
+	if !ok {
+		p = &modelPool{sem: make(chan struct{}, s.maxConcurrency)}
+		s.pools[model] = p
+	}
+	return p
+}
+
+// Generate submits a generate job for req.Model, queuing behind any other
+// in-flight job against the same model past its pool's max concurrency.
+// The returned channel is closed once the job finishes, fails, or ctx is
+// canceled after the job started; ctx canceled while the job is still
+// queued returns ctx.Err() directly instead of a channel, since no job
+// was ever handed to the backend to cancel.
+func (s *Service) Generate(ctx context.Context, req *api.GenerateRequest) (<-chan Chunk, error) {
+	return s.submit(ctx, req.Model, req)
+}
+
+// Chat is Generate's chat-endpoint counterpart.
+func (s *Service) Chat(ctx context.Context, req *api.ChatRequest) (<-chan Chunk, error) {
+	return s.submit(ctx, req.Model, req)
+}
+
+// Acquire blocks until a worker slot in model's pool is free - the same
+// bound Generate/Chat jobs queue behind - and returns a release func the
+// caller must call exactly once when it's done with the slot. This is for
+// a caller that needs to run its own request handling instead of handing
+// a single req to Run (e.g. a multi-turn tool-calling loop that must keep
+// reusing one runner across hops), but still wants that work to queue
+// behind the same per-model concurrency bound as everything submitted via
+// Generate/Chat.
+func (s *Service) Acquire(ctx context.Context, model string) (release func(), err error) {
+	pool := s.pool(model)
+
+	s.queueDepth.add(model, 1)
+	select {
+	case pool.sem <- struct{}{}:
+		s.queueDepth.add(model, -1)
+	case <-ctx.Done():
+		s.queueDepth.add(model, -1)
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func() { once.Do(func() { <-pool.sem }) }, nil
+}
+
+func (s *Service) submit(ctx context.Context, model string, req any) (<-chan Chunk, error) {
+	release, err := s.Acquire(ctx, model)
+	if err != nil {
+		return nil, err
+	}
+
+	jobID, err := newJobID()
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	out := make(chan Chunk)
+	go func() {
+		defer release()
+		defer close(out)
+
+		// Watch ctx independently of Run's own select loop: a Backend
+		// implementation built around a blocking HTTP round-trip (like
+		// the real MLX adapter's Stream call) only notices ctx
+		// cancellation between reads, so this tells the runner directly
+		// rather than waiting for Run to unwind on its own.
+		stopWatching := make(chan struct{})
+		defer close(stopWatching)
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.backend.Cancel(jobID, model)
+			case <-stopWatching:
+			}
+		}()
+
+		s.backend.Run(ctx, jobID, model, req, out)
+	}()
+
+	return out, nil
+}
+
+// QueueDepth returns how many jobs against model are currently waiting for
+// a worker slot in its pool (not counting any job actually running).
+func (s *Service) QueueDepth(model string) int64 {
+	return s.queueDepth.get(model)
+}
+
+// QueueDepthSnapshot returns QueueDepth for every model that has ever had
+// a job submitted, for a /metrics-style exporter to read (see
+// server/supervisor_metrics.go's counterVec/histogramVec, which gaugeVec
+// mirrors).
+func (s *Service) QueueDepthSnapshot() map[string]int64 {
+	return s.queueDepth.snapshot()
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("mlxservice: failed to generate job id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,208 @@
+package mlxservice
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// controlledBackend is a fake Backend whose Run blocks until either ctx is
+// canceled or the test closes release, so tests can deterministically hold
+// a job "in flight" and observe what Service does around it.
+type controlledBackend struct {
+	runStarted chan string
+	release    chan struct{}
+
+	mu       sync.Mutex
+	canceled map[string]bool
+}
+
+func newControlledBackend() *controlledBackend {
+	return &controlledBackend{
+		runStarted: make(chan string, 8),
+		release:    make(chan struct{}),
+		canceled:   make(map[string]bool),
+	}
+}
+
+func (b *controlledBackend) Run(ctx context.Context, jobID, model string, req any, out chan<- Chunk) {
+	b.runStarted <- jobID
+	select {
+	case <-ctx.Done():
+		out <- Chunk{Done: true, DoneReason: "canceled"}
+	case <-b.release:
+		out <- Chunk{Content: "done", Done: true, DoneReason: "stop"}
+	}
+}
+
+func (b *controlledBackend) Cancel(jobID, model string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.canceled[jobID] = true
+}
+
+func (b *controlledBackend) wasCanceled(jobID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.canceled[jobID]
+}
+
+func drainChunks(t *testing.T, ch <-chan Chunk) {
+	t.Helper()
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("timed out draining chunk channel")
+		}
+	}
+}
+
+func TestServiceCancelsAndFreesSlotOnDisconnect(t *testing.T) {
+	backend := newControlledBackend()
+	svc := New(backend, WithMaxConcurrency(1))
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ch1, err := svc.Generate(ctx1, &api.GenerateRequest{Model: "m"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	var jobID1 string
+	select {
+	case jobID1 = <-backend.runStarted:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the first job to start")
+	}
+
+	cancel1() // simulate the HTTP client disconnecting mid-stream
+	drainChunks(t, ch1)
+
+	deadline := time.Now().Add(time.Second)
+	for !backend.wasCanceled(jobID1) {
+		if time.Now().After(deadline) {
+			t.Fatal("backend.Cancel was not called for the disconnected job within the deadline")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The pool slot must be free again: a second job against the same
+	// model must be able to start without waiting behind the first.
+	ch2, err := svc.Generate(context.Background(), &api.GenerateRequest{Model: "m"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	select {
+	case <-backend.runStarted:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the runner's slot to free up after a disconnect")
+	}
+
+	close(backend.release)
+	drainChunks(t, ch2)
+}
+
+func TestServiceQueueDepthReflectsWaitingJobs(t *testing.T) {
+	backend := newControlledBackend()
+	svc := New(backend, WithMaxConcurrency(1))
+
+	ch1, err := svc.Generate(context.Background(), &api.GenerateRequest{Model: "m"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	<-backend.runStarted // first job now holds the pool's only slot
+
+	done2 := make(chan struct{})
+	var ch2 <-chan Chunk
+	go func() {
+		var err error
+		ch2, err = svc.Generate(context.Background(), &api.GenerateRequest{Model: "m"})
+		if err != nil {
+			t.Errorf("Generate() error = %v", err)
+		}
+		close(done2)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for svc.QueueDepth("m") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the queued job to register in QueueDepth")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	close(backend.release) // let the first job finish and free the slot
+	drainChunks(t, ch1)
+
+	select {
+	case <-done2:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the queued job to start")
+	}
+	select {
+	case <-backend.runStarted:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the second job to start running")
+	}
+
+	if depth := svc.QueueDepth("m"); depth != 0 {
+		t.Errorf("QueueDepth(m) = %d, want 0 once the second job is running", depth)
+	}
+
+	drainChunks(t, ch2)
+}
+
+func TestServiceGenerateReturnsErrorWhenCanceledWhileQueued(t *testing.T) {
+	backend := newControlledBackend()
+	svc := New(backend, WithMaxConcurrency(1))
+
+	ch1, err := svc.Generate(context.Background(), &api.GenerateRequest{Model: "m"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	<-backend.runStarted // holds the only slot
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	cancel2() // already canceled before a slot can ever open up for it
+
+	if _, err := svc.Generate(ctx2, &api.GenerateRequest{Model: "m"}); err == nil {
+		t.Fatal("expected Generate to return an error for a context canceled while queued")
+	}
+
+	close(backend.release)
+	drainChunks(t, ch1)
+}
+
+func TestServiceRunsDistinctModelsConcurrently(t *testing.T) {
+	backend := newControlledBackend()
+	svc := New(backend, WithMaxConcurrency(1))
+
+	ch1, err := svc.Generate(context.Background(), &api.GenerateRequest{Model: "a"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	ch2, err := svc.Generate(context.Background(), &api.GenerateRequest{Model: "b"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-backend.runStarted:
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for both models' jobs to start concurrently")
+		}
+	}
+
+	close(backend.release)
+	drainChunks(t, ch1)
+	drainChunks(t, ch2)
+}
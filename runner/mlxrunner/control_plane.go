@@ -0,0 +1,200 @@
+package mlxrunner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ollama/ollama/llm"
+)
+
+// This file adds a control-plane endpoint, /control-plane, built on the
+// same hand-rolled WebSocket primitives /ws already uses (see
+// websocket.go) rather than a gRPC stream: this tree has no protobuf/gRPC
+// dependency available, and the repo already solved "bidirectional,
+// persistent, no new dependency" for /ws, so the control plane reuses that
+// exact mechanism instead of introducing a second one.
+//
+// Once connected, the server (see server/control_plane.go's
+// superviseControlPlane) sends one "sync" frame naming every model it
+// believes should be loaded, and this runner rehydrates any of those it
+// isn't already serving. From then on this runner pushes a "state" frame
+// every controlPlanePushInterval, and answers any "exec" frame with its
+// "op" run against the registry, bounded by execOpTimeout.
+
+const (
+	controlPlanePushInterval = 5 * time.Second
+	execOpTimeout            = 10 * time.Second
+)
+
+type cpSyncFrame struct {
+	Type          string   `json:"type"` // "sync"
+	DesiredModels []string `json:"desired_models"`
+}
+
+type cpStateFrame struct {
+	Type string `json:"type"` // "state"
+	llm.RunnerState
+}
+
+type cpExecFrame struct {
+	Type    string          `json:"type"` // "exec"
+	ID      string          `json:"id"`
+	Op      string          `json:"op"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type cpExecResultFrame struct {
+	Type   string          `json:"type"` // "exec_result"
+	ID     string          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// handleControlPlane upgrades to a WebSocket and runs the control-plane
+// protocol described above until the connection drops; the server side
+// reconnects with backoff when that happens.
+func (s *Server) handleControlPlane(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer conn.conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	go s.readControlPlaneFrames(ctx, cancel, conn)
+
+	if err := conn.writeJSON(s.controlPlaneState()); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(controlPlanePushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := conn.writeJSON(s.controlPlaneState()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readControlPlaneFrames handles every client->server frame the server
+// sends over the connection, canceling ctx (stopping the push loop above)
+// once the connection drops.
+func (s *Server) readControlPlaneFrames(ctx context.Context, cancel context.CancelFunc, conn *wsConn) {
+	defer cancel()
+	for {
+		opcode, payload, err := conn.readFrame()
+		if err != nil {
+			return
+		}
+		if opcode != wsOpText {
+			continue
+		}
+
+		var kind struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(payload, &kind); err != nil {
+			continue
+		}
+
+		switch kind.Type {
+		case "sync":
+			var msg cpSyncFrame
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				continue
+			}
+			s.reconcileDesiredModels(ctx, msg.DesiredModels)
+		case "exec":
+			var msg cpExecFrame
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				continue
+			}
+			go s.execControlPlaneOp(ctx, conn, msg)
+		}
+	}
+}
+
+// reconcileDesiredModels rehydrates, best-effort, every model the server
+// believes should be loaded that this runner isn't already serving, so a
+// restarted runner doesn't wait for the next user request to warm back up.
+func (s *Server) reconcileDesiredModels(ctx context.Context, desired []string) {
+	for _, model := range desired {
+		if _, ok := s.registry.get(model); ok {
+			continue
+		}
+		model := model
+		go func() {
+			if _, err := s.registry.getOrStart(ctx, model, nil, "", 0); err != nil {
+				slog.Warn("control-plane rehydrate failed", "model", model, "error", err)
+			}
+		}()
+	}
+}
+
+// execControlPlaneOp runs one request/response op over the control-plane
+// connection, bounded by execOpTimeout so a stalled op can't hang the
+// connection forever.
+func (s *Server) execControlPlaneOp(ctx context.Context, conn *wsConn, msg cpExecFrame) {
+	opCtx, cancel := context.WithTimeout(ctx, execOpTimeout)
+	defer cancel()
+
+	result, err := s.runControlPlaneOp(opCtx, msg.Op, msg.Payload)
+	resp := cpExecResultFrame{Type: "exec_result", ID: msg.ID}
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Result = result
+	}
+	conn.writeJSON(resp)
+}
+
+// runControlPlaneOp implements every exec op the control plane supports.
+// "ping" is the only one today, round-tripping payload back unchanged so
+// the server side's exec helper has something to verify against; more ops
+// can be added here as the server needs them.
+func (s *Server) runControlPlaneOp(ctx context.Context, op string, payload json.RawMessage) (json.RawMessage, error) {
+	switch op {
+	case "ping":
+		return payload, nil
+	default:
+		return nil, fmt.Errorf("control-plane: unknown op %q", op)
+	}
+}
+
+// controlPlaneState builds this runner's current RunnerState snapshot from
+// the same registry and metrics sources /ps and /metrics read from.
+func (s *Server) controlPlaneState() cpStateFrame {
+	entries := s.registry.list()
+	models := make([]string, len(entries))
+	var wired int64
+	for i, e := range entries {
+		models[i] = e.Model
+		wired += e.RSSBytes
+	}
+
+	inFlight := 0
+	if s.metrics != nil {
+		inFlight = int(s.metrics.inFlightGenerations.Load())
+	}
+
+	return cpStateFrame{
+		Type: "state",
+		RunnerState: llm.RunnerState{
+			LoadedModels:     models,
+			WiredMemoryBytes: wired,
+			InFlightRequests: inFlight,
+		},
+	}
+}
@@ -0,0 +1,332 @@
+package mlxrunner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// This file gives mlxrunner.Server a /metrics endpoint in Prometheus's text
+// exposition format (see
+// https://prometheus.io/docs/instrumenting/exposition_formats/), plus
+// request-tracing middleware that stamps a request ID into context and
+// slog, and a /debug/pprof tree behind --enable-pprof. There's no
+// Prometheus client library available in this tree, so the counters,
+// histograms, and exposition format below are hand-rolled against the
+// documented text format rather than importing client_golang.
+
+// requestIDKey is the context key the tracing middleware stamps the
+// per-request ID under, so downstream log lines (and handlers, if they
+// want to) can pick it up via requestIDFromContext.
+type requestIDKey struct{}
+
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+var requestIDCounter atomic.Uint64
+
+func nextRequestID() string {
+	return fmt.Sprintf("req-%d", requestIDCounter.Add(1))
+}
+
+// counterVec is a set of monotonically increasing counters keyed by a
+// single label value, e.g. route or model.
+type counterVec struct {
+	mu     sync.Mutex
+	values map[string]*atomic.Int64
+}
+
+func newCounterVec() *counterVec {
+	return &counterVec{values: make(map[string]*atomic.Int64)}
+}
+
+func (c *counterVec) inc(label string) {
+	c.add(label, 1)
+}
+
+func (c *counterVec) add(label string, delta int64) {
+	c.mu.Lock()
+	v, ok := c.values[label]
+	if !ok {
+		v = &atomic.Int64{}
+		c.values[label] = v
+	}
+	c.mu.Unlock()
+	v.Add(delta)
+}
+
+func (c *counterVec) snapshot() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]int64, len(c.values))
+	for k, v := range c.values {
+		out[k] = v.Load()
+	}
+	return out
+}
+
+// latencyBucketsMS are the histogram bucket upper bounds, in milliseconds,
+// shared by the time-to-first-token and inter-token-latency histograms.
+var latencyBucketsMS = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// histogramVec is a Prometheus-style cumulative histogram (each bucket
+// counts all observations less than or equal to its upper bound, plus an
+// implicit +Inf bucket), tracked per label value.
+type histogramVec struct {
+	buckets []float64 // sorted ascending
+
+	mu     sync.Mutex
+	counts map[string][]int64 // len(buckets)+1, the last slot is +Inf
+	sums   map[string]float64
+	totals map[string]int64
+}
+
+func newHistogramVec(buckets []float64) *histogramVec {
+	return &histogramVec{
+		buckets: buckets,
+		counts:  make(map[string][]int64),
+		sums:    make(map[string]float64),
+		totals:  make(map[string]int64),
+	}
+}
+
+func (h *histogramVec) observe(label string, value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts, ok := h.counts[label]
+	if !ok {
+		counts = make([]int64, len(h.buckets)+1)
+		h.counts[label] = counts
+	}
+	idx := sort.SearchFloat64s(h.buckets, value)
+	for i := idx; i < len(counts); i++ {
+		counts[i]++
+	}
+	h.sums[label] += value
+	h.totals[label]++
+}
+
+func (h *histogramVec) snapshot() (counts map[string][]int64, sums map[string]float64, totals map[string]int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts = make(map[string][]int64, len(h.counts))
+	for k, v := range h.counts {
+		cp := make([]int64, len(v))
+		copy(cp, v)
+		counts[k] = cp
+	}
+	sums = make(map[string]float64, len(h.sums))
+	for k, v := range h.sums {
+		sums[k] = v
+	}
+	totals = make(map[string]int64, len(h.totals))
+	for k, v := range h.totals {
+		totals[k] = v
+	}
+	return counts, sums, totals
+}
+
+// metricsRegistry holds every metric mlxrunner exposes on /metrics.
+type metricsRegistry struct {
+	requestsTotal         *counterVec // by route
+	promptTokensTotal     *counterVec // by model
+	completionTokensTotal *counterVec // by model
+	backendRestartsTotal  *counterVec // by model
+
+	timeToFirstToken  *histogramVec // by route, milliseconds
+	interTokenLatency *histogramVec // by route, milliseconds
+
+	inFlightGenerations atomic.Int64
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		requestsTotal:         newCounterVec(),
+		promptTokensTotal:     newCounterVec(),
+		completionTokensTotal: newCounterVec(),
+		backendRestartsTotal:  newCounterVec(),
+		timeToFirstToken:      newHistogramVec(latencyBucketsMS),
+		interTokenLatency:     newHistogramVec(latencyBucketsMS),
+	}
+}
+
+func (m *metricsRegistry) recordTokens(model string, promptTokens, completionTokens int) {
+	if promptTokens > 0 {
+		m.promptTokensTotal.add(model, int64(promptTokens))
+	}
+	if completionTokens > 0 {
+		m.completionTokensTotal.add(model, int64(completionTokens))
+	}
+}
+
+func (m *metricsRegistry) recordBackendRestart(model string) {
+	m.backendRestartsTotal.inc(model)
+}
+
+// generationTimer tracks time-to-first-token and inter-token latency for a
+// single streaming generation; callers call onToken for every emitted token
+// and the timer records the first-token latency once and an inter-token
+// delta for every subsequent one.
+type generationTimer struct {
+	registry  *metricsRegistry
+	route     string
+	start     time.Time
+	lastToken time.Time
+	seenToken bool
+}
+
+func (m *metricsRegistry) startGeneration(route string) *generationTimer {
+	m.inFlightGenerations.Add(1)
+	return &generationTimer{registry: m, route: route, start: time.Now()}
+}
+
+func (t *generationTimer) onToken() {
+	now := time.Now()
+	if !t.seenToken {
+		t.seenToken = true
+		t.registry.timeToFirstToken.observe(t.route, float64(now.Sub(t.start).Milliseconds()))
+	} else {
+		t.registry.interTokenLatency.observe(t.route, float64(now.Sub(t.lastToken).Milliseconds()))
+	}
+	t.lastToken = now
+}
+
+func (t *generationTimer) done() {
+	t.registry.inFlightGenerations.Add(-1)
+}
+
+// writeExposition renders every metric in Prometheus text exposition
+// format. RSS gauges for currently-loaded models are pulled live from
+// registry so /metrics never goes stale between scrapes; restart counts
+// come from m's own backendRestartsTotal counter.
+func (m *metricsRegistry) writeExposition(w http.ResponseWriter, registry *ModelRegistry) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	writeCounter(w, "mlxrunner_requests_total", "Total HTTP requests by route.", "route", m.requestsTotal.snapshot())
+	writeCounter(w, "mlxrunner_prompt_tokens_total", "Total prompt tokens processed by model.", "model", m.promptTokensTotal.snapshot())
+	writeCounter(w, "mlxrunner_completion_tokens_total", "Total completion tokens generated by model.", "model", m.completionTokensTotal.snapshot())
+	writeCounter(w, "mlxrunner_backend_restarts_total", "Total MLX backend subprocess restarts by model.", "model", m.backendRestartsTotal.snapshot())
+
+	writeHistogram(w, "mlxrunner_time_to_first_token_ms", "Time from request start to first generated token, in milliseconds, by route.", "route", m.timeToFirstToken)
+	writeHistogram(w, "mlxrunner_inter_token_latency_ms", "Time between consecutive generated tokens, in milliseconds, by route.", "route", m.interTokenLatency)
+
+	fmt.Fprintf(w, "# HELP mlxrunner_in_flight_generations Number of generations currently streaming.\n")
+	fmt.Fprintf(w, "# TYPE mlxrunner_in_flight_generations gauge\n")
+	fmt.Fprintf(w, "mlxrunner_in_flight_generations %d\n", m.inFlightGenerations.Load())
+
+	if registry != nil {
+		fmt.Fprintf(w, "# HELP mlxrunner_backend_rss_bytes Resident set size of each loaded MLX backend subprocess.\n")
+		fmt.Fprintf(w, "# TYPE mlxrunner_backend_rss_bytes gauge\n")
+		for _, entry := range registry.list() {
+			fmt.Fprintf(w, "mlxrunner_backend_rss_bytes{model=%q} %d\n", entry.Model, entry.RSSBytes)
+		}
+	}
+}
+
+func writeCounter(w http.ResponseWriter, name, help, label string, values map[string]int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	labels := make([]string, 0, len(values))
+	for l := range values {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, l, values[l])
+	}
+}
+
+func writeHistogram(w http.ResponseWriter, name, help, label string, h *histogramVec) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+
+	counts, sums, totals := h.snapshot()
+	labels := make([]string, 0, len(counts))
+	for l := range counts {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+
+	for _, l := range labels {
+		bucketCounts := counts[l]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s=%q,le=%q} %d\n", name, label, l, strconv.FormatFloat(upper, 'f', -1, 64), bucketCounts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s=%q,le=\"+Inf\"} %d\n", name, label, l, bucketCounts[len(bucketCounts)-1])
+		fmt.Fprintf(w, "%s_sum{%s=%q} %s\n", name, label, l, strconv.FormatFloat(sums[l], 'f', -1, 64))
+		fmt.Fprintf(w, "%s_count{%s=%q} %d\n", name, label, l, totals[l])
+	}
+}
+
+// statusRecorder wraps an http.ResponseWriter to capture the status code
+// the handler actually wrote, for access logging.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// withMetrics wraps h so that every request to route is counted, stamped
+// with a request ID (propagated into both context and slog), and logged on
+// completion with its route, status, and duration.
+func withMetrics(route string, registry *metricsRegistry, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		registry.requestsTotal.inc(route)
+
+		id := nextRequestID()
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		r = r.WithContext(ctx)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r)
+
+		slog.Info("request completed",
+			"request_id", id,
+			"route", route,
+			"method", r.Method,
+			"status", rec.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	}
+}
+
+// pprofPrefix is the mount point pprof's handlers are registered under when
+// --enable-pprof is set.
+const pprofPrefix = "/debug/pprof"
+
+// registerPprof mounts the standard net/http/pprof handlers on mux under
+// pprofPrefix. It's opt-in (--enable-pprof) since pprof exposes process
+// internals (stacks, heap contents) that shouldn't be reachable by default.
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc(pprofPrefix+"/", pprof.Index)
+	mux.HandleFunc(pprofPrefix+"/cmdline", pprof.Cmdline)
+	mux.HandleFunc(pprofPrefix+"/profile", pprof.Profile)
+	mux.HandleFunc(pprofPrefix+"/symbol", pprof.Symbol)
+	mux.HandleFunc(pprofPrefix+"/trace", pprof.Trace)
+}
+
+// routeLabel strips any leading HTTP method (e.g. "POST ") that
+// http.ServeMux patterns carry, so metrics/log lines use just the path.
+func routeLabel(pattern string) string {
+	if i := strings.IndexByte(pattern, ' '); i != -1 {
+		return pattern[i+1:]
+	}
+	return pattern
+}
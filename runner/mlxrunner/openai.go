@@ -0,0 +1,593 @@
+package mlxrunner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file gives mlxrunner.Server an OpenAI-compatible REST surface
+// (/v1/chat/completions, /v1/completions, /v1/embeddings, /v1/models) so the
+// runner can serve as a drop-in backend for OpenAI-client tooling, alongside
+// its native /completion and /embedding endpoints used by the Ollama server.
+// Requests are translated into the same NDJSON wire protocol the native
+// /completion endpoint speaks, then the response is either re-framed as SSE
+// chat/completion chunks (streaming) or aggregated into a single OpenAI
+// response envelope (non-streaming).
+
+// openAIChatMessage is one entry of an OpenAI chat completion request's
+// "messages" array.
+type openAIChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// openAITool is an OpenAI-style function tool definition, passed through
+// largely opaque: the runner only needs enough of its shape to render a
+// tool-use prompt block, not to validate it.
+type openAITool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		Parameters  json.RawMessage `json:"parameters"`
+	} `json:"function"`
+}
+
+// openAIToolCall is an OpenAI-style tool call, as returned in a chat
+// completion response's message.tool_calls.
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAIChatCompletionRequest struct {
+	Model       string               `json:"model"`
+	Messages    []openAIChatMessage  `json:"messages"`
+	Temperature *float64             `json:"temperature"`
+	TopP        *float64             `json:"top_p"`
+	MaxTokens   *int                 `json:"max_tokens"`
+	Stop        any                  `json:"stop"`
+	Stream      bool                 `json:"stream"`
+	Tools       []openAITool         `json:"tools"`
+}
+
+type openAICompletionRequest struct {
+	Model       string   `json:"model"`
+	Prompt      string   `json:"prompt"`
+	Temperature *float64 `json:"temperature"`
+	TopP        *float64 `json:"top_p"`
+	MaxTokens   *int     `json:"max_tokens"`
+	Stop        any      `json:"stop"`
+	Stream      bool     `json:"stream"`
+}
+
+type openAIEmbeddingsRequest struct {
+	Model string `json:"model"`
+	Input any    `json:"input"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type openAIChatChoice struct {
+	Index        int                `json:"index"`
+	Message      *openAIChatMessage `json:"message,omitempty"`
+	Delta        *openAIChatMessage `json:"delta,omitempty"`
+	ToolCalls    []openAIToolCall   `json:"tool_calls,omitempty"`
+	FinishReason *string            `json:"finish_reason"`
+}
+
+type openAIChatCompletionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []openAIChatChoice `json:"choices"`
+	Usage   *openAIUsage       `json:"usage,omitempty"`
+}
+
+type openAICompletionChoice struct {
+	Index        int     `json:"index"`
+	Text         string  `json:"text"`
+	FinishReason *string `json:"finish_reason"`
+}
+
+type openAICompletionResponse struct {
+	ID      string                   `json:"id"`
+	Object  string                   `json:"object"`
+	Created int64                    `json:"created"`
+	Model   string                   `json:"model"`
+	Choices []openAICompletionChoice `json:"choices"`
+	Usage   *openAIUsage             `json:"usage,omitempty"`
+}
+
+type openAIEmbeddingData struct {
+	Index     int       `json:"index"`
+	Object    string    `json:"object"`
+	Embedding []float64 `json:"embedding"`
+}
+
+type openAIEmbeddingsResponse struct {
+	Object string                `json:"object"`
+	Data   []openAIEmbeddingData `json:"data"`
+	Model  string                `json:"model"`
+	Usage  *openAIUsage          `json:"usage,omitempty"`
+}
+
+type openAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type openAIModelsResponse struct {
+	Object string        `json:"object"`
+	Data   []openAIModel `json:"data"`
+}
+
+// completionChunk mirrors the NDJSON wire protocol the native /completion
+// endpoint streams back (see the Go server's mlxStreamChunk), trimmed to the
+// fields the OpenAI translation needs.
+type completionChunk struct {
+	Content         string `json:"content"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+}
+
+// writeOpenAIError writes an OpenAI-shaped error envelope, matching the
+// {"error": {"message": ...}} format OpenAI clients parse.
+func writeOpenAIError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    "invalid_request_error",
+		},
+	})
+}
+
+// writeOpenAISessionError writes a resolveSession error as an OpenAI-shaped
+// error envelope, using 503 with Retry-After once a model's backend has
+// exceeded its restart budget, 500 otherwise.
+func writeOpenAISessionError(w http.ResponseWriter, err error) {
+	status, retryAfter := httpStatusForSessionError(err)
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	}
+	writeOpenAIError(w, status, err.Error())
+}
+
+// openAISamplerOptions builds the native /completion "options" map from the
+// sampler fields an OpenAI request may set, using the same key names
+// ConvertOptionsToMLXFormat and extractStopSequences expect on the Go server
+// side of this same wire protocol.
+func openAISamplerOptions(temperature, topP *float64, maxTokens *int, stop any) map[string]any {
+	options := make(map[string]any)
+	if temperature != nil {
+		options["temperature"] = *temperature
+	}
+	if topP != nil {
+		options["top_p"] = *topP
+	}
+	if maxTokens != nil {
+		options["max_tokens"] = *maxTokens
+	}
+	if stop != nil {
+		options["stop"] = stop
+	}
+	return options
+}
+
+// toolPromptBlock renders OpenAI tool definitions into a textual block a
+// model can follow, since the MLX backend's /completion endpoint only
+// accepts a fully-rendered prompt string, not a structured tools field.
+func toolPromptBlock(tools []openAITool) string {
+	if len(tools) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("You have access to the following tools:\n")
+	for _, t := range tools {
+		b.WriteString("- ")
+		b.WriteString(t.Function.Name)
+		if t.Function.Description != "" {
+			b.WriteString(": ")
+			b.WriteString(t.Function.Description)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("To call a tool, respond with JSON: {\"name\": \"<tool>\", \"arguments\": {...}}\n")
+	return b.String()
+}
+
+// formatOpenAIChatPrompt renders an OpenAI chat message list into a
+// ChatML-style prompt. Unlike the Go server's per-model-family templating
+// (used for the native /api/chat endpoint), OpenAI requests always carry
+// their own system message explicitly, so there's no default system prompt
+// to inject here - messages are rendered as given.
+func formatOpenAIChatPrompt(messages []openAIChatMessage, tools []openAITool) string {
+	var b strings.Builder
+
+	if block := toolPromptBlock(tools); block != "" {
+		b.WriteString("<|im_start|>system\n")
+		b.WriteString(block)
+		b.WriteString("<|im_end|>\n")
+	}
+
+	for _, m := range messages {
+		b.WriteString("<|im_start|>")
+		b.WriteString(m.Role)
+		b.WriteString("\n")
+		b.WriteString(m.Content)
+		b.WriteString("<|im_end|>\n")
+	}
+	b.WriteString("<|im_start|>assistant\n")
+
+	return b.String()
+}
+
+// completionChunks posts a native /completion request to session's MLX
+// backend and invokes emit for each decoded chunk until done, applying the
+// same malformed-line tolerance as the Go server's mlxStreamDecoder.
+// Canceling ctx aborts the upstream request, tearing down its body to the
+// Python backend so generation actually stops rather than just being
+// ignored client-side.
+func (session *modelSession) completionChunks(ctx context.Context, prompt string, options map[string]any, emit func(completionChunk) error) error {
+	reqBody, err := json.Marshal(map[string]any{
+		"model":   session.modelPath,
+		"prompt":  prompt,
+		"options": options,
+		"stream":  true,
+	})
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/completion", session.port), bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := session.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("mlx backend returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var chunk completionChunk
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			continue
+		}
+		if err := emit(chunk); err != nil {
+			return err
+		}
+		if chunk.Done {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func openAIFinishReason(doneReason string) string {
+	if doneReason == "length" {
+		return "length"
+	}
+	return "stop"
+}
+
+// handleChatCompletions handles POST /v1/chat/completions.
+func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req openAIChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	session, err := s.resolveSession(r.Context(), req.Model, nil)
+	if err != nil {
+		writeOpenAISessionError(w, err)
+		return
+	}
+
+	prompt := formatOpenAIChatPrompt(req.Messages, req.Tools)
+	options := openAISamplerOptions(req.Temperature, req.TopP, req.MaxTokens, req.Stop)
+
+	id := fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
+	created := time.Now().Unix()
+
+	timer := s.metrics.startGeneration(routeLabel("POST /v1/chat/completions"))
+	defer timer.done()
+
+	if !req.Stream {
+		var content strings.Builder
+		var last completionChunk
+		err := session.completionChunks(r.Context(), prompt, options, func(c completionChunk) error {
+			timer.onToken()
+			content.WriteString(c.Content)
+			last = c
+			return nil
+		})
+		if err != nil {
+			writeOpenAIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.metrics.recordTokens(req.Model, last.PromptEvalCount, last.EvalCount)
+
+		finish := openAIFinishReason(last.DoneReason)
+		resp := openAIChatCompletionResponse{
+			ID:      id,
+			Object:  "chat.completion",
+			Created: created,
+			Model:   req.Model,
+			Choices: []openAIChatChoice{{
+				Index:        0,
+				Message:      &openAIChatMessage{Role: "assistant", Content: content.String()},
+				FinishReason: &finish,
+			}},
+			Usage: &openAIUsage{
+				PromptTokens:     last.PromptEvalCount,
+				CompletionTokens: last.EvalCount,
+				TotalTokens:      last.PromptEvalCount + last.EvalCount,
+			},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	err = session.completionChunks(r.Context(), prompt, options, func(c completionChunk) error {
+		timer.onToken()
+		if c.Done {
+			s.metrics.recordTokens(req.Model, c.PromptEvalCount, c.EvalCount)
+		}
+		var finish *string
+		if c.Done {
+			f := openAIFinishReason(c.DoneReason)
+			finish = &f
+		}
+		chunk := openAIChatCompletionResponse{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   req.Model,
+			Choices: []openAIChatChoice{{
+				Index:        0,
+				Delta:        &openAIChatMessage{Content: c.Content},
+				FinishReason: finish,
+			}},
+		}
+		line, err := json.Marshal(chunk)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", line); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("error streaming chat completion", "error", err)
+		return
+	}
+
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+// handleCompletions handles POST /v1/completions.
+func (s *Server) handleCompletions(w http.ResponseWriter, r *http.Request) {
+	var req openAICompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	session, err := s.resolveSession(r.Context(), req.Model, nil)
+	if err != nil {
+		writeOpenAISessionError(w, err)
+		return
+	}
+
+	options := openAISamplerOptions(req.Temperature, req.TopP, req.MaxTokens, req.Stop)
+	id := fmt.Sprintf("cmpl-%d", time.Now().UnixNano())
+	created := time.Now().Unix()
+
+	timer := s.metrics.startGeneration(routeLabel("POST /v1/completions"))
+	defer timer.done()
+
+	if !req.Stream {
+		var text strings.Builder
+		var last completionChunk
+		err := session.completionChunks(r.Context(), req.Prompt, options, func(c completionChunk) error {
+			timer.onToken()
+			text.WriteString(c.Content)
+			last = c
+			return nil
+		})
+		if err != nil {
+			writeOpenAIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.metrics.recordTokens(req.Model, last.PromptEvalCount, last.EvalCount)
+
+		finish := openAIFinishReason(last.DoneReason)
+		resp := openAICompletionResponse{
+			ID:      id,
+			Object:  "text_completion",
+			Created: created,
+			Model:   req.Model,
+			Choices: []openAICompletionChoice{{Index: 0, Text: text.String(), FinishReason: &finish}},
+			Usage: &openAIUsage{
+				PromptTokens:     last.PromptEvalCount,
+				CompletionTokens: last.EvalCount,
+				TotalTokens:      last.PromptEvalCount + last.EvalCount,
+			},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	err = session.completionChunks(r.Context(), req.Prompt, options, func(c completionChunk) error {
+		timer.onToken()
+		if c.Done {
+			s.metrics.recordTokens(req.Model, c.PromptEvalCount, c.EvalCount)
+		}
+		var finish *string
+		if c.Done {
+			f := openAIFinishReason(c.DoneReason)
+			finish = &f
+		}
+		chunk := openAICompletionResponse{
+			ID:      id,
+			Object:  "text_completion.chunk",
+			Created: created,
+			Model:   req.Model,
+			Choices: []openAICompletionChoice{{Index: 0, Text: c.Content, FinishReason: finish}},
+		}
+		line, err := json.Marshal(chunk)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", line); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("error streaming completion", "error", err)
+		return
+	}
+
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+// handleEmbeddings handles POST /v1/embeddings, translating OpenAI's
+// single-string-or-list "input" field into the native /embedding request and
+// back into OpenAI's {"data": [{"embedding": [...]}]} envelope.
+func (s *Server) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var req openAIEmbeddingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	session, err := s.resolveSession(r.Context(), req.Model, nil)
+	if err != nil {
+		writeOpenAISessionError(w, err)
+		return
+	}
+
+	var inputs []string
+	switch v := req.Input.(type) {
+	case string:
+		inputs = []string{v}
+	case []any:
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				inputs = append(inputs, str)
+			}
+		}
+	}
+	if len(inputs) == 0 {
+		writeOpenAIError(w, http.StatusBadRequest, "input must be a string or array of strings")
+		return
+	}
+
+	data := make([]openAIEmbeddingData, 0, len(inputs))
+	for i, text := range inputs {
+		reqBody, _ := json.Marshal(map[string]string{"prompt": text})
+		resp, err := session.client.Post(fmt.Sprintf("http://127.0.0.1:%d/embedding", session.port), "application/json", bytes.NewReader(reqBody))
+		if err != nil {
+			writeOpenAIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		var embedResp struct {
+			Embedding []float64 `json:"embedding"`
+		}
+		decodeErr := json.NewDecoder(resp.Body).Decode(&embedResp)
+		resp.Body.Close()
+		if decodeErr != nil {
+			writeOpenAIError(w, http.StatusInternalServerError, decodeErr.Error())
+			return
+		}
+
+		data = append(data, openAIEmbeddingData{Index: i, Object: "embedding", Embedding: embedResp.Embedding})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openAIEmbeddingsResponse{
+		Object: "list",
+		Data:   data,
+		Model:  req.Model,
+	})
+}
+
+// handleModels handles GET /v1/models, listing every model currently loaded
+// in the registry (plus the configured default model, if nothing has loaded
+// it yet).
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	seen := make(map[string]bool)
+	var data []openAIModel
+	for _, entry := range s.registry.list() {
+		seen[entry.Model] = true
+		data = append(data, openAIModel{ID: entry.Model, Object: "model", OwnedBy: "mlx"})
+	}
+	if s.defaultModel != "" && !seen[s.defaultModel] {
+		data = append(data, openAIModel{ID: s.defaultModel, Object: "model", OwnedBy: "mlx"})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openAIModelsResponse{Object: "list", Data: data})
+}
@@ -0,0 +1,819 @@
+package mlxrunner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ollama/ollama/llm"
+)
+
+// logMLXBackendOutput relays a backend subprocess's stdout/stderr to slog
+// line by line, tagged with the model it belongs to so output from several
+// concurrently loaded backends can be told apart.
+func logMLXBackendOutput(r io.Reader, level slog.Level, model string) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		slog.Log(context.Background(), level, "mlx backend output", "model", model, "line", scanner.Text())
+	}
+}
+
+// modelSession is one loaded MLX model: its own Python backend subprocess,
+// listening on its own port, plus the bookkeeping ModelRegistry needs for LRU
+// eviction and /ps reporting. Earlier, Server held exactly one of these
+// fields inline and guarded readiness with a single sync.WaitGroup shared
+// across the whole process; that WaitGroup could have Done called on it more
+// than once if /load was ever hit twice concurrently. readyOnce below closes
+// readyCh exactly once per session instead.
+type modelSession struct {
+	modelPath string
+	port      int
+	cmd       *exec.Cmd
+	client    *http.Client
+
+	// pythonPath is the explicit --python override, if any; empty lets
+	// PythonResolver discover an interpreter instead (see
+	// startMLXBackendProcess).
+	pythonPath string
+
+	mu        sync.Mutex
+	status    llm.ServerStatus
+	readyCh   chan struct{}
+	readyOnce sync.Once
+
+	// failedCh is closed by markFailed once the supervisor has given up
+	// restarting this session (see superviseSession), so waitReady stops
+	// blocking callers and returns a 503-able error instead of hanging.
+	failedCh   chan struct{}
+	failedOnce sync.Once
+
+	restarts   int
+	lastError  string
+	retryAfter time.Duration
+
+	// superviseCancel stops this session's supervisor goroutine; stop()
+	// calls it before terminating the backend process so an intentional
+	// eviction/unload isn't mistaken for a crash and restarted.
+	superviseCancel context.CancelFunc
+
+	startedAt time.Time
+	lastUsed  time.Time
+}
+
+func newModelSession(modelPath, pythonPath string, client *http.Client) *modelSession {
+	return &modelSession{
+		modelPath:  modelPath,
+		pythonPath: pythonPath,
+		client:     client,
+		status:     llm.ServerStatusLaunched,
+		readyCh:    make(chan struct{}),
+		failedCh:   make(chan struct{}),
+		startedAt:  time.Now(),
+		lastUsed:   time.Now(),
+	}
+}
+
+// markReady closes readyCh if it hasn't already been closed, so a session
+// whose /load response is (incorrectly) observed twice can't panic on a
+// double close(chan).
+func (m *modelSession) markReady() {
+	m.readyOnce.Do(func() {
+		m.mu.Lock()
+		m.status = llm.ServerStatusReady
+		m.mu.Unlock()
+		close(m.readyCh)
+	})
+}
+
+// markFailed records the supervisor giving up on this session and closes
+// failedCh exactly once, so every caller blocked in waitReady (and every
+// future one, via permanentFailure) gets back a backendUnavailableError
+// instead of waiting on a backend that will never become ready.
+func (m *modelSession) markFailed(err error, retryAfter time.Duration) {
+	m.failedOnce.Do(func() {
+		m.mu.Lock()
+		m.lastError = err.Error()
+		m.retryAfter = retryAfter
+		m.mu.Unlock()
+		close(m.failedCh)
+	})
+}
+
+// permanentFailure reports whether the supervisor has given up on this
+// session, returning the error callers should surface (with its
+// Retry-After) if so.
+func (m *modelSession) permanentFailure() (*backendUnavailableError, bool) {
+	select {
+	case <-m.failedCh:
+	default:
+		return nil, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return &backendUnavailableError{retryAfter: m.retryAfter, lastError: m.lastError}, true
+}
+
+func (m *modelSession) recordError(err error) {
+	m.mu.Lock()
+	m.lastError = err.Error()
+	m.mu.Unlock()
+}
+
+func (m *modelSession) clearError() {
+	m.mu.Lock()
+	m.lastError = ""
+	m.mu.Unlock()
+}
+
+func (m *modelSession) waitReady(ctx context.Context) error {
+	select {
+	case <-m.readyCh:
+		return nil
+	case <-m.failedCh:
+		m.mu.Lock()
+		retryAfter, lastError := m.retryAfter, m.lastError
+		m.mu.Unlock()
+		return &backendUnavailableError{retryAfter: retryAfter, lastError: lastError}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sessionHealth is a session's supervision state, as exposed by GET /health.
+type sessionHealth struct {
+	Status    string `json:"status"`
+	Restarts  int    `json:"restarts"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// snapshotHealth reports session's current supervision state: "starting"
+// before its first successful load, "ready" once loaded and healthy,
+// "unhealthy" if its last health probe failed but it hasn't yet been
+// restarted past its budget, or "failed" once the supervisor has given up.
+func (m *modelSession) snapshotHealth() sessionHealth {
+	status := "starting"
+	select {
+	case <-m.readyCh:
+		status = "ready"
+	default:
+	}
+
+	m.mu.Lock()
+	lastError := m.lastError
+	restarts := m.restarts
+	m.mu.Unlock()
+
+	if status == "ready" && lastError != "" {
+		status = "unhealthy"
+	}
+	select {
+	case <-m.failedCh:
+		status = "failed"
+	default:
+	}
+
+	return sessionHealth{Status: status, Restarts: restarts, LastError: lastError}
+}
+
+// backendUnavailableError is returned once a session's supervisor has
+// exceeded its restart budget, so resolveSession/sessionFor callers can
+// translate it into a 503 with Retry-After instead of the generic 500 used
+// for other failures.
+type backendUnavailableError struct {
+	retryAfter time.Duration
+	lastError  string
+}
+
+func (e *backendUnavailableError) Error() string {
+	return fmt.Sprintf("mlx backend is unavailable, retry after %s: %s", e.retryAfter, e.lastError)
+}
+
+// httpStatusForSessionError maps a resolveSession/sessionFor error to the
+// HTTP status (and Retry-After duration, if any) a handler should respond
+// with.
+func httpStatusForSessionError(err error) (status int, retryAfter time.Duration) {
+	var unavailable *backendUnavailableError
+	if errors.As(err, &unavailable) {
+		return http.StatusServiceUnavailable, unavailable.retryAfter
+	}
+	return http.StatusInternalServerError, 0
+}
+
+func (m *modelSession) touch() {
+	m.mu.Lock()
+	m.lastUsed = time.Now()
+	m.mu.Unlock()
+}
+
+// rssBytes reports the session's Python backend's resident set size, used
+// both by /ps and by the registry's memory-budget eviction. It shells out to
+// `ps` rather than reading /proc directly so this works on macOS (where MLX
+// actually runs) as well as Linux.
+func (m *modelSession) rssBytes() int64 {
+	if m.cmd == nil || m.cmd.Process == nil {
+		return 0
+	}
+	out, err := exec.Command("ps", "-o", "rss=", "-p", strconv.Itoa(m.cmd.Process.Pid)).Output()
+	if err != nil {
+		return 0
+	}
+	kb, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return kb * 1024
+}
+
+// terminate sends SIGTERM to the backend process and waits up to
+// gracefulShutdownTimeout for it to exit before killing it outright.
+func (m *modelSession) terminate() {
+	if m.cmd == nil || m.cmd.Process == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.cmd.Wait()
+		close(done)
+	}()
+
+	m.cmd.Process.Signal(syscall.SIGTERM)
+
+	select {
+	case <-done:
+	case <-time.After(gracefulShutdownTimeout):
+		m.cmd.Process.Kill()
+		<-done
+	}
+}
+
+// stop cancels session's supervisor, so the termination below isn't mistaken
+// for a crash and restarted, then kills its backend subprocess.
+func (m *modelSession) stop() {
+	if m.superviseCancel != nil {
+		m.superviseCancel()
+	}
+	m.terminate()
+}
+
+const gracefulShutdownTimeout = 10 * time.Second
+
+// Backend supervision tuning: backoff starts at backendBackoffInitial and
+// doubles on each consecutive failure up to backendBackoffMax; it resets
+// once a session has stayed healthy for backendBackoffResetAfter. A session
+// that restarts more than backendMaxRestarts times within
+// backendRestartWindow is considered crash-looping and is marked
+// permanently failed rather than retried forever.
+const (
+	backendBackoffInitial    = 250 * time.Millisecond
+	backendBackoffMax        = 8 * time.Second
+	backendBackoffResetAfter = 60 * time.Second
+	backendHealthInterval    = 5 * time.Second
+	backendMaxHealthFailures = 3
+	backendMaxRestarts       = 5
+	backendRestartWindow     = 2 * time.Minute
+	backendRetryAfter        = 30 * time.Second
+)
+
+// restartBudget tracks how many restarts have happened within a trailing
+// window, so superviseSession can tell ordinary flakiness from a
+// crash-loop.
+type restartBudget struct {
+	windowStart time.Time
+	count       int
+}
+
+// recordAndCheck records one more restart and reports whether the budget
+// has been exceeded.
+func (b *restartBudget) recordAndCheck() bool {
+	now := time.Now()
+	if b.windowStart.IsZero() || now.Sub(b.windowStart) > backendRestartWindow {
+		b.windowStart = now
+		b.count = 0
+	}
+	b.count++
+	return b.count > backendMaxRestarts
+}
+
+// jitter returns a random duration in [0, d), so many sessions backing off
+// at once don't all retry in lockstep.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// psEntry is one row of GET /ps's response.
+type psEntry struct {
+	Model    string        `json:"model"`
+	Port     int           `json:"port"`
+	RSSBytes int64         `json:"rss_bytes"`
+	Uptime   time.Duration `json:"uptime_ns"`
+}
+
+// ModelRegistry keeps zero or more MLX backend subprocesses alive
+// concurrently, one per loaded model, evicting the least-recently-used model
+// under LRU when either maxLoaded sessions or maxMemoryBytes of combined RSS
+// would otherwise be exceeded by starting a new one.
+type ModelRegistry struct {
+	mu             sync.Mutex
+	sessions       map[string]*modelSession
+	maxLoaded      int
+	maxMemoryBytes int64
+	pythonPath     string
+	client         *http.Client
+	metrics        *metricsRegistry
+}
+
+func NewModelRegistry(maxLoaded int, maxMemoryBytes int64, pythonPath string) *ModelRegistry {
+	return &ModelRegistry{
+		sessions:       make(map[string]*modelSession),
+		maxLoaded:      maxLoaded,
+		maxMemoryBytes: maxMemoryBytes,
+		pythonPath:     pythonPath,
+		client:         &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// SetMetrics attaches registry to r, so restarts are reported on
+// mlxrunner_backend_restarts_total. Optional: tests construct a
+// ModelRegistry without calling it.
+func (r *ModelRegistry) SetMetrics(registry *metricsRegistry) {
+	r.metrics = registry
+}
+
+// recordRestart reports a session restart to r.metrics, if one is attached.
+func (r *ModelRegistry) recordRestart(modelPath string) {
+	if r.metrics != nil {
+		r.metrics.recordBackendRestart(modelPath)
+	}
+}
+
+// get returns the session for modelPath if one is already loaded (warm or
+// still loading), touching its LRU timestamp.
+func (r *ModelRegistry) get(modelPath string) (*modelSession, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.sessions[modelPath]
+	if ok {
+		s.touch()
+	}
+	return s, ok
+}
+
+// getOrStart returns the already-warm (or still-loading) session for
+// modelPath, making /load idempotent per model, or starts a fresh backend
+// subprocess for it, evicting other sessions first if needed to stay within
+// the configured budget. draftModel and draftPort, when draftModel is
+// non-empty, are forwarded to the backend so it can verify speculative
+// tokens against the model already warm on draftPort; they're ignored on
+// the idempotent-return paths, since a session's draft pairing is fixed by
+// whichever call first started it, the same as adapters already is.
+func (r *ModelRegistry) getOrStart(ctx context.Context, modelPath string, adapters []llm.MLXAdapter, draftModel string, draftPort int) (*modelSession, error) {
+	if s, ok := r.get(modelPath); ok {
+		if unavailable, failed := s.permanentFailure(); failed {
+			return nil, unavailable
+		}
+		return s, nil
+	}
+
+	r.mu.Lock()
+	if s, ok := r.sessions[modelPath]; ok {
+		r.mu.Unlock()
+		s.touch()
+		if unavailable, failed := s.permanentFailure(); failed {
+			return nil, unavailable
+		}
+		return s, nil
+	}
+
+	r.evictLocked(modelPath)
+
+	session := newModelSession(modelPath, r.pythonPath, r.client)
+	r.sessions[modelPath] = session
+	r.mu.Unlock()
+
+	// Sessions stay in the map even if startSession returns an error: a
+	// ctx-cancellation just means this caller gave up waiting while the
+	// supervisor keeps trying in the background, and a permanent failure
+	// should keep being reported (fast) to every future caller rather than
+	// spawning a fresh supervisor that would only fail the same way.
+	if err := r.startSession(ctx, session, adapters, draftModel, draftPort); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// evictLocked removes sessions until adding one more would stay within
+// maxLoaded and maxMemoryBytes, preferring the least-recently-used session
+// that isn't keep (the model about to be loaded). Callers must hold r.mu.
+func (r *ModelRegistry) evictLocked(keep string) {
+	for r.overBudgetLocked() {
+		var oldestName string
+		var oldest time.Time
+		for name, s := range r.sessions {
+			if name == keep {
+				continue
+			}
+			s.mu.Lock()
+			lastUsed := s.lastUsed
+			s.mu.Unlock()
+			if oldestName == "" || lastUsed.Before(oldest) {
+				oldestName, oldest = name, lastUsed
+			}
+		}
+		if oldestName == "" {
+			return
+		}
+		s := r.sessions[oldestName]
+		delete(r.sessions, oldestName)
+		go s.stop()
+	}
+}
+
+func (r *ModelRegistry) overBudgetLocked() bool {
+	if r.maxLoaded > 0 && len(r.sessions) >= r.maxLoaded {
+		return true
+	}
+	if r.maxMemoryBytes > 0 {
+		var total int64
+		for _, s := range r.sessions {
+			total += s.rssBytes()
+		}
+		if total >= r.maxMemoryBytes {
+			return true
+		}
+	}
+	return false
+}
+
+// startSession launches session's backend under a supervisor goroutine (see
+// superviseSession) that keeps it running for as long as the session stays
+// in the registry, and blocks until it's first ready, permanently failed,
+// or ctx is canceled.
+func (r *ModelRegistry) startSession(ctx context.Context, session *modelSession, adapters []llm.MLXAdapter, draftModel string, draftPort int) error {
+	superviseCtx, cancel := context.WithCancel(context.Background())
+	session.superviseCancel = cancel
+	go r.superviseSession(superviseCtx, session, adapters, draftModel, draftPort)
+	return session.waitReady(ctx)
+}
+
+// attemptStart makes one attempt to start session's backend subprocess and
+// load its model; superviseSession wraps this with backoff and restarts.
+func (r *ModelRegistry) attemptStart(ctx context.Context, session *modelSession, adapters []llm.MLXAdapter, draftModel string, draftPort int) error {
+	if err := startMLXBackendProcess(ctx, session); err != nil {
+		return err
+	}
+	if err := loadModelIntoBackend(session, adapters, draftModel, draftPort); err != nil {
+		session.terminate()
+		return err
+	}
+	return nil
+}
+
+// superviseSession keeps session's MLX backend subprocess running for as
+// long as ctx is alive: it starts the backend and loads adapters into it,
+// then polls /health until the backend crashes or becomes unhealthy, at
+// which point it restarts the same way (re-issuing the same adapters, so
+// callers don't see a cold model). Restarts back off exponentially with
+// jitter, resetting once a run has stayed healthy for
+// backendBackoffResetAfter. A session that restarts more than
+// backendMaxRestarts times within backendRestartWindow is considered
+// crash-looping and is marked permanently failed instead of retried
+// forever.
+func (r *ModelRegistry) superviseSession(ctx context.Context, session *modelSession, adapters []llm.MLXAdapter, draftModel string, draftPort int) {
+	backoff := backendBackoffInitial
+	var budget restartBudget
+
+	for {
+		if err := r.attemptStart(ctx, session, adapters, draftModel, draftPort); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			session.recordError(err)
+			session.mu.Lock()
+			session.restarts++
+			session.mu.Unlock()
+			r.recordRestart(session.modelPath)
+
+			if budget.recordAndCheck() {
+				session.markFailed(err, backendRetryAfter)
+				return
+			}
+
+			select {
+			case <-time.After(jitter(backoff)):
+			case <-ctx.Done():
+				return
+			}
+			backoff = minDuration(backoff*2, backendBackoffMax)
+			continue
+		}
+
+		session.markReady()
+		session.clearError()
+		readySince := time.Now()
+
+		if shuttingDown := r.monitorHealth(ctx, session); shuttingDown {
+			return
+		}
+
+		session.terminate()
+		if time.Since(readySince) >= backendBackoffResetAfter {
+			budget = restartBudget{}
+			backoff = backendBackoffInitial
+		}
+		session.mu.Lock()
+		session.restarts++
+		session.mu.Unlock()
+		r.recordRestart(session.modelPath)
+
+		if budget.recordAndCheck() {
+			session.markFailed(fmt.Errorf("mlx backend for %q became unhealthy", session.modelPath), backendRetryAfter)
+			return
+		}
+
+		select {
+		case <-time.After(jitter(backoff)):
+		case <-ctx.Done():
+			return
+		}
+		backoff = minDuration(backoff*2, backendBackoffMax)
+	}
+}
+
+// monitorHealth polls session's /health endpoint every backendHealthInterval
+// and also watches for the backend process exiting on its own. It returns
+// true if ctx was canceled (the caller should stop supervising entirely),
+// or false if the backend should be treated as crashed/unhealthy and
+// restarted.
+func (r *ModelRegistry) monitorHealth(ctx context.Context, session *modelSession) bool {
+	exited := make(chan struct{})
+	go func() {
+		session.cmd.Wait()
+		close(exited)
+	}()
+
+	ticker := time.NewTicker(backendHealthInterval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return true
+		case <-exited:
+			session.recordError(fmt.Errorf("mlx backend process for %q exited unexpectedly", session.modelPath))
+			return false
+		case <-ticker.C:
+			url := fmt.Sprintf("http://127.0.0.1:%d/health", session.port)
+			resp, err := session.client.Get(url)
+			if err == nil && resp.StatusCode == http.StatusOK {
+				resp.Body.Close()
+				failures = 0
+				session.clearError()
+				continue
+			}
+			if resp != nil {
+				resp.Body.Close()
+			}
+			failures++
+			session.recordError(fmt.Errorf("health probe failed (%d/%d): %w", failures, backendMaxHealthFailures, err))
+			if failures >= backendMaxHealthFailures {
+				return false
+			}
+		}
+	}
+}
+
+// loadModelIntoBackend sends the load request to session's own Python
+// backend, carrying any stacked LoRA adapters alongside the model name.
+// draftModel, when set, names a smaller model already loaded and listening
+// on draftPort (another session entirely, possibly in another runner
+// process) for this backend to verify speculative tokens against.
+func loadModelIntoBackend(session *modelSession, adapters []llm.MLXAdapter, draftModel string, draftPort int) error {
+	payload := map[string]any{"model": session.modelPath}
+	if len(adapters) > 0 {
+		payload["adapters"] = adapters
+	}
+	if draftModel != "" {
+		payload["draft_model"] = draftModel
+		payload["draft_port"] = draftPort
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := session.client.Post(fmt.Sprintf("http://127.0.0.1:%d/load", session.port), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("load failed: %s", strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// unload stops and removes modelPath's session, if any.
+func (r *ModelRegistry) unload(modelPath string) bool {
+	r.mu.Lock()
+	s, ok := r.sessions[modelPath]
+	if ok {
+		delete(r.sessions, modelPath)
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+	s.stop()
+	return true
+}
+
+// list returns a /ps row for every currently loaded model.
+func (r *ModelRegistry) list() []psEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := make([]psEntry, 0, len(r.sessions))
+	for name, s := range r.sessions {
+		entries = append(entries, psEntry{
+			Model:    name,
+			Port:     s.port,
+			RSSBytes: s.rssBytes(),
+			Uptime:   time.Since(s.startedAt),
+		})
+	}
+	return entries
+}
+
+// shutdown gracefully terminates every loaded session, waiting for each to
+// exit (or forcing a kill after gracefulShutdownTimeout).
+func (r *ModelRegistry) shutdown() {
+	r.mu.Lock()
+	sessions := make([]*modelSession, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		sessions = append(sessions, s)
+	}
+	r.sessions = make(map[string]*modelSession)
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, s := range sessions {
+		wg.Add(1)
+		go func(s *modelSession) {
+			defer wg.Done()
+			s.stop()
+		}(s)
+	}
+	wg.Wait()
+}
+
+// parseMemorySize parses a human-friendly size like "48GiB", "512MiB", or
+// "10GB" into a byte count, for the --max-memory flag. A bare number is
+// interpreted as bytes. Returns 0 (no limit) for an empty string.
+func parseMemorySize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"GiB", 1 << 30},
+		{"MiB", 1 << 20},
+		{"KiB", 1 << 10},
+		{"GB", 1_000_000_000},
+		{"MB", 1_000_000},
+		{"KB", 1_000},
+		{"B", 1},
+	}
+
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			numStr := strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			n, err := strconv.ParseFloat(numStr, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid memory size %q: %w", s, err)
+			}
+			return int64(n * float64(u.multiplier)), nil
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory size %q: %w", s, err)
+	}
+	return n, nil
+}
+
+// modelPathFromRequestBody peeks at a JSON request body's "model" field
+// without fully decoding it into the caller's own request type, so /load,
+// /completion, and /embedding can all route by model before each parses the
+// rest of the body itself. fallback is used for legacy callers that omit
+// "model" entirely, e.g. single-model clients predating the registry.
+func modelPathFromRequestBody(body []byte, fallback string) string {
+	var peek struct {
+		Model string `json:"model"`
+	}
+	if len(body) > 0 {
+		_ = json.Unmarshal(body, &peek)
+	}
+	if peek.Model == "" {
+		return fallback
+	}
+	return peek.Model
+}
+
+// startMLXBackendProcess launches a Python MLX backend subprocess for
+// session on a freshly allocated port and blocks until it reports healthy.
+// This is the per-session equivalent of the old single-backend
+// Server.startMLXBackend.
+func startMLXBackendProcess(ctx context.Context, session *modelSession) error {
+	mlxBackendPath, err := findMLXBackendPath()
+	if err != nil {
+		return err
+	}
+
+	pythonCmd, err := (PythonResolver{Explicit: session.pythonPath}).Resolve(mlxBackendPath)
+	if err != nil {
+		return err
+	}
+	if err := checkMLXDeps(ctx, pythonCmd); err != nil {
+		return err
+	}
+
+	port := 0
+	if a, err := net.ResolveTCPAddr("tcp", "localhost:0"); err == nil {
+		var l *net.TCPListener
+		if l, err = net.ListenTCP("tcp", a); err == nil {
+			port = l.Addr().(*net.TCPAddr).Port
+			l.Close()
+		}
+	}
+	if port == 0 {
+		return fmt.Errorf("failed to allocate a port for the MLX backend")
+	}
+	session.port = port
+
+	slog.Info("starting MLX backend", "model", session.modelPath, "port", port, "path", mlxBackendPath, "python", pythonCmd.exe)
+
+	session.cmd = pythonCmd.command(ctx, mlxBackendPath, "--port", strconv.Itoa(port))
+	session.cmd.Env = os.Environ()
+
+	stdout, _ := session.cmd.StdoutPipe()
+	stderr, _ := session.cmd.StderrPipe()
+
+	go logMLXBackendOutput(stdout, slog.LevelDebug, session.modelPath)
+	go logMLXBackendOutput(stderr, slog.LevelWarn, session.modelPath)
+
+	if err := session.cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start MLX backend: %w", err)
+	}
+
+	mlxURL := fmt.Sprintf("http://127.0.0.1:%d/health", port)
+	for i := 0; i < 30; i++ {
+		resp, err := session.client.Get(mlxURL)
+		if err == nil && resp.StatusCode == 200 {
+			resp.Body.Close()
+			slog.Info("MLX backend is ready", "model", session.modelPath, "port", port)
+			return nil
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	return fmt.Errorf("MLX backend for %q failed to start within timeout", session.modelPath)
+}
@@ -1,11 +1,9 @@
 package mlxrunner
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -15,28 +13,28 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
-	"sync"
-	"time"
+	"syscall"
 
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/llm"
 	"github.com/ollama/ollama/logutil"
 )
 
-// Server manages the MLX Python backend subprocess and proxies requests to it
+// Server fronts a ModelRegistry with the runner's HTTP surface: the native
+// /load, /completion, /embedding, /health, /unload and /ps endpoints the
+// Ollama server speaks, plus the OpenAI-compatible surface in openai.go.
+// Requests route to a backend by a "model" field in the JSON body (see
+// modelPathFromRequestBody); defaultModel is used when a legacy caller omits
+// it, so single-model clients predating the registry keep working unchanged.
 type Server struct {
-	modelPath  string
-	mlxPort    int
-	mlxCmd     *exec.Cmd
-	mlxClient  *http.Client
-	status     llm.ServerStatus
-	ready      sync.WaitGroup
-	mu         sync.Mutex
-	cond       *sync.Cond
-	pythonPath string
+	registry     *ModelRegistry
+	defaultModel string
+	metrics      *metricsRegistry
 }
 
 // LoadRequest matches the structure expected by the MLX backend
@@ -116,161 +114,243 @@ func findMLXBackendPath() (string, error) {
 	return "", fmt.Errorf("MLX backend server not found; candidates: %s; executable: %s; working directory: %s", strings.Join(uniqueCandidates, ", "), exePath, wd)
 }
 
-// startMLXBackend launches the Python MLX backend server
-func (s *Server) startMLXBackend(ctx context.Context) error {
-	// Find Python executable
-	pythonExe := "python3"
-	if s.pythonPath != "" {
-		pythonExe = s.pythonPath
+// pythonCommand is how to invoke a resolved Python interpreter: args is
+// prepended before the backend script path and its own arguments, so a
+// plain interpreter is {exe: "/path/to/python3"} while a uv-managed project
+// is {exe: "uv", args: ["run", "--project", dir, "python"]}.
+type pythonCommand struct {
+	exe  string
+	args []string
+}
+
+func (c pythonCommand) command(ctx context.Context, scriptArgs ...string) *exec.Cmd {
+	args := append(append([]string{}, c.args...), scriptArgs...)
+	return exec.CommandContext(ctx, c.exe, args...)
+}
+
+// installCommand suggests how to install pkgs under c, since "pip install"
+// isn't the right instruction for a uv-managed project.
+func (c pythonCommand) installCommand(pkgs []string) string {
+	if c.exe == "uv" {
+		return "uv pip install " + strings.Join(pkgs, " ")
 	}
+	return fmt.Sprintf("%s -m pip install %s", c.exe, strings.Join(pkgs, " "))
+}
 
-	// Locate the MLX backend server script
-	mlxBackendPath, err := findMLXBackendPath()
-	if err != nil {
-		return err
+// PythonResolver finds a Python interpreter to launch the MLX backend with.
+// Explicit (the --python flag) always wins; otherwise Resolve tries, in
+// order, $OLLMLX_PYTHON, a .venv next to the backend script, a uv-managed
+// project, a detected conda environment, and finally python3 on PATH. This
+// removes the need for every user to discover and pass --python by hand.
+type PythonResolver struct {
+	Explicit string
+}
+
+// Resolve finds a Python interpreter to run mlxBackendPath's script with.
+// It only fails if none of its candidates can be found at all; whether the
+// resolved interpreter actually has mlx/mlx_lm installed is checked
+// separately by checkMLXDeps.
+func (r PythonResolver) Resolve(mlxBackendPath string) (pythonCommand, error) {
+	if r.Explicit != "" {
+		return pythonCommand{exe: r.Explicit}, nil
+	}
+	if path := os.Getenv("OLLMLX_PYTHON"); path != "" {
+		return pythonCommand{exe: path}, nil
+	}
+
+	backendDir := filepath.Dir(mlxBackendPath)
+
+	if venvPython := filepath.Join(backendDir, ".venv", "bin", "python"); fileExists(venvPython) {
+		return pythonCommand{exe: venvPython}, nil
+	}
+
+	if uvPath, err := exec.LookPath("uv"); err == nil && fileExists(filepath.Join(backendDir, "pyproject.toml")) {
+		return pythonCommand{exe: uvPath, args: []string{"run", "--project", backendDir, "python"}}, nil
 	}
 
-	// Allocate a random port for the MLX backend
-	s.mlxPort = 0
-	if a, err := net.ResolveTCPAddr("tcp", "localhost:0"); err == nil {
-		var l *net.TCPListener
-		if l, err = net.ListenTCP("tcp", a); err == nil {
-			s.mlxPort = l.Addr().(*net.TCPAddr).Port
-			l.Close()
+	if condaPrefix := os.Getenv("CONDA_PREFIX"); condaPrefix != "" {
+		if condaPython := filepath.Join(condaPrefix, "bin", "python"); fileExists(condaPython) {
+			return pythonCommand{exe: condaPython}, nil
 		}
 	}
-	if s.mlxPort == 0 {
-		s.mlxPort = 9090 // fallback port
+
+	if path, err := exec.LookPath("python3"); err == nil {
+		return pythonCommand{exe: path}, nil
 	}
 
-	slog.Info("starting MLX backend", "port", s.mlxPort, "path", mlxBackendPath)
+	return pythonCommand{}, fmt.Errorf("no Python interpreter found; install python3 or set --python / $OLLMLX_PYTHON")
+}
 
-	// Start the MLX backend Python server
-	s.mlxCmd = exec.CommandContext(ctx, pythonExe, mlxBackendPath, "--port", strconv.Itoa(s.mlxPort))
-	s.mlxCmd.Env = os.Environ()
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
 
-	// Capture stdout/stderr for debugging
-	stdout, _ := s.mlxCmd.StdoutPipe()
-	stderr, _ := s.mlxCmd.StderrPipe()
+// mlxDepPackages maps the module names imported by checkMLXDeps to the pip
+// package names that provide them.
+var mlxDepPackages = map[string]string{
+	"mlx":    "mlx",
+	"mlx_lm": "mlx-lm",
+}
 
-	go func() {
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			slog.Debug("mlx backend stdout", "line", scanner.Text())
-		}
-	}()
+var missingModuleRe = regexp.MustCompile(`No module named '([\w.]+)'`)
+
+// checkMLXDeps runs a throwaway `import mlx, mlx_lm` under cmd and turns a
+// failure into an actionable error naming the missing package(s) and the
+// exact command to install them, instead of letting the backend subprocess
+// fail opaquely on its first request.
+func checkMLXDeps(ctx context.Context, cmd pythonCommand) error {
+	check := cmd.command(ctx, "-c", "import mlx, mlx_lm; print(mlx.__version__)")
+	out, err := check.CombinedOutput()
+	if err == nil {
+		return nil
+	}
 
-	go func() {
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			slog.Warn("mlx backend stderr", "line", scanner.Text())
+	missing := []string{"mlx", "mlx-lm"}
+	if m := missingModuleRe.FindStringSubmatch(string(out)); m != nil {
+		if pkg, ok := mlxDepPackages[m[1]]; ok {
+			missing = []string{pkg}
 		}
-	}()
+	}
 
-	if err := s.mlxCmd.Start(); err != nil {
-		return fmt.Errorf("failed to start MLX backend: %w", err)
+	return fmt.Errorf("MLX dependencies are missing for %s (%s): run `%s`",
+		cmd.exe, strings.TrimSpace(string(out)), cmd.installCommand(missing))
+}
+
+// resolveSession returns model's warm session (starting one on demand if
+// it isn't loaded yet), falling back to defaultModel when model is empty.
+func (s *Server) resolveSession(ctx context.Context, model string, adapters []llm.MLXAdapter) (*modelSession, error) {
+	if model == "" {
+		model = s.defaultModel
+	}
+	if model == "" {
+		return nil, fmt.Errorf("no model specified and no default model configured")
 	}
 
-	// Wait for the MLX backend to be ready
-	mlxURL := fmt.Sprintf("http://127.0.0.1:%d/health", s.mlxPort)
-	for i := 0; i < 30; i++ {
-		resp, err := s.mlxClient.Get(mlxURL)
-		if err == nil && resp.StatusCode == 200 {
-			resp.Body.Close()
-			slog.Info("MLX backend is ready")
-			return nil
-		}
-		if resp != nil {
-			resp.Body.Close()
-		}
-		time.Sleep(500 * time.Millisecond)
+	session, err := s.registry.getOrStart(ctx, model, adapters, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := session.waitReady(ctx); err != nil {
+		return nil, err
 	}
+	return session, nil
+}
 
-	return fmt.Errorf("MLX backend failed to start within timeout")
+// sessionFor resolves the model a request body targets (from its "model"
+// JSON field, falling back to defaultModel) and returns its warm session.
+func (s *Server) sessionFor(ctx context.Context, body []byte, adapters []llm.MLXAdapter) (*modelSession, error) {
+	return s.resolveSession(ctx, modelPathFromRequestBody(body, ""), adapters)
 }
 
-// load handles the /load endpoint - loads the model into MLX backend
+// load handles the /load endpoint. It is idempotent per model: if the model
+// is already warm, it returns immediately instead of reloading.
 func (s *Server) load(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// The model should already be set from command line, but we can accept it here too
-	var req llm.LoadRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	slog.Info("loading model into MLX backend", "model", s.modelPath)
+	var req struct {
+		Adapters   []llm.MLXAdapter `json:"adapters"`
+		DraftModel string           `json:"draft_model"`
+		DraftPort  int              `json:"draft_port"`
+	}
+	if len(body) > 0 {
+		_ = json.Unmarshal(body, &req)
+	}
+
+	model := modelPathFromRequestBody(body, s.defaultModel)
+	if model == "" {
+		http.Error(w, "no model specified and no default model configured", http.StatusBadRequest)
+		return
+	}
 
-	// Send load request to MLX backend using model name
-	loadReq := map[string]string{"model": s.modelPath}
-	reqBody, err := json.Marshal(loadReq)
+	slog.Info("loading model into MLX backend", "model", model, "adapters", len(req.Adapters), "draft_model", req.DraftModel)
+
+	session, err := s.registry.getOrStart(r.Context(), model, req.Adapters, req.DraftModel, req.DraftPort)
 	if err != nil {
-		slog.Error("failed to marshal MLX load request", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.Error("failed to load model", "model", model, "error", err)
+		writeSessionError(w, err)
+		return
+	}
+	if err := session.waitReady(r.Context()); err != nil {
+		writeSessionError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(llm.LoadResponse{})
+}
+
+// unload handles POST /unload, stopping a loaded model's backend subprocess
+// and freeing its slot in the registry.
+func (s *Server) unload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	mlxURL := fmt.Sprintf("http://127.0.0.1:%d/load", s.mlxPort)
-	resp, err := s.mlxClient.Post(mlxURL, "application/json", bytes.NewReader(reqBody))
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		slog.Error("failed to load model in MLX backend", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			slog.Error("failed to read MLX backend load response", "error", err)
-			http.Error(w, "failed to read backend response", http.StatusInternalServerError)
-			return
-		}
+	model := modelPathFromRequestBody(body, s.defaultModel)
+	if model == "" {
+		http.Error(w, "no model specified and no default model configured", http.StatusBadRequest)
+		return
+	}
 
-		slog.Error("MLX backend load failed", "status", resp.StatusCode, "body", string(body))
-		http.Error(w, string(body), resp.StatusCode)
+	if !s.registry.unload(model) {
+		http.Error(w, fmt.Sprintf("model %q is not loaded", model), http.StatusNotFound)
 		return
 	}
 
-	s.mu.Lock()
-	s.status = llm.ServerStatusReady
-	s.mu.Unlock()
-	s.ready.Done()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "unloaded", "model": model})
+}
 
+// ps handles GET /ps, listing every currently loaded model alongside its
+// resident memory usage and uptime.
+func (s *Server) ps(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(llm.LoadResponse{})
+	json.NewEncoder(w).Encode(map[string]any{"models": s.registry.list()})
 }
 
-// completion handles the /completion endpoint - proxies to MLX backend
+// completion handles the /completion endpoint - proxies to the MLX backend
+// for whichever model the request body names.
 func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
-	s.ready.Wait()
-
-	// Read the completion request
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Forward to MLX backend
-	mlxURL := fmt.Sprintf("http://127.0.0.1:%d/completion", s.mlxPort)
-	resp, err := s.mlxClient.Post(mlxURL, "application/json", bytes.NewReader(body))
+	session, err := s.sessionFor(r.Context(), body, nil)
 	if err != nil {
-		slog.Error("failed to forward completion to MLX backend", "error", err)
+		writeSessionError(w, err)
+		return
+	}
+
+	resp, err := session.client.Post(fmt.Sprintf("http://127.0.0.1:%d/completion", session.port), "application/json", bytes.NewReader(body))
+	if err != nil {
+		slog.Error("failed to forward completion to MLX backend", "model", session.modelPath, "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	defer resp.Body.Close()
 
-	// Stream the response back
 	w.Header().Set("Content-Type", "application/x-ndjson")
 	w.WriteHeader(resp.StatusCode)
 
-	// Copy the streaming response
 	if _, err := io.Copy(w, resp.Body); err != nil {
 		slog.Error("error streaming response", "error", err)
 	}
@@ -278,19 +358,21 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 
 // embeddings handles the /embedding endpoint
 func (s *Server) embeddings(w http.ResponseWriter, r *http.Request) {
-	s.ready.Wait()
-
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Forward to MLX backend
-	mlxURL := fmt.Sprintf("http://127.0.0.1:%d/embedding", s.mlxPort)
-	resp, err := s.mlxClient.Post(mlxURL, "application/json", bytes.NewReader(body))
+	session, err := s.sessionFor(r.Context(), body, nil)
 	if err != nil {
-		slog.Error("failed to get embeddings from MLX backend", "error", err)
+		writeSessionError(w, err)
+		return
+	}
+
+	resp, err := session.client.Post(fmt.Sprintf("http://127.0.0.1:%d/embedding", session.port), "application/json", bytes.NewReader(body))
+	if err != nil {
+		slog.Error("failed to get embeddings from MLX backend", "model", session.modelPath, "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -301,24 +383,84 @@ func (s *Server) embeddings(w http.ResponseWriter, r *http.Request) {
 	io.Copy(w, resp.Body)
 }
 
-// health handles the /health endpoint
-func (s *Server) health(w http.ResponseWriter, r *http.Request) {
-	// Check both our health and MLX backend health
-	mlxURL := fmt.Sprintf("http://127.0.0.1:%d/health", s.mlxPort)
-	resp, err := s.mlxClient.Get(mlxURL)
+// rerank handles the /rerank endpoint - proxies a query plus a batch of
+// candidate documents to a cross-encoder MLX backend the same way
+// embeddings proxies to an embedding backend.
+func (s *Server) rerank(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	session, err := s.sessionFor(r.Context(), body, nil)
 	if err != nil {
-		http.Error(w, "MLX backend unhealthy", http.StatusServiceUnavailable)
+		writeSessionError(w, err)
+		return
+	}
+
+	resp, err := session.client.Post(fmt.Sprintf("http://127.0.0.1:%d/rerank", session.port), "application/json", bytes.NewReader(body))
+	if err != nil {
+		slog.Error("failed to get reranking scores from MLX backend", "model", session.modelPath, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		http.Error(w, "MLX backend unhealthy", http.StatusServiceUnavailable)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// writeSessionError writes a resolveSession/sessionFor error as an HTTP
+// response: 503 with a Retry-After header once a model's backend has
+// exceeded its restart budget (see backendUnavailableError), 500 otherwise.
+func writeSessionError(w http.ResponseWriter, err error) {
+	status, retryAfter := httpStatusForSessionError(err)
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// health handles the /health endpoint. With several backends potentially
+// loaded, there's no single subprocess to check by default; it reports the
+// runner process itself is serving, which is what the Ollama server polls
+// for during runner startup, plus ?model=<name>'s (or defaultModel's, if
+// set) supervision state: whether its backend is ready, how many times it
+// has restarted, and its last error. If that model's backend has exceeded
+// its restart budget, health responds 503 with Retry-After instead of 200.
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+	model := r.URL.Query().Get("model")
+	if model == "" {
+		model = s.defaultModel
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if model == "" {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(sessionHealth{Status: "ready"})
+		return
+	}
+
+	session, ok := s.registry.get(model)
+	if !ok {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(sessionHealth{Status: "not loaded"})
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("ok"))
+	health := session.snapshotHealth()
+	if unavailable, failed := session.permanentFailure(); failed {
+		if unavailable.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(unavailable.retryAfter.Seconds())))
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(health)
 }
 
 // Execute starts the MLX runner server
@@ -326,7 +468,10 @@ func Execute(args []string) error {
 	fs := flag.NewFlagSet("mlxrunner", flag.ExitOnError)
 	mpath := fs.String("model", "", "Path to model or model name")
 	port := fs.Int("port", 8080, "Port to expose the server on")
-	pythonPath := fs.String("python", "python3", "Path to Python executable")
+	pythonPath := fs.String("python", "", "Path to Python executable (default: auto-discover via $OLLMLX_PYTHON, .venv, uv, conda, then python3 on PATH)")
+	maxLoaded := fs.Int("max-loaded", 1, "Maximum number of models to keep loaded concurrently (0 = unlimited)")
+	maxMemory := fs.String("max-memory", "", "Maximum combined resident memory for loaded models, e.g. 48GiB (empty = unlimited)")
+	enablePprof := fs.Bool("enable-pprof", false, "Expose the net/http/pprof debug tree at /debug/pprof")
 	_ = fs.Bool("verbose", false, "verbose output (default: disabled)")
 
 	fs.Usage = func() {
@@ -341,28 +486,25 @@ func Execute(args []string) error {
 	slog.SetDefault(logutil.NewLogger(os.Stderr, envconfig.LogLevel()))
 	slog.Info("starting MLX runner")
 
-	server := &Server{
-		modelPath:  *mpath,
-		status:     llm.ServerStatusLaunched,
-		mlxClient:  &http.Client{Timeout: 5 * time.Minute},
-		pythonPath: *pythonPath,
+	maxMemoryBytes, err := parseMemorySize(*maxMemory)
+	if err != nil {
+		return err
 	}
 
-	server.ready.Add(1)
-	server.cond = sync.NewCond(&server.mu)
+	registry := NewModelRegistry(*maxLoaded, maxMemoryBytes, *pythonPath)
+	server := &Server{registry: registry, defaultModel: *mpath, metrics: newMetricsRegistry()}
+	registry.SetMetrics(server.metrics)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Start the MLX backend
-	if err := server.startMLXBackend(ctx); err != nil {
-		return fmt.Errorf("failed to start MLX backend: %w", err)
-	}
-	defer func() {
-		if server.mlxCmd != nil && server.mlxCmd.Process != nil {
-			server.mlxCmd.Process.Kill()
+	// Preload the model passed on the command line, for parity with callers
+	// that still expect a model to be warm as soon as the runner is healthy.
+	if *mpath != "" {
+		if _, err := registry.getOrStart(ctx, *mpath, nil, "", 0); err != nil {
+			return fmt.Errorf("failed to preload model %q: %w", *mpath, err)
 		}
-	}()
+	}
 
 	// Start the HTTP server
 	addr := "127.0.0.1:" + strconv.Itoa(*port)
@@ -374,17 +516,62 @@ func Execute(args []string) error {
 	defer listener.Close()
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST /load", server.load)
-	mux.HandleFunc("/embedding", server.embeddings)
-	mux.HandleFunc("/completion", server.completion)
-	mux.HandleFunc("/health", server.health)
+	route := func(pattern string, h http.HandlerFunc) {
+		mux.HandleFunc(pattern, withMetrics(routeLabel(pattern), server.metrics, h))
+	}
+	route("POST /load", server.load)
+	route("POST /unload", server.unload)
+	route("GET /ps", server.ps)
+	route("/embedding", server.embeddings)
+	route("/rerank", server.rerank)
+	route("/completion", server.completion)
+	route("/health", server.health)
+
+	// OpenAI-compatible surface, so the runner is a drop-in backend for
+	// clients like LangChain, LiteLLM, and OpenWebUI.
+	route("POST /v1/chat/completions", server.handleChatCompletions)
+	route("POST /v1/completions", server.handleCompletions)
+	route("POST /v1/embeddings", server.handleEmbeddings)
+	route("GET /v1/models", server.handleModels)
+
+	// Persistent WebSocket streaming, so a client can hold one connection
+	// across many turns instead of paying reconnect/TLS setup on each one.
+	route("/ws", server.handleWS)
+	route("/v1/realtime", server.handleWS)
+
+	// Control-plane connection the server holds open to this runner for
+	// state reconciliation (see control_plane.go).
+	route("/control-plane", server.handleControlPlane)
+
+	// Metrics aren't wrapped by withMetrics themselves, to avoid a scrape
+	// inflating mlxrunner_requests_total for every other route.
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		server.metrics.writeExposition(w, server.registry)
+	})
+	if *enablePprof {
+		registerPprof(mux)
+	}
 
 	httpServer := http.Server{
 		Handler: mux,
 	}
 
+	// Gracefully tear down every loaded model's backend subprocess on
+	// SIGINT/SIGTERM rather than leaving them orphaned.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		slog.Info("shutting down MLX runner", "signal", sig)
+		cancel()
+		registry.shutdown()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), gracefulShutdownTimeout)
+		defer shutdownCancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
 	log.Println("MLX Runner server listening on", addr)
-	if err := httpServer.Serve(listener); err != nil {
+	if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
 		slog.Error("server error", "error", err)
 		return err
 	}
@@ -0,0 +1,351 @@
+package mlxrunner
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// This file adds a hand-rolled WebSocket endpoint (RFC 6455) to
+// mlxrunner.Server, registered at /ws and /v1/realtime, so chat UIs can hold
+// one persistent connection instead of paying HTTP/TLS setup cost on every
+// turn. There's no WebSocket dependency available in this tree, so the
+// handshake and frame (de)serialization are implemented directly; only what
+// this endpoint needs is supported (single-frame text messages, no
+// fragmentation or compression), not the full protocol surface.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// wsGenerateRequest is a client->server frame requesting a generation.
+// Multiple of these can be in flight at once on one socket, distinguished by
+// ID.
+type wsGenerateRequest struct {
+	Type        string   `json:"type"` // "generate"
+	ID          string   `json:"id"`
+	Model       string   `json:"model"`
+	Prompt      string   `json:"prompt"`
+	Temperature *float64 `json:"temperature"`
+	TopP        *float64 `json:"top_p"`
+	MaxTokens   *int     `json:"max_tokens"`
+	Stop        any      `json:"stop"`
+}
+
+// wsCancelRequest is a client->server frame aborting an in-flight generation.
+type wsCancelRequest struct {
+	Type string `json:"type"` // "cancel"
+	ID   string `json:"id"`
+}
+
+// wsTokenFrame, wsDoneFrame, and wsErrorFrame are the server->client frame
+// shapes, all tagged by "type" and the request "id" they belong to so a
+// client can demultiplex several in-flight generations on one socket.
+type wsTokenFrame struct {
+	Type string `json:"type"` // "token"
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+type wsDoneFrame struct {
+	Type  string      `json:"type"` // "done"
+	ID    string      `json:"id"`
+	Usage openAIUsage `json:"usage"`
+}
+
+type wsErrorFrame struct {
+	Type  string `json:"type"` // "error"
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+// wsConn wraps a hijacked connection with the write lock WebSocket frames
+// need, since several generate goroutines share one socket.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	mu   sync.Mutex
+}
+
+func (c *wsConn) writeJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsOpText, data)
+}
+
+// writeFrame writes a single, unmasked, unfragmented server->client frame.
+// RFC 6455 forbids servers from masking their frames.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var header []byte
+	header = append(header, 0x80|opcode) // FIN=1, opcode
+
+	n := len(payload)
+	switch {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		var ext [2]byte
+		binary.BigEndian.PutUint16(ext[:], uint16(n))
+		header = append(header, ext[:]...)
+	default:
+		header = append(header, 127)
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		header = append(header, ext[:]...)
+	}
+
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// readFrame reads a single client->server frame and unmasks its payload
+// (RFC 6455 requires every client frame to be masked). Fragmented messages
+// aren't supported: callers get one (opcode, payload) per frame as sent.
+func (c *wsConn) readFrame() (opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return 0, nil, err
+	}
+
+	opcode = head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	length := int64(head[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	var maskKey [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, maskKey[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return 0, nil, err
+	}
+
+	if masked {
+		for i := range payload {
+			payload[i] ^= maskKey[i%4]
+		}
+	}
+
+	return opcode, payload, nil
+}
+
+// upgradeWebSocket performs the RFC 6455 handshake over a hijacked
+// connection and returns a wsConn ready to exchange frames.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return nil, fmt.Errorf("not a websocket upgrade request")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, fmt.Errorf("missing Sec-WebSocket-Key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, fmt.Errorf("connection does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	accept := computeWebSocketAccept(key)
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + accept + "\r\n\r\n"
+	if _, err := rw.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &wsConn{conn: conn, br: rw.Reader}, nil
+}
+
+func computeWebSocketAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// handleWS handles both /ws and /v1/realtime: after the handshake, it reads
+// generate/cancel frames from the client and streams token/done/error frames
+// back, fanning out an arbitrary number of concurrent generations (keyed by
+// the client-supplied id) over the one connection.
+func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer conn.conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	var mu sync.Mutex
+	inFlight := make(map[string]context.CancelFunc)
+
+	for {
+		opcode, payload, err := conn.readFrame()
+		if err != nil {
+			return
+		}
+
+		switch opcode {
+		case wsOpClose:
+			conn.writeFrame(wsOpClose, nil)
+			return
+		case wsOpPing:
+			conn.writeFrame(wsOpPong, payload)
+			continue
+		case wsOpPong, wsOpContinuation:
+			continue
+		case wsOpText:
+			// fall through to dispatch below
+		default:
+			continue
+		}
+
+		var kind struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(payload, &kind); err != nil {
+			conn.writeJSON(wsErrorFrame{Type: "error", Error: "malformed frame: " + err.Error()})
+			continue
+		}
+
+		switch kind.Type {
+		case "generate":
+			var req wsGenerateRequest
+			if err := json.Unmarshal(payload, &req); err != nil {
+				conn.writeJSON(wsErrorFrame{Type: "error", Error: "malformed generate frame: " + err.Error()})
+				continue
+			}
+
+			genCtx, genCancel := context.WithCancel(ctx)
+			mu.Lock()
+			inFlight[req.ID] = genCancel
+			mu.Unlock()
+
+			go func() {
+				defer func() {
+					mu.Lock()
+					delete(inFlight, req.ID)
+					mu.Unlock()
+					genCancel()
+				}()
+				s.runWSGeneration(genCtx, conn, req)
+			}()
+
+		case "cancel":
+			var req wsCancelRequest
+			if err := json.Unmarshal(payload, &req); err != nil {
+				continue
+			}
+			mu.Lock()
+			if genCancel, ok := inFlight[req.ID]; ok {
+				genCancel()
+			}
+			mu.Unlock()
+
+		default:
+			conn.writeJSON(wsErrorFrame{Type: "error", Error: "unknown frame type: " + kind.Type})
+		}
+	}
+}
+
+// runWSGeneration resolves req's model session and streams its completion as
+// token/done frames, or a single error frame on failure. Canceling ctx (via
+// a "cancel" message) aborts the upstream HTTP request to the Python
+// backend, which is what actually stops generation.
+func (s *Server) runWSGeneration(ctx context.Context, conn *wsConn, req wsGenerateRequest) {
+	session, err := s.resolveSession(ctx, req.Model, nil)
+	if err != nil {
+		conn.writeJSON(wsErrorFrame{Type: "error", ID: req.ID, Error: err.Error()})
+		return
+	}
+
+	options := openAISamplerOptions(req.Temperature, req.TopP, req.MaxTokens, req.Stop)
+
+	timer := s.metrics.startGeneration("/ws")
+	defer timer.done()
+
+	var last completionChunk
+	err = session.completionChunks(ctx, req.Prompt, options, func(c completionChunk) error {
+		timer.onToken()
+		last = c
+		if c.Content != "" {
+			if err := conn.writeJSON(wsTokenFrame{Type: "token", ID: req.ID, Text: c.Content}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			slog.Info("websocket generation canceled", "id", req.ID)
+			return
+		}
+		conn.writeJSON(wsErrorFrame{Type: "error", ID: req.ID, Error: err.Error()})
+		return
+	}
+
+	s.metrics.recordTokens(req.Model, last.PromptEvalCount, last.EvalCount)
+	conn.writeJSON(wsDoneFrame{
+		Type: "done",
+		ID:   req.ID,
+		Usage: openAIUsage{
+			PromptTokens:     last.PromptEvalCount,
+			CompletionTokens: last.EvalCount,
+			TotalTokens:      last.PromptEvalCount + last.EvalCount,
+		},
+	})
+}
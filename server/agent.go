@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+)
+
+// agentConfig is a named bundle of defaults selectable per-request via
+// api.ChatRequest's Agent field: a system prompt override (replacing the
+// hard-coded "You are a helpful assistant." every format*Prompt used to
+// carry), the subset of tools that agent may call, and a default model to
+// fall back to when a request names this agent but no model. Agents are
+// configured in a JSON file pointed to by OLLAMA_AGENTS_CONFIG, keyed by
+// agent name, the same "env var names a config file" convention
+// OLLAMA_MCP_CONFIG uses for MCP servers.
+type agentConfig struct {
+	SystemPrompt string   `json:"system_prompt"`
+	AllowedTools []string `json:"allowed_tools"`
+	Model        string   `json:"model"`
+}
+
+type agentRegistry struct {
+	agents map[string]agentConfig
+}
+
+// lookup returns name's agentConfig, or ok=false when name is empty or
+// isn't configured.
+func (r *agentRegistry) lookup(name string) (agentConfig, bool) {
+	if name == "" {
+		return agentConfig{}, false
+	}
+	cfg, ok := r.agents[name]
+	return cfg, ok
+}
+
+// allowsTool reports whether cfg permits calling toolName. An agent with no
+// AllowedTools declared at all permits every tool the request otherwise has
+// access to, rather than none.
+func (cfg agentConfig) allowsTool(toolName string) bool {
+	if len(cfg.AllowedTools) == 0 {
+		return true
+	}
+	for _, name := range cfg.AllowedTools {
+		if name == toolName {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	agentRegistryOnce sync.Once
+	agentRegistryInst *agentRegistry
+)
+
+// getAgentRegistry lazily loads OLLAMA_AGENTS_CONFIG on first use and
+// reuses it for the life of the process, the same pattern getMCPRegistry
+// uses for OLLAMA_MCP_CONFIG. An unset variable (or one that fails to load)
+// leaves the registry empty rather than failing requests.
+func getAgentRegistry() *agentRegistry {
+	agentRegistryOnce.Do(func() {
+		agentRegistryInst = &agentRegistry{agents: map[string]agentConfig{}}
+
+		path := os.Getenv("OLLAMA_AGENTS_CONFIG")
+		if path == "" {
+			return
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Error("failed to read agents config", "path", path, "error", err)
+			return
+		}
+		if err := json.Unmarshal(data, &agentRegistryInst.agents); err != nil {
+			slog.Error("failed to parse agents config", "path", path, "error", err)
+		}
+	})
+	return agentRegistryInst
+}
+
+// filterToolsForAgent drops every tool from tools that cfg's allowlist
+// doesn't permit, so a scoped agent never even advertises a disallowed tool
+// to the model.
+func filterToolsForAgent(tools api.Tools, cfg agentConfig) api.Tools {
+	if len(cfg.AllowedTools) == 0 {
+		return tools
+	}
+
+	out := tools[:0:0]
+	for _, t := range tools {
+		if cfg.allowsTool(t.Function.Name) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
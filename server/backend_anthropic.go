@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// anthropicBackend routes a model to the Anthropic Messages API,
+// translating between api.GenerateRequest/api.ToolCall and Anthropic's
+// server-sent event stream, including tool_use content blocks.
+type anthropicBackend struct {
+	model   string
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func newAnthropicBackend(model, baseURL string) *anthropicBackend {
+	if baseURL == "" {
+		baseURL = "https://api.anthropic.com/v1"
+	}
+	return &anthropicBackend{
+		model:   model,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  os.Getenv("ANTHROPIC_API_KEY"),
+		client:  &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+func (b *anthropicBackend) Stream(ctx context.Context, req *api.GenerateRequest) (completionStream, error) {
+	body, err := json.Marshal(map[string]any{
+		"model":      b.model,
+		"max_tokens": extractMaxTokens(req.Options, 4096),
+		"stream":     true,
+		"messages":   []map[string]string{{"role": "user", "content": req.Prompt}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, b.baseURL+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
+	if b.apiKey != "" {
+		httpReq.Header.Set("x-api-key", b.apiKey)
+	}
+
+	resp, err := b.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, &backendHTTPError{status: resp.StatusCode, message: strings.TrimSpace(string(msg))}
+	}
+
+	return newSSECompletionStream(resp.Body, newAnthropicEventParser()), nil
+}
+
+// anthropicBackend has no text-embedding endpoint.
+func (b *anthropicBackend) Embed(ctx context.Context, input []string, opts EmbedOptions) (*EmbedResult, error) {
+	return nil, fmt.Errorf("anthropic backend does not support embeddings")
+}
+
+// anthropicBackend has no reranking endpoint.
+func (b *anthropicBackend) Rerank(ctx context.Context, query string, documents []string) ([]RerankScore, error) {
+	return nil, fmt.Errorf("anthropic backend does not support reranking")
+}
+
+// anthropicBackend is stateless between requests: there's no local process
+// or keepalive cache entry to release.
+func (b *anthropicBackend) Close() {}
+
+// anthropicToolBlock accumulates one tool_use content block's id, name, and
+// incrementally-streamed JSON input across several content_block_delta
+// events before it's complete.
+type anthropicToolBlock struct {
+	id       string
+	name     string
+	argsJSON strings.Builder
+}
+
+// newAnthropicEventParser returns a parseEvent closure for
+// sseCompletionStream that turns Anthropic's Messages streaming protocol
+// (content_block_start/delta/stop, message_delta, message_stop) into the
+// common mlxStreamChunk currency, accumulating tool_use blocks by content
+// index and finalizing them into api.ToolCall on message_stop.
+func newAnthropicEventParser() func(event string, data []byte) (mlxStreamChunk, bool) {
+	toolBlocks := map[int]*anthropicToolBlock{}
+	var order []int
+	outputTokens := 0
+
+	return func(event string, data []byte) (mlxStreamChunk, bool) {
+		switch event {
+		case "content_block_start":
+			var evt struct {
+				Index        int `json:"index"`
+				ContentBlock struct {
+					Type string `json:"type"`
+					ID   string `json:"id"`
+					Name string `json:"name"`
+				} `json:"content_block"`
+			}
+			if err := json.Unmarshal(data, &evt); err != nil {
+				return mlxStreamChunk{}, false
+			}
+			if evt.ContentBlock.Type == "tool_use" {
+				toolBlocks[evt.Index] = &anthropicToolBlock{id: evt.ContentBlock.ID, name: evt.ContentBlock.Name}
+				order = append(order, evt.Index)
+			}
+			return mlxStreamChunk{}, false
+
+		case "content_block_delta":
+			var evt struct {
+				Index int `json:"index"`
+				Delta struct {
+					Type        string `json:"type"`
+					Text        string `json:"text"`
+					PartialJSON string `json:"partial_json"`
+				} `json:"delta"`
+			}
+			if err := json.Unmarshal(data, &evt); err != nil {
+				return mlxStreamChunk{}, false
+			}
+			switch evt.Delta.Type {
+			case "text_delta":
+				if evt.Delta.Text == "" {
+					return mlxStreamChunk{}, false
+				}
+				return mlxStreamChunk{Content: evt.Delta.Text}, true
+			case "input_json_delta":
+				if tb, ok := toolBlocks[evt.Index]; ok {
+					tb.argsJSON.WriteString(evt.Delta.PartialJSON)
+				}
+			}
+			return mlxStreamChunk{}, false
+
+		case "message_delta":
+			var evt struct {
+				Usage struct {
+					OutputTokens int `json:"output_tokens"`
+				} `json:"usage"`
+			}
+			if err := json.Unmarshal(data, &evt); err == nil {
+				outputTokens = evt.Usage.OutputTokens
+			}
+			return mlxStreamChunk{}, false
+
+		case "message_stop":
+			chunk := mlxStreamChunk{Done: true, DoneReason: "stop", EvalCount: outputTokens}
+			for _, idx := range order {
+				tb := toolBlocks[idx]
+				var toolArgs map[string]any
+				_ = json.Unmarshal([]byte(tb.argsJSON.String()), &toolArgs)
+				chunk.ToolCalls = append(chunk.ToolCalls, api.ToolCall{
+					Function: api.ToolCallFunction{Name: tb.name, Arguments: toolArgs},
+				})
+			}
+			return chunk, true
+
+		default:
+			return mlxStreamChunk{}, false
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// googleBackend routes a model to Google's Gemini API,
+// translating between api.GenerateRequest/api.ToolCall and Gemini's
+// streamGenerateContent SSE protocol, including functionCall parts.
+type googleBackend struct {
+	model   string
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func newGoogleBackend(model, baseURL string) *googleBackend {
+	if baseURL == "" {
+		baseURL = "https://generativelanguage.googleapis.com/v1beta"
+	}
+	return &googleBackend{
+		model:   model,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  os.Getenv("GOOGLE_API_KEY"),
+		client:  &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+func (b *googleBackend) Stream(ctx context.Context, req *api.GenerateRequest) (completionStream, error) {
+	body, err := json.Marshal(map[string]any{
+		"contents": []map[string]any{
+			{"role": "user", "parts": []map[string]string{{"text": req.Prompt}}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse&key=%s", b.baseURL, b.model, b.apiKey)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, &backendHTTPError{status: resp.StatusCode, message: strings.TrimSpace(string(msg))}
+	}
+
+	return newSSECompletionStream(resp.Body, newGoogleEventParser()), nil
+}
+
+// Embed batches input through Gemini's batchEmbedContents endpoint in one
+// request, ignoring opts.Pooling/Truncate: Gemini pools server-side and has
+// no truncation mode to select.
+func (b *googleBackend) Embed(ctx context.Context, input []string, opts EmbedOptions) (*EmbedResult, error) {
+	requests := make([]map[string]any, len(input))
+	for i, text := range input {
+		requests[i] = map[string]any{
+			"model":   "models/" + b.model,
+			"content": map[string]any{"parts": []map[string]string{{"text": text}}},
+		}
+	}
+
+	body, err := json.Marshal(map[string]any{"requests": requests})
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/models/%s:batchEmbedContents?key=%s", b.baseURL, b.model, b.apiKey)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, &backendHTTPError{status: resp.StatusCode, message: strings.TrimSpace(string(msg))}
+	}
+
+	var parsed struct {
+		Embeddings []struct {
+			Values []float32 `json:"values"`
+		} `json:"embeddings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("failed to decode embedding response: %w", err)
+	}
+
+	out := make([][]float32, len(parsed.Embeddings))
+	for i, e := range parsed.Embeddings {
+		out[i] = e.Values
+	}
+	return &EmbedResult{Embeddings: out}, nil
+}
+
+// googleBackend has no reranking endpoint.
+func (b *googleBackend) Rerank(ctx context.Context, query string, documents []string) ([]RerankScore, error) {
+	return nil, fmt.Errorf("google backend does not support reranking")
+}
+
+// googleBackend is stateless between requests: there's no local process or
+// keepalive cache entry to release.
+func (b *googleBackend) Close() {}
+
+// newGoogleEventParser returns a parseEvent closure for sseCompletionStream
+// that turns Gemini's streamGenerateContent SSE frames into the common
+// mlxStreamChunk currency. Unlike OpenAI's tool_calls, Gemini's functionCall
+// parts arrive whole in a single part rather than accumulated across
+// deltas, so each is turned into an api.ToolCall as soon as it's seen.
+func newGoogleEventParser() func(event string, data []byte) (mlxStreamChunk, bool) {
+	return func(_ string, data []byte) (mlxStreamChunk, bool) {
+		var raw struct {
+			Candidates []struct {
+				Content struct {
+					Parts []struct {
+						Text         string `json:"text"`
+						FunctionCall *struct {
+							Name string         `json:"name"`
+							Args map[string]any `json:"args"`
+						} `json:"functionCall"`
+					} `json:"parts"`
+				} `json:"content"`
+				FinishReason string `json:"finishReason"`
+			} `json:"candidates"`
+			UsageMetadata struct {
+				CandidatesTokenCount int `json:"candidatesTokenCount"`
+			} `json:"usageMetadata"`
+		}
+		if err := json.Unmarshal(data, &raw); err != nil || len(raw.Candidates) == 0 {
+			return mlxStreamChunk{}, false
+		}
+		candidate := raw.Candidates[0]
+
+		var content strings.Builder
+		var toolCalls []api.ToolCall
+		for _, part := range candidate.Content.Parts {
+			content.WriteString(part.Text)
+			if part.FunctionCall != nil {
+				toolCalls = append(toolCalls, api.ToolCall{
+					Function: api.ToolCallFunction{Name: part.FunctionCall.Name, Arguments: part.FunctionCall.Args},
+				})
+			}
+		}
+
+		if candidate.FinishReason == "" {
+			if content.Len() == 0 && len(toolCalls) == 0 {
+				return mlxStreamChunk{}, false
+			}
+			return mlxStreamChunk{Content: content.String(), ToolCalls: toolCalls}, true
+		}
+
+		return mlxStreamChunk{
+			Content:    content.String(),
+			ToolCalls:  toolCalls,
+			Done:       true,
+			DoneReason: strings.ToLower(candidate.FinishReason),
+			EvalCount:  raw.UsageMetadata.CandidatesTokenCount,
+		}, true
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// openAIBackend routes a model to an external OpenAI-compatible HTTP
+// endpoint (OpenAI itself, or a self-hosted LM Studio/vLLM/llama.cpp server
+// exposing the same /v1/chat/completions and /v1/embeddings surface),
+// translating between api.GenerateRequest/api.ToolCall and the wire format
+// those endpoints speak.
+type openAIBackend struct {
+	model   string
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func newOpenAIBackend(model, baseURL string) *openAIBackend {
+	if baseURL == "" {
+		baseURL = "https://api.openai.com/v1"
+	}
+	return &openAIBackend{
+		model:   model,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  os.Getenv("OPENAI_API_KEY"),
+		client:  &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+func (b *openAIBackend) Stream(ctx context.Context, req *api.GenerateRequest) (completionStream, error) {
+	body, err := json.Marshal(map[string]any{
+		"model":    b.model,
+		"stream":   true,
+		"messages": []map[string]string{{"role": "user", "content": req.Prompt}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, b.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if b.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+b.apiKey)
+	}
+
+	resp, err := b.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, &backendHTTPError{status: resp.StatusCode, message: strings.TrimSpace(string(msg))}
+	}
+
+	return newSSECompletionStream(resp.Body, newOpenAIEventParser()), nil
+}
+
+// Embed ignores opts.Pooling/Truncate: OpenAI's /v1/embeddings always
+// pools server-side and has no truncation mode to select.
+func (b *openAIBackend) Embed(ctx context.Context, input []string, opts EmbedOptions) (*EmbedResult, error) {
+	body, err := json.Marshal(map[string]any{"model": b.model, "input": input})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, b.baseURL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if b.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+b.apiKey)
+	}
+
+	resp, err := b.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, &backendHTTPError{status: resp.StatusCode, message: strings.TrimSpace(string(msg))}
+	}
+
+	var parsed struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+		Usage struct {
+			TotalTokens int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("failed to decode embedding response: %w", err)
+	}
+
+	out := make([][]float32, len(parsed.Data))
+	for i, d := range parsed.Data {
+		out[i] = d.Embedding
+	}
+	return &EmbedResult{Embeddings: out, PromptEvalCount: parsed.Usage.TotalTokens}, nil
+}
+
+// openAIBackend has no reranking endpoint.
+func (b *openAIBackend) Rerank(ctx context.Context, query string, documents []string) ([]RerankScore, error) {
+	return nil, fmt.Errorf("openai backend does not support reranking")
+}
+
+// openAIBackend is stateless between requests: there's no local process or
+// keepalive cache entry to release.
+func (b *openAIBackend) Close() {}
+
+// openAIChatChunk is one SSE data frame of an OpenAI-compatible
+// /v1/chat/completions streaming response.
+type openAIChatChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   string                `json:"content"`
+			ToolCalls []openAIToolCallDelta `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+// openAIToolCallDelta is one incremental fragment of a streamed tool call;
+// Function.Arguments accumulates across several deltas sharing the same
+// Index before the call is complete.
+type openAIToolCallDelta struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// newOpenAIEventParser returns a parseEvent closure for sseCompletionStream
+// that accumulates streamed tool-call argument fragments by index (OpenAI
+// splits a single tool call's JSON arguments across many deltas) and
+// finalizes them into api.ToolCall once finish_reason arrives.
+func newOpenAIEventParser() func(event string, data []byte) (mlxStreamChunk, bool) {
+	names := map[int]string{}
+	args := map[int]*strings.Builder{}
+	var order []int
+
+	return func(_ string, data []byte) (mlxStreamChunk, bool) {
+		if string(data) == "[DONE]" {
+			return mlxStreamChunk{}, false
+		}
+
+		var raw openAIChatChunk
+		if err := json.Unmarshal(data, &raw); err != nil || len(raw.Choices) == 0 {
+			return mlxStreamChunk{}, false
+		}
+		choice := raw.Choices[0]
+
+		for _, delta := range choice.Delta.ToolCalls {
+			if _, seen := args[delta.Index]; !seen {
+				args[delta.Index] = &strings.Builder{}
+				order = append(order, delta.Index)
+			}
+			if delta.Function.Name != "" {
+				names[delta.Index] = delta.Function.Name
+			}
+			args[delta.Index].WriteString(delta.Function.Arguments)
+		}
+
+		if choice.FinishReason == "" {
+			if choice.Delta.Content == "" {
+				return mlxStreamChunk{}, false
+			}
+			return mlxStreamChunk{Content: choice.Delta.Content}, true
+		}
+
+		chunk := mlxStreamChunk{Content: choice.Delta.Content, Done: true, DoneReason: choice.FinishReason}
+		for _, idx := range order {
+			var parsedArgs map[string]any
+			_ = json.Unmarshal([]byte(args[idx].String()), &parsedArgs)
+			chunk.ToolCalls = append(chunk.ToolCalls, api.ToolCall{
+				Function: api.ToolCallFunction{Name: names[idx], Arguments: parsedArgs},
+			})
+		}
+		return chunk, true
+	}
+}
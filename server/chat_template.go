@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/chattmpl"
+	"github.com/ollama/ollama/llm"
+)
+
+// mlxChatTemplateEntry is a model's parsed chat_template plus the bos/eos
+// tokens its tokenizer_config.json shipped alongside it, since real chat
+// templates reference both.
+type mlxChatTemplateEntry struct {
+	tmpl     *chattmpl.Template
+	bosToken string
+	eosToken string
+}
+
+// mlxChatTemplates caches the outcome of loading each model's
+// tokenizer_config.json, keyed by model name. A cached nil entry means the
+// model has no usable chat_template (missing file, unparseable JSON, or a
+// template this package's Jinja subset doesn't support), so
+// formatChatPromptWithModel doesn't re-read and re-parse it on every
+// request.
+var mlxChatTemplates = struct {
+	mu      sync.Mutex
+	entries map[string]*mlxChatTemplateEntry
+}{entries: make(map[string]*mlxChatTemplateEntry)}
+
+// loadMLXChatTemplate returns modelName's parsed chat template, loading and
+// caching it from tokenizer_config.json on first use. It returns nil if the
+// model ships no chat_template, or one this package can't render.
+func loadMLXChatTemplate(modelName string) *mlxChatTemplateEntry {
+	mlxChatTemplates.mu.Lock()
+	defer mlxChatTemplates.mu.Unlock()
+
+	if e, ok := mlxChatTemplates.entries[modelName]; ok {
+		return e
+	}
+
+	e := parseMLXChatTemplateFile(modelName)
+	mlxChatTemplates.entries[modelName] = e
+	return e
+}
+
+func parseMLXChatTemplateFile(modelName string) *mlxChatTemplateEntry {
+	path := filepath.Join(llm.NewMLXModelManager().GetModelPath(modelName), "tokenizer_config.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var cfg struct {
+		ChatTemplate json.RawMessage `json:"chat_template"`
+		BosToken     json.RawMessage `json:"bos_token"`
+		EosToken     json.RawMessage `json:"eos_token"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		slog.Warn("failed to parse tokenizer_config.json", "model", modelName, "error", err)
+		return nil
+	}
+
+	templateSrc, ok := decodeTokenizerString(cfg.ChatTemplate)
+	if !ok || templateSrc == "" {
+		return nil
+	}
+
+	tmpl, err := chattmpl.Parse(templateSrc)
+	if err != nil {
+		slog.Warn("model's chat_template isn't supported, falling back to built-in prompt formatter", "model", modelName, "error", err)
+		return nil
+	}
+
+	bos, _ := decodeTokenizerString(cfg.BosToken)
+	eos, _ := decodeTokenizerString(cfg.EosToken)
+	return &mlxChatTemplateEntry{tmpl: tmpl, bosToken: bos, eosToken: eos}
+}
+
+// decodeTokenizerString decodes a tokenizer_config.json field that HF
+// represents either as a plain string or as {"content": "...", ...} (the
+// form added_tokens use for bos_token/eos_token on some tokenizers).
+func decodeTokenizerString(raw json.RawMessage) (string, bool) {
+	if len(raw) == 0 {
+		return "", false
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, true
+	}
+	var obj struct {
+		Content string `json:"content"`
+	}
+	if err := json.Unmarshal(raw, &obj); err == nil {
+		return obj.Content, true
+	}
+	return "", false
+}
+
+// renderMLXChatTemplate renders entry's template against messages/tools. It
+// returns ok=false (rather than an error) on any render failure, since the
+// caller's only recourse is the same one it takes for a model with no
+// chat_template at all: fall back to the hard-coded per-family formatter.
+func renderMLXChatTemplate(entry *mlxChatTemplateEntry, messages []api.Message, tools api.Tools) (prompt string, ok bool) {
+	env := map[string]interface{}{
+		"messages":              toTemplateMessages(messages),
+		"tools":                 toTemplateTools(tools),
+		"add_generation_prompt": true,
+		"bos_token":             entry.bosToken,
+		"eos_token":             entry.eosToken,
+	}
+
+	rendered, err := entry.tmpl.Render(env)
+	if err != nil {
+		slog.Warn("chat template render failed, falling back to built-in prompt formatter", "error", err)
+		return "", false
+	}
+	return rendered, true
+}
+
+func toTemplateMessages(messages []api.Message) []interface{} {
+	out := make([]interface{}, len(messages))
+	for i, m := range messages {
+		out[i] = map[string]interface{}{
+			"role":    m.Role,
+			"content": m.Content,
+		}
+	}
+	return out
+}
+
+// toTemplateTools renders tools into the dict shape HF chat templates
+// expect (a list of {"type": "function", "function": {...}}), or nil (so
+// {% if tools %} is false) when there are none.
+func toTemplateTools(tools api.Tools) interface{} {
+	if len(tools) == 0 {
+		return nil
+	}
+
+	out := make([]interface{}, len(tools))
+	for i, t := range tools {
+		properties := make(map[string]interface{}, len(t.Function.Parameters.Properties))
+		for name := range t.Function.Parameters.Properties {
+			properties[name] = map[string]interface{}{}
+		}
+		out[i] = map[string]interface{}{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        t.Function.Name,
+				"description": t.Function.Description,
+				"parameters": map[string]interface{}{
+					"type":       "object",
+					"properties": properties,
+				},
+			},
+		}
+	}
+	return out
+}
@@ -0,0 +1,380 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const testControlPlaneWSGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// acceptTestControlPlaneHandshake reads conn's HTTP upgrade request and
+// writes the 101 response, returning a *bufio.Reader positioned right
+// after the request so the caller can keep reading frames off the same
+// buffered bytes rather than losing whatever else the client already sent.
+func acceptTestControlPlaneHandshake(t *testing.T, conn net.Conn) *bufio.Reader {
+	t.Helper()
+
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		t.Fatalf("read handshake request: %v", err)
+	}
+
+	key := req.Header.Get("Sec-WebSocket-Key")
+	h := sha1.New()
+	h.Write([]byte(key + testControlPlaneWSGUID))
+	accept := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + accept + "\r\n\r\n"
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		t.Fatalf("write handshake response: %v", err)
+	}
+
+	return br
+}
+
+// writeTestWSFrame writes a single unmasked, unfragmented server->client
+// text frame - the shape controlPlaneConn.readFrame expects a runner to
+// send.
+func writeTestWSFrame(conn net.Conn, payload []byte) error {
+	var header []byte
+	header = append(header, 0x80|0x1)
+
+	n := len(payload)
+	switch {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		var ext [2]byte
+		binary.BigEndian.PutUint16(ext[:], uint16(n))
+		header = append(header, ext[:]...)
+	default:
+		header = append(header, 127)
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		header = append(header, ext[:]...)
+	}
+
+	if _, err := conn.Write(header); err != nil {
+		return err
+	}
+	_, err := conn.Write(payload)
+	return err
+}
+
+// readTestClientFrame reads a single (masked) client->server frame, the
+// shape controlPlaneConn.writeFrame produces.
+func readTestClientFrame(t *testing.T, br *bufio.Reader) []byte {
+	t.Helper()
+
+	var head [2]byte
+	if _, err := readFullTest(br, head[:]); err != nil {
+		t.Fatalf("read frame header: %v", err)
+	}
+	length := int64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := readFullTest(br, ext[:]); err != nil {
+			t.Fatalf("read extended length: %v", err)
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := readFullTest(br, ext[:]); err != nil {
+			t.Fatalf("read extended length: %v", err)
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	var maskKey [4]byte
+	if _, err := readFullTest(br, maskKey[:]); err != nil {
+		t.Fatalf("read mask key: %v", err)
+	}
+
+	payload := make([]byte, length)
+	if _, err := readFullTest(br, payload); err != nil {
+		t.Fatalf("read payload: %v", err)
+	}
+	for i := range payload {
+		payload[i] ^= maskKey[i%4]
+	}
+	return payload
+}
+
+func readFullTest(br *bufio.Reader, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := br.Read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func newTestControlPlaneEntry(t *testing.T, port int) *mlxRunnerEntry {
+	t.Helper()
+	entry := &mlxRunnerEntry{
+		model:         "test-model",
+		port:          port,
+		ready:         make(chan struct{}),
+		controlSynced: make(chan struct{}),
+	}
+	close(entry.ready)
+	return entry
+}
+
+func TestRunControlPlaneSessionSyncsAndRecordsState(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := acceptTestControlPlaneHandshake(t, conn)
+		readTestClientFrame(t, br) // the initial "sync" frame
+
+		writeTestWSFrame(conn, []byte(`{"type":"state","loaded_models":["test-model"],"in_flight_requests":2}`))
+		time.Sleep(50 * time.Millisecond)
+	}()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	entry := newTestControlPlaneEntry(t, port)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := runControlPlaneSession(ctx, entry); err != nil && ctx.Err() == nil {
+		t.Fatalf("runControlPlaneSession() = %v", err)
+	}
+
+	entry.controlMu.Lock()
+	state := entry.lastState
+	entry.controlMu.Unlock()
+
+	if state == nil {
+		t.Fatal("expected lastState to be recorded")
+	}
+	if state.InFlightRequests != 2 {
+		t.Errorf("InFlightRequests = %d, want 2", state.InFlightRequests)
+	}
+	if len(state.LoadedModels) != 1 || state.LoadedModels[0] != "test-model" {
+		t.Errorf("LoadedModels = %v, want [test-model]", state.LoadedModels)
+	}
+
+	select {
+	case <-entry.controlSynced:
+	default:
+		t.Error("expected controlSynced to be closed after the first state frame")
+	}
+}
+
+func TestSuperviseControlPlaneReconnectsAndReplaysSync(t *testing.T) {
+	original := controlPlaneBackoffBase
+	controlPlaneBackoffBase = 50 * time.Millisecond
+	defer func() { controlPlaneBackoffBase = original }()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+
+	syncCount := make(chan struct{}, 4)
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			br := acceptTestControlPlaneHandshake(t, conn)
+			readTestClientFrame(t, br)
+			syncCount <- struct{}{}
+
+			writeTestWSFrame(conn, []byte(`{"type":"state","loaded_models":["test-model"]}`))
+			// First connection drops right away to force a reconnect;
+			// the second stays open for 500ms, then closes so the
+			// session's pending read returns after the test cancels ctx.
+			if i == 0 {
+				conn.Close()
+			} else {
+				<-time.After(500 * time.Millisecond)
+				conn.Close()
+			}
+		}
+	}()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	entry := newTestControlPlaneEntry(t, port)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		superviseControlPlane(ctx, entry)
+		close(done)
+	}()
+
+	select {
+	case <-syncCount:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for first sync")
+	}
+	select {
+	case <-syncCount:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for reconnect's replayed sync")
+	}
+
+	cancel()
+	<-done
+}
+
+func TestExecControlPlaneOpRoundTrips(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := acceptTestControlPlaneHandshake(t, conn)
+		readTestClientFrame(t, br) // the initial "sync" frame
+
+		execPayload := readTestClientFrame(t, br)
+		var exec cpExecFrame
+		if err := json.Unmarshal(execPayload, &exec); err != nil {
+			t.Errorf("failed to parse exec frame: %v", err)
+			return
+		}
+		if exec.Type != "exec" || exec.Op != "ping" {
+			t.Errorf("unexpected exec frame: %+v", exec)
+			return
+		}
+
+		result, _ := json.Marshal(cpExecResultFrame{Type: "exec_result", ID: exec.ID, Result: exec.Payload})
+		writeTestWSFrame(conn, result)
+
+		<-time.After(100 * time.Millisecond)
+	}()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	entry := newTestControlPlaneEntry(t, port)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	sessionDone := make(chan struct{})
+	go func() {
+		runControlPlaneSession(ctx, entry)
+		close(sessionDone)
+	}()
+
+	// Wait for the session to actually establish its connection before
+	// issuing the exec, the same handshake waitForControlPlaneSync gates
+	// request handling on elsewhere.
+	deadline := time.Now().Add(time.Second)
+	for {
+		entry.controlMu.Lock()
+		conn := entry.controlConn
+		entry.controlMu.Unlock()
+		if conn != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for control-plane connection to establish")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	result, err := execControlPlaneOp(ctx, entry, "ping", map[string]string{"hello": "world"})
+	if err != nil {
+		t.Fatalf("execControlPlaneOp() error = %v", err)
+	}
+
+	var got map[string]string
+	if err := json.Unmarshal(result, &got); err != nil {
+		t.Fatalf("failed to parse exec result: %v", err)
+	}
+	if got["hello"] != "world" {
+		t.Errorf("exec result = %v, want {hello: world}", got)
+	}
+
+	cancel()
+	<-sessionDone
+}
+
+func TestExecControlPlaneOpFailsWithoutAConnection(t *testing.T) {
+	entry := &mlxRunnerEntry{model: "test-model"}
+
+	_, err := execControlPlaneOp(context.Background(), entry, "ping", nil)
+	if err == nil {
+		t.Fatal("expected an error with no control-plane connection established")
+	}
+}
+
+func TestWaitForControlPlaneSyncTimesOutOnAStalledRunner(t *testing.T) {
+	original := controlPlaneSyncTimeout
+	controlPlaneSyncTimeout = 50 * time.Millisecond
+	defer func() { controlPlaneSyncTimeout = original }()
+
+	// A listener that accepts but never completes the handshake, modeling
+	// a runner whose control plane never responds.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			<-time.After(time.Second)
+		}
+	}()
+
+	entry := newTestControlPlaneEntry(t, listener.Addr().(*net.TCPAddr).Port)
+
+	start := time.Now()
+	waitForControlPlaneSync(context.Background(), entry)
+	elapsed := time.Since(start)
+
+	if elapsed > time.Second {
+		t.Errorf("waitForControlPlaneSync took %v, want it to give up around controlPlaneSyncTimeout", elapsed)
+	}
+	select {
+	case <-entry.controlSynced:
+		t.Error("expected controlSynced to remain open when the runner never responds")
+	default:
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListMLXConversations serves GET /api/conversations: every conversation_id
+// that has at least one persisted message, newest first.
+func (s *Server) ListMLXConversations(c *gin.Context) {
+	ids, err := listMLXConversations()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"conversations": ids})
+}
+
+// MLXConversationTree serves GET /api/conversations/:id/tree: the full
+// branch tree (every root-to-leaf path) of a conversation, so a client can
+// render a branch-and-edit UI without walking parent_id pointers itself.
+func (s *Server) MLXConversationTree(c *gin.Context) {
+	conversationID := c.Param("id")
+	if !isValidConversationRef(conversationID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+		return
+	}
+
+	roots, err := mlxConversations.tree(conversationID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"conversation_id": conversationID, "roots": roots})
+}
+
+// branchRequest is the body POST /api/conversations/:id/branch and
+// .../edit accept.
+type branchRequest struct {
+	ParentMessageID string `json:"parent_message_id"`
+	MessageID       string `json:"message_id"`
+	Role            string `json:"role"`
+	Content         string `json:"content"`
+	Model           string `json:"model"`
+}
+
+// BranchMLXConversation serves POST /api/conversations/:id/branch: appends
+// a new message under parent_message_id, diverging from any existing
+// children it already has, and returns the new node. Generating an actual
+// model response for the new branch is a separate call to the regular chat
+// endpoint with this message's id as parent_message_id.
+func (s *Server) BranchMLXConversation(c *gin.Context) {
+	conversationID := c.Param("id")
+	if !isValidConversationRef(conversationID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+		return
+	}
+
+	var req branchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Role == "" || req.Content == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role and content are required"})
+		return
+	}
+	if req.ParentMessageID != "" && !isValidConversationRef(req.ParentMessageID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parent_message_id"})
+		return
+	}
+
+	saved, err := mlxConversations.append(mlxConversationMessage{
+		ConversationID: conversationID,
+		ParentID:       req.ParentMessageID,
+		Role:           req.Role,
+		Content:        req.Content,
+		Model:          req.Model,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, saved)
+}
+
+// EditMLXConversationMessage serves POST /api/conversations/:id/edit: edits
+// never mutate a message in place (every branch descending from it is still
+// valid history), so this creates and returns a new sibling instead.
+func (s *Server) EditMLXConversationMessage(c *gin.Context) {
+	conversationID := c.Param("id")
+	if !isValidConversationRef(conversationID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+		return
+	}
+
+	var req branchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.MessageID == "" || !isValidConversationRef(req.MessageID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "message_id is required"})
+		return
+	}
+
+	edited, err := mlxConversations.edit(conversationID, req.MessageID, req.Content)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, edited)
+}
+
+// RegenerateMLXMessage serves POST /api/conversations/:id/regenerate:
+// resolves an existing assistant message's parent and hands it back, so the
+// caller can re-run the chat endpoint with that parent_message_id and get a
+// new sibling assistant branch instead of losing the original response.
+func (s *Server) RegenerateMLXMessage(c *gin.Context) {
+	conversationID := c.Param("id")
+	if !isValidConversationRef(conversationID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+		return
+	}
+
+	var req branchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.MessageID == "" || !isValidConversationRef(req.MessageID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "message_id is required"})
+		return
+	}
+
+	parentID, err := mlxConversations.regenerate(conversationID, req.MessageID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"parent_message_id": parentID})
+}
@@ -0,0 +1,410 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// mlxConversationMessage is one node in a branching chat conversation: a
+// single message with a pointer at its parent rather than a fixed position
+// in a linear transcript, so a conversation can fork (via branch/edit/
+// regenerate) without losing any prior branch.
+type mlxConversationMessage struct {
+	ConversationID string         `json:"conversation_id"`
+	MessageID      string         `json:"message_id"`
+	ParentID       string         `json:"parent_id,omitempty"`
+	Role           string         `json:"role"`
+	Content        string         `json:"content"`
+	ToolCalls      []api.ToolCall `json:"tool_calls,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	Model          string         `json:"model"`
+}
+
+// mlxConversationNode is mlxConversationMessage plus its children, used to
+// render a conversation's full branch tree for GET .../tree.
+type mlxConversationNode struct {
+	mlxConversationMessage
+	Children []*mlxConversationNode `json:"children,omitempty"`
+}
+
+// mlxConversationStore persists branching chat conversations as one JSON
+// file per message under <models>/mlx/conversations/<conversation_id>/
+// <message_id>.json, the same plain-files-on-disk convention the Modelfile
+// manifest store (llm/mlx_manifest.go) uses rather than pulling in a SQL or
+// BoltDB dependency for what's fundamentally a small, append-mostly log.
+// mu only serializes writes; reads list the directory directly.
+type mlxConversationStore struct {
+	mu sync.Mutex
+}
+
+func newMLXConversationStore() *mlxConversationStore {
+	return &mlxConversationStore{}
+}
+
+func mlxConversationsDir() string {
+	return filepath.Join(llm.NewMLXModelManager().GetModelsDir(), "mlx", "conversations")
+}
+
+// mlxConversationRefPattern is the exact shape newConversationRef generates:
+// 32 lowercase hex characters. conversation_id and message_id arrive from
+// request URLs and bodies, so every on-disk path built from either is
+// checked against this pattern first - without it, an id like
+// "../../../../etc" would let a request read or write files anywhere the
+// process can reach instead of staying under mlxConversationsDir.
+var mlxConversationRefPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func isValidConversationRef(id string) bool {
+	return mlxConversationRefPattern.MatchString(id)
+}
+
+func mlxConversationDir(conversationID string) (string, error) {
+	if !isValidConversationRef(conversationID) {
+		return "", fmt.Errorf("invalid conversation id %q", conversationID)
+	}
+	return filepath.Join(mlxConversationsDir(), conversationID), nil
+}
+
+func mlxMessagePath(conversationID, messageID string) (string, error) {
+	dir, err := mlxConversationDir(conversationID)
+	if err != nil {
+		return "", err
+	}
+	if !isValidConversationRef(messageID) {
+		return "", fmt.Errorf("invalid message id %q", messageID)
+	}
+	return filepath.Join(dir, messageID+".json"), nil
+}
+
+// newConversationRef generates a random hex identifier suitable for either a
+// conversation_id or a message_id.
+func newConversationRef() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand failing would mean the platform's CSPRNG is broken;
+		// fall back to a timestamp rather than panicking over an ID.
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// append saves msg as a new message, assigning ConversationID, MessageID,
+// and CreatedAt if they're unset, and returns the saved message.
+func (st *mlxConversationStore) append(msg mlxConversationMessage) (mlxConversationMessage, error) {
+	if msg.ConversationID == "" {
+		msg.ConversationID = newConversationRef()
+	}
+	if msg.MessageID == "" {
+		msg.MessageID = newConversationRef()
+	}
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now().UTC()
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	dir, err := mlxConversationDir(msg.ConversationID)
+	if err != nil {
+		return mlxConversationMessage{}, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return mlxConversationMessage{}, fmt.Errorf("failed to create conversation directory: %w", err)
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return mlxConversationMessage{}, fmt.Errorf("failed to marshal conversation message: %w", err)
+	}
+
+	path, err := mlxMessagePath(msg.ConversationID, msg.MessageID)
+	if err != nil {
+		return mlxConversationMessage{}, err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return mlxConversationMessage{}, fmt.Errorf("failed to write conversation message: %w", err)
+	}
+
+	return msg, nil
+}
+
+// get loads a single message by id.
+func (st *mlxConversationStore) get(conversationID, messageID string) (mlxConversationMessage, error) {
+	path, err := mlxMessagePath(conversationID, messageID)
+	if err != nil {
+		return mlxConversationMessage{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return mlxConversationMessage{}, err
+	}
+
+	var msg mlxConversationMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return mlxConversationMessage{}, fmt.Errorf("failed to parse conversation message %s: %w", messageID, err)
+	}
+	return msg, nil
+}
+
+// list returns every message in a conversation, oldest first.
+func (st *mlxConversationStore) list(conversationID string) ([]mlxConversationMessage, error) {
+	dir, err := mlxConversationDir(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	messages := make([]mlxConversationMessage, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		messageID := strings.TrimSuffix(entry.Name(), ".json")
+		msg, err := st.get(conversationID, messageID)
+		if err != nil {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+
+	sort.Slice(messages, func(i, j int) bool { return messages[i].CreatedAt.Before(messages[j].CreatedAt) })
+	return messages, nil
+}
+
+// listMLXConversations returns every conversation_id that has at least one
+// message stored, newest first by directory modification time.
+func listMLXConversations() ([]string, error) {
+	entries, err := os.ReadDir(mlxConversationsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	type dirInfo struct {
+		id      string
+		modTime time.Time
+	}
+	dirs := make([]dirInfo, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		dirs = append(dirs, dirInfo{id: entry.Name(), modTime: info.ModTime()})
+	}
+
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].modTime.After(dirs[j].modTime) })
+
+	ids := make([]string, len(dirs))
+	for i, d := range dirs {
+		ids[i] = d.id
+	}
+	return ids, nil
+}
+
+// ancestors walks parent_id pointers from messageID back to a root,
+// returning the chain root-first so it can be rendered directly as chat
+// history. An empty messageID (a fresh conversation with no parent yet)
+// returns an empty chain.
+func (st *mlxConversationStore) ancestors(conversationID, messageID string) ([]mlxConversationMessage, error) {
+	if messageID == "" {
+		return nil, nil
+	}
+
+	var chain []mlxConversationMessage
+	for messageID != "" {
+		msg, err := st.get(conversationID, messageID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load message %s: %w", messageID, err)
+		}
+		chain = append(chain, msg)
+		messageID = msg.ParentID
+	}
+
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain, nil
+}
+
+// tree builds every root-to-leaf branch of a conversation into a forest of
+// mlxConversationNode (almost always a single root, but nothing enforces
+// that a conversation_id has just one).
+func (st *mlxConversationStore) tree(conversationID string) ([]*mlxConversationNode, error) {
+	messages, err := st.list(conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string]*mlxConversationNode, len(messages))
+	for _, msg := range messages {
+		nodes[msg.MessageID] = &mlxConversationNode{mlxConversationMessage: msg}
+	}
+
+	var roots []*mlxConversationNode
+	for _, msg := range messages {
+		node := nodes[msg.MessageID]
+		if msg.ParentID == "" {
+			roots = append(roots, node)
+			continue
+		}
+		parent, ok := nodes[msg.ParentID]
+		if !ok {
+			// Parent missing (shouldn't happen outside manual tampering with
+			// the store): treat this node as a root rather than dropping it.
+			roots = append(roots, node)
+			continue
+		}
+		parent.Children = append(parent.Children, node)
+	}
+
+	return roots, nil
+}
+
+// edit creates a new sibling of messageID carrying newContent: branching
+// chat UIs never mutate a message in place, since every other branch
+// descending from it is still valid history.
+func (st *mlxConversationStore) edit(conversationID, messageID, newContent string) (mlxConversationMessage, error) {
+	original, err := st.get(conversationID, messageID)
+	if err != nil {
+		return mlxConversationMessage{}, fmt.Errorf("message %s not found: %w", messageID, err)
+	}
+
+	return st.append(mlxConversationMessage{
+		ConversationID: conversationID,
+		ParentID:       original.ParentID,
+		Role:           original.Role,
+		Content:        newContent,
+		Model:          original.Model,
+	})
+}
+
+// regenerate resolves the parent of an existing assistant message, so the
+// caller can re-run the chat endpoint with that parent as parent_message_id
+// and get back a new sibling assistant branch instead of losing the
+// original response.
+func (st *mlxConversationStore) regenerate(conversationID, assistantMessageID string) (string, error) {
+	msg, err := st.get(conversationID, assistantMessageID)
+	if err != nil {
+		return "", fmt.Errorf("message %s not found: %w", assistantMessageID, err)
+	}
+	if msg.Role != "assistant" {
+		return "", fmt.Errorf("message %s is not an assistant message", assistantMessageID)
+	}
+	return msg.ParentID, nil
+}
+
+// toAPIMessages renders a root-to-leaf ancestor chain as api.Message history
+// to prepend to a new chat request's own Messages.
+func toAPIMessages(chain []mlxConversationMessage) []api.Message {
+	messages := make([]api.Message, len(chain))
+	for i, msg := range chain {
+		messages[i] = api.Message{Role: msg.Role, Content: msg.Content, ToolCalls: msg.ToolCalls}
+	}
+	return messages
+}
+
+// longestCommonMessagePrefix returns how many leading messages a and b share
+// verbatim (same role and content). mlxRunnerEntry.lastMessages records the
+// message history of the most recent request a runner served; diffing a
+// sibling branch's history against it at this granularity approximates the
+// token-level longest-common-prefix a real KV-cache reuse hint would want,
+// without duplicating mlx_lm's tokenizer in Go just to compute one.
+func longestCommonMessagePrefix(a, b []api.Message) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	i := 0
+	for i < n && a[i].Role == b[i].Role && a[i].Content == b[i].Content {
+		i++
+	}
+	return i
+}
+
+var mlxConversations = newMLXConversationStore()
+
+// extractConversationRef pulls conversation_id/parent_message_id out of a
+// chat request's freeform Options map, the same convention
+// extractStopSequences uses for "stop". Both are optional: an empty
+// conversationID means the request isn't part of a persisted conversation
+// at all, and chatMLXModel skips the store entirely.
+func extractConversationRef(options map[string]interface{}) (conversationID, parentMessageID string) {
+	if v, ok := options["conversation_id"].(string); ok {
+		conversationID = v
+	}
+	if v, ok := options["parent_message_id"].(string); ok {
+		parentMessageID = v
+	}
+	return conversationID, parentMessageID
+}
+
+// persistMLXAssistantTurn saves a model's response as a new message under
+// parentID, once chatMLXModel has a final answer. It's a no-op when
+// conversationID is empty, i.e. the request wasn't part of a persisted
+// conversation. Failures are logged rather than surfaced to the client: the
+// response has already been generated, and losing the ability to branch
+// from it shouldn't fail an otherwise-successful request.
+func persistMLXAssistantTurn(conversationID, parentID, model, content string, toolCalls []api.ToolCall) {
+	if conversationID == "" {
+		return
+	}
+	if _, err := mlxConversations.append(mlxConversationMessage{
+		ConversationID: conversationID,
+		ParentID:       parentID,
+		Role:           "assistant",
+		Content:        content,
+		ToolCalls:      toolCalls,
+		Model:          model,
+	}); err != nil {
+		slog.Warn("failed to persist assistant message", "conversation_id", conversationID, "error", err)
+	}
+}
+
+// persistMLXHopMessage saves one intermediate message produced by the auto
+// tool-execution loop (see tool_auto_exec.go) - an assistant turn that only
+// emitted tool calls, or a tool role result - returning its MessageID so
+// the next message in the hop can chain off it. It's a no-op (returning
+// parentID unchanged) when conversationID is empty or the save fails, the
+// same failure handling persistMLXAssistantTurn uses.
+func persistMLXHopMessage(conversationID, parentID, model, role, content string, toolCalls []api.ToolCall) string {
+	if conversationID == "" {
+		return parentID
+	}
+	saved, err := mlxConversations.append(mlxConversationMessage{
+		ConversationID: conversationID,
+		ParentID:       parentID,
+		Role:           role,
+		Content:        content,
+		ToolCalls:      toolCalls,
+		Model:          model,
+	})
+	if err != nil {
+		slog.Warn("failed to persist auto tool-execution message", "conversation_id", conversationID, "role", role, "error", err)
+		return parentID
+	}
+	return saved.MessageID
+}
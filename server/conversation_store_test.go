@@ -0,0 +1,42 @@
+package server
+
+import "testing"
+
+func TestIsValidConversationRefRejectsPathTraversal(t *testing.T) {
+	valid := newConversationRef()
+	if !isValidConversationRef(valid) {
+		t.Errorf("isValidConversationRef(%q) = false, want true (shape newConversationRef itself generates)", valid)
+	}
+
+	for _, id := range []string{
+		"../../../../etc/passwd",
+		"..",
+		"foo/bar",
+		"foo\\bar",
+		"",
+		valid + "/../../escape",
+		valid[:31], // one character short
+	} {
+		if isValidConversationRef(id) {
+			t.Errorf("isValidConversationRef(%q) = true, want false", id)
+		}
+	}
+}
+
+func TestConversationStoreRejectsTraversalIDs(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	st := newMLXConversationStore()
+
+	if _, err := st.append(mlxConversationMessage{ConversationID: "../../../../tmp/evil", Role: "user", Content: "hi"}); err == nil {
+		t.Error("append() with a traversal conversation_id succeeded, want error")
+	}
+
+	if _, err := st.get("../../../../tmp/evil", newConversationRef()); err == nil {
+		t.Error("get() with a traversal conversation_id succeeded, want error")
+	}
+
+	valid := newConversationRef()
+	if _, err := st.get(valid, "../../../../tmp/evil"); err == nil {
+		t.Error("get() with a traversal message_id succeeded, want error")
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// builtinDraftModelDefaults pairs a model family substring (matched
+// case-insensitively against the full model name, so it catches both a bare
+// "qwen2.5-7b" and a full HuggingFace path like
+// "mlx-community/Qwen2.5-7B-Instruct-4bit") with the small sibling model
+// ollmlx spins up as its speculative decoding draft by default.
+var builtinDraftModelDefaults = map[string]string{
+	"qwen2.5-7b":    "qwen2.5-0.5b",
+	"qwen2.5-14b":   "qwen2.5-0.5b",
+	"qwen2.5-32b":   "qwen2.5-1.5b",
+	"qwen2.5-72b":   "qwen2.5-1.5b",
+	"llama-3.1-8b":  "llama-3.2-1b",
+	"llama-3.1-70b": "llama-3.2-1b",
+}
+
+// draftModelRegistry is the family -> draft model name table resolveDraftModel
+// consults once the request itself doesn't name a draft explicitly.
+type draftModelRegistry struct {
+	defaults map[string]string
+}
+
+var (
+	draftModelRegistryOnce sync.Once
+	draftModelRegistryInst *draftModelRegistry
+)
+
+// getDraftModelRegistry lazily loads OLLAMA_DRAFT_MODELS_CONFIG on first use,
+// the same env-var-names-a-config-file convention OLLAMA_MCP_CONFIG and
+// OLLAMA_AGENTS_CONFIG use, merging its entries over builtinDraftModelDefaults
+// so a user only needs to override the families they want to change. An unset
+// variable (or one that fails to load) leaves the built-in defaults in place.
+func getDraftModelRegistry() *draftModelRegistry {
+	draftModelRegistryOnce.Do(func() {
+		draftModelRegistryInst = &draftModelRegistry{defaults: map[string]string{}}
+		for family, draft := range builtinDraftModelDefaults {
+			draftModelRegistryInst.defaults[family] = draft
+		}
+
+		path := os.Getenv("OLLAMA_DRAFT_MODELS_CONFIG")
+		if path == "" {
+			return
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Error("failed to read draft models config", "path", path, "error", err)
+			return
+		}
+
+		var overrides map[string]string
+		if err := json.Unmarshal(data, &overrides); err != nil {
+			slog.Error("failed to parse draft models config", "path", path, "error", err)
+			return
+		}
+		for family, draft := range overrides {
+			draftModelRegistryInst.defaults[family] = draft
+		}
+	})
+	return draftModelRegistryInst
+}
+
+// resolveDraftModel decides which (if any) smaller model should run as
+// model's speculative decoding draft. A "draft_model" request option always
+// wins, letting a client pin an exact pairing; otherwise the draft model
+// registry is checked for a family whose substring appears in model's name.
+// Returns "" - no speculative decoding - when neither names one, which is the
+// common case for a model with no configured or requested draft.
+func resolveDraftModel(model string, options map[string]interface{}) string {
+	if raw, ok := options["draft_model"]; ok {
+		if s, ok := raw.(string); ok && s != "" {
+			return s
+		}
+	}
+
+	lower := strings.ToLower(model)
+	for family, draft := range getDraftModelRegistry().defaults {
+		if strings.Contains(lower, family) {
+			return draft
+		}
+	}
+	return ""
+}
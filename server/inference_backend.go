@@ -0,0 +1,496 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// InferenceBackend is the common interface every inference engine
+// implementation speaks: the locally-spawned MLX runner (mlxBackend), an
+// OpenAI-compatible HTTP endpoint (openAIBackend, for OpenAI itself or a
+// self-hosted LM Studio/vLLM/llama.cpp server), the Anthropic Messages
+// API (anthropicBackend), and Google's API (googleBackend). generateMLXModel, chatMLXModel, and
+// EmbedMLXModel go through this interface via getBackend instead of talking
+// to the MLX runner pool directly, so they don't need to know which
+// implementation is actually serving a given model.
+type InferenceBackend interface {
+	// Stream opens a streaming completion for req and returns a
+	// completionStream to iterate, or an error (a *backendHTTPError if the
+	// upstream responded with a non-2xx status) if the request could not
+	// even be started.
+	Stream(ctx context.Context, req *api.GenerateRequest) (completionStream, error)
+	// Embed returns one embedding vector per entry in input, batched into a
+	// single backend call, honoring opts's pooling/truncation knobs where
+	// the backend supports them.
+	Embed(ctx context.Context, input []string, opts EmbedOptions) (*EmbedResult, error)
+	// Rerank scores every entry in documents against query, for a
+	// cross-encoder model. Backends with no reranking endpoint (OpenAI,
+	// Anthropic) return an error.
+	Rerank(ctx context.Context, query string, documents []string) ([]RerankScore, error)
+	// Close releases any per-request bookkeeping the backend holds, e.g.
+	// the MLX runner pool's keepalive/eviction accounting.
+	Close()
+}
+
+// completionStream iterates the chunks of one in-flight completion, in the
+// same mlxStreamChunk currency the native MLX protocol uses, regardless of
+// which backend produced them.
+type completionStream interface {
+	// Next returns the next chunk, ok=false once the stream is exhausted
+	// (the chunk with Done=true is still returned with ok=true), or an
+	// error.
+	Next() (mlxStreamChunk, bool, error)
+	// Abort notifies the backend that the caller gave up (e.g. the client
+	// disconnected), best-effort.
+	Abort()
+}
+
+// backendHTTPError is returned by Stream/Embed when the backend's upstream
+// responds with a non-2xx status, so callers can surface that same status
+// instead of collapsing every backend failure to 500.
+type backendHTTPError struct {
+	status  int
+	message string
+}
+
+// EmbedOptions are the pooling/truncation knobs an embedding request can
+// set, extracted from api.EmbedRequest.Options by extractEmbedOptions.
+// Normalize is applied by EmbedMLXModel itself once a backend returns, not
+// by Embed, so every backend gets it for free regardless of whether it
+// understands Pooling/Truncate.
+type EmbedOptions struct {
+	Pooling  string // "mean" (default), "cls", or "last_token"
+	Truncate string // "end" (default), "start", or "none"
+}
+
+// EmbedResult is one batch Embed call's output: one vector per input, plus
+// how many prompt tokens the batch consumed in total.
+type EmbedResult struct {
+	Embeddings      [][]float32
+	PromptEvalCount int
+}
+
+// RerankScore is one document's relevance score from a Rerank call, indexed
+// into the original documents slice so the caller can sort without losing
+// track of which document a score belongs to.
+type RerankScore struct {
+	Index          int
+	RelevanceScore float64
+}
+
+func (e *backendHTTPError) Error() string {
+	return e.message
+}
+
+// backendEnvVar is the environment variable name getBackend checks to
+// decide which implementation serves model, e.g. "llama3" becomes
+// OLLAMA_BACKEND_LLAMA3.
+func backendEnvVar(model string) string {
+	var sb strings.Builder
+	sb.WriteString("OLLAMA_BACKEND_")
+	for _, r := range strings.ToUpper(model) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
+
+// getBackend resolves which InferenceBackend implementation should serve
+// model. A model name prefixed with a provider tag ("mlx:", "openai:",
+// "anthropic:", or "google:", e.g. "anthropic:claude-3-opus") is routed by
+// splitProviderPrefix to that provider, with the prefix stripped before the
+// remaining model name is sent upstream; "mlx:" just pins the request to
+// the local runner path below under its unprefixed name. The provider's
+// base URL, and an optional fallback provider to retry on a 5xx response or
+// timeout, come from the routing table OLLAMA_PROVIDERS_CONFIG loads (see
+// provider_router.go). For a model with no recognized prefix, an
+// OLLAMA_BACKEND_<MODEL> environment variable of the form
+// "openai:<base_url>" or "anthropic:<base_url>" (base_url may be omitted to
+// use the provider's default) still routes it to that external endpoint,
+// preserving existing deployments. Anything else, including an unset
+// variable, keeps the original behavior: a locally-spawned MLX backend
+// pulled from (or added to) mlxRunnerPool, keyed by localName. draftModel,
+// resolved by the caller via resolveDraftModel, names the speculative
+// decoding draft the local runner should load alongside model; it's ignored
+// for a request routed to an external provider, which has no such concept.
+func getBackend(ctx context.Context, model, localName string, keepalive time.Duration, adapters []llm.MLXAdapter, draftModel string) (InferenceBackend, error) {
+	if prefix, upstreamModel, ok := splitProviderPrefix(model); ok {
+		if prefix != "mlx" {
+			return newRoutedProviderBackend(prefix, upstreamModel)
+		}
+		model = upstreamModel
+	}
+
+	if spec := os.Getenv(backendEnvVar(model)); spec != "" {
+		kind, rest, _ := strings.Cut(spec, ":")
+		switch kind {
+		case "openai":
+			return newOpenAIBackend(model, rest), nil
+		case "anthropic":
+			return newAnthropicBackend(model, rest), nil
+		case "mlx":
+			// fall through to the local runner below
+		default:
+			return nil, fmt.Errorf("unknown backend kind %q in %s", kind, backendEnvVar(model))
+		}
+	}
+
+	entry, err := mlxRunnerPool.getRunner(ctx, localName, keepalive, adapters, draftModel)
+	if err != nil {
+		return nil, err
+	}
+	return &mlxBackend{entry: entry, pool: mlxRunnerPool, name: localName, keepalive: keepalive}, nil
+}
+
+// doWithWriteDeadline races client.Do(req) against deadline's write
+// deadline channel, so a backend that never even accepts the connection is
+// bounded the same way a slow first token is bounded by the read deadline.
+// The caller is expected to cancel req's context once this returns an error
+// so the abandoned goroutine's in-flight Do unblocks instead of leaking.
+func doWithWriteDeadline(client *http.Client, req *http.Request, deadline *deadlineTimer) (*http.Response, error) {
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+
+	resultCh := make(chan result, 1)
+	go func() {
+		resp, err := client.Do(req)
+		resultCh <- result{resp, err}
+	}()
+
+	select {
+	case r := <-resultCh:
+		return r.resp, r.err
+	case <-deadline.writeDeadline():
+		return nil, fmt.Errorf("mlx backend: timed out connecting to runner")
+	}
+}
+
+// mlxBackend is the InferenceBackend implementation fronting a locally
+// spawned MLX runner subprocess, reachable at 127.0.0.1:entry.port.
+type mlxBackend struct {
+	entry     *mlxRunnerEntry
+	pool      *mlxRunnerCache
+	name      string
+	keepalive time.Duration
+}
+
+// recordPromptPrefixHint diffs messages (this request's resolved
+// conversation branch) against the entry's last-served history and, if they
+// share a prefix, folds a prompt_prefix_hint option into req so the MLX
+// backend can reuse that much of its cached KV state instead of
+// recomputing the whole prompt from scratch. It then records messages as
+// the new "last served" history for the next request to diff against.
+func (b *mlxBackend) recordPromptPrefixHint(req *api.GenerateRequest, messages []api.Message) {
+	if lcp := longestCommonMessagePrefix(messages, b.entry.lastMessages); lcp > 0 {
+		if req.Options == nil {
+			req.Options = map[string]interface{}{}
+		}
+		req.Options["prompt_prefix_hint"] = lcp
+	}
+	b.entry.lastMessages = messages
+}
+
+func (b *mlxBackend) Stream(ctx context.Context, req *api.GenerateRequest) (completionStream, error) {
+	requestBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	timeouts := extractStreamTimeouts(req.Options)
+	streamCtx, cancel := ctx, context.CancelFunc(func() {})
+	if timeouts.total > 0 {
+		streamCtx, cancel = context.WithTimeout(ctx, timeouts.total)
+	} else {
+		streamCtx, cancel = context.WithCancel(ctx)
+	}
+
+	httpReq, err := http.NewRequestWithContext(streamCtx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/completion", b.entry.port), bytes.NewReader(requestBody))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	// Bound connecting and sending the request itself by the write
+	// deadline, mirroring how the read deadline bounds receiving it below.
+	b.entry.deadline.SetWriteDeadline(timeouts.ttft)
+	resp, err := doWithWriteDeadline(b.entry.client, httpReq, b.entry.deadline)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		defer cancel()
+		msg, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backend response: %w", err)
+		}
+		return nil, &backendHTTPError{status: resp.StatusCode, message: strings.TrimSpace(string(msg))}
+	}
+
+	b.entry.deadline.SetReadDeadline(timeouts.ttft)
+
+	return &mlxCompletionStream{
+		client:   b.entry.client,
+		port:     b.entry.port,
+		body:     resp.Body,
+		decoder:  newMLXStreamDecoder(resp.Body, extractStopSequences(req.Options)),
+		deadline: b.entry.deadline,
+		timeouts: timeouts,
+		ctx:      streamCtx,
+		cancel:   cancel,
+		model:    b.entry.model,
+		start:    time.Now(),
+	}, nil
+}
+
+// Embed sends the whole batch to the runner's /embedding endpoint in one
+// request (rather than one round-trip per input) and passes opts through
+// for the Python backend to apply during pooling.
+func (b *mlxBackend) Embed(ctx context.Context, input []string, opts EmbedOptions) (*EmbedResult, error) {
+	reqBody, err := json.Marshal(mlxEmbeddingRequest{Prompts: input, Pooling: opts.Pooling, Truncate: opts.Truncate})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/embedding", b.entry.port), bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.entry.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("embedding request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &backendHTTPError{status: resp.StatusCode, message: strings.TrimSpace(string(body))}
+	}
+
+	var embResp mlxEmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embResp); err != nil {
+		return nil, fmt.Errorf("failed to decode embedding response: %v", err)
+	}
+
+	return &EmbedResult{Embeddings: embResp.Embeddings, PromptEvalCount: embResp.PromptEvalCount}, nil
+}
+
+// Rerank sends query and documents to the runner's /rerank endpoint in one
+// batched request, for a cross-encoder model loaded the same way an
+// embedding or generation model is.
+func (b *mlxBackend) Rerank(ctx context.Context, query string, documents []string) ([]RerankScore, error) {
+	reqBody, err := json.Marshal(mlxRerankRequest{Query: query, Documents: documents})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/rerank", b.entry.port), bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.entry.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("rerank request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &backendHTTPError{status: resp.StatusCode, message: strings.TrimSpace(string(body))}
+	}
+
+	var rerankResp mlxRerankResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rerankResp); err != nil {
+		return nil, fmt.Errorf("failed to decode rerank response: %v", err)
+	}
+
+	scores := make([]RerankScore, len(rerankResp.Results))
+	for i, r := range rerankResp.Results {
+		scores[i] = RerankScore{Index: r.Index, RelevanceScore: r.RelevanceScore}
+	}
+	return scores, nil
+}
+
+// Close reports this request is done with its MLX runner, either evicting
+// it immediately (keepalive of 0) or refreshing its LRU timestamp, matching
+// the keepalive semantics generateMLXModel/chatMLXModel applied directly
+// before getBackend existed.
+func (b *mlxBackend) Close() {
+	if b.keepalive == 0 {
+		b.pool.evict(b.name)
+	} else {
+		b.pool.touch(b.name)
+	}
+}
+
+// mlxCompletionStream decodes the NDJSON streaming protocol off an MLX
+// runner's POST /completion response, enforcing the request's
+// ttft_timeout/token_idle_timeout/total_timeout deadlines by racing the
+// decoder's scan against deadline and total-timeout channels.
+type mlxCompletionStream struct {
+	client   *http.Client
+	port     int
+	body     io.ReadCloser
+	decoder  *mlxStreamDecoder
+	deadline *deadlineTimer
+	timeouts mlxStreamTimeouts
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	// model and start support recording first_token_latency_seconds on the
+	// first chunk of output; firstTokenSeen guards against a second
+	// (incorrect) observation on every subsequent chunk.
+	model          string
+	start          time.Time
+	firstTokenSeen bool
+}
+
+func (s *mlxCompletionStream) Next() (mlxStreamChunk, bool, error) {
+	type result struct {
+		chunk mlxStreamChunk
+		ok    bool
+		err   error
+	}
+
+	resultCh := make(chan result, 1)
+	go func() {
+		chunk, ok, err := s.decoder.next()
+		resultCh <- result{chunk, ok, err}
+	}()
+
+	select {
+	case r := <-resultCh:
+		if !r.ok || r.err != nil || r.chunk.Done {
+			s.cancel()
+		}
+		if !r.ok || r.err != nil {
+			s.body.Close()
+			return r.chunk, r.ok, r.err
+		}
+		// Once generation has started, later tokens are bounded by
+		// token_idle_timeout rather than ttft_timeout.
+		s.deadline.SetReadDeadline(s.timeouts.tokenIdle)
+		if !s.firstTokenSeen {
+			s.firstTokenSeen = true
+			mlxSupervisor.metrics.firstTokenLatency.observe(s.model, time.Since(s.start).Seconds())
+		}
+		return r.chunk, r.ok, r.err
+	case <-s.deadline.readDeadline():
+		return s.timeoutChunk()
+	case <-s.ctx.Done():
+		return s.timeoutChunk()
+	}
+}
+
+// timeoutChunk cancels the outbound request and tells the runner to stop
+// generating, then reports a well-formed done_reason: "timeout" terminator
+// instead of leaving the caller to see a truncated stream or a raw
+// context-canceled error.
+func (s *mlxCompletionStream) timeoutChunk() (mlxStreamChunk, bool, error) {
+	s.cancel()
+	sendMLXAbort(s.client, s.port)
+	s.body.Close()
+	return mlxStreamChunk{Done: true, DoneReason: "timeout"}, true, nil
+}
+
+func (s *mlxCompletionStream) Abort() {
+	s.cancel()
+	sendMLXAbort(s.client, s.port)
+	s.body.Close()
+}
+
+// sseCompletionStream reads a backend's SSE-framed streaming response
+// (`data: ...` lines, optionally preceded by an `event: ...` line) and hands
+// each frame to parseEvent, which turns that backend's wire format into the
+// common mlxStreamChunk currency. parseEvent returns ok=false to skip an
+// event that doesn't correspond to visible output (e.g. a ping, or a
+// tool-call argument fragment still being accumulated).
+type sseCompletionStream struct {
+	body         io.ReadCloser
+	scanner      *bufio.Scanner
+	parseEvent   func(event string, data []byte) (mlxStreamChunk, bool)
+	pendingEvent string
+}
+
+func newSSECompletionStream(body io.ReadCloser, parseEvent func(string, []byte) (mlxStreamChunk, bool)) *sseCompletionStream {
+	return &sseCompletionStream{body: body, scanner: bufio.NewScanner(body), parseEvent: parseEvent}
+}
+
+func (s *sseCompletionStream) Next() (mlxStreamChunk, bool, error) {
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if strings.HasPrefix(line, "event:") {
+			s.pendingEvent = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			continue
+		}
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		event := s.pendingEvent
+		s.pendingEvent = ""
+		if data == "" {
+			continue
+		}
+
+		chunk, ok := s.parseEvent(event, []byte(data))
+		if !ok {
+			continue
+		}
+		return chunk, true, nil
+	}
+
+	s.body.Close()
+	return mlxStreamChunk{}, false, s.scanner.Err()
+}
+
+func (s *sseCompletionStream) Abort() {
+	s.body.Close()
+}
+
+// extractMaxTokens pulls a max-tokens-style option out of a generate/chat
+// request's freeform Options map, since Anthropic's Messages API requires
+// max_tokens on every request (unlike MLX and OpenAI, where it's optional).
+func extractMaxTokens(options map[string]any, fallback int) int {
+	for _, key := range []string{"num_predict", "max_tokens"} {
+		raw, ok := options[key]
+		if !ok {
+			continue
+		}
+		switch v := raw.(type) {
+		case float64:
+			if v > 0 {
+				return int(v)
+			}
+		case int:
+			if v > 0 {
+				return v
+			}
+		}
+	}
+	return fallback
+}
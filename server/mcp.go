@@ -0,0 +1,548 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// mcpTransportKind identifies how an mcpClient talks to a configured MCP
+// server: a locally-spawned process speaking JSON-RPC over stdio, or an
+// HTTP endpoint speaking either the legacy SSE transport or the newer
+// streamable-HTTP transport (both are a POST per call; the only difference
+// is whether the response comes back as one JSON body or an SSE stream, and
+// readHTTPResult handles both).
+type mcpTransportKind string
+
+const (
+	mcpTransportStdio mcpTransportKind = "stdio"
+	mcpTransportSSE   mcpTransportKind = "sse"
+	mcpTransportHTTP  mcpTransportKind = "streamable-http"
+)
+
+// mcpServerConfig is one entry of an MCP config file's "mcpServers" object,
+// the same shape Claude Desktop's config uses: a transport-specific
+// command/url plus an optional per-tool allow/deny list.
+type mcpServerConfig struct {
+	Transport mcpTransportKind  `json:"transport,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Args      []string          `json:"args,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	URL       string            `json:"url,omitempty"`
+	Allow     []string          `json:"allow,omitempty"`
+	Deny      []string          `json:"deny,omitempty"`
+}
+
+// mcpConfig is the top-level shape of an MCP config file pointed to by
+// OLLAMA_MCP_CONFIG.
+type mcpConfig struct {
+	MCPServers map[string]mcpServerConfig `json:"mcpServers"`
+}
+
+// mcpToolDef is one entry of an MCP server's tools/list result.
+type mcpToolDef struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+// mcpContentBlock is one entry of a tools/call result's "content" array.
+// Only Type and the field it uses are populated: Text for "text", Data and
+// MimeType for "image", Resource for "resource".
+type mcpContentBlock struct {
+	Type     string         `json:"type"`
+	Text     string         `json:"text,omitempty"`
+	Data     string         `json:"data,omitempty"`
+	MimeType string         `json:"mimeType,omitempty"`
+	Resource map[string]any `json:"resource,omitempty"`
+}
+
+// jsonRPCRequest is a JSON-RPC 2.0 request frame, the envelope every MCP
+// message (both directions) travels in.
+type jsonRPCRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      int64  `json:"id,omitempty"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+type jsonRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int64           `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonRPCError   `json:"error,omitempty"`
+}
+
+type jsonRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// mcpTransport sends one JSON-RPC request to an MCP server and returns its
+// result payload, or fires a notification that expects no reply.
+type mcpTransport interface {
+	call(ctx context.Context, method string, params any) (json.RawMessage, error)
+	notify(ctx context.Context, method string, params any) error
+	close()
+}
+
+// stdioTransport speaks newline-delimited JSON-RPC over a spawned MCP
+// server's stdin/stdout, matching responses to requests by id.
+type stdioTransport struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	writeM sync.Mutex
+
+	nextID int64
+
+	pendingM sync.Mutex
+	pending  map[int64]chan jsonRPCResponse
+}
+
+func newStdioTransport(ctx context.Context, cfg mcpServerConfig) (*stdioTransport, error) {
+	cmd := exec.CommandContext(ctx, cfg.Command, cfg.Args...)
+	cmd.Env = os.Environ()
+	for k, v := range cfg.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("mcp stdio: stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("mcp stdio: stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("mcp stdio: start %s: %w", cfg.Command, err)
+	}
+
+	t := &stdioTransport{cmd: cmd, stdin: stdin, pending: map[int64]chan jsonRPCResponse{}}
+	go t.readLoop(stdout)
+	return t, nil
+}
+
+func (t *stdioTransport) readLoop(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var resp jsonRPCResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			continue
+		}
+
+		t.pendingM.Lock()
+		ch, ok := t.pending[resp.ID]
+		delete(t.pending, resp.ID)
+		t.pendingM.Unlock()
+
+		if ok {
+			ch <- resp
+		}
+	}
+}
+
+func (t *stdioTransport) call(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	id := atomic.AddInt64(&t.nextID, 1)
+	ch := make(chan jsonRPCResponse, 1)
+	t.pendingM.Lock()
+	t.pending[id] = ch
+	t.pendingM.Unlock()
+
+	if err := t.write(jsonRPCRequest{JSONRPC: "2.0", ID: id, Method: method, Params: params}); err != nil {
+		t.pendingM.Lock()
+		delete(t.pending, id)
+		t.pendingM.Unlock()
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return nil, fmt.Errorf("mcp: %s", resp.Error.Message)
+		}
+		return resp.Result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (t *stdioTransport) notify(_ context.Context, method string, params any) error {
+	return t.write(jsonRPCRequest{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (t *stdioTransport) write(req jsonRPCRequest) error {
+	line, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	t.writeM.Lock()
+	defer t.writeM.Unlock()
+	_, err = t.stdin.Write(append(line, '\n'))
+	return err
+}
+
+func (t *stdioTransport) close() {
+	t.stdin.Close()
+	if t.cmd.Process != nil {
+		t.cmd.Process.Kill()
+	}
+	t.cmd.Wait()
+}
+
+// httpTransport speaks one-JSON-RPC-request-per-POST to an MCP server's
+// URL, for both the legacy SSE transport and the streamable-HTTP transport:
+// the request shape is identical, and readHTTPResult transparently handles
+// whichever response framing the server chooses to reply with.
+type httpTransport struct {
+	url    string
+	client *http.Client
+	nextID int64
+}
+
+func newHTTPTransport(cfg mcpServerConfig) *httpTransport {
+	return &httpTransport{url: cfg.URL, client: &http.Client{Timeout: 60 * time.Second}}
+}
+
+func (t *httpTransport) call(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	id := atomic.AddInt64(&t.nextID, 1)
+	resp, err := t.post(ctx, jsonRPCRequest{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("mcp: %s", resp.Error.Message)
+	}
+	return resp.Result, nil
+}
+
+func (t *httpTransport) notify(ctx context.Context, method string, params any) error {
+	_, err := t.post(ctx, jsonRPCRequest{JSONRPC: "2.0", Method: method, Params: params})
+	return err
+}
+
+func (t *httpTransport) post(ctx context.Context, req jsonRPCRequest) (jsonRPCResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return jsonRPCResponse{}, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return jsonRPCResponse{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json, text/event-stream")
+
+	resp, err := t.client.Do(httpReq)
+	if err != nil {
+		return jsonRPCResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusAccepted {
+		// A notification: the server has nothing to reply with.
+		return jsonRPCResponse{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return jsonRPCResponse{}, fmt.Errorf("mcp server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	return readHTTPResult(resp)
+}
+
+// readHTTPResult decodes an MCP HTTP response as a single JSON-RPC object,
+// or, if the server streamed it as SSE, as the first "data:" frame that
+// parses as one.
+func readHTTPResult(resp *http.Response) (jsonRPCResponse, error) {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		var out jsonRPCResponse
+		err := json.NewDecoder(resp.Body).Decode(&out)
+		return out, err
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue
+		}
+		var out jsonRPCResponse
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &out); err == nil {
+			return out, nil
+		}
+	}
+	return jsonRPCResponse{}, fmt.Errorf("mcp: no JSON-RPC frame in event stream")
+}
+
+func (t *httpTransport) close() {}
+
+// mcpClient is one connected MCP server: its transport plus the allow/deny
+// list from its config entry.
+type mcpClient struct {
+	name      string
+	transport mcpTransport
+	allow     map[string]bool
+	deny      map[string]bool
+}
+
+// dialMCPServer connects to one mcpServers config entry and performs the
+// MCP initialize handshake.
+func dialMCPServer(ctx context.Context, name string, cfg mcpServerConfig) (*mcpClient, error) {
+	transport, err := newMCPTransport(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &mcpClient{name: name, transport: transport, allow: toSet(cfg.Allow), deny: toSet(cfg.Deny)}
+
+	if _, err := transport.call(ctx, "initialize", map[string]any{
+		"protocolVersion": "2024-11-05",
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]any{"name": "ollmlx", "version": "0.1"},
+	}); err != nil {
+		transport.close()
+		return nil, fmt.Errorf("mcp server %s: initialize: %w", name, err)
+	}
+	if err := transport.notify(ctx, "notifications/initialized", nil); err != nil {
+		slog.Warn("mcp server did not acknowledge initialized notification", "server", name, "error", err)
+	}
+
+	return c, nil
+}
+
+func newMCPTransport(ctx context.Context, cfg mcpServerConfig) (mcpTransport, error) {
+	kind := cfg.Transport
+	if kind == "" {
+		if cfg.Command != "" {
+			kind = mcpTransportStdio
+		} else {
+			kind = mcpTransportHTTP
+		}
+	}
+
+	switch kind {
+	case mcpTransportStdio:
+		if cfg.Command == "" {
+			return nil, fmt.Errorf("mcp server: transport %q requires \"command\"", kind)
+		}
+		return newStdioTransport(ctx, cfg)
+	case mcpTransportSSE, mcpTransportHTTP:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("mcp server: transport %q requires \"url\"", kind)
+		}
+		return newHTTPTransport(cfg), nil
+	default:
+		return nil, fmt.Errorf("mcp server: unknown transport %q", kind)
+	}
+}
+
+func toSet(items []string) map[string]bool {
+	if len(items) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
+
+func (c *mcpClient) allowed(tool string) bool {
+	if c.deny[tool] {
+		return false
+	}
+	if len(c.allow) == 0 {
+		return true
+	}
+	return c.allow[tool]
+}
+
+func (c *mcpClient) listTools(ctx context.Context) ([]mcpToolDef, error) {
+	result, err := c.transport.call(ctx, "tools/list", map[string]any{})
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed struct {
+		Tools []mcpToolDef `json:"tools"`
+	}
+	if err := json.Unmarshal(result, &parsed); err != nil {
+		return nil, fmt.Errorf("mcp server %s: parse tools/list: %w", c.name, err)
+	}
+
+	tools := parsed.Tools[:0]
+	for _, t := range parsed.Tools {
+		if c.allowed(t.Name) {
+			tools = append(tools, t)
+		}
+	}
+	return tools, nil
+}
+
+func (c *mcpClient) callTool(ctx context.Context, name string, arguments map[string]any) ([]mcpContentBlock, error) {
+	if !c.allowed(name) {
+		return nil, fmt.Errorf("tool %s is not permitted by mcp server %s's allow/deny list", name, c.name)
+	}
+
+	result, err := c.transport.call(ctx, "tools/call", map[string]any{"name": name, "arguments": arguments})
+	if err != nil {
+		return nil, fmt.Errorf("mcp server %s: tools/call %s: %w", c.name, name, err)
+	}
+
+	var parsed struct {
+		Content []mcpContentBlock `json:"content"`
+		IsError bool              `json:"isError"`
+	}
+	if err := json.Unmarshal(result, &parsed); err != nil {
+		return nil, fmt.Errorf("mcp server %s: parse tools/call result: %w", c.name, err)
+	}
+	if parsed.IsError {
+		return parsed.Content, fmt.Errorf("tool %s reported an error", name)
+	}
+	return parsed.Content, nil
+}
+
+// mcpRegistry fans out tool discovery and dispatch across every MCP server
+// configured in OLLAMA_MCP_CONFIG.
+type mcpRegistry struct {
+	clients []*mcpClient
+}
+
+func newMCPRegistry() *mcpRegistry {
+	return &mcpRegistry{}
+}
+
+// loadMCPConfig connects to every server in an mcpServers config file (the
+// same shape Claude Desktop's config uses). A server that fails to connect
+// is logged and skipped rather than failing the whole registry, so one
+// misconfigured entry doesn't take down every other tool.
+func loadMCPConfig(ctx context.Context, path string) (*mcpRegistry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read mcp config: %w", err)
+	}
+
+	var cfg mcpConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse mcp config: %w", err)
+	}
+
+	reg := newMCPRegistry()
+	for name, serverCfg := range cfg.MCPServers {
+		client, err := dialMCPServer(ctx, name, serverCfg)
+		if err != nil {
+			slog.Error("failed to connect to mcp server", "server", name, "error", err)
+			continue
+		}
+		reg.clients = append(reg.clients, client)
+	}
+	return reg, nil
+}
+
+// ownerOf returns whichever connected MCP server advertises a tool named
+// name, discovering tools fresh on every call so schema or allow/deny
+// changes on the server side take effect without a restart.
+func (r *mcpRegistry) ownerOf(ctx context.Context, name string) *mcpClient {
+	for _, client := range r.clients {
+		tools, err := client.listTools(ctx)
+		if err != nil {
+			slog.Warn("failed to list tools from mcp server", "server", client.name, "error", err)
+			continue
+		}
+		for _, t := range tools {
+			if t.Name == name {
+				return client
+			}
+		}
+	}
+	return nil
+}
+
+// tools returns every tool discovered across every connected MCP server as
+// api.Tools, so toolPromptBlock can advertise live schemas pulled straight
+// from tools/list instead of only whatever the request body provided.
+func (r *mcpRegistry) tools(ctx context.Context) api.Tools {
+	var out api.Tools
+	for _, client := range r.clients {
+		tools, err := client.listTools(ctx)
+		if err != nil {
+			slog.Warn("failed to list tools from mcp server", "server", client.name, "error", err)
+			continue
+		}
+		for _, t := range tools {
+			tool := api.Tool{Type: "function", Function: api.ToolFunction{Name: t.Name, Description: t.Description}}
+			if schema, err := json.Marshal(t.InputSchema); err == nil {
+				_ = json.Unmarshal(schema, &tool.Function.Parameters)
+			}
+			out = append(out, tool)
+		}
+	}
+	return out
+}
+
+var (
+	mcpRegistryOnce sync.Once
+	mcpRegistryInst *mcpRegistry
+)
+
+// getMCPRegistry lazily connects to every server named in OLLAMA_MCP_CONFIG
+// on first use and reuses those connections for the life of the process.
+func getMCPRegistry(ctx context.Context) *mcpRegistry {
+	mcpRegistryOnce.Do(func() {
+		path := os.Getenv("OLLAMA_MCP_CONFIG")
+		if path == "" {
+			mcpRegistryInst = newMCPRegistry()
+			return
+		}
+
+		reg, err := loadMCPConfig(ctx, path)
+		if err != nil {
+			slog.Error("failed to load mcp config", "path", path, "error", err)
+			reg = newMCPRegistry()
+		}
+		mcpRegistryInst = reg
+	})
+	return mcpRegistryInst
+}
+
+// mergeMCPTools appends every tool discovered across configured MCP servers
+// to tools, skipping any name the caller already declared, so a request's
+// own tools take precedence over same-named MCP ones.
+func mergeMCPTools(ctx context.Context, tools api.Tools) api.Tools {
+	registry := getMCPRegistry(ctx)
+	if len(registry.clients) == 0 {
+		return tools
+	}
+
+	have := make(map[string]bool, len(tools))
+	for _, t := range tools {
+		have[t.Function.Name] = true
+	}
+
+	for _, t := range registry.tools(ctx) {
+		if !have[t.Function.Name] {
+			tools = append(tools, t)
+			have[t.Function.Name] = true
+		}
+	}
+	return tools
+}
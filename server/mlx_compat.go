@@ -115,6 +115,13 @@ func applyLegacyMLXChatAdapters(req *api.ChatRequest, legacy *legacyMLXChatPaylo
 		req.Model = normalized
 	}
 
+	if req.ToolChoice == "" {
+		// Default to "auto" here, at binding time, so every other reader of
+		// req.ToolChoice (resolveToolChoice included) only ever has to
+		// handle the three values tool_choice can actually mean.
+		req.ToolChoice = "auto"
+	}
+
 	return warnings
 }
 
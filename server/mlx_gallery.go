@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ollama/ollama/llm"
+)
+
+// galleryEntry is a GalleryModel annotated with whether it's already been
+// downloaded, so a picker UI can gray out models the user already has.
+type galleryEntry struct {
+	llm.GalleryModel
+	Downloaded bool `json:"downloaded"`
+}
+
+// MLXGallery serves GET /api/mlx/gallery: the merged catalog of every
+// manifest named by OLLAMA_MLX_GALLERY_URLS (see
+// llm.MLXModelManager.FetchGallery).
+func (s *Server) MLXGallery(c *gin.Context) {
+	manager := llm.NewMLXModelManager()
+
+	catalog, err := manager.FetchGallery(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	entries := make([]galleryEntry, len(catalog))
+	for i, model := range catalog {
+		entries[i] = galleryEntry{GalleryModel: model, Downloaded: manager.ModelExists(model.Name)}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"models": entries})
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/llm/mlxservice"
+)
+
+// mlxServiceBackend adapts the existing getBackend/InferenceBackend path
+// (see inference_backend.go) to mlxservice.Backend, so a
+// mlxservice.Service can queue jobs against it. generateMLXModel submits
+// every request through a Service built around this backend (see
+// mlxGenerationServiceFor, collectMLXGenerateViaService,
+// streamMLXGenerateViaService), so /api/generate requests against the same
+// model now queue behind its pool instead of racing getBackend directly.
+//
+// chatMLXModel does not submit its requests through Run: its multi-hop
+// tool-call auto-execution loop (tool_auto_exec.go) keeps reusing one
+// getBackend-resolved runner across hops for KV-cache prefix hinting (see
+// mlxBackend.recordPromptPrefixHint), which Run's one-shot
+// getBackend-per-job model doesn't fit. It still goes through the same
+// per-model Service, though: chatMLXModel calls Service.Acquire up front
+// and holds the slot for the whole turn, so /api/chat queues behind the
+// same bound /api/generate does against a given model rather than
+// bypassing it.
+//
+// It only implements the generate path: a *api.GenerateRequest passes
+// through as is, and a chat request is accepted too, by formatting its
+// messages into a prompt with formatChatPromptWithModel the same way
+// applyLegacyMLXChatAdapters does the reverse conversion, but without
+// chatMLXModel's tool-calling/agent/conversation handling layered on top -
+// chatMLXModel doesn't use this path for exactly that reason (see above).
+type mlxServiceBackend struct {
+	localName  string
+	keepAlive  time.Duration
+	adapters   []llm.MLXAdapter
+	draftModel string
+
+	mu    sync.Mutex
+	ports map[string]int // jobID -> runner port, so Cancel can reach it after Run's own stack frame is gone
+}
+
+// newMLXServiceBackend builds an mlxservice.Backend for model localName,
+// with the same pre-resolved adapters/draftModel/keepAlive
+// generateMLXModel/chatMLXModel compute before calling getBackend directly.
+func newMLXServiceBackend(localName string, keepAlive time.Duration, adapters []llm.MLXAdapter, draftModel string) *mlxServiceBackend {
+	return &mlxServiceBackend{
+		localName:  localName,
+		keepAlive:  keepAlive,
+		adapters:   adapters,
+		draftModel: draftModel,
+		ports:      make(map[string]int),
+	}
+}
+
+func (b *mlxServiceBackend) Run(ctx context.Context, jobID, model string, req any, out chan<- mlxservice.Chunk) {
+	genReq, err := b.toGenerateRequest(req)
+	if err != nil {
+		out <- mlxservice.Chunk{Err: err}
+		return
+	}
+
+	backend, err := getBackend(ctx, model, b.localName, b.keepAlive, b.adapters, b.draftModel)
+	if err != nil {
+		out <- mlxservice.Chunk{Err: fmt.Errorf("failed to provision MLX runner: %w", err)}
+		return
+	}
+	defer backend.Close()
+
+	if mb, ok := backend.(*mlxBackend); ok {
+		b.mu.Lock()
+		b.ports[jobID] = mb.entry.port
+		b.mu.Unlock()
+		defer func() {
+			b.mu.Lock()
+			delete(b.ports, jobID)
+			b.mu.Unlock()
+		}()
+	}
+
+	stream, err := backend.Stream(ctx, genReq)
+	if err != nil {
+		out <- mlxservice.Chunk{Err: err}
+		return
+	}
+	defer stream.Abort()
+
+	for {
+		chunk, ok, err := stream.Next()
+		if err != nil {
+			out <- mlxservice.Chunk{Err: err}
+			return
+		}
+
+		out <- mlxservice.Chunk{
+			Content:    chunk.Content,
+			ToolCalls:  chunk.ToolCalls,
+			Metrics:    chunk.metrics(),
+			Done:       chunk.Done,
+			DoneReason: chunk.DoneReason,
+		}
+		if !ok || chunk.Done {
+			return
+		}
+	}
+}
+
+// Cancel reaches the runner directly by job ID (see sendMLXCancel), for a
+// disconnect mlxservice.Service observed on a job that may be sharing its
+// runner with others - ctx cancellation alone only stops this goroutine
+// from reading further, it can't tell the runner which of several
+// in-flight generations to actually stop.
+func (b *mlxServiceBackend) Cancel(jobID, model string) {
+	b.mu.Lock()
+	port, ok := b.ports[jobID]
+	b.mu.Unlock()
+	if !ok {
+		return
+	}
+	sendMLXCancel(http.DefaultClient, port, jobID)
+}
+
+// toGenerateRequest accepts either request type Run is handed: a
+// *api.GenerateRequest passes through unchanged, and a *api.ChatRequest is
+// flattened into one by formatting its messages into a prompt, the same
+// direction applyLegacyMLXChatAdapters converts the other way for the
+// legacy chat payload.
+func (b *mlxServiceBackend) toGenerateRequest(req any) (*api.GenerateRequest, error) {
+	switch r := req.(type) {
+	case *api.GenerateRequest:
+		return r, nil
+	case *api.ChatRequest:
+		return &api.GenerateRequest{
+			Model:     r.Model,
+			Prompt:    formatChatPromptWithModel(r.Messages, r.Tools, r.Model, ""),
+			Options:   r.Options,
+			Stream:    r.Stream,
+			KeepAlive: r.KeepAlive,
+		}, nil
+	default:
+		return nil, fmt.Errorf("mlxServiceBackend: unsupported request type %T", req)
+	}
+}
+
+var (
+	mlxGenerationServicesMu sync.Mutex
+	mlxGenerationServices   = make(map[string]*mlxservice.Service)
+)
+
+// mlxGenerationServiceFor returns the shared mlxservice.Service bounding
+// concurrent generate/chat jobs against localName, building it from this
+// call's resolved adapters/keepAlive/draftModel the first time localName is
+// seen - the same lazily-created-per-model cache pattern mlxRunnerPool
+// already uses for the runner itself (see newMLXRunnerCache). A later call
+// for the same localName reuses the existing Service (and its pool) even
+// if that call's adapters/draftModel differ slightly from the ones it was
+// built with; those are resolved from the model's Modelfile and rarely
+// change between requests, and the Service's value here is the shared
+// queue, not per-call precision.
+func mlxGenerationServiceFor(localName string, keepAlive time.Duration, adapters []llm.MLXAdapter, draftModel string) *mlxservice.Service {
+	mlxGenerationServicesMu.Lock()
+	defer mlxGenerationServicesMu.Unlock()
+
+	if svc, ok := mlxGenerationServices[localName]; ok {
+		return svc
+	}
+
+	svc := mlxservice.New(newMLXServiceBackend(localName, keepAlive, adapters, draftModel))
+	mlxGenerationServices[localName] = svc
+	return svc
+}
+
+// collectMLXGenerateViaService runs req to completion through svc, the
+// queued-dispatch counterpart to collectMLXCompletion.
+func collectMLXGenerateViaService(ctx context.Context, svc *mlxservice.Service, req *api.GenerateRequest) (*api.GenerateResponse, error) {
+	ch, err := svc.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	created := time.Now().UTC()
+	var buf strings.Builder
+	var last mlxservice.Chunk
+	var toolCalls []api.ToolCall
+
+	for chunk := range ch {
+		if chunk.Err != nil {
+			var httpErr *backendHTTPError
+			if errors.As(chunk.Err, &httpErr) {
+				return nil, fmt.Errorf("completion failed: %s", httpErr.message)
+			}
+			return nil, chunk.Err
+		}
+		last = chunk
+		if len(chunk.ToolCalls) > 0 {
+			toolCalls = chunk.ToolCalls
+		}
+		buf.WriteString(chunk.Content)
+	}
+
+	if len(toolCalls) > 0 && buf.Len() == 0 {
+		if data, err := json.Marshal(map[string]any{"tool_calls": toolCalls}); err == nil {
+			buf.Write(data)
+		}
+	}
+
+	return &api.GenerateResponse{
+		Model:      req.Model,
+		CreatedAt:  created,
+		Response:   buf.String(),
+		Done:       true,
+		DoneReason: last.DoneReason,
+		Metrics:    last.Metrics,
+	}, nil
+}
+
+// streamMLXGenerateViaService streams req's completion from svc back to c,
+// the queued-dispatch counterpart to streamMLXCompletion.
+func streamMLXGenerateViaService(ctx context.Context, c *gin.Context, svc *mlxservice.Service, req *api.GenerateRequest) error {
+	ch, err := svc.Generate(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	sse := wantsMLXEventStream(c)
+	if sse {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+	}
+	c.Status(http.StatusOK)
+
+	flusher, _ := c.Writer.(http.Flusher)
+	created := time.Now().UTC()
+
+	for chunk := range ch {
+		if chunk.Err != nil {
+			var httpErr *backendHTTPError
+			if errors.As(chunk.Err, &httpErr) {
+				c.AbortWithStatusJSON(httpErr.status, gin.H{"error": httpErr.message})
+				return nil
+			}
+			return chunk.Err
+		}
+
+		out := api.GenerateResponse{
+			Model:      req.Model,
+			CreatedAt:  created,
+			Response:   chunk.Content,
+			Done:       chunk.Done,
+			DoneReason: chunk.DoneReason,
+			Metrics:    chunk.Metrics,
+		}
+
+		if sse {
+			if err := writeMLXEventStreamChunk(c.Writer, flusher, out); err != nil {
+				return err
+			}
+		} else {
+			line, _ := json.Marshal(out)
+			c.Writer.Write(line)
+			c.Writer.Write([]byte("\n"))
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/llm/mlxservice"
+)
+
+// TestMLXServiceBackendCancelsViaCancelEndpointOnDisconnect exercises
+// mlxServiceBackend end to end through mlxservice.Service against a fake
+// runner: it asserts that canceling a job's context (the client
+// disconnecting mid-stream) reaches the runner's /cancel/{job_id}
+// endpoint within a deadline, the same assertion the request asked the
+// worker-pool layer to cover.
+func TestMLXServiceBackendCancelsViaCancelEndpointOnDisconnect(t *testing.T) {
+	modelName := "mlx-community/service-backend-test"
+	localName := strings.ReplaceAll(modelName, "/", "_")
+
+	modelsRoot := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", modelsRoot)
+
+	modelDir := filepath.Join(modelsRoot, "mlx", localName)
+	if err := os.MkdirAll(modelDir, 0o755); err != nil {
+		t.Fatalf("failed to create model directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(modelDir, "config.json"), []byte("{}"), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(modelDir, "weights.npz"), []byte{}, 0o644); err != nil {
+		t.Fatalf("failed to write weights: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start listener: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	block := make(chan struct{})
+	var mu sync.Mutex
+	var canceledJobID string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/completion", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		chunk, _ := json.Marshal(map[string]any{"content": "hel", "done": false})
+		fmt.Fprintf(w, "%s\n", chunk)
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		<-block
+	})
+	mux.HandleFunc("/cancel/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		canceledJobID = strings.TrimPrefix(r.URL.Path, "/cancel/")
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(listener)
+	t.Cleanup(func() {
+		close(block)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+
+	startMLXRunnerFunc = func(ctx context.Context, modelName string) (*exec.Cmd, int, error) {
+		return exec.CommandContext(ctx, "true"), port, nil
+	}
+	defer func() { startMLXRunnerFunc = startMLXRunner }()
+
+	loadMLXModelFunc = func(context.Context, *http.Client, int, string, []llm.MLXAdapter, string, int) error {
+		return nil
+	}
+	defer func() { loadMLXModelFunc = loadMLXModel }()
+
+	backend := newMLXServiceBackend(localName, time.Minute, nil, "")
+	svc := mlxservice.New(backend)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := svc.Generate(ctx, &api.GenerateRequest{Model: modelName})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	select {
+	case chunk, ok := <-ch:
+		if !ok || chunk.Content != "hel" {
+			t.Fatalf("unexpected first chunk: %+v ok=%v", chunk, ok)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the first chunk")
+	}
+
+	go func() {
+		for range ch {
+		}
+	}()
+
+	cancel() // simulate the HTTP client disconnecting mid-stream
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		got := canceledJobID
+		mu.Unlock()
+		if got != "" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("runner's /cancel/{job_id} endpoint was never hit within the deadline")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
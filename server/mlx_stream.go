@@ -0,0 +1,340 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ollama/ollama/api"
+)
+
+// mlxStreamChunk is one line of the NDJSON streaming protocol the MLX
+// backend speaks over POST /completion: content is the incremental text
+// delta for this chunk, tokens the newly generated token ids, and
+// eval_duration_ns wall-clock nanoseconds (rather than Go's time.Duration
+// encoding, since the Python backend emits a plain integer).
+// stop_sequence_matched is set on the final chunk when generation stopped
+// because it hit one of the request's stop strings, naming which one.
+type mlxStreamChunk struct {
+	Content             string         `json:"content"`
+	Tokens              []int          `json:"tokens"`
+	Logprobs            any            `json:"logprobs"`
+	PromptEvalCount     int            `json:"prompt_eval_count"`
+	PromptEvalDuration  time.Duration  `json:"prompt_eval_duration"`
+	EvalCount           int            `json:"eval_count"`
+	EvalDurationNs      int64          `json:"eval_duration_ns"`
+	Done                bool           `json:"done"`
+	DoneReason          string         `json:"done_reason"`
+	StopSequenceMatched string         `json:"stop_sequence_matched"`
+	ToolCalls           []api.ToolCall `json:"tool_calls"`
+
+	// NumDraftTokens and AcceptanceRate are only populated when the runner
+	// served this chunk with a speculative decoding draft model loaded (see
+	// resolveDraftModel): how many draft tokens the main model verified in
+	// its last batch, and the fraction of those batches it's accepted
+	// across the generation so far.
+	NumDraftTokens int     `json:"num_draft_tokens"`
+	AcceptanceRate float64 `json:"acceptance_rate"`
+}
+
+// metrics adapts a chunk's accounting fields to api.Metrics.
+func (c mlxStreamChunk) metrics() api.Metrics {
+	return api.Metrics{
+		PromptEvalCount:    c.PromptEvalCount,
+		PromptEvalDuration: c.PromptEvalDuration,
+		EvalCount:          c.EvalCount,
+		EvalDuration:       time.Duration(c.EvalDurationNs),
+		NumDraftTokens:     c.NumDraftTokens,
+		AcceptanceRate:     c.AcceptanceRate,
+	}
+}
+
+// mlxStreamDecoder reads the NDJSON streaming protocol off an MLX backend's
+// POST /completion response. It holds back any trailing content that could
+// still grow into one of the request's stop sequences, so a stop string
+// split across two backend chunks (e.g. "<|e" then "ot_id|>") never leaks
+// into the client-visible stream.
+type mlxStreamDecoder struct {
+	scanner *bufio.Scanner
+	stops   []string
+	pending string
+}
+
+func newMLXStreamDecoder(body io.Reader, stops []string) *mlxStreamDecoder {
+	return &mlxStreamDecoder{scanner: bufio.NewScanner(body), stops: stops}
+}
+
+// next decodes the next NDJSON line, applying stop-sequence buffering, and
+// reports ok=false once the stream is exhausted. Malformed lines are skipped
+// rather than surfaced as errors, matching how the MLX backend's occasional
+// heartbeat/log lines were tolerated before this decoder existed.
+func (d *mlxStreamDecoder) next() (mlxStreamChunk, bool, error) {
+	for d.scanner.Scan() {
+		var chunk mlxStreamChunk
+		if err := json.Unmarshal(d.scanner.Bytes(), &chunk); err != nil {
+			continue
+		}
+
+		d.pending += chunk.Content
+
+		var emit string
+		if chunk.Done {
+			emit = d.pending
+			d.pending = ""
+			if chunk.StopSequenceMatched != "" {
+				emit = strings.TrimSuffix(emit, chunk.StopSequenceMatched)
+			}
+		} else {
+			emit, d.pending = splitAtPendingStop(d.pending, d.stops)
+		}
+		chunk.Content = emit
+
+		return chunk, true, nil
+	}
+
+	return mlxStreamChunk{}, false, d.scanner.Err()
+}
+
+// splitAtPendingStop splits pending into the prefix that's safe to emit now
+// and the suffix that must be held back because it's a prefix of one of
+// stops and could still grow into a full match on a later chunk.
+//
+// When more than one stop string actually matches within pending, the
+// earliest match wins regardless of the stops' order: e.g. with
+// stops=["world","He"] and pending="Hello world", "He" matches at index 0
+// and "world" at index 6, so the cut must land at index 0 - generation
+// stopped there first, and anything after it (including "world") was never
+// meant to reach the client.
+func splitAtPendingStop(pending string, stops []string) (emit, hold string) {
+	matchIdx := -1
+	matchLen := 0
+	for _, stop := range stops {
+		if stop == "" {
+			continue
+		}
+		if idx := strings.Index(pending, stop); idx >= 0 && (matchIdx == -1 || idx < matchIdx) {
+			matchIdx = idx
+			matchLen = len(stop)
+		}
+	}
+	if matchIdx >= 0 {
+		return pending[:matchIdx], pending[matchIdx+matchLen:]
+	}
+
+	holdLen := 0
+	for _, stop := range stops {
+		if stop == "" {
+			continue
+		}
+		for n := minInt(len(stop)-1, len(pending)); n > holdLen; n-- {
+			if strings.HasSuffix(pending, stop[:n]) {
+				holdLen = n
+				break
+			}
+		}
+	}
+	return pending[:len(pending)-holdLen], pending[len(pending)-holdLen:]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// deadlineTimer implements independently re-armable read and write
+// deadlines as channels closed on expiry, the pattern gVisor's gonet.Conn
+// uses to let a blocked I/O loop select on a deadline instead of requiring
+// the underlying connection to support SetDeadline itself. A zero or
+// negative duration disarms the corresponding deadline.
+type deadlineTimer struct {
+	mu         sync.Mutex
+	readTimer  *time.Timer
+	readCh     chan struct{}
+	writeTimer *time.Timer
+	writeCh    chan struct{}
+}
+
+func newDeadlineTimer() *deadlineTimer {
+	return &deadlineTimer{readCh: make(chan struct{}), writeCh: make(chan struct{})}
+}
+
+// SetReadDeadline re-arms t's read deadline to close readDeadline() after d,
+// discarding any previously armed read deadline.
+func (t *deadlineTimer) SetReadDeadline(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.readTimer != nil {
+		t.readTimer.Stop()
+	}
+	t.readCh = make(chan struct{})
+	if d <= 0 {
+		t.readTimer = nil
+		return
+	}
+	ch := t.readCh
+	t.readTimer = time.AfterFunc(d, func() { close(ch) })
+}
+
+// SetWriteDeadline re-arms t's write deadline to close writeDeadline() after
+// d, discarding any previously armed write deadline.
+func (t *deadlineTimer) SetWriteDeadline(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.writeTimer != nil {
+		t.writeTimer.Stop()
+	}
+	t.writeCh = make(chan struct{})
+	if d <= 0 {
+		t.writeTimer = nil
+		return
+	}
+	ch := t.writeCh
+	t.writeTimer = time.AfterFunc(d, func() { close(ch) })
+}
+
+func (t *deadlineTimer) readDeadline() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.readCh
+}
+
+func (t *deadlineTimer) writeDeadline() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.writeCh
+}
+
+// mlxStreamTimeouts bounds one streaming completion's three independently
+// configurable deadlines, sourced from a generate/chat request's Options.
+// A zero field leaves that deadline disabled.
+type mlxStreamTimeouts struct {
+	ttft      time.Duration // time to first token
+	tokenIdle time.Duration // idle time allowed between tokens once generation has started
+	total     time.Duration // ceiling on the whole generation
+}
+
+// extractStreamTimeouts reads ttft_timeout/token_idle_timeout/total_timeout
+// (seconds) out of a generate/chat request's freeform Options map, the same
+// convention extractStopSequences uses for "stop".
+func extractStreamTimeouts(options map[string]interface{}) mlxStreamTimeouts {
+	return mlxStreamTimeouts{
+		ttft:      optionSeconds(options, "ttft_timeout"),
+		tokenIdle: optionSeconds(options, "token_idle_timeout"),
+		total:     optionSeconds(options, "total_timeout"),
+	}
+}
+
+func optionSeconds(options map[string]interface{}, key string) time.Duration {
+	raw, ok := options[key]
+	if !ok {
+		return 0
+	}
+
+	switch v := raw.(type) {
+	case float64:
+		if v > 0 {
+			return time.Duration(v * float64(time.Second))
+		}
+	case int:
+		if v > 0 {
+			return time.Duration(v) * time.Second
+		}
+	}
+	return 0
+}
+
+// extractStopSequences pulls the "stop" option out of a generate/chat
+// request's freeform Options map, tolerating the JSON shapes it can arrive
+// in: a single string or a list of strings.
+func extractStopSequences(options map[string]interface{}) []string {
+	raw, ok := options["stop"]
+	if !ok {
+		return nil
+	}
+
+	switch v := raw.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		stops := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				stops = append(stops, s)
+			}
+		}
+		return stops
+	default:
+		return nil
+	}
+}
+
+// sendMLXAbort notifies the MLX backend that the client went away so it can
+// stop generating rather than run the rest of the prompt for nobody. This is
+// best-effort: the runner may already be shutting down, and a failure here
+// must not block returning control to the caller.
+func sendMLXAbort(client *http.Client, port int) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/abort", port), nil)
+	if err != nil {
+		return
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.Warn("failed to notify MLX backend of client disconnect", "port", port, "error", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// sendMLXCancel is sendMLXAbort's job-scoped counterpart: POST
+// /cancel/{job_id} asks the runner to stop exactly that job's generation
+// (mapped to mlx.core.stop() on its active generation), which mlxservice's
+// worker pool needs when a client disconnects from a job sharing a runner
+// with others, where the bare /abort endpoint has no way to say which
+// generation to stop. Best-effort, same as sendMLXAbort.
+func sendMLXCancel(client *http.Client, port int, jobID string) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/cancel/%s", port, jobID), nil)
+	if err != nil {
+		return
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.Warn("failed to cancel MLX job", "port", port, "job_id", jobID, "error", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// wantsMLXEventStream reports whether the client asked for the SSE variant
+// of a streaming endpoint via the Accept header.
+func wantsMLXEventStream(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+}
+
+// writeMLXEventStreamChunk writes v as one `data: ...` SSE frame and flushes
+// it immediately so browser-based clients see tokens as they arrive.
+func writeMLXEventStreamChunk(w io.Writer, flusher http.Flusher, v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "data: %s\n\n", line); err != nil {
+		return err
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+	return nil
+}
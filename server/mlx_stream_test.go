@@ -0,0 +1,45 @@
+package server
+
+import "testing"
+
+func TestSplitAtPendingStopUsesEarliestMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		pending  string
+		stops    []string
+		wantEmit string
+		wantHold string
+	}{
+		{
+			name:     "earliest match wins regardless of stop order",
+			pending:  "Hello world",
+			stops:    []string{"world", "He"},
+			wantEmit: "",
+			wantHold: "llo world",
+		},
+		{
+			name:     "single stop still matches",
+			pending:  "Hello world",
+			stops:    []string{"world"},
+			wantEmit: "Hello ",
+			wantHold: "",
+		},
+		{
+			name:     "no match holds back a potential partial suffix",
+			pending:  "Hello w",
+			stops:    []string{"world"},
+			wantEmit: "Hello ",
+			wantHold: "w",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emit, hold := splitAtPendingStop(tt.pending, tt.stops)
+			if emit != tt.wantEmit || hold != tt.wantHold {
+				t.Errorf("splitAtPendingStop(%q, %v) = (%q, %q), want (%q, %q)",
+					tt.pending, tt.stops, emit, hold, tt.wantEmit, tt.wantHold)
+			}
+		})
+	}
+}
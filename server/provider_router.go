@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+)
+
+// providerRoute is one entry in the routing table loaded from
+// OLLAMA_PROVIDERS_CONFIG, keyed by the model-name prefix a request uses to
+// select it (e.g. "anthropic" for a model named "anthropic:claude-3-opus").
+// Fallback, when set, names another entry in the same table to retry
+// against if this provider returns a 5xx response or times out.
+type providerRoute struct {
+	BaseURL  string `json:"base_url"`
+	Fallback string `json:"fallback"`
+}
+
+type providerRouter struct {
+	routes map[string]providerRoute
+}
+
+var (
+	providerRouterOnce sync.Once
+	providerRouterInst *providerRouter
+)
+
+// getProviderRouter lazily loads OLLAMA_PROVIDERS_CONFIG on first use, the
+// same env-var-names-a-config-file convention OLLAMA_MCP_CONFIG and
+// OLLAMA_AGENTS_CONFIG use. An unset variable (or one that fails to load)
+// leaves the table empty, so every recognized prefix still routes to its
+// provider's default base URL with no fallback.
+func getProviderRouter() *providerRouter {
+	providerRouterOnce.Do(func() {
+		providerRouterInst = &providerRouter{routes: map[string]providerRoute{}}
+
+		path := os.Getenv("OLLAMA_PROVIDERS_CONFIG")
+		if path == "" {
+			return
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Error("failed to read providers config", "path", path, "error", err)
+			return
+		}
+		if err := json.Unmarshal(data, &providerRouterInst.routes); err != nil {
+			slog.Error("failed to parse providers config", "path", path, "error", err)
+		}
+	})
+	return providerRouterInst
+}
+
+// splitProviderPrefix splits a model name of the form "<prefix>:<model>"
+// into its routing prefix and the upstream model name to send that
+// provider, recognizing only the prefixes ollmlx has an adapter for.
+// Anything else - including a bare local model name, which never contains a
+// colon in practice - reports ok=false so getBackend falls through to its
+// existing OLLAMA_BACKEND_<MODEL>/local runner resolution.
+func splitProviderPrefix(model string) (prefix, upstreamModel string, ok bool) {
+	kind, rest, found := strings.Cut(model, ":")
+	if !found {
+		return "", "", false
+	}
+	switch kind {
+	case "mlx", "openai", "anthropic", "google":
+		return kind, rest, true
+	default:
+		return "", "", false
+	}
+}
+
+// stripMLXProviderPrefix removes a leading "mlx:" routing prefix from
+// model, if present, so every local-model-manager lookup (ModelExists,
+// GetModelInfo, resolveMLXAdaptersForModel, ...) and the downstream
+// getBackend call all agree on the same model name - "mlx:llama3" names
+// the same local model as "llama3", the prefix only disambiguates it from
+// a provider-routed model sharing that base name. Other provider prefixes
+// (openai:/anthropic:/google:) are left untouched: a model routed to one of
+// those already needs a local model entry under that literal prefixed
+// name, the same convention an OLLAMA_BACKEND_<MODEL>-routed model has
+// always required.
+func stripMLXProviderPrefix(model string) string {
+	if prefix, upstreamModel, ok := splitProviderPrefix(model); ok && prefix == "mlx" {
+		return upstreamModel
+	}
+	return model
+}
+
+// newRoutedProviderBackend builds the InferenceBackend for a request whose
+// model carried a recognized provider prefix, wrapping it in a
+// fallbackBackend when the routing table configures one.
+func newRoutedProviderBackend(prefix, upstreamModel string) (InferenceBackend, error) {
+	router := getProviderRouter()
+
+	backend, err := newProviderBackend(prefix, upstreamModel, router.routes[prefix])
+	if err != nil {
+		return nil, err
+	}
+
+	fallbackPrefix := router.routes[prefix].Fallback
+	if fallbackPrefix == "" {
+		return backend, nil
+	}
+
+	secondary, err := newProviderBackend(fallbackPrefix, upstreamModel, router.routes[fallbackPrefix])
+	if err != nil {
+		slog.Warn("ignoring invalid fallback provider", "prefix", prefix, "fallback", fallbackPrefix, "error", err)
+		return backend, nil
+	}
+	return &fallbackBackend{primary: backend, secondary: secondary}, nil
+}
+
+// newProviderBackend builds the InferenceBackend for prefix, pointing it at
+// route.BaseURL (each provider's default when empty).
+func newProviderBackend(prefix, upstreamModel string, route providerRoute) (InferenceBackend, error) {
+	switch prefix {
+	case "openai":
+		return newOpenAIBackend(upstreamModel, route.BaseURL), nil
+	case "anthropic":
+		return newAnthropicBackend(upstreamModel, route.BaseURL), nil
+	case "google":
+		return newGoogleBackend(upstreamModel, route.BaseURL), nil
+	default:
+		return nil, fmt.Errorf("unknown provider prefix %q", prefix)
+	}
+}
+
+// fallbackBackend wraps two InferenceBackends: every call goes to primary
+// first, and is retried once against secondary when primary's error looks
+// transient (see shouldFallback). It only covers a call failing outright -
+// once a Stream has started handing back chunks, a mid-stream failure is
+// not retried, since any content already sent to the client can't be
+// un-sent.
+type fallbackBackend struct {
+	primary   InferenceBackend
+	secondary InferenceBackend
+}
+
+func (b *fallbackBackend) Stream(ctx context.Context, req *api.GenerateRequest) (completionStream, error) {
+	stream, err := b.primary.Stream(ctx, req)
+	if !shouldFallback(ctx, err) {
+		return stream, err
+	}
+	return b.secondary.Stream(ctx, req)
+}
+
+func (b *fallbackBackend) Embed(ctx context.Context, input []string, opts EmbedOptions) (*EmbedResult, error) {
+	result, err := b.primary.Embed(ctx, input, opts)
+	if !shouldFallback(ctx, err) {
+		return result, err
+	}
+	return b.secondary.Embed(ctx, input, opts)
+}
+
+func (b *fallbackBackend) Rerank(ctx context.Context, query string, documents []string) ([]RerankScore, error) {
+	scores, err := b.primary.Rerank(ctx, query, documents)
+	if !shouldFallback(ctx, err) {
+		return scores, err
+	}
+	return b.secondary.Rerank(ctx, query, documents)
+}
+
+func (b *fallbackBackend) Close() {
+	b.primary.Close()
+	b.secondary.Close()
+}
+
+// shouldFallback reports whether err from the primary backend looks
+// transient enough to retry against the secondary: a 5xx backendHTTPError,
+// or a timeout - but never when ctx itself is already done, since a client
+// disconnect should propagate rather than trigger an equally pointless
+// second call.
+func shouldFallback(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var httpErr *backendHTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.status >= 500
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
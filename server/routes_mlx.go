@@ -1,7 +1,6 @@
 package server
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"crypto/sha256"
@@ -10,12 +9,14 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -69,23 +70,45 @@ func PullHuggingFaceModel(ctx context.Context, modelName string, fn func(api.Pro
 	return nil
 }
 
-type mlxCompletionChunk struct {
-	Content            string         `json:"content"`
-	Done               bool           `json:"done"`
-	DoneReason         string         `json:"done_reason"`
-	PromptEvalCount    int            `json:"prompt_eval_count"`
-	PromptEvalDuration time.Duration  `json:"prompt_eval_duration"`
-	EvalCount          int            `json:"eval_count"`
-	EvalDuration       time.Duration  `json:"eval_duration"`
-	Logprobs           any            `json:"logprobs"`
-	ToolCalls          []api.ToolCall `json:"tool_calls"`
-}
-
 var (
 	startMLXRunnerFunc = startMLXRunner
 	loadMLXModelFunc   = loadMLXModel
 )
 
+// resolveMLXAdaptersForModel loads the stored Modelfile configuration for name
+// (if any) and resolves its ADAPTER directives to absolute paths. Models created
+// without a Modelfile simply have no adapters to stack.
+func resolveMLXAdaptersForModel(name string) ([]llm.MLXAdapter, error) {
+	mf, err := llm.GetMLXModelConfig(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return llm.ResolveMLXAdapters(mf)
+}
+
+// applyMLXSamplerOptions rewrites options into the field names the MLX
+// backend expects (e.g. repetition_context_size instead of repeat_last_n),
+// folding in any MLX-only sampler extras (xtc_probability/xtc_threshold)
+// declared via the model's Modelfile PARAMETER directives. Both
+// generateMLXModel and chatMLXModel call this on their request's Options
+// before provisioning a runner, so min_p/typical_p/repetition_context_size/XTC
+// apply the same way to /api/generate and /api/chat.
+func applyMLXSamplerOptions(options map[string]interface{}, mf *llm.MLXModelfile) (map[string]interface{}, error) {
+	var opts api.Options
+	if len(options) > 0 {
+		data, err := json.Marshal(options)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &opts); err != nil {
+			return nil, err
+		}
+	}
+
+	return llm.ConvertOptionsToMLXFormat(opts, mf.Parameters), nil
+}
+
 type mlxRunnerEntry struct {
 	model     string
 	port      int
@@ -96,6 +119,36 @@ type mlxRunnerEntry struct {
 	err       error
 	lastUsed  time.Time
 	keepalive time.Duration
+
+	// deadline bounds the in-flight stream's time-to-first-token and
+	// per-token idle time (see mlxBackend.Stream / mlxCompletionStream).
+	// This runner serves one generation at a time, so a single shared
+	// deadlineTimer is sufficient.
+	deadline *deadlineTimer
+
+	// lastMessages is the resolved message history (conversation ancestors
+	// plus the new turn) the runner most recently served, used to compute a
+	// KV-cache reuse hint when a later request re-prompts a sibling branch.
+	// See mlxBackend.recordPromptPrefixHint.
+	lastMessages []api.Message
+
+	// draftModel, when non-empty, names the smaller MLX model this entry's
+	// runner loads alongside model to verify tokens against for speculative
+	// decoding (see resolveDraftModel). It's fixed for the entry's lifetime,
+	// the same as adapters: the first request to create the entry decides it
+	// for every request that shares the cached runner afterward.
+	draftModel string
+
+	// controlSynced closes once this runner's control-plane connection has
+	// received its first RunnerState push (see superviseControlPlane),
+	// gating new requests on that handshake the same way ready gates them
+	// on the runner process itself having come up.
+	controlSynced     chan struct{}
+	controlSyncedOnce sync.Once
+
+	controlMu   sync.Mutex
+	lastState   *llm.RunnerState
+	controlConn *controlPlaneConn
 }
 
 type mlxRunnerCache struct {
@@ -177,7 +230,46 @@ func (c *mlxRunnerCache) stopEntry(entry *mlxRunnerEntry) {
 	}
 }
 
-func (c *mlxRunnerCache) getRunner(ctx context.Context, model string, keepalive time.Duration) (*mlxRunnerEntry, error) {
+// gaugeSnapshot reports the live values behind the mlx_runners_active and
+// mlx_runners_idle_seconds metrics: how many runners are cached per model
+// (always 0 or 1 today, since getRunner keys entries by model), and how long
+// each has sat idle since its last request.
+func (c *mlxRunnerCache) gaugeSnapshot() (active map[string]int64, idleSeconds map[string]float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	active = make(map[string]int64, len(c.entries))
+	idleSeconds = make(map[string]float64, len(c.entries))
+	now := time.Now()
+	for model, entry := range c.entries {
+		active[model] = 1
+		idleSeconds[model] = now.Sub(entry.lastUsed).Seconds()
+	}
+	return active, idleSeconds
+}
+
+// Drain gracefully stops every currently cached MLX runner, so the server
+// doesn't leave runner subprocesses behind as orphans on shutdown. Each
+// entry's supervising goroutine (see RunnerSupervisor.Supervise) is
+// responsible for actually terminating its subprocess, SIGTERM before
+// SIGKILL, once its context is canceled here.
+func (c *mlxRunnerCache) Drain() {
+	c.mu.Lock()
+	entries := make([]*mlxRunnerEntry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, entry)
+	}
+	c.entries = make(map[string]*mlxRunnerEntry)
+	c.mu.Unlock()
+
+	for _, entry := range entries {
+		if entry.cancel != nil {
+			entry.cancel()
+		}
+	}
+}
+
+func (c *mlxRunnerCache) getRunner(ctx context.Context, model string, keepalive time.Duration, adapters []llm.MLXAdapter, draftModel string) (*mlxRunnerEntry, error) {
 	if keepalive < 0 {
 		keepalive = 0
 	}
@@ -198,16 +290,20 @@ func (c *mlxRunnerCache) getRunner(ctx context.Context, model string, keepalive
 			if entry.err != nil {
 				return nil, entry.err
 			}
+			waitForControlPlaneSync(ctx, entry)
 			return entry, nil
 		}
 	}
 
 	entry := &mlxRunnerEntry{
-		model:     model,
-		keepalive: keepalive,
-		ready:     make(chan struct{}),
-		lastUsed:  time.Now(),
-		client:    &http.Client{Timeout: 30 * time.Minute},
+		model:         model,
+		keepalive:     keepalive,
+		draftModel:    draftModel,
+		ready:         make(chan struct{}),
+		controlSynced: make(chan struct{}),
+		lastUsed:      time.Now(),
+		client:        &http.Client{Timeout: 30 * time.Minute},
+		deadline:      newDeadlineTimer(),
 	}
 	c.entries[model] = entry
 	c.mu.Unlock()
@@ -216,36 +312,13 @@ func (c *mlxRunnerCache) getRunner(ctx context.Context, model string, keepalive
 		bgCtx, cancel := context.WithCancel(context.Background())
 		entry.cancel = cancel
 
-		cmd, port, err := startMLXRunnerFunc(bgCtx, model)
-		if err != nil {
-			entry.err = err
-			close(entry.ready)
-			return
-		}
-		entry.cmd = cmd
-		entry.port = port
-
-		if err := entry.cmd.Start(); err != nil {
-			entry.err = err
-			close(entry.ready)
-			return
-		}
-
-		if err := waitForMLXRunner(bgCtx, entry.client, port); err != nil {
-			entry.err = err
-			_ = entry.cmd.Process.Kill()
-			close(entry.ready)
-			return
-		}
-
-		if err := loadMLXModelFunc(bgCtx, entry.client, port, model); err != nil {
-			entry.err = err
-			_ = entry.cmd.Process.Kill()
-			close(entry.ready)
+		mlxSupervisor.Launch(bgCtx, entry, adapters)
+		if entry.err != nil {
 			return
 		}
 
-		close(entry.ready)
+		go superviseControlPlane(bgCtx, entry)
+		mlxSupervisor.Supervise(bgCtx, entry, adapters)
 	}()
 
 	select {
@@ -256,12 +329,15 @@ func (c *mlxRunnerCache) getRunner(ctx context.Context, model string, keepalive
 			c.evict(model)
 			return nil, entry.err
 		}
+		waitForControlPlaneSync(ctx, entry)
 		return entry, nil
 	}
 }
 
 var mlxRunnerPool = newMLXRunnerCache()
 
+var mlxSupervisor = NewRunnerSupervisor(newSupervisorMetrics())
+
 func startMLXRunner(ctx context.Context, modelName string) (*exec.Cmd, int, error) {
 	l, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -277,52 +353,9 @@ func startMLXRunner(ctx context.Context, modelName string) (*exec.Cmd, int, erro
 
 	args := []string{"--mlx-engine", "-model", modelName, "-port", strconv.Itoa(port)}
 
-	// Determine Python path
-	pythonPath := "python3"
-	if p := os.Getenv("OLLAMA_PYTHON"); p != "" {
-		pythonPath = p
-	} else {
-		home, err := os.UserHomeDir()
-		if err == nil {
-			// Priority 1: Application Support (Ollmlx.app standard)
-			appSupport := filepath.Join(home, "Library", "Application Support", "Ollmlx", "venv", "bin", "python3")
-			// Priority 2: Dotfile (Legacy/Dev)
-			dotFile := filepath.Join(home, ".ollmlx", "venv", "bin", "python3")
-
-			if _, err := os.Stat(appSupport); err == nil {
-				pythonPath = appSupport
-			} else if _, err := os.Stat(dotFile); err == nil {
-				pythonPath = dotFile
-			} else {
-				// Bootstrap?
-				// If we are in an App Bundle, we should try to bootstrap the venv in Application Support
-				exe, _ := os.Executable()
-				resourcesReqs := filepath.Join(filepath.Dir(exe), "../Resources/mlx_backend/requirements.txt")
-				if _, err := os.Stat(resourcesReqs); err == nil {
-					// We are likely in an App Bundle and have requirements available
-					slog.Info("bootstrapping python environment in Application Support", "requirements", resourcesReqs)
-					venvDir := filepath.Join(home, "Library", "Application Support", "Ollmlx", "venv")
-					
-					// 1. Create venv
-					if err := exec.Command("python3", "-m", "venv", venvDir).Run(); err == nil {
-						// 2. Install deps
-						pip := filepath.Join(venvDir, "bin", "pip")
-						if err := exec.Command(pip, "install", "-r", resourcesReqs).Run(); err == nil {
-							pythonPath = filepath.Join(venvDir, "bin", "python3")
-							slog.Info("bootstrap complete", "python", pythonPath)
-						} else {
-							slog.Error("failed to install dependencies during bootstrap")
-						}
-					} else {
-						slog.Error("failed to create venv during bootstrap")
-					}
-				}
-			}
-		}
-	}
-
-	// append python path arg
-	args = append(args, "-python", pythonPath)
+	// Resolving (and possibly bootstrapping) the Python interpreter is the
+	// supervisor's job now: see RunnerSupervisor.resolvePython.
+	args = append(args, "-python", mlxSupervisor.resolvePython())
 
 	cmd := exec.CommandContext(ctx, bin, args...)
 	cmd.Stdout = os.Stdout
@@ -476,8 +509,21 @@ func waitForMLXRunner(ctx context.Context, client *http.Client, port int) error
 	return fmt.Errorf("mlx runner did not become healthy")
 }
 
-func loadMLXModel(ctx context.Context, client *http.Client, port int, modelName string) error {
-	body, _ := json.Marshal(map[string]string{"model": modelName})
+// loadMLXModel tells the runner at port to load modelName, optionally
+// stacking adapters on top of it. When draftModel is non-empty, draftPort
+// names the already-running runner serving it, so the Python backend can
+// connect to it directly and verify that model's tokens against modelName
+// in batches instead of generating every token itself.
+func loadMLXModel(ctx context.Context, client *http.Client, port int, modelName string, adapters []llm.MLXAdapter, draftModel string, draftPort int) error {
+	payload := map[string]any{"model": modelName}
+	if len(adapters) > 0 {
+		payload["adapters"] = adapters
+	}
+	if draftModel != "" {
+		payload["draft_model"] = draftModel
+		payload["draft_port"] = draftPort
+	}
+	body, _ := json.Marshal(payload)
 	resp, err := client.Post(fmt.Sprintf("http://127.0.0.1:%d/load", port), "application/json", bytes.NewReader(body))
 	if err != nil {
 		return err
@@ -495,226 +541,148 @@ func loadMLXModel(ctx context.Context, client *http.Client, port int, modelName
 	return nil
 }
 
-func streamMLXCompletion(ctx context.Context, c *gin.Context, client *http.Client, port int, req *api.GenerateRequest) error {
-	requestBody, err := json.Marshal(req)
-	if err != nil {
-		return err
-	}
-
-	resp, err := client.Post(fmt.Sprintf("http://127.0.0.1:%d/completion", port), "application/json", bytes.NewReader(requestBody))
+// streamMLXChat streams one generation turn's response as NDJSON chunks to
+// c. suppressTerminalDone is set by the auto tool-execution loop (see
+// tool_auto_exec.go) for every hop but its last: when the backend's final
+// chunk carries tool calls, the wire chunk is written with done=false
+// instead of true, since the loop is about to execute those calls and
+// re-prompt the model itself rather than hand the response back to the
+// client yet.
+func streamMLXChat(ctx context.Context, c *gin.Context, backend InferenceBackend, req *api.ChatRequest, genReq *api.GenerateRequest, suppressTerminalDone bool) (string, []api.ToolCall, error) {
+	stream, err := backend.Stream(ctx, genReq)
 	if err != nil {
-		return err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		defer resp.Body.Close()
-		msg, err := io.ReadAll(resp.Body)
-		if err != nil {
-			slog.Error("failed to read MLX backend completion response", "error", err)
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to read backend response"})
-			return nil
+		var httpErr *backendHTTPError
+		if errors.As(err, &httpErr) {
+			c.AbortWithStatusJSON(httpErr.status, gin.H{"error": httpErr.message})
+			return "", nil, nil
 		}
-		c.AbortWithStatusJSON(resp.StatusCode, gin.H{"error": strings.TrimSpace(string(msg))})
-		return nil
+		return "", nil, err
 	}
 
-	defer resp.Body.Close()
 	c.Header("Content-Type", "application/x-ndjson")
 	c.Status(http.StatusOK)
 
-	scanner := bufio.NewScanner(resp.Body)
 	flusher, _ := c.Writer.(http.Flusher)
 	created := time.Now().UTC()
+	var visible strings.Builder
+	var detectedToolCalls []api.ToolCall
+	parser := newToolCallParser(detectMLXChatTemplate(req.Model))
 
-	for scanner.Scan() {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
+	writeChunk := func(contentOut string, toolCalls []api.ToolCall, chunk mlxStreamChunk) {
+		visible.WriteString(contentOut)
 
-		var chunk mlxCompletionChunk
-		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
-			continue
+		wireDone, wireDoneReason := chunk.Done, chunk.DoneReason
+		if wireDone && len(toolCalls) > 0 && suppressTerminalDone {
+			wireDone, wireDoneReason = false, ""
 		}
 
-		out := api.GenerateResponse{
+		respMsg := api.Message{Role: "assistant", Content: contentOut, ToolCalls: toolCalls}
+		chatResp := api.ChatResponse{
 			Model:      req.Model,
 			CreatedAt:  created,
-			Response:   chunk.Content,
-			Done:       chunk.Done,
-			DoneReason: chunk.DoneReason,
-			Metrics: api.Metrics{
-				PromptEvalCount:    chunk.PromptEvalCount,
-				PromptEvalDuration: chunk.PromptEvalDuration,
-				EvalCount:          chunk.EvalCount,
-				EvalDuration:       chunk.EvalDuration,
-			},
+			Message:    respMsg,
+			Done:       wireDone,
+			DoneReason: wireDoneReason,
+			Metrics:    chunk.metrics(),
 		}
 
-		line, _ := json.Marshal(out)
+		line, _ := json.Marshal(chatResp)
 		c.Writer.Write(line)
 		c.Writer.Write([]byte("\n"))
 		if flusher != nil {
 			flusher.Flush()
 		}
-
-		if chunk.Done {
-			break
-		}
-	}
-
-	return scanner.Err()
-}
-
-func streamMLXChat(ctx context.Context, c *gin.Context, client *http.Client, port int, req *api.ChatRequest, genReq *api.GenerateRequest) ([]api.ToolCall, error) {
-	requestBody, err := json.Marshal(genReq)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := client.Post(fmt.Sprintf("http://127.0.0.1:%d/completion", port), "application/json", bytes.NewReader(requestBody))
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		defer resp.Body.Close()
-		msg, err := io.ReadAll(resp.Body)
-		if err != nil {
-			slog.Error("failed to read MLX backend chat completion response", "error", err)
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to read backend response"})
-			return nil, nil
-		}
-		c.AbortWithStatusJSON(resp.StatusCode, gin.H{"error": strings.TrimSpace(string(msg))})
-		return nil, nil
 	}
 
-	defer resp.Body.Close()
-	c.Header("Content-Type", "application/x-ndjson")
-	c.Status(http.StatusOK)
-
-	scanner := bufio.NewScanner(resp.Body)
-	flusher, _ := c.Writer.(http.Flusher)
-	created := time.Now().UTC()
-	var full strings.Builder
-	var detectedToolCalls []api.ToolCall
-
-	for scanner.Scan() {
+	for {
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			stream.Abort()
+			return visible.String(), detectedToolCalls, ctx.Err()
 		default:
 		}
 
-		var chunk mlxCompletionChunk
-		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
-			continue
+		chunk, ok, err := stream.Next()
+		if err != nil {
+			return visible.String(), detectedToolCalls, err
+		}
+		if !ok {
+			return visible.String(), detectedToolCalls, nil
 		}
 
-		full.WriteString(chunk.Content)
-		contentOut := chunk.Content
+		var contentOut string
 		toolCalls := chunk.ToolCalls
-		if len(toolCalls) == 0 && chunk.Done {
-			if calls, ok := parseToolCallsFromText(full.String()); ok {
+		if len(toolCalls) > 0 {
+			// The backend already extracted structured tool calls itself;
+			// don't run our own text-based detection over this chunk, and
+			// let the model's own text through untouched.
+			contentOut = chunk.Content
+			detectedToolCalls = toolCalls
+		} else {
+			emit, calls, _ := parser.Feed(chunk.Content)
+			contentOut = emit
+			if len(calls) > 0 {
 				toolCalls = calls
-				detectedToolCalls = calls
-				// FIX: Extract non-JSON content to preserve reasoning/text
-				// Only clear content if it's purely JSON, otherwise keep it
-				fullText := full.String()
-				jsonStart := strings.Index(fullText, "{")
-				if jsonStart > 0 {
-					// There's text before the JSON - preserve it
-					contentOut = strings.TrimSpace(fullText[:jsonStart])
-				} else {
-					// Content is just JSON tool call, no need to show it again
-					contentOut = ""
-				}
+				detectedToolCalls = append(detectedToolCalls, calls...)
 			}
-		} else if len(toolCalls) > 0 {
-			// Tool calls came from backend - preserve any reasoning text
-			detectedToolCalls = toolCalls
-			// Don't clear contentOut - let the model's response text through
 		}
 
-		respMsg := api.Message{Role: "assistant", Content: contentOut, ToolCalls: toolCalls}
-		chatResp := api.ChatResponse{
-			Model:      req.Model,
-			CreatedAt:  created,
-			Message:    respMsg,
-			Done:       chunk.Done,
-			DoneReason: chunk.DoneReason,
-			Metrics: api.Metrics{
-				PromptEvalCount:    chunk.PromptEvalCount,
-				PromptEvalDuration: chunk.PromptEvalDuration,
-				EvalCount:          chunk.EvalCount,
-				EvalDuration:       chunk.EvalDuration,
-			},
+		if chunk.Done && len(chunk.ToolCalls) == 0 {
+			flushed, calls, _ := parser.Feed("")
+			contentOut += flushed
+			if len(calls) > 0 {
+				toolCalls = append(toolCalls, calls...)
+				detectedToolCalls = append(detectedToolCalls, calls...)
+			}
 		}
 
-		line, _ := json.Marshal(chatResp)
-		c.Writer.Write(line)
-		c.Writer.Write([]byte("\n"))
-		if flusher != nil {
-			flusher.Flush()
-		}
+		writeChunk(contentOut, toolCalls, chunk)
 
 		if chunk.Done {
-			break
+			return visible.String(), detectedToolCalls, nil
 		}
 	}
-
-	return detectedToolCalls, scanner.Err()
 }
 
-func collectMLXCompletion(ctx context.Context, client *http.Client, port int, req *api.GenerateRequest) (*api.GenerateResponse, error) {
-	requestBody, err := json.Marshal(req)
+func collectMLXCompletion(ctx context.Context, backend InferenceBackend, req *api.GenerateRequest) (*api.GenerateResponse, error) {
+	stream, err := backend.Stream(ctx, req)
 	if err != nil {
-		return nil, err
-	}
-
-	resp, err := client.Post(fmt.Sprintf("http://127.0.0.1:%d/completion", port), "application/json", bytes.NewReader(requestBody))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		msg, err := io.ReadAll(resp.Body)
-		if err != nil {
-			slog.Error("failed to read MLX backend completion response", "error", err)
-			return nil, fmt.Errorf("failed to read backend response: %w", err)
+		var httpErr *backendHTTPError
+		if errors.As(err, &httpErr) {
+			return nil, fmt.Errorf("completion failed: %s", httpErr.message)
 		}
-		return nil, fmt.Errorf("completion failed: %s", strings.TrimSpace(string(msg)))
+		return nil, err
 	}
 
-	scanner := bufio.NewScanner(resp.Body)
 	created := time.Now().UTC()
 	var buf strings.Builder
-	var last mlxCompletionChunk
+	var last mlxStreamChunk
 	var toolCalls []api.ToolCall
 
-	for scanner.Scan() {
+	for {
 		select {
 		case <-ctx.Done():
+			stream.Abort()
 			return nil, ctx.Err()
 		default:
 		}
 
-		if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
-			continue
-		}
-		if len(last.ToolCalls) > 0 {
-			toolCalls = last.ToolCalls
+		chunk, ok, err := stream.Next()
+		if err != nil {
+			return nil, err
 		}
-		buf.WriteString(last.Content)
-		if last.Done {
+		if !ok {
 			break
 		}
-	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+		last = chunk
+		if len(chunk.ToolCalls) > 0 {
+			toolCalls = chunk.ToolCalls
+		}
+		buf.WriteString(chunk.Content)
+		if chunk.Done {
+			break
+		}
 	}
 
 	if len(toolCalls) > 0 && buf.Len() == 0 {
@@ -729,77 +697,122 @@ func collectMLXCompletion(ctx context.Context, client *http.Client, port int, re
 		Response:   buf.String(),
 		Done:       true,
 		DoneReason: last.DoneReason,
-		Metrics: api.Metrics{
-			PromptEvalCount:    last.PromptEvalCount,
-			PromptEvalDuration: last.PromptEvalDuration,
-			EvalCount:          last.EvalCount,
-			EvalDuration:       last.EvalDuration,
-		},
+		Metrics:    last.metrics(),
 	}, nil
 }
 
-// executeToolCalls will synchronously execute provided tool calls using the
-// tool definitions supplied in the request. Currently supports HTTP-style
-// tools where the tool's Items is either a string URL or a map containing a
-// "url" key. The function returns a human-readable aggregation of tool outputs.
+// executeToolCalls will synchronously execute provided tool calls via
+// executeOneToolCall, aggregating their outputs into one human-readable
+// block.
 func executeToolCalls(ctx context.Context, tools api.Tools, calls []api.ToolCall) (string, error) {
 	var sb strings.Builder
 	for _, call := range calls {
-		name := call.Function.Name
-		var tool *api.Tool
-		for i := range tools {
-			if tools[i].Function.Name == name {
-				tool = &tools[i]
-				break
-			}
-		}
-		if tool == nil {
-			return "", fmt.Errorf("tool %s not found", name)
+		result, err := executeOneToolCall(ctx, tools, call)
+		if err != nil {
+			return "", err
 		}
+		sb.WriteString(fmt.Sprintf("Tool %s response:\n%s\n\n", call.Function.Name, result))
+	}
+	return sb.String(), nil
+}
 
-		// Determine endpoint URL for the tool. Support either a string or
-		// an object with a "url" field in Items.
-		var url string
-		switch v := tool.Items.(type) {
-		case string:
-			url = v
-		case map[string]any:
-			if u, ok := v["url"].(string); ok {
-				url = u
-			}
-		default:
-			// attempt to marshal/unmarshal to map to be tolerant
-			var maybe map[string]any
-			b, _ := json.Marshal(v)
-			_ = json.Unmarshal(b, &maybe)
-			if u, ok := maybe["url"].(string); ok {
-				url = u
-			}
-		}
+// executeOneToolCall runs a single tool call: a built-in toolbox
+// implementation (see toolbox.go) first, then a call whose name is owned
+// by a configured MCP server (see mcp.go) dispatched there via tools/call,
+// and finally the legacy bespoke convention where the tool's Items is
+// either a string URL or a map containing a "url" key.
+func executeOneToolCall(ctx context.Context, tools api.Tools, call api.ToolCall) (string, error) {
+	name := call.Function.Name
 
-		if url == "" {
-			return "", fmt.Errorf("tool %s has no url configured in Items", name)
-		}
+	if output, ok, err := getToolbox().call(ctx, name, call.Function.Arguments); ok {
+		return output, err
+	}
 
-		// Prepare HTTP request with the arguments as JSON
-		body, _ := json.Marshal(call.Function.Arguments)
-		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	registry := getMCPRegistry(ctx)
+	if client := registry.ownerOf(ctx, name); client != nil {
+		content, err := client.callTool(ctx, name, call.Function.Arguments)
 		if err != nil {
-			return "", fmt.Errorf("create request for tool %s: %w", name, err)
+			return "", err
 		}
-		req.Header.Set("Content-Type", "application/json")
+		return formatMCPContent(content), nil
+	}
 
-		client := &http.Client{Timeout: 30 * time.Second}
-		resp, err := client.Do(req)
-		if err != nil {
-			return "", fmt.Errorf("tool %s request failed: %w", name, err)
+	var tool *api.Tool
+	for i := range tools {
+		if tools[i].Function.Name == name {
+			tool = &tools[i]
+			break
+		}
+	}
+	if tool == nil {
+		return "", fmt.Errorf("tool %s not found", name)
+	}
+
+	// Determine endpoint URL for the tool. Support either a string or
+	// an object with a "url" field in Items.
+	var url string
+	switch v := tool.Items.(type) {
+	case string:
+		url = v
+	case map[string]any:
+		if u, ok := v["url"].(string); ok {
+			url = u
+		}
+	default:
+		// attempt to marshal/unmarshal to map to be tolerant
+		var maybe map[string]any
+		b, _ := json.Marshal(v)
+		_ = json.Unmarshal(b, &maybe)
+		if u, ok := maybe["url"].(string); ok {
+			url = u
 		}
-		respBody, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
+	}
 
-		sb.WriteString(fmt.Sprintf("Tool %s response:\n%s\n\n", name, strings.TrimSpace(string(respBody))))
+	if url == "" {
+		return "", fmt.Errorf("tool %s has no url configured in Items", name)
 	}
-	return sb.String(), nil
+
+	// Prepare HTTP request with the arguments as JSON
+	body, _ := json.Marshal(call.Function.Arguments)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("create request for tool %s: %w", name, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("tool %s request failed: %w", name, err)
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	return strings.TrimSpace(string(respBody)), nil
+}
+
+// formatMCPContent renders a tools/call result's content blocks as text for
+// executeToolCalls' aggregated output. Image and resource blocks are
+// summarized rather than inlined, since the aggregation is plain text fed
+// back into the model's prompt.
+func formatMCPContent(blocks []mcpContentBlock) string {
+	var sb strings.Builder
+	for i, b := range blocks {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		switch b.Type {
+		case "text":
+			sb.WriteString(b.Text)
+		case "image":
+			sb.WriteString(fmt.Sprintf("[image: %s]", b.MimeType))
+		case "resource":
+			sb.WriteString(fmt.Sprintf("[resource: %v]", b.Resource))
+		default:
+			sb.WriteString(fmt.Sprintf("[%s content]", b.Type))
+		}
+	}
+	return sb.String()
 }
 
 func toolPromptBlock(tools api.Tools) string {
@@ -917,36 +930,75 @@ func getImageToken(modelName string, imageIndex int) string {
 }
 
 func formatChatPrompt(messages []api.Message, tools api.Tools) string {
-	return formatChatPromptWithModel(messages, tools, "")
+	return formatChatPromptWithModel(messages, tools, "", "")
 }
 
-func formatChatPromptWithModel(messages []api.Message, tools api.Tools, modelName string) string {
+// formatChatPromptWithModel formats messages into modelName's prompt
+// format. systemPrompt, when non-empty, overrides that family's default
+// system message - e.g. an agent's configured SystemPrompt (see agent.go)
+// in place of each format*Prompt's own default ("You are a helpful
+// assistant." for most families, "You are a helpful AI assistant." for
+// SmolLM; Gemma adds no system text unless one is given).
+func formatChatPromptWithModel(messages []api.Message, tools api.Tools, modelName, systemPrompt string) string {
+	if entry := loadMLXChatTemplate(modelName); entry != nil {
+		msgs := messages
+		if systemPrompt != "" {
+			msgs = withSystemPrompt(messages, systemPrompt)
+		}
+		if prompt, ok := renderMLXChatTemplate(entry, msgs, tools); ok {
+			return prompt
+		}
+	}
+
 	template := detectMLXChatTemplate(modelName)
 
 	switch template {
 	case TemplateQwen:
-		return formatQwenPrompt(messages, tools, modelName)
+		return formatQwenPrompt(messages, tools, modelName, systemPrompt)
 	case TemplateLlama:
-		return formatLlamaPrompt(messages, tools, modelName)
+		return formatLlamaPrompt(messages, tools, modelName, systemPrompt)
 	case TemplateMistral:
-		return formatMistralPrompt(messages, tools, modelName)
+		return formatMistralPrompt(messages, tools, modelName, systemPrompt)
 	case TemplatePhi:
-		return formatPhiPrompt(messages, tools, modelName)
+		return formatPhiPrompt(messages, tools, modelName, systemPrompt)
 	case TemplateGemma:
-		return formatGemmaPrompt(messages, tools, modelName)
+		return formatGemmaPrompt(messages, tools, modelName, systemPrompt)
 	case TemplateSmolLM:
-		return formatSmolLMPrompt(messages, tools, modelName)
+		return formatSmolLMPrompt(messages, tools, modelName, systemPrompt)
 	default:
-		return formatChatMLPrompt(messages, tools, modelName)
+		return formatChatMLPrompt(messages, tools, modelName, systemPrompt)
 	}
 }
 
+// withSystemPrompt returns messages with systemPrompt as its system
+// message: replacing a leading system message's content if one exists, or
+// prepending a new one otherwise. Used to carry an agent's SystemPrompt
+// override into a model's own Jinja chat_template, which (unlike the
+// hard-coded format*Prompt functions) has no separate "default system
+// text" parameter - the system message is the only lever it exposes.
+func withSystemPrompt(messages []api.Message, systemPrompt string) []api.Message {
+	if len(messages) > 0 && messages[0].Role == "system" {
+		out := make([]api.Message, len(messages))
+		copy(out, messages)
+		out[0].Content = systemPrompt
+		return out
+	}
+
+	out := make([]api.Message, 0, len(messages)+1)
+	out = append(out, api.Message{Role: "system", Content: systemPrompt})
+	return append(out, messages...)
+}
+
 // formatQwenPrompt formats messages using Qwen's chat template
-func formatQwenPrompt(messages []api.Message, tools api.Tools, modelName string) string {
+func formatQwenPrompt(messages []api.Message, tools api.Tools, modelName, systemPrompt string) string {
 	var b strings.Builder
+	if systemPrompt == "" {
+		systemPrompt = "You are a helpful assistant."
+	}
 
 	// System message with tools
-	b.WriteString("<|im_start|>system\nYou are a helpful assistant.")
+	b.WriteString("<|im_start|>system\n")
+	b.WriteString(systemPrompt)
 	if len(tools) > 0 {
 		b.WriteString(" ")
 		b.WriteString(toolPromptBlock(tools))
@@ -974,10 +1026,13 @@ func formatQwenPrompt(messages []api.Message, tools api.Tools, modelName string)
 }
 
 // formatLlamaPrompt formats messages using Llama's chat template
-func formatLlamaPrompt(messages []api.Message, tools api.Tools, modelName string) string {
+func formatLlamaPrompt(messages []api.Message, tools api.Tools, modelName, systemPrompt string) string {
 	var b strings.Builder
 	lower := strings.ToLower(modelName)
 	isLlama3 := strings.Contains(lower, "llama-3") || strings.Contains(lower, "llama3")
+	if systemPrompt == "" {
+		systemPrompt = "You are a helpful assistant."
+	}
 
 	if isLlama3 {
 		// Llama 3 format
@@ -985,7 +1040,7 @@ func formatLlamaPrompt(messages []api.Message, tools api.Tools, modelName string
 
 		// System message
 		b.WriteString("<|start_header_id|>system<|end_header_id|>\n\n")
-		b.WriteString("You are a helpful assistant.")
+		b.WriteString(systemPrompt)
 		if len(tools) > 0 {
 			b.WriteString(" ")
 			b.WriteString(toolPromptBlock(tools))
@@ -1009,7 +1064,8 @@ func formatLlamaPrompt(messages []api.Message, tools api.Tools, modelName string
 		b.WriteString("<|start_header_id|>assistant<|end_header_id|>\n\n")
 	} else {
 		// Llama 2 format
-		b.WriteString("[INST] <<SYS>>\nYou are a helpful assistant.")
+		b.WriteString("[INST] <<SYS>>\n")
+		b.WriteString(systemPrompt)
 		if len(tools) > 0 {
 			b.WriteString(" ")
 			b.WriteString(toolPromptBlock(tools))
@@ -1040,13 +1096,16 @@ func formatLlamaPrompt(messages []api.Message, tools api.Tools, modelName string
 }
 
 // formatMistralPrompt formats messages using Mistral's chat template
-func formatMistralPrompt(messages []api.Message, tools api.Tools, modelName string) string {
+func formatMistralPrompt(messages []api.Message, tools api.Tools, modelName, systemPrompt string) string {
 	var b strings.Builder
 
 	b.WriteString("<s>")
 
 	// Combine system message with first user message if present
-	sysMsg := "You are a helpful assistant."
+	sysMsg := systemPrompt
+	if sysMsg == "" {
+		sysMsg = "You are a helpful assistant."
+	}
 	if len(tools) > 0 {
 		sysMsg += " " + toolPromptBlock(tools)
 	}
@@ -1076,11 +1135,15 @@ func formatMistralPrompt(messages []api.Message, tools api.Tools, modelName stri
 }
 
 // formatPhiPrompt formats messages using Phi's chat template
-func formatPhiPrompt(messages []api.Message, tools api.Tools, modelName string) string {
+func formatPhiPrompt(messages []api.Message, tools api.Tools, modelName, systemPrompt string) string {
 	var b strings.Builder
+	if systemPrompt == "" {
+		systemPrompt = "You are a helpful assistant."
+	}
 
 	// System message
-	b.WriteString("<|system|>\nYou are a helpful assistant.")
+	b.WriteString("<|system|>\n")
+	b.WriteString(systemPrompt)
 	if len(tools) > 0 {
 		b.WriteString(" ")
 		b.WriteString(toolPromptBlock(tools))
@@ -1107,9 +1170,13 @@ func formatPhiPrompt(messages []api.Message, tools api.Tools, modelName string)
 }
 
 // formatGemmaPrompt formats messages using Gemma's chat template
-func formatGemmaPrompt(messages []api.Message, tools api.Tools, modelName string) string {
+func formatGemmaPrompt(messages []api.Message, tools api.Tools, modelName, systemPrompt string) string {
 	var b strings.Builder
 
+	// Gemma has no dedicated system turn, so fold systemPrompt into the
+	// first user turn, the same place tools are folded in below.
+	firstUser := true
+
 	// Gemma uses a simpler format
 	for _, m := range messages {
 		if m.Role == "user" {
@@ -1118,6 +1185,11 @@ func formatGemmaPrompt(messages []api.Message, tools api.Tools, modelName string
 				b.WriteString(getImageToken(modelName, i))
 				b.WriteString("\n")
 			}
+			if firstUser && systemPrompt != "" {
+				b.WriteString(systemPrompt)
+				b.WriteString("\n\n")
+			}
+			firstUser = false
 			b.WriteString(m.Content)
 			if len(tools) > 0 {
 				b.WriteString("\n\n")
@@ -1138,11 +1210,15 @@ func formatGemmaPrompt(messages []api.Message, tools api.Tools, modelName string
 }
 
 // formatSmolLMPrompt formats messages using SmolLM's chat template
-func formatSmolLMPrompt(messages []api.Message, tools api.Tools, modelName string) string {
+func formatSmolLMPrompt(messages []api.Message, tools api.Tools, modelName, systemPrompt string) string {
 	var b strings.Builder
+	if systemPrompt == "" {
+		systemPrompt = "You are a helpful AI assistant."
+	}
 
 	// SmolLM uses ChatML-like format
-	b.WriteString("<|im_start|>system\nYou are a helpful AI assistant.")
+	b.WriteString("<|im_start|>system\n")
+	b.WriteString(systemPrompt)
 	if len(tools) > 0 {
 		b.WriteString(" ")
 		b.WriteString(toolPromptBlock(tools))
@@ -1167,11 +1243,15 @@ func formatSmolLMPrompt(messages []api.Message, tools api.Tools, modelName strin
 }
 
 // formatChatMLPrompt is the default fallback using ChatML format
-func formatChatMLPrompt(messages []api.Message, tools api.Tools, modelName string) string {
+func formatChatMLPrompt(messages []api.Message, tools api.Tools, modelName, systemPrompt string) string {
 	var b strings.Builder
+	if systemPrompt == "" {
+		systemPrompt = "You are a helpful assistant."
+	}
 
 	// System message with tools
-	b.WriteString("<|im_start|>system\nYou are a helpful assistant.")
+	b.WriteString("<|im_start|>system\n")
+	b.WriteString(systemPrompt)
 	if len(tools) > 0 {
 		b.WriteString(" ")
 		b.WriteString(toolPromptBlock(tools))
@@ -1321,7 +1401,11 @@ func parseToolCallsFromText(text string) ([]api.ToolCall, bool) {
 	return nil, false
 }
 
-// ListMLXModels returns all locally cached MLX models
+// ListMLXModels returns all locally cached MLX models, plus any model
+// created via CreateMLXModelFromModelfile under a custom name that isn't
+// itself a literal download directory (e.g. "ollmlx create mymodel -f
+// Modelfile"), so MLX models created from a Modelfile appear in the standard
+// Ollama CLI listing alongside raw downloaded ones.
 func ListMLXModels() ([]api.ListModelResponse, error) {
 	manager := llm.NewMLXModelManager()
 
@@ -1331,7 +1415,9 @@ func ListMLXModels() ([]api.ListModelResponse, error) {
 	}
 
 	var models []api.ListModelResponse
+	seen := make(map[string]bool, len(mlxModels))
 	for _, m := range mlxModels {
+		seen[m.Name] = true
 		models = append(models, api.ListModelResponse{
 			Model:      m.Name,
 			Name:       m.Name,
@@ -1347,6 +1433,25 @@ func ListMLXModels() ([]api.ListModelResponse, error) {
 		})
 	}
 
+	tagged, err := llm.ListMLXModels()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tagged {
+		if seen[t.Name] {
+			continue
+		}
+		models = append(models, api.ListModelResponse{
+			Model:      t.Name,
+			Name:       t.Name,
+			Digest:     t.Digest,
+			ModifiedAt: t.ModifiedAt,
+			Details: api.ModelDetails{
+				Format: "MLX",
+			},
+		})
+	}
+
 	return models, nil
 }
 
@@ -1362,13 +1467,29 @@ func ShowMLXModel(modelName string) (*api.ShowResponse, error) {
 		return nil, err
 	}
 
+	modelInfo := map[string]any{
+		"general.architecture":       "mlx",
+		"general.family":             info.Family,
+		"general.parameter_count":    float64(parseParameterCount(info.ParameterSize)),
+		"general.quantization_level": info.QuantizLevel,
+	}
+
+	if info.LayoutFingerprint != "" {
+		modelInfo["general.layout_fingerprint"] = string(info.LayoutFingerprint)
+	}
+	if len(info.ContentDigest) > 0 {
+		modelInfo["general.content_digest"] = info.ContentDigest
+	}
+
+	// If modelName was created via a Modelfile, surface its manifest digest
+	// and FROM target alongside the raw download directory's metadata.
+	if mf, digest, err := llm.ShowMLXModel(modelName); err == nil {
+		modelInfo["general.manifest_digest"] = digest
+		modelInfo["general.from"] = mf.From
+	}
+
 	return &api.ShowResponse{
-		ModelInfo: map[string]any{
-			"general.architecture":       "mlx",
-			"general.family":             info.Family,
-			"general.parameter_count":    float64(parseParameterCount(info.ParameterSize)),
-			"general.quantization_level": info.QuantizLevel,
-		},
+		ModelInfo:  modelInfo,
 		ModifiedAt: info.ModifiedAt,
 		Details: api.ModelDetails{
 			Format:            "MLX",
@@ -1379,9 +1500,15 @@ func ShowMLXModel(modelName string) (*api.ShowResponse, error) {
 	}, nil
 }
 
-// DeleteMLXModel removes an MLX model from local storage
+// DeleteMLXModel removes an MLX model from local storage, along with its
+// manifest tag if it was created via a Modelfile.
 func DeleteMLXModel(modelName string) error {
 	manager := llm.NewMLXModelManager()
+
+	if err := llm.RemoveMLXModel(modelName); err != nil && !os.IsNotExist(err) {
+		slog.Warn("failed to remove MLX model manifest tag", "model", modelName, "error", err)
+	}
+
 	return manager.DeleteModel(modelName)
 }
 
@@ -1408,6 +1535,7 @@ func IsMLXModelReference(modelName string) bool {
 // generateMLXModel handles generation requests for MLX models
 func (s *Server) generateMLXModel(c *gin.Context, req *api.GenerateRequest) {
 	ctx := c.Request.Context()
+	req.Model = stripMLXProviderPrefix(req.Model)
 	manager := llm.NewMLXModelManager()
 	modelName := req.Model
 	localName := strings.ReplaceAll(modelName, "/", "_")
@@ -1433,22 +1561,33 @@ func (s *Server) generateMLXModel(c *gin.Context, req *api.GenerateRequest) {
 		return
 	}
 
-	entry, err := mlxRunnerPool.getRunner(ctx, localName, keepAlive)
+	mf, err := llm.GetMLXModelConfig(modelName)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to provision MLX runner: %v", err)})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if keepAlive == 0 {
-		defer mlxRunnerPool.evict(localName)
-	} else {
-		defer mlxRunnerPool.touch(localName)
+
+	adapters, err := llm.ResolveMLXAdapters(mf)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	client := entry.client
-	port := entry.port
+	options, err := applyMLXSamplerOptions(req.Options, mf)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid options: %v", err)})
+		return
+	}
+	// resolveDraftModel must read req.Options before it's overwritten below:
+	// "draft_model" isn't a field on api.Options, so it doesn't survive the
+	// marshal/ConvertOptionsToMLXFormat round-trip applyMLXSamplerOptions did.
+	draftModel := resolveDraftModel(req.Model, req.Options)
+	req.Options = options
+
+	svc := mlxGenerationServiceFor(localName, keepAlive, adapters, draftModel)
 
 	if req.Stream != nil && !*req.Stream {
-		resp, err := collectMLXCompletion(ctx, client, port, req)
+		resp, err := collectMLXGenerateViaService(ctx, svc, req)
 		if err != nil {
 			status := http.StatusInternalServerError
 			if errors.Is(err, context.Canceled) {
@@ -1464,13 +1603,20 @@ func (s *Server) generateMLXModel(c *gin.Context, req *api.GenerateRequest) {
 		return
 	}
 
-	if err := streamMLXCompletion(ctx, c, client, port, req); err != nil && !errors.Is(err, context.Canceled) {
+	if err := streamMLXGenerateViaService(ctx, c, svc, req); err != nil && !errors.Is(err, context.Canceled) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 	}
 }
 
 func (s *Server) chatMLXModel(c *gin.Context, req *api.ChatRequest) {
 	ctx := c.Request.Context()
+	req.Model = stripMLXProviderPrefix(req.Model)
+
+	agentCfg, hasAgent := getAgentRegistry().lookup(req.Agent)
+	if hasAgent && req.Model == "" {
+		req.Model = agentCfg.Model
+	}
+
 	manager := llm.NewMLXModelManager()
 	localName := strings.ReplaceAll(req.Model, "/", "_")
 	keepAlive := 5 * time.Minute
@@ -1488,84 +1634,185 @@ func (s *Server) chatMLXModel(c *gin.Context, req *api.ChatRequest) {
 		return
 	}
 
-	entry, err := mlxRunnerPool.getRunner(ctx, localName, keepAlive)
+	mf, err := llm.GetMLXModelConfig(req.Model)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to provision MLX runner: %v", err)})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if keepAlive == 0 {
-		defer mlxRunnerPool.evict(localName)
-	} else {
-		defer mlxRunnerPool.touch(localName)
+
+	adapters, err := llm.ResolveMLXAdapters(mf)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	client := entry.client
-	port := entry.port
+	options, err := applyMLXSamplerOptions(req.Options, mf)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid options: %v", err)})
+		return
+	}
+	// resolveDraftModel must read req.Options before it's overwritten below:
+	// "draft_model" isn't a field on api.Options, so it doesn't survive the
+	// marshal/ConvertOptionsToMLXFormat round-trip applyMLXSamplerOptions did.
+	draftModel := resolveDraftModel(req.Model, req.Options)
+	req.Options = options
+
+	// chatMLXModel can't submit its request through the Service directly
+	// (see mlxServiceBackend's doc comment: the tool-auto-exec loop below
+	// needs one runner held across hops for KV-cache prefix hinting), but
+	// it still queues behind the same per-model bound /api/generate does,
+	// by acquiring a worker slot from the same shared Service up front and
+	// holding it for the whole turn.
+	svc := mlxGenerationServiceFor(localName, keepAlive, adapters, draftModel)
+	release, err := svc.Acquire(ctx, req.Model)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, context.Canceled) {
+			status = http.StatusRequestTimeout
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	defer release()
+
+	backend, err := getBackend(ctx, req.Model, localName, keepAlive, adapters, draftModel)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to provision MLX runner: %v", err)})
+		return
+	}
+	defer backend.Close()
 
 	stream := true
 	if req.Stream != nil {
 		stream = *req.Stream
 	}
 
-	prompt := formatChatPromptWithModel(req.Messages, req.Tools, req.Model)
-	images := extractImagesFromMessages(req.Messages)
-	genReq := &api.GenerateRequest{
-		Model:     req.Model,
-		Prompt:    prompt,
-		Stream:    &stream,
-		Format:    req.Format,
-		KeepAlive: req.KeepAlive,
-		Options:   req.Options,
-		Tools:     req.Tools,
-		Images:    images,
+	conversationID, parentMessageID := extractConversationRef(req.Options)
+	messages := req.Messages
+	branchParent := parentMessageID
+	if conversationID != "" {
+		ancestors, err := mlxConversations.ancestors(conversationID, parentMessageID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		messages = append(toAPIMessages(ancestors), req.Messages...)
+
+		for _, m := range req.Messages {
+			saved, err := mlxConversations.append(mlxConversationMessage{
+				ConversationID: conversationID,
+				ParentID:       branchParent,
+				Role:           m.Role,
+				Content:        m.Content,
+				ToolCalls:      m.ToolCalls,
+				Model:          req.Model,
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			branchParent = saved.MessageID
+		}
 	}
 
-	if stream {
-		toolCalls, err := streamMLXChat(ctx, c, client, port, req, genReq)
-		if err != nil && !errors.Is(err, context.Canceled) {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	tools := mergeMCPTools(ctx, req.Tools)
+	tools = mergeToolboxTools(tools)
+	systemPrompt := ""
+	if hasAgent {
+		tools = filterToolsForAgent(tools, agentCfg)
+		systemPrompt = agentCfg.SystemPrompt
+	}
+	tools = resolveToolChoice(tools, req.ToolChoice)
+
+	images := extractImagesFromMessages(messages)
+	autoExecute := req.AutoExecuteTools && len(tools) > 0
+
+	// Each iteration runs one generation turn; when autoExecute and the
+	// model only emitted tool calls, runToolAutoExecutionHop executes them
+	// and appends their results as "tool" messages for the next turn's
+	// prompt, up to maxAutoToolHops turns.
+	var resp *api.GenerateResponse
+	var toolCalls []api.ToolCall
+	for hop := 0; ; hop++ {
+		prompt := formatChatPromptWithModel(messages, tools, req.Model, systemPrompt)
+		genReq := &api.GenerateRequest{
+			Model:     req.Model,
+			Prompt:    prompt,
+			Stream:    &stream,
+			Format:    req.Format,
+			KeepAlive: req.KeepAlive,
+			Options:   req.Options,
+			Tools:     tools,
+			Images:    images,
+		}
+		if mb, ok := backend.(*mlxBackend); ok {
+			mb.recordPromptPrefixHint(genReq, messages)
+		}
+
+		isFinalHop := !autoExecute || hop >= maxAutoToolHops
+		resp, toolCalls, err = runMLXChatTurn(ctx, c, backend, req, genReq, tools, stream, isFinalHop)
+		if err != nil {
+			if stream {
+				if !errors.Is(err, context.Canceled) {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				}
+				return
+			}
+			status := http.StatusInternalServerError
+			if errors.Is(err, context.Canceled) {
+				status = http.StatusRequestTimeout
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
 		}
-		// Note: For streaming, tool calls are already included in the final chunk
-		// We log them here for debugging but the client already received them
-		if len(toolCalls) > 0 && len(req.Tools) > 0 {
-			slog.Debug("streaming detected tool calls", "count", len(toolCalls))
+
+		if len(toolCalls) == 0 || isFinalHop {
+			break
+		}
+
+		messages = append(messages, api.Message{Role: "assistant", ToolCalls: toolCalls})
+		branchParent = persistMLXHopMessage(conversationID, branchParent, req.Model, "assistant", "", toolCalls)
+
+		before := len(messages)
+		messages = runToolAutoExecutionHop(ctx, c, req.Model, tools, toolCalls, messages, stream)
+		for _, m := range messages[before:] {
+			branchParent = persistMLXHopMessage(conversationID, branchParent, req.Model, m.Role, m.Content, nil)
 		}
-		return
 	}
 
-	resp, err := collectMLXCompletion(ctx, client, port, genReq)
-	if err != nil {
-		status := http.StatusInternalServerError
-		if errors.Is(err, context.Canceled) {
-			status = http.StatusRequestTimeout
+	if stream {
+		persistMLXAssistantTurn(conversationID, branchParent, req.Model, resp.Response, toolCalls)
+		// Note: For streaming, tool calls are already included in the final chunk.
+		// We log them here for debugging but the client already received them.
+		if len(toolCalls) > 0 && len(tools) > 0 {
+			slog.Debug("streaming detected tool calls", "count", len(toolCalls))
 		}
-		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
-	// If the model requested tool calls, return them to the client.
-	// Standard Ollama behavior is to return tool calls for the client to handle.
-	if len(req.Tools) > 0 {
-		if toolCalls, ok := parseToolCallsFromText(resp.Response); ok && len(toolCalls) > 0 {
-			// Return the tool calls in the message - client handles execution
-			message := api.Message{
-				Role:      "assistant",
-				Content:   "", // Clear content when we have tool calls
-				ToolCalls: toolCalls,
-			}
-			chatResp := api.ChatResponse{
-				Model:      req.Model,
-				CreatedAt:  resp.CreatedAt,
-				Message:    message,
-				Done:       true,
-				DoneReason: "tool_calls",
-				Metrics:    resp.Metrics,
-			}
-			c.JSON(http.StatusOK, chatResp)
-			return
+	// If the model requested tool calls and they weren't auto-executed,
+	// return them to the client. Standard Ollama behavior is to return
+	// tool calls for the client to handle itself.
+	if len(toolCalls) > 0 {
+		message := api.Message{
+			Role:      "assistant",
+			Content:   "", // Clear content when we have tool calls
+			ToolCalls: toolCalls,
 		}
+		chatResp := api.ChatResponse{
+			Model:      req.Model,
+			CreatedAt:  resp.CreatedAt,
+			Message:    message,
+			Done:       true,
+			DoneReason: "tool_calls",
+			Metrics:    resp.Metrics,
+		}
+		persistMLXAssistantTurn(conversationID, branchParent, req.Model, message.Content, toolCalls)
+		c.JSON(http.StatusOK, chatResp)
+		return
 	}
 
+	persistMLXAssistantTurn(conversationID, branchParent, req.Model, resp.Response, nil)
 	message := api.Message{Role: "assistant", Content: resp.Response}
 
 	chatResp := api.ChatResponse{
@@ -1662,59 +1909,198 @@ func parseParameterCount(paramSize string) int64 {
 	return 0
 }
 
+// mlxEmbeddingRequest is the batched body sent to the MLX runner's
+// /embedding endpoint: every input in one round-trip instead of one POST
+// per string.
+type mlxEmbeddingRequest struct {
+	Prompts  []string `json:"prompts"`
+	Pooling  string   `json:"pooling,omitempty"`
+	Truncate string   `json:"truncate,omitempty"`
+}
+
 // mlxEmbeddingResponse is the response from the MLX backend embedding endpoint
 type mlxEmbeddingResponse struct {
-	Embeddings [][]float32 `json:"embeddings"`
-	Model      string      `json:"model"`
+	Embeddings      [][]float32 `json:"embeddings"`
+	Model           string      `json:"model"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
+// mlxRerankRequest is the body sent to the MLX runner's /rerank endpoint: a
+// query scored against a batch of candidate documents by a cross-encoder
+// model.
+type mlxRerankRequest struct {
+	Query     string   `json:"query"`
+	Documents []string `json:"documents"`
+}
+
+// mlxRerankResponse is the response from the MLX backend's /rerank
+// endpoint: one relevance score per document, indexed into the request's
+// Documents slice.
+type mlxRerankResponse struct {
+	Results []struct {
+		Index          int     `json:"index"`
+		RelevanceScore float64 `json:"relevance_score"`
+	} `json:"results"`
 }
 
-// EmbedMLXModel generates embeddings using an MLX model
-func (s *Server) EmbedMLXModel(c *gin.Context, modelName string, input []string) ([][]float32, error) {
+// mlxEmbedOptions are the knobs extractEmbedOptions reads out of an embed
+// request's freeform Options map, the same convention extractStreamTimeouts
+// and extractConversationRef use for their own options.
+type mlxEmbedOptions struct {
+	pooling   string // "mean" (default), "cls", or "last_token"
+	normalize bool
+	truncate  string // "end" (default), "start", or "none"
+}
+
+// extractEmbedOptions reads pooling/normalize/truncate out of options,
+// defaulting to mean pooling, no L2 normalization, and truncating from the
+// end for anything unset or of the wrong type.
+func extractEmbedOptions(options map[string]interface{}) mlxEmbedOptions {
+	opts := mlxEmbedOptions{pooling: "mean", truncate: "end"}
+	if v, ok := options["pooling"].(string); ok && v != "" {
+		opts.pooling = v
+	}
+	if v, ok := options["normalize"].(bool); ok {
+		opts.normalize = v
+	}
+	if v, ok := options["truncate"].(string); ok && v != "" {
+		opts.truncate = v
+	}
+	return opts
+}
+
+// l2Normalize scales vec in place to unit length. A zero vector is left
+// alone rather than dividing by zero.
+func l2Normalize(vec []float32) {
+	var sumSq float64
+	for _, v := range vec {
+		sumSq += float64(v) * float64(v)
+	}
+	if sumSq == 0 {
+		return
+	}
+	norm := float32(math.Sqrt(sumSq))
+	for i := range vec {
+		vec[i] /= norm
+	}
+}
+
+// EmbedMLXModel generates embeddings for every string in req.Input in a
+// single batched call to the backend (rather than one round-trip per
+// input), honoring pooling/normalize/truncate knobs read out of
+// req.Options by extractEmbedOptions.
+func (s *Server) EmbedMLXModel(c *gin.Context, req *api.EmbedRequest) {
 	ctx := c.Request.Context()
+	req.Model = stripMLXProviderPrefix(req.Model)
 	manager := llm.NewMLXModelManager()
-	localName := strings.ReplaceAll(modelName, "/", "_")
+	localName := strings.ReplaceAll(req.Model, "/", "_")
 	keepAlive := 5 * time.Minute
+	if req.KeepAlive != nil {
+		keepAlive = req.KeepAlive.Duration
+	}
 
 	if !manager.ModelExists(localName) {
-		return nil, fmt.Errorf("model '%s' not found", modelName)
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		return
 	}
 
-	entry, err := mlxRunnerPool.getRunner(ctx, localName, keepAlive)
+	adapters, err := resolveMLXAdaptersForModel(req.Model)
 	if err != nil {
-		return nil, fmt.Errorf("failed to provision MLX runner: %v", err)
-	}
-	defer mlxRunnerPool.touch(localName)
-
-	client := entry.client
-	port := entry.port
-
-	// Generate embeddings for each input
-	var allEmbeddings [][]float32
-	for _, text := range input {
-		reqBody, _ := json.Marshal(map[string]string{"prompt": text})
-		resp, err := client.Post(
-			fmt.Sprintf("http://127.0.0.1:%d/embedding", port),
-			"application/json",
-			bytes.NewReader(reqBody),
-		)
-		if err != nil {
-			return nil, fmt.Errorf("embedding request failed: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	backend, err := getBackend(ctx, req.Model, localName, keepAlive, adapters, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to provision MLX runner: %v", err)})
+		return
+	}
+	defer backend.Close()
+
+	opts := extractEmbedOptions(req.Options)
+	result, err := backend.Embed(ctx, req.Input, EmbedOptions{Pooling: opts.pooling, Truncate: opts.truncate})
+	if err != nil {
+		status := http.StatusInternalServerError
+		var httpErr *backendHTTPError
+		if errors.As(err, &httpErr) {
+			status = httpErr.status
 		}
-		defer resp.Body.Close()
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
 
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			return nil, fmt.Errorf("embedding failed: %s", strings.TrimSpace(string(body)))
+	if opts.normalize {
+		for _, vec := range result.Embeddings {
+			l2Normalize(vec)
 		}
+	}
+
+	c.JSON(http.StatusOK, api.EmbedResponse{
+		Model:           req.Model,
+		Embeddings:      result.Embeddings,
+		PromptEvalCount: result.PromptEvalCount,
+	})
+}
+
+// RerankMLXModel scores req.Documents against req.Query using a
+// cross-encoder MLX model, reusing the same runner pool and provisioning
+// path as generation/embedding models, and returns them sorted by
+// descending relevance. req.TopN, if set, caps how many results come back.
+func (s *Server) RerankMLXModel(c *gin.Context, req *api.RerankRequest) {
+	ctx := c.Request.Context()
+	req.Model = stripMLXProviderPrefix(req.Model)
+	manager := llm.NewMLXModelManager()
+	localName := strings.ReplaceAll(req.Model, "/", "_")
+	keepAlive := 5 * time.Minute
+	if req.KeepAlive != nil {
+		keepAlive = req.KeepAlive.Duration
+	}
 
-		var embResp mlxEmbeddingResponse
-		if err := json.NewDecoder(resp.Body).Decode(&embResp); err != nil {
-			return nil, fmt.Errorf("failed to decode embedding response: %v", err)
+	if !manager.ModelExists(localName) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		return
+	}
+
+	adapters, err := resolveMLXAdaptersForModel(req.Model)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	backend, err := getBackend(ctx, req.Model, localName, keepAlive, adapters, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to provision MLX runner: %v", err)})
+		return
+	}
+	defer backend.Close()
+
+	scores, err := backend.Rerank(ctx, req.Query, req.Documents)
+	if err != nil {
+		status := http.StatusInternalServerError
+		var httpErr *backendHTTPError
+		if errors.As(err, &httpErr) {
+			status = httpErr.status
 		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	sort.Slice(scores, func(i, j int) bool { return scores[i].RelevanceScore > scores[j].RelevanceScore })
 
-		allEmbeddings = append(allEmbeddings, embResp.Embeddings...)
+	topN := len(scores)
+	if req.TopN > 0 && req.TopN < topN {
+		topN = req.TopN
+	}
+
+	results := make([]api.RerankResult, topN)
+	for i, s := range scores[:topN] {
+		results[i] = api.RerankResult{
+			Index:          s.Index,
+			Document:       req.Documents[s.Index],
+			RelevanceScore: s.RelevanceScore,
+		}
 	}
 
-	return allEmbeddings, nil
+	c.JSON(http.StatusOK, api.RerankResponse{Model: req.Model, Results: results})
 }
 
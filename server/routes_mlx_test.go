@@ -17,6 +17,7 @@ import (
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/llm"
 )
 
 func TestStartMLXRunnerPropagatesModelsEnv(t *testing.T) {
@@ -44,6 +45,10 @@ func TestStartMLXRunnerPropagatesModelsEnv(t *testing.T) {
 func TestGenerateMLXModelUsesLocalName(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
+	original := controlPlaneSyncTimeout
+	controlPlaneSyncTimeout = 50 * time.Millisecond
+	defer func() { controlPlaneSyncTimeout = original }()
+
 	modelName := "mlx-community/llama-2"
 	localName := strings.ReplaceAll(modelName, "/", "_")
 
@@ -94,7 +99,7 @@ func TestGenerateMLXModelUsesLocalName(t *testing.T) {
 	defer func() { startMLXRunnerFunc = startMLXRunner }()
 
 	var loadedModel string
-	loadMLXModelFunc = func(_ context.Context, _ *http.Client, p int, modelName string) error {
+	loadMLXModelFunc = func(_ context.Context, _ *http.Client, p int, modelName string, _ []llm.MLXAdapter, _ string, _ int) error {
 		if p != port {
 			t.Fatalf("unexpected port: got %d want %d", p, port)
 		}
@@ -126,6 +131,92 @@ func TestGenerateMLXModelUsesLocalName(t *testing.T) {
 	}
 }
 
+func TestGenerateMLXModelHonorsRequestedDraftModel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	original := controlPlaneSyncTimeout
+	controlPlaneSyncTimeout = 50 * time.Millisecond
+	defer func() { controlPlaneSyncTimeout = original }()
+
+	// A name no other test uses: runner entries and generation services are
+	// cached per local name, and the first request fixes the draft model.
+	modelName := "mlx-community/llama-2-draft-target"
+	localName := strings.ReplaceAll(modelName, "/", "_")
+
+	modelsRoot := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", modelsRoot)
+
+	modelDir := filepath.Join(modelsRoot, "mlx", localName)
+	if err := os.MkdirAll(modelDir, 0o755); err != nil {
+		t.Fatalf("failed to create model directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(modelDir, "config.json"), []byte("{}"), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(modelDir, "weights.npz"), []byte{}, 0o644); err != nil {
+		t.Fatalf("failed to write weights: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start listener: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/completion", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprintf(w, `{"content":"ok","done":true,"done_reason":"stop"}\n`)
+	})
+
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(listener)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	startMLXRunnerFunc = func(ctx context.Context, modelName string) (*exec.Cmd, int, error) {
+		return exec.CommandContext(ctx, "true"), port, nil
+	}
+	defer func() { startMLXRunnerFunc = startMLXRunner }()
+
+	var gotDraftModel string
+	loadMLXModelFunc = func(_ context.Context, _ *http.Client, p int, _ string, _ []llm.MLXAdapter, draftModel string, _ int) error {
+		gotDraftModel = draftModel
+		return nil
+	}
+	defer func() { loadMLXModelFunc = loadMLXModel }()
+
+	stream := false
+	req := &api.GenerateRequest{
+		Model:   modelName,
+		Prompt:  "Hello",
+		Stream:  &stream,
+		Options: map[string]interface{}{"draft_model": "mlx-community/llama-2-draft"},
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/generate", nil)
+
+	srvInstance := &Server{}
+	srvInstance.generateMLXModel(c, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: got %d body %s", w.Code, w.Body.String())
+	}
+
+	if gotDraftModel != "mlx-community/llama-2-draft" {
+		t.Fatalf("runner loaded with draft model %q, want %q", gotDraftModel, "mlx-community/llama-2-draft")
+	}
+}
+
 func TestWaitForMLXRunnerPropagatesHealthError(t *testing.T) {
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ollama/ollama/llm"
+)
+
+const (
+	// mlxHealthCheckInterval is how often Supervise polls a running
+	// runner's /health endpoint between requests.
+	mlxHealthCheckInterval = 2 * time.Second
+	// mlxDrainTimeout bounds how long drain waits for a SIGTERM'd runner
+	// to exit on its own before escalating to SIGKILL.
+	mlxDrainTimeout = 10 * time.Second
+	// mlxRestartBackoffBase and mlxRestartBackoffMax bound the
+	// exponential backoff Supervise applies between restart attempts,
+	// resetting to the base once a restart succeeds.
+	mlxRestartBackoffBase = 1 * time.Second
+	mlxRestartBackoffMax  = 30 * time.Second
+)
+
+// RunnerSupervisor owns the lifecycle of MLX runner subprocesses: resolving
+// (and bootstrapping) their Python interpreter, launching them, watching
+// their health, restarting them with backoff if they crash or stop
+// responding, and draining them gracefully on shutdown. mlxRunnerCache holds
+// the per-model entries; RunnerSupervisor is what actually starts, watches,
+// and stops the process behind each one.
+type RunnerSupervisor struct {
+	metrics *supervisorMetrics
+
+	bootstrapMu  sync.Mutex
+	bootstrapped map[string]string // venv dir -> resolved python3 path
+}
+
+func NewRunnerSupervisor(metrics *supervisorMetrics) *RunnerSupervisor {
+	return &RunnerSupervisor{
+		metrics:      metrics,
+		bootstrapped: make(map[string]string),
+	}
+}
+
+// resolvePython returns the python3 interpreter MLX runners should be
+// launched with, checking OLLAMA_PYTHON, the Application Support and
+// dotfile venvs, and finally an app-bundle bootstrap, in the same priority
+// order startMLXRunner always has. It never errors: any failure along the
+// way falls back to the "python3" on PATH.
+func (s *RunnerSupervisor) resolvePython() string {
+	if p := os.Getenv("OLLAMA_PYTHON"); p != "" {
+		return p
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "python3"
+	}
+
+	// Priority 1: Application Support (Ollmlx.app standard)
+	if appSupport := filepath.Join(home, "Library", "Application Support", "Ollmlx", "venv", "bin", "python3"); fileExists(appSupport) {
+		return appSupport
+	}
+	// Priority 2: Dotfile (Legacy/Dev)
+	if dotFile := filepath.Join(home, ".ollmlx", "venv", "bin", "python3"); fileExists(dotFile) {
+		return dotFile
+	}
+
+	venvDir := filepath.Join(home, "Library", "Application Support", "Ollmlx", "venv")
+	return s.bootstrapVenv(venvDir)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// bootstrapVenv creates venvDir from the app bundle's requirements.txt (if
+// one is available) and returns its python3, falling back to "python3" on
+// PATH if there's no requirements.txt to install from or any step fails.
+// bootstrapMu serializes this across concurrent runner launches and
+// bootstrapped memoizes the result, so two models starting at once don't
+// race to create (or reinstall into) the same venv.
+func (s *RunnerSupervisor) bootstrapVenv(venvDir string) string {
+	s.bootstrapMu.Lock()
+	defer s.bootstrapMu.Unlock()
+
+	if python, ok := s.bootstrapped[venvDir]; ok {
+		return python
+	}
+
+	exe, _ := os.Executable()
+	resourcesReqs := filepath.Join(filepath.Dir(exe), "../Resources/mlx_backend/requirements.txt")
+	if !fileExists(resourcesReqs) {
+		return "python3"
+	}
+
+	slog.Info("bootstrapping python environment in Application Support", "requirements", resourcesReqs)
+
+	if err := exec.Command("python3", "-m", "venv", venvDir).Run(); err != nil {
+		slog.Error("failed to create venv during bootstrap", "error", err)
+		return "python3"
+	}
+
+	pip := filepath.Join(venvDir, "bin", "pip")
+	if err := exec.Command(pip, "install", "-r", resourcesReqs).Run(); err != nil {
+		slog.Error("failed to install dependencies during bootstrap", "error", err)
+		return "python3"
+	}
+
+	python := filepath.Join(venvDir, "bin", "python3")
+	slog.Info("bootstrap complete", "python", python)
+	s.bootstrapped[venvDir] = python
+	return python
+}
+
+// Launch starts entry's MLX runner subprocess and blocks until it's ready
+// to serve or has failed to become ready, closing entry.ready either way
+// (entry.err is set only in the failure case). It's called once per entry,
+// from the goroutine mlxRunnerCache.getRunner spawns for a newly created
+// entry; that goroutine hands off to Supervise for the rest of entry's
+// life once Launch returns successfully.
+func (s *RunnerSupervisor) Launch(ctx context.Context, entry *mlxRunnerEntry, adapters []llm.MLXAdapter) {
+	start := time.Now()
+	slog.Info("runner.start", "model", entry.model)
+
+	if err := s.launchOnce(ctx, entry, adapters); err != nil {
+		entry.err = err
+		close(entry.ready)
+		return
+	}
+
+	duration := time.Since(start)
+	s.metrics.bootstrapDuration.observe(entry.model, duration.Seconds())
+	slog.Info("runner.ready", "model", entry.model, "port", entry.port, "pid", pidOf(entry.cmd), "duration_ms", duration.Milliseconds())
+	close(entry.ready)
+}
+
+// launchOnce starts entry's subprocess, waits for its health endpoint, and
+// loads the model, leaving entry.cmd/entry.port set on success. On failure
+// it kills whatever it started and returns the error; it does not touch
+// entry.err or entry.ready, so it can be reused by Supervise's restart path.
+func (s *RunnerSupervisor) launchOnce(ctx context.Context, entry *mlxRunnerEntry, adapters []llm.MLXAdapter) error {
+	cmd, port, err := startMLXRunnerFunc(ctx, entry.model)
+	if err != nil {
+		return err
+	}
+	entry.cmd = cmd
+	entry.port = port
+
+	if err := entry.cmd.Start(); err != nil {
+		return err
+	}
+
+	if err := waitForMLXRunner(ctx, entry.client, port); err != nil {
+		_ = entry.cmd.Process.Kill()
+		return err
+	}
+
+	draftPort := 0
+	if entry.draftModel != "" {
+		// The draft entry is pulled from the same pool, under the draft
+		// model's own name and entry.keepalive, so requests for the draft
+		// as a main model (or as another entry's draft) share this exact
+		// runner and it's evicted on the same keep-alive schedule as any
+		// other entry.
+		draftEntry, err := mlxRunnerPool.getRunner(ctx, entry.draftModel, entry.keepalive, nil, "")
+		if err != nil {
+			_ = entry.cmd.Process.Kill()
+			return fmt.Errorf("provision draft model %q: %w", entry.draftModel, err)
+		}
+		draftPort = draftEntry.port
+	}
+
+	if err := loadMLXModelFunc(ctx, entry.client, port, entry.model, adapters, entry.draftModel, draftPort); err != nil {
+		_ = entry.cmd.Process.Kill()
+		return err
+	}
+
+	return nil
+}
+
+// Supervise runs for the rest of entry's life after Launch succeeds: it
+// watches the subprocess for an unexpected exit or a failed health check,
+// restarts it with exponential backoff (recording runner.exit/runner.restart
+// slog events and the restarts_total/health_check_failures_total counters
+// along the way), and drains it gracefully once ctx is canceled (by
+// mlxRunnerCache.evict, evictExpired, or Drain).
+func (s *RunnerSupervisor) Supervise(ctx context.Context, entry *mlxRunnerEntry, adapters []llm.MLXAdapter) {
+	backoff := mlxRestartBackoffBase
+
+	for {
+		exitCh := make(chan error, 1)
+		go func(cmd *exec.Cmd) { exitCh <- cmd.Wait() }(entry.cmd)
+
+		exitErr, shuttingDown := s.watch(ctx, entry, exitCh)
+		if shuttingDown {
+			s.drain(entry, exitCh)
+			return
+		}
+
+		s.metrics.restartsTotal.inc(entry.model)
+		slog.Warn("runner.exit", "model", entry.model, "port", entry.port, "pid", pidOf(entry.cmd), "exit_code", exitCodeOf(exitErr))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		slog.Info("runner.restart", "model", entry.model, "backoff_ms", backoff.Milliseconds())
+		if err := s.launchOnce(ctx, entry, adapters); err != nil {
+			slog.Error("runner.restart failed", "model", entry.model, "error", err)
+			backoff = minDuration(backoff*2, mlxRestartBackoffMax)
+			continue
+		}
+		backoff = mlxRestartBackoffBase
+	}
+}
+
+// watch blocks until entry's subprocess exits, its health check starts
+// failing, or ctx is canceled, whichever comes first. shuttingDown is true
+// only in the ctx-canceled case, in which exitErr is meaningless.
+func (s *RunnerSupervisor) watch(ctx context.Context, entry *mlxRunnerEntry, exitCh <-chan error) (exitErr error, shuttingDown bool) {
+	ticker := time.NewTicker(mlxHealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-exitCh:
+			return err, false
+		case <-ctx.Done():
+			return nil, true
+		case <-ticker.C:
+			if err := pingMLXRunner(entry.client, entry.port); err != nil {
+				s.metrics.healthCheckFailuresTotal.inc(entry.model)
+				slog.Warn("mlx runner health check failed", "model", entry.model, "port", entry.port, "error", err)
+				_ = entry.cmd.Process.Kill()
+				return <-exitCh, false
+			}
+		}
+	}
+}
+
+// pingMLXRunner issues a single health check, unlike waitForMLXRunner's
+// retry loop, since Supervise is the one doing the retrying here (on its
+// own poll interval rather than a tight startup loop).
+func pingMLXRunner(client *http.Client, port int) error {
+	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/health", port))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned %s", resp.Status)
+	}
+	return nil
+}
+
+// drain asks entry's subprocess to exit gracefully (SIGTERM), escalating to
+// SIGKILL if it hasn't exited within mlxDrainTimeout. exitCh is the channel
+// Supervise's cmd.Wait goroutine feeds, so drain never calls Wait a second
+// time on the same *exec.Cmd (which os/exec forbids).
+func (s *RunnerSupervisor) drain(entry *mlxRunnerEntry, exitCh <-chan error) {
+	if entry.cmd == nil || entry.cmd.Process == nil {
+		return
+	}
+
+	slog.Info("draining mlx runner", "model", entry.model, "pid", pidOf(entry.cmd))
+	_ = entry.cmd.Process.Signal(syscall.SIGTERM)
+
+	select {
+	case <-exitCh:
+	case <-time.After(mlxDrainTimeout):
+		slog.Warn("mlx runner did not exit after SIGTERM, killing", "model", entry.model, "pid", pidOf(entry.cmd))
+		_ = entry.cmd.Process.Kill()
+		<-exitCh
+	}
+}
+
+func pidOf(cmd *exec.Cmd) int {
+	if cmd == nil || cmd.Process == nil {
+		return 0
+	}
+	return cmd.Process.Pid
+}
+
+func exitCodeOf(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// This file gives RunnerSupervisor a /metrics endpoint in Prometheus's text
+// exposition format, mirroring runner/mlxrunner/metrics.go's hand-rolled
+// counters and histograms: there's no Prometheus client library available in
+// this tree, and the two packages' metric types are unexported, so the
+// pattern is duplicated here rather than shared.
+
+// counterVec is a set of monotonically increasing counters keyed by a
+// single label value (here, always the model name).
+type counterVec struct {
+	mu     sync.Mutex
+	values map[string]*atomic.Int64
+}
+
+func newCounterVec() *counterVec {
+	return &counterVec{values: make(map[string]*atomic.Int64)}
+}
+
+func (c *counterVec) inc(label string) {
+	c.mu.Lock()
+	v, ok := c.values[label]
+	if !ok {
+		v = &atomic.Int64{}
+		c.values[label] = v
+	}
+	c.mu.Unlock()
+	v.Add(1)
+}
+
+func (c *counterVec) snapshot() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]int64, len(c.values))
+	for k, v := range c.values {
+		out[k] = v.Load()
+	}
+	return out
+}
+
+// bootstrapDurationBuckets and firstTokenLatencyBuckets are the histogram
+// bucket upper bounds, in seconds, for the two supervisor histograms.
+var (
+	bootstrapDurationBuckets = []float64{0.5, 1, 2.5, 5, 10, 15, 30, 60, 120}
+	firstTokenLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+)
+
+// histogramVec is a Prometheus-style cumulative histogram (each bucket
+// counts all observations less than or equal to its upper bound, plus an
+// implicit +Inf bucket), tracked per model.
+type histogramVec struct {
+	buckets []float64 // sorted ascending
+
+	mu     sync.Mutex
+	counts map[string][]int64 // len(buckets)+1, the last slot is +Inf
+	sums   map[string]float64
+	totals map[string]int64
+}
+
+func newHistogramVec(buckets []float64) *histogramVec {
+	return &histogramVec{
+		buckets: buckets,
+		counts:  make(map[string][]int64),
+		sums:    make(map[string]float64),
+		totals:  make(map[string]int64),
+	}
+}
+
+func (h *histogramVec) observe(label string, value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts, ok := h.counts[label]
+	if !ok {
+		counts = make([]int64, len(h.buckets)+1)
+		h.counts[label] = counts
+	}
+	idx := sort.SearchFloat64s(h.buckets, value)
+	for i := idx; i < len(counts); i++ {
+		counts[i]++
+	}
+	h.sums[label] += value
+	h.totals[label]++
+}
+
+func (h *histogramVec) snapshot() (counts map[string][]int64, sums map[string]float64, totals map[string]int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts = make(map[string][]int64, len(h.counts))
+	for k, v := range h.counts {
+		cp := make([]int64, len(v))
+		copy(cp, v)
+		counts[k] = cp
+	}
+	sums = make(map[string]float64, len(h.sums))
+	for k, v := range h.sums {
+		sums[k] = v
+	}
+	totals = make(map[string]int64, len(h.totals))
+	for k, v := range h.totals {
+		totals[k] = v
+	}
+	return counts, sums, totals
+}
+
+// supervisorMetrics holds every metric RunnerSupervisor and mlxRunnerCache
+// expose on /metrics.
+type supervisorMetrics struct {
+	restartsTotal            *counterVec // by model
+	healthCheckFailuresTotal *counterVec // by model
+
+	bootstrapDuration *histogramVec // by model, seconds
+	firstTokenLatency *histogramVec // by model, seconds
+}
+
+func newSupervisorMetrics() *supervisorMetrics {
+	return &supervisorMetrics{
+		restartsTotal:            newCounterVec(),
+		healthCheckFailuresTotal: newCounterVec(),
+		bootstrapDuration:        newHistogramVec(bootstrapDurationBuckets),
+		firstTokenLatency:        newHistogramVec(firstTokenLatencyBuckets),
+	}
+}
+
+// writeExposition renders every supervisor metric in Prometheus text
+// exposition format. The active/idle gauges are computed live from cache so
+// /metrics never goes stale between scrapes.
+func (m *supervisorMetrics) writeExposition(w http.ResponseWriter, cache *mlxRunnerCache) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	writeCounter(w, "mlx_runner_restarts_total", "Total MLX runner subprocess restarts by model.", "model", m.restartsTotal.snapshot())
+	writeCounter(w, "mlx_health_check_failures_total", "Total failed MLX runner health checks by model.", "model", m.healthCheckFailuresTotal.snapshot())
+
+	writeHistogram(w, "mlx_bootstrap_duration_seconds", "Time to launch and load an MLX runner, in seconds, by model.", "model", m.bootstrapDuration)
+	writeHistogram(w, "mlx_first_token_latency_seconds", "Time from request start to first generated token, in seconds, by model.", "model", m.firstTokenLatency)
+
+	active, idleSeconds := cache.gaugeSnapshot()
+
+	fmt.Fprintf(w, "# HELP mlx_runners_active Number of MLX runner subprocesses currently cached, by model.\n")
+	fmt.Fprintf(w, "# TYPE mlx_runners_active gauge\n")
+	for _, model := range sortedKeys(active) {
+		fmt.Fprintf(w, "mlx_runners_active{model=%q} %d\n", model, active[model])
+	}
+
+	fmt.Fprintf(w, "# HELP mlx_runners_idle_seconds Seconds since an MLX runner last served a request, by model.\n")
+	fmt.Fprintf(w, "# TYPE mlx_runners_idle_seconds gauge\n")
+	for _, model := range sortedKeys(idleSeconds) {
+		fmt.Fprintf(w, "mlx_runners_idle_seconds{model=%q} %s\n", model, strconv.FormatFloat(idleSeconds[model], 'f', -1, 64))
+	}
+}
+
+func writeCounter(w http.ResponseWriter, name, help, label string, values map[string]int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	for _, l := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, l, values[l])
+	}
+}
+
+func writeHistogram(w http.ResponseWriter, name, help, label string, h *histogramVec) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+
+	counts, sums, totals := h.snapshot()
+	labels := make([]string, 0, len(counts))
+	for l := range counts {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+
+	for _, l := range labels {
+		bucketCounts := counts[l]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s=%q,le=%q} %d\n", name, label, l, strconv.FormatFloat(upper, 'f', -1, 64), bucketCounts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s=%q,le=\"+Inf\"} %d\n", name, label, l, bucketCounts[len(bucketCounts)-1])
+		fmt.Fprintf(w, "%s_sum{%s=%q} %s\n", name, label, l, strconv.FormatFloat(sums[l], 'f', -1, 64))
+		fmt.Fprintf(w, "%s_count{%s=%q} %d\n", name, label, l, totals[l])
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MLXMetrics serves the MLX runner supervisor's /metrics endpoint in
+// Prometheus text exposition format.
+func (s *Server) MLXMetrics(c *gin.Context) {
+	mlxSupervisor.metrics.writeExposition(c.Writer, mlxRunnerPool)
+	c.Status(http.StatusOK)
+}
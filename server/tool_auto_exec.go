@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ollama/ollama/api"
+)
+
+// maxAutoToolHops bounds how many times chatMLXModel will run a model's
+// tool calls and re-prompt it (see api.ChatRequest's AutoExecuteTools
+// field) before giving up and returning whatever the last turn produced,
+// so a model that never stops calling tools can't loop a request forever.
+const maxAutoToolHops = 8
+
+// runMLXChatTurn runs one generation turn of genReq to completion, either
+// streaming it to c (isFinalHop controls whether the client is told this
+// turn is done, see streamMLXChat) or collecting the full response. It's
+// the single step chatMLXModel's auto tool-execution loop iterates.
+func runMLXChatTurn(ctx context.Context, c *gin.Context, backend InferenceBackend, req *api.ChatRequest, genReq *api.GenerateRequest, tools api.Tools, stream, isFinalHop bool) (*api.GenerateResponse, []api.ToolCall, error) {
+	if stream {
+		content, toolCalls, err := streamMLXChat(ctx, c, backend, req, genReq, !isFinalHop)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &api.GenerateResponse{Model: req.Model, Response: content}, validateAndFilterToolCalls(tools, toolCalls), nil
+	}
+
+	resp, err := collectMLXCompletion(ctx, backend, genReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tools) > 0 {
+		if toolCalls, ok := parseToolCallsForModel(req.Model, resp.Response); ok && len(toolCalls) > 0 {
+			if valid := validateAndFilterToolCalls(tools, toolCalls); len(valid) > 0 {
+				return resp, valid, nil
+			}
+		}
+	}
+	return resp, nil, nil
+}
+
+// runToolAutoExecutionHop runs every one of calls through
+// executeOneToolCall and appends each as its own "tool" role message, so
+// the next hop's prompt includes the results. When stream, each result is
+// also written to c as its own NDJSON chunk - distinct from the assistant
+// content and tool_calls chunks streamMLXChat already writes - so a TUI
+// can render a tool's execution as it happens rather than only seeing the
+// next turn's content appear.
+func runToolAutoExecutionHop(ctx context.Context, c *gin.Context, model string, tools api.Tools, calls []api.ToolCall, messages []api.Message, stream bool) []api.Message {
+	for _, call := range calls {
+		result, err := executeOneToolCall(ctx, tools, call)
+		if err != nil {
+			slog.Warn("auto tool execution failed", "tool", call.Function.Name, "error", err)
+			result = fmt.Sprintf("error: %v", err)
+		}
+
+		if stream {
+			writeMLXToolResultChunk(c, model, call, result)
+		}
+
+		messages = append(messages, api.Message{Role: "tool", Content: result, ToolName: call.Function.Name})
+	}
+	return messages
+}
+
+// writeMLXToolResultChunk writes one NDJSON line carrying a tool role
+// message: the auto-execute loop's distinct chunk for a tool's result.
+func writeMLXToolResultChunk(c *gin.Context, model string, call api.ToolCall, result string) {
+	chatResp := api.ChatResponse{
+		Model:     model,
+		CreatedAt: time.Now().UTC(),
+		Message:   api.Message{Role: "tool", Content: result, ToolName: call.Function.Name},
+		Done:      false,
+	}
+
+	line, err := json.Marshal(chatResp)
+	if err != nil {
+		return
+	}
+	c.Writer.Write(line)
+	c.Writer.Write([]byte("\n"))
+	if flusher, ok := c.Writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// ToolCallParser incrementally separates a model's raw streamed output into
+// visible content and completed tool calls, understanding the specific
+// grammar each model family wraps tool calls in (Qwen's <tool_call> tags,
+// Llama 3.1's <|python_tag|> envelope, Mistral's [TOOL_CALLS] prefix, or a
+// bare JSON blob for families that don't mark tool calls at all). This lets
+// streamMLXChat strip partial tool-call tokens out of the content stream as
+// they arrive instead of only detecting them once the full response is in.
+//
+// Feed is called once per streamed chunk. Once the stream ends, callers
+// must make one final call with an empty chunk ("") to flush anything the
+// parser is still holding back (e.g. an unterminated tag, or buffered text
+// that turned out not to be a tool call after all); that final call always
+// returns done=true.
+type ToolCallParser interface {
+	Feed(chunk string) (emit string, calls []api.ToolCall, done bool)
+}
+
+// newToolCallParser returns the ToolCallParser for template, using the same
+// family dispatch formatChatPromptWithModel's detectMLXChatTemplate uses to
+// pick a prompt formatter, since a model's tool-call grammar is as much a
+// property of its family as its prompt format is.
+func newToolCallParser(template ChatTemplateType) ToolCallParser {
+	switch template {
+	case TemplateQwen:
+		return &tagToolCallParser{openTag: "<tool_call>", closeTag: "</tool_call>"}
+	case TemplateLlama:
+		return &envelopeToolCallParser{marker: "<|python_tag|>"}
+	case TemplateMistral:
+		return &envelopeToolCallParser{marker: "[TOOL_CALLS]"}
+	default:
+		// Phi, Gemma, SmolLM, and plain ChatML/Hermes models emit tool
+		// calls as a bare JSON blob with no wrapping marker.
+		return &genericToolCallParser{}
+	}
+}
+
+// tagToolCallParser handles families that wrap each tool call in a pair of
+// tags, e.g. Qwen's "<tool_call>{...}</tool_call>" (one or more per
+// response).
+type tagToolCallParser struct {
+	openTag, closeTag string
+	buf               strings.Builder
+	emittedContent    int
+	emittedCalls      int
+}
+
+func (p *tagToolCallParser) Feed(chunk string) (string, []api.ToolCall, bool) {
+	p.buf.WriteString(chunk)
+	content, calls, tail := extractTagCalls(p.buf.String(), p.openTag, p.closeTag)
+
+	newContent := content[p.emittedContent:]
+	p.emittedContent = len(content)
+	newCalls := calls[p.emittedCalls:]
+	p.emittedCalls = len(calls)
+
+	if chunk != "" {
+		return newContent, newCalls, tail == ""
+	}
+
+	// End-of-stream flush: an openTag that never closed wasn't actually a
+	// tool call, so surface it as plain text instead of silently dropping
+	// it.
+	return newContent + tail, newCalls, true
+}
+
+// extractTagCalls walks buf for complete openTag...closeTag spans, parsing
+// each span's contents as a {"name":...,"arguments":...} tool call. content
+// is buf with every complete span removed. tail is whatever of buf can't be
+// released as content yet: either a trailing openTag with no matching
+// closeTag, or (when openTag hasn't fully arrived at all) the last
+// len(openTag)-1 bytes, since they could be its opening prefix — without
+// that margin, a tag split across two Feed calls would have its leading
+// characters emitted as ordinary text before the tag was recognized.
+func extractTagCalls(buf, openTag, closeTag string) (content string, calls []api.ToolCall, tail string) {
+	var b strings.Builder
+	pos := 0
+	for {
+		oi := strings.Index(buf[pos:], openTag)
+		if oi == -1 {
+			remainder := buf[pos:]
+			safeLen := len(remainder) - (len(openTag) - 1)
+			if safeLen < 0 {
+				safeLen = 0
+			}
+			b.WriteString(remainder[:safeLen])
+			return b.String(), calls, remainder[safeLen:]
+		}
+		oi += pos
+
+		ci := strings.Index(buf[oi+len(openTag):], closeTag)
+		if ci == -1 {
+			b.WriteString(buf[pos:oi])
+			return b.String(), calls, buf[oi:]
+		}
+		ci += oi + len(openTag)
+
+		b.WriteString(buf[pos:oi])
+		if tc, ok := parseSingleToolCallJSON(buf[oi+len(openTag) : ci]); ok {
+			calls = append(calls, tc)
+		}
+		pos = ci + len(closeTag)
+	}
+}
+
+// parseSingleToolCallJSON parses one {"name": "...", "arguments": {...}}
+// (or "parameters" instead of "arguments", which some model cards use)
+// object into an api.ToolCall.
+func parseSingleToolCallJSON(src string) (api.ToolCall, bool) {
+	var obj struct {
+		Name       string         `json:"name"`
+		Arguments  map[string]any `json:"arguments"`
+		Parameters map[string]any `json:"parameters"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(src)), &obj); err != nil || obj.Name == "" {
+		return api.ToolCall{}, false
+	}
+	args := obj.Arguments
+	if args == nil {
+		args = obj.Parameters
+	}
+	return api.ToolCall{Function: api.ToolCallFunction{Name: obj.Name, Arguments: args}}, true
+}
+
+// envelopeToolCallParser handles families whose tool calls are introduced
+// by a single marker with no closing tag, the rest of the generation being
+// the call payload: Llama 3.1's "<|python_tag|>{...}" and Mistral's
+// "[TOOL_CALLS][...]".
+type envelopeToolCallParser struct {
+	marker    string
+	buf       strings.Builder
+	sawMarker bool
+}
+
+func (p *envelopeToolCallParser) Feed(chunk string) (string, []api.ToolCall, bool) {
+	if chunk == "" {
+		if !p.sawMarker {
+			return p.buf.String(), nil, true
+		}
+		return "", parseEnvelopeCalls(p.buf.String()), true
+	}
+
+	p.buf.WriteString(chunk)
+	if p.sawMarker {
+		// Everything from here to end-of-stream is the call payload; hold
+		// it back rather than guessing where it ends mid-stream.
+		return "", nil, false
+	}
+
+	full := p.buf.String()
+	idx := strings.Index(full, p.marker)
+	if idx == -1 {
+		// The marker itself could be split across this chunk and the
+		// next, so only release the prefix that can no longer possibly
+		// be (the start of) the marker.
+		safeLen := len(full) - (len(p.marker) - 1)
+		if safeLen <= 0 {
+			return "", nil, false
+		}
+		emit := full[:safeLen]
+		p.buf.Reset()
+		p.buf.WriteString(full[safeLen:])
+		return emit, nil, false
+	}
+
+	before := full[:idx]
+	p.sawMarker = true
+	p.buf.Reset()
+	p.buf.WriteString(full[idx+len(p.marker):])
+	return before, nil, false
+}
+
+// parseEnvelopeCalls parses the payload that follows an envelope marker:
+// either a JSON array of calls (Mistral's [TOOL_CALLS] format) or one or
+// more JSON objects separated by ";" (Llama 3.1's convention for more than
+// one call).
+func parseEnvelopeCalls(payload string) []api.ToolCall {
+	payload = strings.TrimSpace(payload)
+	if payload == "" {
+		return nil
+	}
+
+	var arr []struct {
+		Name       string         `json:"name"`
+		Arguments  map[string]any `json:"arguments"`
+		Parameters map[string]any `json:"parameters"`
+	}
+	if err := json.Unmarshal([]byte(payload), &arr); err == nil && len(arr) > 0 {
+		var calls []api.ToolCall
+		for _, a := range arr {
+			if a.Name == "" {
+				continue
+			}
+			args := a.Arguments
+			if args == nil {
+				args = a.Parameters
+			}
+			calls = append(calls, api.ToolCall{Function: api.ToolCallFunction{Name: a.Name, Arguments: args}})
+		}
+		return calls
+	}
+
+	var calls []api.ToolCall
+	for _, seg := range splitTopLevel(payload, ';') {
+		if tc, ok := parseSingleToolCallJSON(seg); ok {
+			calls = append(calls, tc)
+		}
+	}
+	return calls
+}
+
+// splitTopLevel splits s on sep, ignoring any sep that falls inside a
+// {...} or [...] span, so a tool call argument containing ";" doesn't get
+// cut in half.
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, s[start:])
+	return parts
+}
+
+// genericToolCallParser is the fallback for families with no tool-call
+// marker at all: it holds content back only while the buffer still looks
+// like it could be (the start of) a JSON tool call, releasing it as plain
+// text the moment that stops being plausible, and otherwise defers to
+// parseToolCallsFromText at end-of-stream.
+type genericToolCallParser struct {
+	buf strings.Builder
+}
+
+func (p *genericToolCallParser) Feed(chunk string) (string, []api.ToolCall, bool) {
+	if chunk == "" {
+		text := p.buf.String()
+		if calls, ok := parseToolCallsFromText(text); ok {
+			return leadingNonJSONText(text), calls, true
+		}
+		return text, nil, true
+	}
+
+	p.buf.WriteString(chunk)
+	trimmed := strings.TrimSpace(p.buf.String())
+	if trimmed == "" {
+		return "", nil, false
+	}
+	if trimmed[0] == '{' || trimmed[0] == '[' {
+		// Could still turn into a tool call once more of it arrives; hold
+		// it back rather than flicker raw JSON onto the client.
+		return "", nil, false
+	}
+
+	out := p.buf.String()
+	p.buf.Reset()
+	return out, nil, false
+}
+
+// leadingNonJSONText returns whatever text precedes the first JSON object
+// in text, the same "keep any reasoning text the model wrote before the
+// tool call" behavior streamMLXChat always applied.
+func leadingNonJSONText(text string) string {
+	if jsonStart := strings.Index(text, "{"); jsonStart > 0 {
+		return strings.TrimSpace(text[:jsonStart])
+	}
+	return ""
+}
+
+// parseToolCallsForModel runs modelName's family-specific ToolCallParser
+// over a complete (non-streamed) response, for the non-streaming chat path.
+func parseToolCallsForModel(modelName, text string) ([]api.ToolCall, bool) {
+	parser := newToolCallParser(detectMLXChatTemplate(modelName))
+	_, calls, _ := parser.Feed(text)
+	_, flushed, _ := parser.Feed("")
+	calls = append(calls, flushed...)
+	return calls, len(calls) > 0
+}
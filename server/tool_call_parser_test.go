@@ -0,0 +1,122 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+)
+
+// feedAll drives parser with src split into chunkSize-byte pieces (plus a
+// final flush), the way streamMLXChat actually calls Feed, and returns the
+// concatenated visible content and every tool call observed.
+func feedAll(t *testing.T, parser ToolCallParser, src string, chunkSize int) (string, []api.ToolCall) {
+	t.Helper()
+
+	var content string
+	var calls []api.ToolCall
+	for i := 0; i < len(src); i += chunkSize {
+		end := i + chunkSize
+		if end > len(src) {
+			end = len(src)
+		}
+		emit, c, _ := parser.Feed(src[i:end])
+		content += emit
+		calls = append(calls, c...)
+	}
+	emit, c, done := parser.Feed("")
+	content += emit
+	calls = append(calls, c...)
+	if !done {
+		t.Fatalf("Feed(\"\") done = false, want true")
+	}
+	return content, calls
+}
+
+func TestQwenToolCallParser(t *testing.T) {
+	// A real Qwen2.5 tool-call response: reasoning text, then a tagged call.
+	const src = "Let me check the weather.\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"SF\"}}\n</tool_call>"
+
+	for _, chunkSize := range []int{len(src), 7, 1} {
+		content, calls := feedAll(t, newToolCallParser(TemplateQwen), src, chunkSize)
+
+		if content != "Let me check the weather.\n" {
+			t.Errorf("chunkSize=%d: content = %q", chunkSize, content)
+		}
+		want := []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather", Arguments: map[string]any{"city": "SF"}}}}
+		if !reflect.DeepEqual(calls, want) {
+			t.Errorf("chunkSize=%d: calls = %+v, want %+v", chunkSize, calls, want)
+		}
+	}
+}
+
+func TestLlamaToolCallParser(t *testing.T) {
+	// Llama 3.1's python_tag envelope, no preceding text.
+	const src = `<|python_tag|>{"name": "get_weather", "parameters": {"city": "SF"}}`
+
+	for _, chunkSize := range []int{len(src), 5, 1} {
+		content, calls := feedAll(t, newToolCallParser(TemplateLlama), src, chunkSize)
+
+		if content != "" {
+			t.Errorf("chunkSize=%d: content = %q, want empty", chunkSize, content)
+		}
+		want := []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather", Arguments: map[string]any{"city": "SF"}}}}
+		if !reflect.DeepEqual(calls, want) {
+			t.Errorf("chunkSize=%d: calls = %+v, want %+v", chunkSize, calls, want)
+		}
+	}
+}
+
+func TestMistralToolCallParser(t *testing.T) {
+	const src = `[TOOL_CALLS][{"name": "get_weather", "arguments": {"city": "SF"}}]`
+
+	content, calls := feedAll(t, newToolCallParser(TemplateMistral), src, 9)
+	if content != "" {
+		t.Errorf("content = %q, want empty", content)
+	}
+	want := []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather", Arguments: map[string]any{"city": "SF"}}}}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %+v, want %+v", calls, want)
+	}
+}
+
+func TestGenericToolCallParserJSONBlock(t *testing.T) {
+	// Hermes/Phi-style: a bare JSON tool call with no wrapping marker.
+	const src = `{"name": "get_weather", "arguments": {"city": "SF"}}`
+
+	content, calls := feedAll(t, newToolCallParser(TemplatePhi), src, 4)
+	if content != "" {
+		t.Errorf("content = %q, want empty", content)
+	}
+	want := []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_weather", Arguments: map[string]any{"city": "SF"}}}}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %+v, want %+v", calls, want)
+	}
+}
+
+func TestGenericToolCallParserPlainText(t *testing.T) {
+	// Plain chat output with no tool call at all must still stream through.
+	const src = "Hello, how can I help you today?"
+
+	content, calls := feedAll(t, newToolCallParser(TemplateGemma), src, 6)
+	if content != src {
+		t.Errorf("content = %q, want %q", content, src)
+	}
+	if len(calls) != 0 {
+		t.Errorf("calls = %+v, want none", calls)
+	}
+}
+
+func TestQwenToolCallParserUnterminatedTagIsTreatedAsText(t *testing.T) {
+	// A tag that never closes (truncated generation) must surface as text
+	// rather than being silently dropped.
+	const src = "before <tool_call>\n{not valid json"
+
+	content, calls := feedAll(t, newToolCallParser(TemplateQwen), src, 5)
+	if len(calls) != 0 {
+		t.Errorf("calls = %+v, want none", calls)
+	}
+	if content != src {
+		t.Errorf("content = %q, want %q", content, src)
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// toolParameterSchema is the JSON Schema shape api.ToolFunction.Parameters
+// round-trips through - the same shape toolDef and mcpRegistry.tools
+// populate it from - read back out via json.Marshal/Unmarshal so
+// validateToolCallArguments doesn't need to depend on that type's own
+// field names, only on it marshaling to standard JSON Schema.
+type toolParameterSchema struct {
+	Required   []string                     `json:"required"`
+	Properties map[string]toolParameterProp `json:"properties"`
+}
+
+type toolParameterProp struct {
+	Type string `json:"type"`
+}
+
+// validateToolCallArguments checks call's arguments against tool's declared
+// JSON Schema: every required property must be present, and any property
+// call does supply must roughly match its declared type. It intentionally
+// doesn't enforce anything the schema doesn't (extra properties, precise
+// numeric types), since models routinely emit schema-compatible JSON that
+// isn't byte-identical to the schema's own strictness.
+func validateToolCallArguments(tool api.Tool, call api.ToolCall) error {
+	raw, err := json.Marshal(tool.Function.Parameters)
+	if err != nil {
+		return nil
+	}
+	var schema toolParameterSchema
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return nil
+	}
+
+	for _, name := range schema.Required {
+		if _, ok := call.Function.Arguments[name]; !ok {
+			return fmt.Errorf("tool %q call missing required argument %q", tool.Function.Name, name)
+		}
+	}
+
+	for name, value := range call.Function.Arguments {
+		prop, ok := schema.Properties[name]
+		if !ok || prop.Type == "" {
+			continue
+		}
+		if !valueMatchesSchemaType(value, prop.Type) {
+			return fmt.Errorf("tool %q call argument %q does not match declared type %q", tool.Function.Name, name, prop.Type)
+		}
+	}
+
+	return nil
+}
+
+func valueMatchesSchemaType(v any, schemaType string) bool {
+	switch strings.ToLower(schemaType) {
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "number", "integer":
+		_, ok := v.(float64)
+		return ok
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	default:
+		return true
+	}
+}
+
+// validateAndFilterToolCalls drops any of calls that fails
+// validateToolCallArguments against tools' schemas, logging a warning for
+// each one dropped, so a model's malformed tool call degrades to "no tool
+// call" (falling back to its raw content, the same as if parsing had
+// failed outright) rather than being auto-executed or surfaced to the
+// caller unvalidated. A call naming a tool that isn't in tools at all is
+// left alone - there's no schema to check it against, and that's an
+// existing-behavior question for executeOneToolCall, not this function.
+func validateAndFilterToolCalls(tools api.Tools, calls []api.ToolCall) []api.ToolCall {
+	if len(calls) == 0 {
+		return calls
+	}
+
+	byName := make(map[string]api.Tool, len(tools))
+	for _, t := range tools {
+		byName[t.Function.Name] = t
+	}
+
+	valid := calls[:0:0]
+	for _, call := range calls {
+		tool, ok := byName[call.Function.Name]
+		if !ok {
+			valid = append(valid, call)
+			continue
+		}
+		if err := validateToolCallArguments(tool, call); err != nil {
+			slog.Warn("dropping tool call that failed schema validation", "tool", call.Function.Name, "error", err)
+			continue
+		}
+		valid = append(valid, call)
+	}
+	return valid
+}
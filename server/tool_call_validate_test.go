@@ -0,0 +1,90 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/ollama/ollama/api"
+)
+
+func weatherTool() api.Tool {
+	return toolDef("get_weather", "Get the current weather for a city.",
+		`{"type":"object","properties":{"city":{"type":"string"},"units":{"type":"string"}},"required":["city"]}`)
+}
+
+func TestValidateToolCallArguments(t *testing.T) {
+	tool := weatherTool()
+
+	cases := []struct {
+		name    string
+		args    map[string]any
+		wantErr bool
+	}{
+		{"valid", map[string]any{"city": "San Francisco"}, false},
+		{"valid with optional property", map[string]any{"city": "San Francisco", "units": "celsius"}, false},
+		{"missing required property", map[string]any{"units": "celsius"}, true},
+		{"wrong type for declared property", map[string]any{"city": 42.0}, true},
+		{"unknown property is ignored", map[string]any{"city": "SF", "forecast_days": 3.0}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			call := api.ToolCall{Function: api.ToolCallFunction{Name: tool.Function.Name, Arguments: tc.args}}
+			err := validateToolCallArguments(tool, call)
+			if tc.wantErr && err == nil {
+				t.Errorf("validateToolCallArguments() = nil, want error")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("validateToolCallArguments() = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestValidateAndFilterToolCallsDropsInvalidCalls(t *testing.T) {
+	tools := api.Tools{weatherTool()}
+
+	calls := []api.ToolCall{
+		{Function: api.ToolCallFunction{Name: "get_weather", Arguments: map[string]any{"city": "Tokyo"}}},
+		{Function: api.ToolCallFunction{Name: "get_weather", Arguments: map[string]any{"units": "celsius"}}},
+	}
+
+	got := validateAndFilterToolCalls(tools, calls)
+	if len(got) != 1 {
+		t.Fatalf("validateAndFilterToolCalls() = %+v, want 1 surviving call", got)
+	}
+	if got[0].Function.Arguments["city"] != "Tokyo" {
+		t.Errorf("surviving call = %+v, want the Tokyo call", got[0])
+	}
+}
+
+func TestResolveToolChoice(t *testing.T) {
+	weather := weatherTool()
+	other := toolDef("get_time", "Get the current time.", `{"type":"object","properties":{}}`)
+	tools := api.Tools{weather, other}
+
+	cases := []struct {
+		name      string
+		choice    string
+		wantNames []string
+	}{
+		{"auto keeps every tool", "auto", []string{"get_weather", "get_time"}},
+		{"empty behaves like auto", "", []string{"get_weather", "get_time"}},
+		{"none disables every tool", "none", nil},
+		{"named tool narrows to just that tool", "get_weather", []string{"get_weather"}},
+		{"unknown named tool leaves the set untouched", "not_a_tool", []string{"get_weather", "get_time"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := resolveToolChoice(tools, tc.choice)
+			if len(got) != len(tc.wantNames) {
+				t.Fatalf("resolveToolChoice(%q) = %+v, want names %v", tc.choice, got, tc.wantNames)
+			}
+			for i, name := range tc.wantNames {
+				if got[i].Function.Name != name {
+					t.Errorf("resolveToolChoice(%q)[%d] = %q, want %q", tc.choice, i, got[i].Function.Name, name)
+				}
+			}
+		})
+	}
+}
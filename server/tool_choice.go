@@ -0,0 +1,28 @@
+package server
+
+import "github.com/ollama/ollama/api"
+
+// resolveToolChoice narrows tools per req.ToolChoice's "auto"/"none"/named
+// convention (the same shape OpenAI's tool_choice takes): "auto" (and the
+// empty string, before applyLegacyMLXChatAdapters defaults it) leaves every
+// tool available, "none" disables tool calling for this request entirely,
+// and any other value names the one tool the model should be steered
+// toward, mirroring filterToolsForAgent's allowlist filtering of an agent's
+// tools. Narrowing to a single tool is the strongest enforcement available
+// here - the model still has to choose to call it - since nothing below
+// this forces a completion to contain a tool call.
+func resolveToolChoice(tools api.Tools, choice string) api.Tools {
+	switch choice {
+	case "", "auto":
+		return tools
+	case "none":
+		return nil
+	default:
+		for _, t := range tools {
+			if t.Function.Name == choice {
+				return api.Tools{t}
+			}
+		}
+		return tools
+	}
+}
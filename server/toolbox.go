@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// toolboxFunc is one built-in Go tool implementation: it receives the
+// model's call arguments and returns the text to feed back as that call's
+// tool-role result message.
+type toolboxFunc func(ctx context.Context, args map[string]any) (string, error)
+
+// toolboxEntry pairs a toolboxFunc with the api.Tool schema advertised to
+// the model, the same shape an MCP server's tools/list entries take.
+type toolboxEntry struct {
+	def api.Tool
+	run toolboxFunc
+}
+
+// toolbox is the server-side registry of built-in, in-process tool
+// implementations, as opposed to mcpRegistry's out-of-process MCP servers.
+// Only tools named in OLLAMA_TOOLBOX_ALLOW are registered, since
+// dir_tree/read_file/modify_file expose the local filesystem and http_get
+// can reach internal network services - a deployment must opt into each
+// one explicitly rather than getting all of them by default.
+type toolbox struct {
+	entries map[string]toolboxEntry
+}
+
+// tools returns every registered tool's schema, sorted by name for a
+// stable prompt across requests.
+func (tb *toolbox) tools() api.Tools {
+	names := make([]string, 0, len(tb.entries))
+	for name := range tb.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make(api.Tools, 0, len(names))
+	for _, name := range names {
+		out = append(out, tb.entries[name].def)
+	}
+	return out
+}
+
+// call runs name with args if it's registered, reporting ok=false rather
+// than an error when name isn't one of tb's tools at all, so callers can
+// fall back to another tool source (MCP, the legacy URL convention).
+func (tb *toolbox) call(ctx context.Context, name string, args map[string]any) (output string, ok bool, err error) {
+	entry, found := tb.entries[name]
+	if !found {
+		return "", false, nil
+	}
+	out, err := entry.run(ctx, args)
+	return out, true, err
+}
+
+// toolDef builds an api.Tool from a JSON Schema literal the same way
+// mcpRegistry.tools does for a discovered MCP tool: unmarshal straight into
+// the anonymous Parameters field rather than naming its type.
+func toolDef(name, description, parametersJSON string) api.Tool {
+	t := api.Tool{Type: "function", Function: api.ToolFunction{Name: name, Description: description}}
+	if err := json.Unmarshal([]byte(parametersJSON), &t.Function.Parameters); err != nil {
+		panic(fmt.Sprintf("toolbox: invalid parameters schema for %s: %v", name, err))
+	}
+	return t
+}
+
+// starterToolboxDefs is every tool the starter toolbox ships, keyed by the
+// name OLLAMA_TOOLBOX_ALLOW must list to enable it.
+var starterToolboxDefs = map[string]toolboxEntry{
+	"dir_tree": {
+		def: toolDef("dir_tree", "List files and directories under a path, recursively.",
+			`{"type":"object","properties":{"path":{"type":"string","description":"Directory to list."}},"required":["path"]}`),
+		run: dirTreeTool,
+	},
+	"read_file": {
+		def: toolDef("read_file", "Read a file's entire contents as text.",
+			`{"type":"object","properties":{"path":{"type":"string","description":"File to read."}},"required":["path"]}`),
+		run: readFileTool,
+	},
+	"modify_file": {
+		def: toolDef("modify_file", "Overwrite a file with new contents, creating it if it doesn't exist.",
+			`{"type":"object","properties":{"path":{"type":"string","description":"File to write."},"content":{"type":"string","description":"New file contents."}},"required":["path","content"]}`),
+		run: modifyFileTool,
+	},
+	"http_get": {
+		def: toolDef("http_get", "Fetch a URL with an HTTP GET request and return the response body.",
+			`{"type":"object","properties":{"url":{"type":"string","description":"URL to fetch."}},"required":["url"]}`),
+		run: httpGetTool,
+	},
+}
+
+var (
+	toolboxOnce sync.Once
+	toolboxInst *toolbox
+)
+
+// getToolbox lazily builds the process's toolbox from OLLAMA_TOOLBOX_ALLOW
+// (a comma-separated list drawn from starterToolboxDefs's names) on first
+// use. An empty/unset variable leaves the toolbox empty.
+func getToolbox() *toolbox {
+	toolboxOnce.Do(func() {
+		toolboxInst = &toolbox{entries: map[string]toolboxEntry{}}
+
+		allow := toSet(strings.Split(os.Getenv("OLLAMA_TOOLBOX_ALLOW"), ","))
+		for name, entry := range starterToolboxDefs {
+			if allow[name] {
+				toolboxInst.entries[name] = entry
+			}
+		}
+	})
+	return toolboxInst
+}
+
+// mergeToolboxTools appends the process's toolbox tools to tools, skipping
+// any name the caller already declared, mirroring how mergeMCPTools folds
+// in MCP-discovered tools.
+func mergeToolboxTools(tools api.Tools) api.Tools {
+	tb := getToolbox()
+	if len(tb.entries) == 0 {
+		return tools
+	}
+
+	have := make(map[string]bool, len(tools))
+	for _, t := range tools {
+		have[t.Function.Name] = true
+	}
+
+	for _, t := range tb.tools() {
+		if !have[t.Function.Name] {
+			tools = append(tools, t)
+			have[t.Function.Name] = true
+		}
+	}
+	return tools
+}
+
+func dirTreeTool(ctx context.Context, args map[string]any) (string, error) {
+	path, _ := args["path"].(string)
+	if path == "" {
+		path = "."
+	}
+
+	var lines []string
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == path {
+			return nil
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			rel = p
+		}
+		if info.IsDir() {
+			rel += "/"
+		}
+		lines = append(lines, rel)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("dir_tree %s: %w", path, err)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func readFileTool(ctx context.Context, args map[string]any) (string, error) {
+	path, _ := args["path"].(string)
+	if path == "" {
+		return "", fmt.Errorf("read_file: \"path\" argument is required")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read_file %s: %w", path, err)
+	}
+	return string(data), nil
+}
+
+func modifyFileTool(ctx context.Context, args map[string]any) (string, error) {
+	path, _ := args["path"].(string)
+	if path == "" {
+		return "", fmt.Errorf("modify_file: \"path\" argument is required")
+	}
+	content, _ := args["content"].(string)
+
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		return "", fmt.Errorf("modify_file %s: %w", path, err)
+	}
+	return fmt.Sprintf("wrote %d bytes to %s", len(content), path), nil
+}
+
+func httpGetTool(ctx context.Context, args map[string]any) (string, error) {
+	url, _ := args["url"].(string)
+	if url == "" {
+		return "", fmt.Errorf("http_get: \"url\" argument is required")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("http_get %s: %w", url, err)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("http_get %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("http_get %s: read response: %w", url, err)
+	}
+	return strings.TrimSpace(string(body)), nil
+}